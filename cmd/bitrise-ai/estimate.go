@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/simulate"
+)
+
+func estimateCmd(args []string) error {
+	var p simulate.Plan
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	fs.IntVar(&p.Items, "items", 1, "number of items, each processed by an agent run like the recorded ones")
+	fs.IntVar(&p.Concurrency, "concurrency", 1, "number of agents running at the same time")
+	fs.DurationVar(&p.Latency.PerRequest, "request-latency", 0, "time to the first token of a response")
+	fs.DurationVar(&p.Latency.PerOutputToken, "token-latency", 0, "generation time of an output token")
+	fs.DurationVar(&p.ToolTime, "tool-time", 0, "time of the tool calls of a turn")
+	fs.IntVar(&p.TokensPerMinute, "tpm", 0, "rate limit of the model in tokens per minute")
+	pricingFlags(fs, &p.Pricing)
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: bitrise-ai estimate [flags] <session file>...")
+	}
+
+	var histories [][]llm.Message
+	for _, path := range fs.Args() {
+		session, err := core.ReadSession(path, sessionKeys())
+		if err != nil {
+			return fmt.Errorf("read session %s: %w", path, err)
+		}
+		histories = append(histories, session.Messages)
+	}
+	p.Runs = simulate.RecordedRuns(histories...)
+	e, err := simulate.Estimate(p)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, e)
+	if e.RateLimited {
+		fmt.Fprintln(os.Stdout, "the wall time is limited by the rate limit")
+	}
+	fmt.Fprintf(os.Stdout, "prompt cache: %s\n", core.NewCacheStats(e.Usage))
+	return nil
+}
@@ -0,0 +1,302 @@
+// Command bitrise-ai runs agents defined in YAML specs and inspects the
+// session files they write.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/audit"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/journal"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/output"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/spec"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+const usage = `Usage: bitrise-ai <command> [flags] [args]
+
+Commands:
+  run      run an agent from a YAML spec
+  inspect  pretty-print a session file
+  replay   run an agent from a YAML spec with the prompt of a session
+  usage    show the token usage and cost of a session
+  estimate estimate the cost and time of running an agent on many items
+  tools    list the tools available to specs
+
+Run "bitrise-ai <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "run":
+		err = runCmd(ctx, args)
+	case "inspect":
+		err = inspectCmd(args)
+	case "replay":
+		err = replayCmd(ctx, args)
+	case "usage":
+		err = usageCmd(args)
+	case "estimate":
+		err = estimateCmd(args)
+	case "tools":
+		for _, name := range tool.DefaultRegistry.Names() {
+			fmt.Fprintln(os.Stdout, name)
+		}
+	case "-h", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+type runFlags struct {
+	specPath    string
+	dir         string
+	sessionPath string
+	format      string
+	transcript  bool
+	verbose     bool
+	seed        int64
+	auditPath   string
+	dryRun      bool
+	rollback    bool
+	sandbox     string
+	secretsDir  string
+}
+
+func (f *runFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.specPath, "spec", "", "path of the agent spec (mandatory)")
+	fs.StringVar(&f.dir, "dir", ".", "root directory of the tools")
+	fs.StringVar(&f.sessionPath, "session", "", "session file to continue and write the conversation to")
+	fs.StringVar(&f.format, "format", "text", "output format: text, json or markdown")
+	fs.BoolVar(&f.transcript, "transcript", false, "include the transcript in json and markdown output")
+	fs.BoolVar(&f.verbose, "v", false, "verbose logging")
+	fs.StringVar(&f.auditPath, "audit-log", "", "JSONL file to append the audit records of the tool calls to")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "preview the run: tools changing files or external systems describe their effect instead")
+	fs.BoolVar(&f.rollback, "rollback-on-error", false, "revert the files changed by the tools if the run fails")
+	fs.StringVar(&f.sandbox, "sandbox", "", "container image to run the commands of the tools in (docker, no network)")
+	fs.StringVar(&f.secretsDir, "secrets-dir", "", "directory of the secrets of the tools (one file per secret), the environment is used otherwise")
+	fs.Int64Var(&f.seed, "seed", 0, "sampling seed to replay a run with (OpenAI and Gemini, none by default)")
+}
+
+func runCmd(ctx context.Context, args []string) error {
+	var f runFlags
+	var prompt string
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	f.register(fs)
+	fs.StringVar(&prompt, "prompt", "", `prompt of the run, overrides the prompt of the spec ("-" reads it from stdin)`)
+	_ = fs.Parse(args)
+
+	if prompt == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("read prompt: %w", err)
+		}
+		prompt = string(b)
+	}
+	return runSpec(ctx, f, prompt)
+}
+
+func replayCmd(ctx context.Context, args []string) error {
+	var f runFlags
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	f.register(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: bitrise-ai replay -spec <spec> [flags] <session file>")
+	}
+
+	session, err := core.ReadSession(fs.Arg(0), sessionKeys())
+	if err != nil {
+		return fmt.Errorf("read session: %w", err)
+	}
+	prompt := firstPrompt(session.Messages)
+	if prompt == "" {
+		return fmt.Errorf("session has no user prompt")
+	}
+	return runSpec(ctx, f, prompt)
+}
+
+func runSpec(ctx context.Context, f runFlags, prompt string) error {
+	if f.specPath == "" {
+		return fmt.Errorf("missing -spec")
+	}
+	s, err := spec.Load(f.specPath)
+	if err != nil {
+		return fmt.Errorf("load spec: %w", err)
+	}
+	var changes *journal.Journal
+	if f.rollback {
+		changes = journal.New()
+	}
+	p, err := s.RunParams(strings.TrimSpace(prompt), tool.DefaultRegistry.Lookup(tool.FactoryParams{Dir: f.dir, Journal: changes}))
+	if err != nil {
+		return fmt.Errorf("spec %s: %w", s.Name, err)
+	}
+
+	level := slog.LevelWarn
+	if f.verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+	base, err := s.NewBase(logger)
+	if err != nil {
+		return fmt.Errorf("spec %s: %w", s.Name, err)
+	}
+	base.SessionFilePath = f.sessionPath
+	base.SessionKeys = sessionKeys()
+	base.DryRun = f.dryRun
+	base.Secrets = secrets.Env{}
+	if f.secretsDir != "" {
+		base.Secrets = secrets.Chain(secrets.Files{Dir: f.secretsDir}, secrets.Env{})
+	}
+	if f.sandbox != "" {
+		base.Sandbox = &sandbox.Config{Image: f.sandbox, Workspace: f.dir}
+	}
+	if f.seed != 0 {
+		p.Seed = &f.seed
+	}
+	if err := errors.Join(base.Validate(), p.Validate()); err != nil {
+		return fmt.Errorf("spec %s: invalid configuration:\n%w", s.Name, err)
+	}
+	if f.auditPath != "" {
+		auditLog, err := audit.OpenFile(f.auditPath, nil)
+		if err != nil {
+			return err
+		}
+		defer auditLog.Close()
+		base.AuditLog = auditLog
+	}
+	result, meta, err := spec.Run(ctx, base, p)
+	if err != nil {
+		// Without a run ID the run failed before the agent started, no
+		// tool changed anything.
+		if changes != nil && meta.RunID != "" {
+			if rbErr := changes.RollbackRun(meta.RunID); rbErr != nil {
+				logger.Error("rollback failed", "run-id", meta.RunID, "error", rbErr)
+			} else {
+				logger.Warn("changes of the failed run reverted", "run-id", meta.RunID)
+			}
+		}
+		return fmt.Errorf("run agent %s: %w", s.Name, err)
+	}
+	logger.Info("run finished", "run-id", meta.RunID, "usage", meta.Usage, "seed", meta.Seed, "fingerprints", meta.Fingerprints)
+
+	var messages []llm.Message
+	if f.transcript {
+		messages = meta.Messages
+	}
+	var value any
+	if err := json.Unmarshal(result, &value); err != nil {
+		return fmt.Errorf("unmarshal result: %w", err)
+	}
+	switch f.format {
+	case "text":
+		err = writeText(result)
+	case "json":
+		err = output.WriteJSON(os.Stdout, value, messages)
+	case "markdown":
+		err = output.WriteMarkdown(os.Stdout, value, messages)
+	default:
+		err = fmt.Errorf("unknown format %q", f.format)
+	}
+	return err
+}
+
+// writeText prints string results as is and object results as indented
+// JSON.
+func writeText(result json.RawMessage) error {
+	var text string
+	if err := json.Unmarshal(result, &text); err == nil {
+		_, err := fmt.Fprintln(os.Stdout, text)
+		return err
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, result, "", "  "); err != nil {
+		return fmt.Errorf("indent result: %w", err)
+	}
+	_, err := fmt.Fprintln(os.Stdout, b.String())
+	return err
+}
+
+// sessionKeys encrypts the session files if the key is set in the
+// environment.
+func sessionKeys() core.SessionKeyProvider {
+	if os.Getenv(core.DefaultSessionKeyEnv) == "" {
+		return nil
+	}
+	return core.SessionKeyFromEnv(core.DefaultSessionKeyEnv)
+}
+
+func inspectCmd(args []string) error {
+	var format string
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.StringVar(&format, "format", "markdown", "output format: markdown, json, or the native format of a provider: anthropic, openai or gemini")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: bitrise-ai inspect [flags] <session file>")
+	}
+
+	session, err := core.ReadSession(fs.Arg(0), sessionKeys())
+	if err != nil {
+		return fmt.Errorf("read session: %w", err)
+	}
+	switch format {
+	case "markdown":
+		if session.RunID != "" {
+			fmt.Fprintf(os.Stdout, "Run ID: %s\n\n", session.RunID)
+		}
+		return output.WriteTranscriptMarkdown(os.Stdout, session.Messages)
+	case "json":
+		return output.WriteJSON(os.Stdout, nil, session.Messages)
+	case string(llm.ProviderAnthropic), string(llm.ProviderOpenAI), string(llm.ProviderGemini):
+		b, err := llm.ExportMessages(session.Messages, llm.ProviderName(format))
+		if err != nil {
+			return fmt.Errorf("export messages: %w", err)
+		}
+		_, err = fmt.Fprintln(os.Stdout, string(b))
+		return err
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func firstPrompt(messages []llm.Message) string {
+	for _, msg := range messages {
+		if msg.Role != llm.RoleUser {
+			continue
+		}
+		for _, part := range msg.Parts {
+			if text, ok := part.(llm.TextContent); ok && text.Text != "" {
+				return text.Text
+			}
+		}
+	}
+	return ""
+}
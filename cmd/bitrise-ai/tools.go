@@ -0,0 +1,9 @@
+package main
+
+import (
+	// The toolsets available to specs, registered to tool.DefaultRegistry.
+	_ "github.com/bitrise-io/bitrise-ai-core/pkg/tools/git"
+	_ "github.com/bitrise-io/bitrise-ai-core/pkg/tools/patch"
+	_ "github.com/bitrise-io/bitrise-ai-core/pkg/tools/search"
+	_ "github.com/bitrise-io/bitrise-ai-core/pkg/tools/shell"
+)
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/simulate"
+)
+
+// pricingFlags adds the flags of the prices of a model.
+func pricingFlags(fs *flag.FlagSet, p *simulate.Pricing) {
+	fs.Float64Var(&p.Input, "input-price", 0, "price of input tokens in USD per million tokens")
+	fs.Float64Var(&p.Output, "output-price", 0, "price of output tokens in USD per million tokens")
+	fs.Float64Var(&p.CacheWrite, "cache-write-price", 0, "price of cache creation tokens in USD per million tokens")
+	fs.Float64Var(&p.CacheRead, "cache-read-price", 0, "price of cache read tokens in USD per million tokens")
+}
+
+func usageCmd(args []string) error {
+	var p simulate.Pricing
+	var perTurn bool
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	pricingFlags(fs, &p)
+	fs.BoolVar(&perTurn, "turns", false, "show the usage of every turn")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: bitrise-ai usage [flags] <session file>")
+	}
+
+	session, err := core.ReadSession(fs.Arg(0), sessionKeys())
+	if err != nil {
+		return fmt.Errorf("read session: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "turn\tinput\toutput\tcache write\tcache read\tcost (USD)\t")
+	var total llm.TokenUsage
+	var turns int
+	for _, msg := range session.Messages {
+		if msg.Role != llm.RoleAssistant {
+			continue
+		}
+		turns++
+		u := msg.Usage
+		total = total.Add(u)
+		if perTurn {
+			writeUsageRow(w, fmt.Sprint(turns), u, p)
+		}
+	}
+	writeUsageRow(w, "total", total, p)
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write usage: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "\nprompt cache: %s\n", core.NewCacheStats(total))
+	return nil
+}
+
+func writeUsageRow(w *tabwriter.Writer, label string, u llm.TokenUsage, p simulate.Pricing) {
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.4f\t\n",
+		label, u.InputTokens, u.OutputTokens, u.CacheCreationTokens, u.CacheReadTokens, p.Cost(u))
+}
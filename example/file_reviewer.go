@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/bitrise-io/bitrise-ai-core/pkg/agent"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jail"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
 )
 
@@ -16,21 +17,25 @@ type FileReviewerResult struct {
 	Comments       string `json:"comments" jsonschema_description:"Detailed comments about the file content."`
 }
 
-func NewFileReviewer(b *agent.Base) fileReviewer {
-	return fileReviewer{Base: b}
+func NewFileReviewer(b *agent.Base, workspace *jail.Jail) fileReviewer {
+	return fileReviewer{Base: b, readTool: newReadTool(workspace)}
 }
 
-type fileReviewer struct{ *agent.Base }
+type fileReviewer struct {
+	*agent.Base
+	readTool tool.Definition
+}
 
-func (r fileReviewer) Run(ctx context.Context, path string) (FileReviewerResult, agent.RunMeta, error) {
+func (r fileReviewer) Run(ctx context.Context, path string, maxTokens int) (FileReviewerResult, agent.RunMeta, error) {
 	return agent.Run[FileReviewerResult](ctx, r.Base, agent.RunParams{
-		System: systemFileReviewer,
-		Prompt: promptFileReviewer(path),
+		System:        systemFileReviewer,
+		Prompt:        promptFileReviewer(path),
+		MaxTokenUsage: maxTokens,
 		// In reality, we would read the file content and inject it into the
 		// prompt or in case of large files, enable reading parts of it via
 		// the read tool.
 		// This is simplified for the example.
-		Tools: []tool.Definition{readTool},
+		Tools: []tool.Definition{r.readTool},
 	})
 }
 
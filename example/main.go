@@ -7,9 +7,10 @@ import (
 	"log/slog"
 	"os"
 
-	"github.com/bitrise-io/bitrise-ai-core/pkg/agent"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/config"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jail"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
-	"github.com/jinzhu/configor"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/orchestrate"
 )
 
 func main() {
@@ -36,11 +37,17 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("read dir: %w", err)
 	}
+	workspace, err := jail.New(dir)
+	if err != nil {
+		return fmt.Errorf("new workspace jail: %w", err)
+	}
 
-	var cfg Config
-	if err := configor.Load(&cfg); err != nil {
+	cfg, err := config.Load(config.LoadParams{})
+	if err != nil {
 		return fmt.Errorf("load configuration: %w", err)
 	}
+	ctx, cancel := cfg.Context(ctx)
+	defer cancel()
 
 	logger := slog.New(
 		slog.NewTextHandler(
@@ -49,75 +56,52 @@ func run() error {
 		),
 	)
 
-	model := llm.Model{
-		Provider:        llm.ProviderName(cfg.ModelProvider),
-		Name:            cfg.Model,
-		MaxOutputTokens: cfg.MaxOutputTokens,
-	}
-	if err := model.SetDefaults(); err != nil {
-		return fmt.Errorf("set defaults on model: %w", err)
-	}
-	logger.Info(fmt.Sprintf("using model %+v", model))
-
-	agentBase := &agent.Base{
-		Model:            model,
-		MaxToolLogLength: cfg.MaxToolLogLength,
-		Logger:           logger,
-		CacheBust:        cfg.CacheBust,
-		SessionFilePath:  cfg.SessionFilePath,
-		MaxTokenUsage:    cfg.MaxTokenUsage,
-		Timebox:          cfg.Timebox,
+	agentBase, err := cfg.Base(logger)
+	if err != nil {
+		return fmt.Errorf("new agent base: %w", err)
 	}
+	logger.Info(fmt.Sprintf("using model %+v", agentBase.Model))
 
-	reviewer := NewFileReviewer(agentBase)
-	type workerResult struct {
-		file         string
-		reviewResult FileReviewerResult
-		err          error
-	}
-	cWorkerResults := make(chan workerResult)
-	var numFiles int
+	reviewer := NewFileReviewer(agentBase, workspace)
+	var paths []string
 	for _, entry := range dirEntries {
-		if entry.IsDir() {
-			continue
+		if !entry.IsDir() {
+			paths = append(paths, entry.Name()) // relative to the workspace
 		}
-		numFiles++
-
-		go func() {
-			filePath := fmt.Sprintf("%s/%s", dir, entry.Name())
-			reviewResult, _, err := reviewer.Run(ctx, filePath)
+	}
+	summarizer := NewSummarizer(agentBase)
+	result, err := orchestrate.MapReduce(ctx, paths, orchestrate.MapReduceParams[string, FileReviewerResult, string]{
+		MapParams: orchestrate.MapParams{MaxWorkers: cfg.MaxWorkers},
+		Map: func(ctx context.Context, path string, maxTokens int) (FileReviewerResult, llm.TokenUsage, error) {
+			reviewResult, meta, err := reviewer.Run(ctx, path, maxTokens)
 			if err != nil {
-				cWorkerResults <- workerResult{
-					file: entry.Name(),
-					err:  fmt.Errorf("review file %s: %w", filePath, err),
-				}
-				return
+				return FileReviewerResult{}, meta.Usage, fmt.Errorf("review file %s: %w", path, err)
 			}
-			cWorkerResults <- workerResult{
-				file:         entry.Name(),
-				reviewResult: reviewResult,
+			return reviewResult, meta.Usage, nil
+		},
+		Reduce: func(ctx context.Context, reviews []orchestrate.Result[string, FileReviewerResult], maxTokens int) (string, llm.TokenUsage, error) {
+			var files []string
+			var reviewResults []FileReviewerResult
+			for _, review := range reviews {
+				files = append(files, review.Item)
+				reviewResults = append(reviewResults, review.Value)
 			}
-		}()
+			summary, meta, err := summarizer.Run(ctx, files, reviewResults, maxTokens)
+			return summary, meta.Usage, err
+		},
+		MaxTokenUsage: cfg.MaxTokenUsage,
+		Progress: func(p orchestrate.Progress) {
+			logger.Info("reviewing files", "done", p.Done, "failed", p.Failed, "total", p.Total)
+		},
+	})
+	for _, failed := range result.Mapped.Failed() {
+		logger.Error(failed.Err.Error())
 	}
-	var files []string
-	var reviewResults []FileReviewerResult
-	for i := 0; i < numFiles; i++ {
-		result := <-cWorkerResults
-		if result.err != nil {
-			logger.Error(result.err.Error())
-			continue
-		}
-		files = append(files, result.file)
-		reviewResults = append(reviewResults, result.reviewResult)
-	}
-
-	summarizer := NewSummarizer(agentBase)
-	summary, _, err := summarizer.Run(ctx, files, reviewResults)
 	if err != nil {
-		return fmt.Errorf("summarize reviews: %w", err)
+		return fmt.Errorf("review files: %w", err)
 	}
 
-	fmt.Printf("Summary of reviews:\n\n%s\n\n", summary)
+	fmt.Printf("Summary of reviews:\n\n%s\n\n", result.Value)
 	logger.Info(fmt.Sprintf("total usage: %s", agentBase.LLMUsage()))
 
 	return nil
@@ -15,10 +15,11 @@ func NewSummarizer(b *agent.Base) summarizer {
 
 type summarizer struct{ *agent.Base }
 
-func (r summarizer) Run(ctx context.Context, files []string, reviews []FileReviewerResult) (string, agent.RunMeta, error) {
+func (r summarizer) Run(ctx context.Context, files []string, reviews []FileReviewerResult, maxTokens int) (string, agent.RunMeta, error) {
 	return agent.Run[string](ctx, r.Base, agent.RunParams{
-		System: systemSummarizer,
-		Prompt: promptSummarizer(files, reviews),
+		System:        systemSummarizer,
+		Prompt:        promptSummarizer(files, reviews),
+		MaxTokenUsage: maxTokens,
 	})
 }
 
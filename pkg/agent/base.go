@@ -1,36 +1,145 @@
 package agent
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
 	"sync"
 	"time"
 
+	"github.com/bitrise-io/bitrise-ai-core/pkg/audit"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/prompt/sanitize"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/workspace"
+	"github.com/invopop/jsonschema"
 )
 
 type Base struct {
 	// Mandatory fields:
-	Model            llm.Model
-	MaxToolLogLength int
-	Logger           *slog.Logger
+	Model  llm.Model
+	Logger *slog.Logger
 	// Optional fields:
-	CacheBust       bool
-	SessionFilePath string
-	MaxTokenUsage   int
-	Timebox         time.Duration
+	// MaxToolLogLength is the length of the tool logs to keep, defaults to
+	// core.DefaultMaxToolLogLength.
+	MaxToolLogLength int
+	CacheBust        bool
+	SessionFilePath  string
+	MaxTokenUsage    int
+	// Timebox limits the duration of each run. If unset, the deadline of the
+	// context is used.
+	Timebox time.Duration
+	// FinalTurnBuffer is reserved before the context deadline for the final
+	// result turn, defaults to core.DefaultFinalTurnBuffer.
+	FinalTurnBuffer time.Duration
+	// WorkspaceDir enables the workspace snapshot (OS, hardware, git state,
+	// project type) which is collected once and appended to the system
+	// prompt of every run.
+	WorkspaceDir string
+	// TokenEfficientTools and DisableParallelToolUse cut the token usage of
+	// heavy tool users, see llm.NewMessageParams.
+	TokenEfficientTools    bool
+	DisableParallelToolUse bool
+	// Sanitize pre-processes user prompts and tool results, e.g.
+	// sanitize.Default strips ANSI codes from CI logs (optional).
+	Sanitize sanitize.Func
+	// ReminderStrategy selects how system reminders are injected (optional).
+	ReminderStrategy core.ReminderStrategy
+	// MaxEmptyResponseNudges limits the consecutive empty responses of the
+	// model, defaults to core.DefaultMaxEmptyResponseNudges.
+	MaxEmptyResponseNudges int
+	// IDGenerator generates agent and run IDs, defaults to core.DefaultIDGenerator.
+	IDGenerator core.IDGenerator
+	// MaxParallelTools limits the tools running at the same time within a
+	// turn (optional, unlimited by default).
+	MaxParallelTools int
+	// MaxToolResultBytes truncates larger tool results (optional, unlimited by
+	// default), see core.NewAgentParams.MaxToolResultBytes.
+	MaxToolResultBytes int
+	// StrictHistory fails runs continuing from a corrupt history instead of
+	// repairing it (optional).
+	StrictHistory bool
+	// SessionKeys encrypts the session file (optional), see
+	// core.NewAgentParams.SessionKeys.
+	SessionKeys core.SessionKeyProvider
+	// SessionLock selects what happens if the session file is used by another
+	// agent (optional), defaults to core.SessionLockFail.
+	SessionLock core.SessionLockPolicy
+	// SessionRetention rotates oversized session files (optional).
+	SessionRetention core.SessionRetention
+	// SequentialToolCalls executes the tool calls of a turn one by one (optional),
+	// see core.NewAgentParams.SequentialToolCalls.
+	SequentialToolCalls bool
+	// SchemaFailurePolicy escalates if the results keep failing to parse
+	// (optional), see core.DefaultSchemaFailurePolicy.
+	SchemaFailurePolicy *core.SchemaFailurePolicy
+	// AuditLog records the tool invocations of every run (optional).
+	AuditLog *audit.Log
+	// Policy permits or denies the tool calls of every run (optional), it
+	// can be overridden by RunParams.Policy.
+	Policy *tool.Policy
+	// DryRun previews every run without calling the mutating tools (optional),
+	// see core.NewAgentParams.DryRun.
+	DryRun bool
+	// Sandbox runs the commands of the tools of every run in a container
+	// (optional), see core.NewAgentParams.EnableSandbox.
+	Sandbox *sandbox.Config
+	// ToolExecutor executes the tool calls of every run, e.g. on a remote
+	// worker (optional), see core.NewAgentParams.ToolExecutor.
+	ToolExecutor tool.Executor
+	// Secrets provides the credentials of the tools of every run (optional).
+	Secrets secrets.Secrets
+	// Limiter bounds the LLM requests and tool calls in flight (optional).
+	// Share one Limiter between the Bases of a process to limit all of its
+	// agents, see core.NewLimiter.
+	Limiter *core.Limiter
+	// Priority of the runs when waiting for the Limiter (optional), it can
+	// be overridden by RunParams.Priority.
+	Priority core.Priority
+	// EnrichTools appends usage examples and size hints to the tool
+	// descriptions of every run (optional), see tool.Enrich.
+	EnrichTools *tool.EnrichParams
+	// Clock measures the timeboxes and the cool-downs of the runs (optional),
+	// defaults to clock.Real, see core.NewAgentParams.Clock.
+	Clock clock.Clock
+	// SummarizeToolResults condenses the large tool results of every run
+	// with a cheap model (optional), see core.ToolResultSummaryParams.
+	SummarizeToolResults *core.ToolResultSummaryParams
 	// Internal fields:
-	llmUsage llm.TokenUsage
-	mu       sync.Mutex
+	llmUsage  llm.TokenUsage
+	workspace *workspace.Context
+	mu        sync.Mutex
+	// workspaceMu serializes collecting the workspace, see
+	// workspaceContext.
+	workspaceMu sync.Mutex
 }
 
 type RunMeta struct {
 	AgentID  int
+	RunID    string
 	Usage    llm.TokenUsage
 	Messages []llm.Message
+	Plan     tool.Plan
+	// Findings are the findings reported in the last run, including the
+	// ones of a failed run.
+	Findings []tool.Finding
+	// UsageBreakdown attributes Usage to phases and tools, it only covers the
+	// last run.
+	UsageBreakdown core.UsageBreakdown
+	// Timeline is the timing data of the last run.
+	Timeline core.Timeline
+	// CacheStats summarize the prompt caching of Usage.
+	CacheStats core.CacheStats
+	// Seed and Fingerprints allow best-effort replays, see core.RunResult.
+	Seed         int64
+	Fingerprints []string
+	// ToolStats are the statistics of the tool calls of the last run.
+	ToolStats map[string]core.ToolStats
 }
 
 type RunParams struct {
@@ -38,68 +147,305 @@ type RunParams struct {
 	System       string            // optional override
 	Tools        []tool.Definition // optional
 	PreviousMeta RunMeta           // optional to continue a conversation
+	Hooks        core.Hooks        // optional
+	// RunID correlates the run with external systems (optional, generated
+	// if empty).
+	RunID string
+	// Planning enables the UpdatePlan tool (optional).
+	Planning bool
+	// PlanReminderTurns re-injects the plan after this many turns without
+	// an update (optional, requires Planning).
+	PlanReminderTurns int
+	// Findings enables the EmitFinding tool (optional), the findings are
+	// reported with Hooks.OnFinding as they come and returned in the RunMeta.
+	Findings bool
+	// Critique enables a review of the final result before accepting it (optional).
+	Critique *CritiqueParams
+	// Resume continues a failed run from PreviousMeta (returned alongside the
+	// error) instead of sending Prompt as a new message. Prompt must be the
+	// original prompt of the run.
+	Resume bool
+	// Recovery resumes the run automatically after a failure (optional).
+	Recovery *RecoveryParams
+	// ReasoningEffort and Verbosity override the settings of the model for
+	// this run (optional, OpenAI reasoning models only).
+	ReasoningEffort llm.ReasoningEffort
+	Verbosity       llm.Verbosity
+	// GeminiGeneration overrides the Gemini generation settings of the model
+	// for this run (optional, Gemini only).
+	GeminiGeneration *llm.GeminiGenerationConfig
+	// Router selects the model of the run (or of every turn) instead of the
+	// model of the Base (optional).
+	Router *Router
+	// ResultSchema overrides the schema of the result generated from ResultT
+	// (optional), e.g. to run agents with json.RawMessage results defined in
+	// a spec.
+	ResultSchema *jsonschema.Schema
+	// Timebox overrides the timebox of the Base for this run (optional).
+	Timebox time.Duration
+	// MaxTokenUsage limits the tokens this run may use, on top of the budget
+	// shared by the runs of the Base (optional).
+	MaxTokenUsage int
+	// Seed replays a run with the seed of its RunMeta (optional). Defaults
+	// to the seed of PreviousMeta, no seed is sent if neither is set.
+	Seed *int64
+	// Policy overrides the tool policy of the Base for this run (optional).
+	Policy *tool.Policy
+	// DryRun previews this run without calling the mutating tools (optional).
+	DryRun bool
+	// Priority overrides the priority of the Base for this run (optional).
+	Priority *core.Priority
+	// Hedge sends the requests of this run to a second model too when the
+	// model of the run is slow to respond (optional).
+	Hedge *HedgeParams
+	// FinalResultToolName and FinalResultToolDescription override the name
+	// and the description of the tool returning the result (optional), e.g.
+	// if "FinalResult" conflicts with the prompt.
+	FinalResultToolName        string
+	FinalResultToolDescription string
+	// FreeText finishes the run when the model responds without tool calls,
+	// taking the text of the response as the result instead of waiting for a
+	// FinalResult call (optional, ResultT must be string).
+	FreeText bool
+}
+
+// HedgeParams configure request hedging, see llm.HedgedProvider.
+type HedgeParams struct {
+	// Model answers the requests the model of the run didn't answer within
+	// Delay (mandatory), typically a model of another provider.
+	Model llm.Model
+	Delay time.Duration
+}
+
+type CritiqueParams struct {
+	// Model reviews the result, defaults to the model of the Base.
+	Model *llm.Model
+	// Criteria are the instructions the result is checked against (mandatory).
+	Criteria string
+	// MaxRevisions is the number of times the model can revise its result
+	// after a rejection.
+	MaxRevisions int
 }
 
 // Run runs the base agent with the given parameters.
 // Its a method for the Base struct, but Go does not support generic methods.
 func Run[ResultT any](ctx context.Context, b *Base, p RunParams) (ResultT, RunMeta, error) {
-	provider, err := b.Model.NewProvider(ctx)
+	return run[ResultT](ctx, b, b.Model, b.SessionFilePath, p)
+}
+
+// run runs an agent with the given model, sharing the budget and usage of the
+// Base. On failure the returned RunMeta contains the partial conversation.
+func run[ResultT any](ctx context.Context, b *Base, model llm.Model, sessionFilePath string, p RunParams) (ResultT, RunMeta, error) {
+	if p.Router != nil && !p.Router.PerTurn {
+		routed, err := p.Router.route(ctx, b, p)
+		if err != nil {
+			return *new(ResultT), RunMeta{}, fmt.Errorf("route model: %w", err)
+		}
+		model = routed
+	}
+	if p.ReasoningEffort != "" {
+		model.ReasoningEffort = p.ReasoningEffort
+	}
+	if p.Verbosity != "" {
+		model.Verbosity = p.Verbosity
+	}
+	if p.GeminiGeneration != nil {
+		model.GeminiGeneration = p.GeminiGeneration
+	}
+	if p.Recovery != nil {
+		return runWithRecovery[ResultT](ctx, b, model, sessionFilePath, p)
+	}
+	return runOnce[ResultT](ctx, b, model, sessionFilePath, p)
+}
+
+func runOnce[ResultT any](ctx context.Context, b *Base, model llm.Model, sessionFilePath string, p RunParams) (ResultT, RunMeta, error) {
+	var provider llm.Provider
+	var err error
+	if p.Router != nil && p.Router.PerTurn {
+		provider, err = p.Router.newProvider(ctx)
+	} else {
+		provider, err = model.NewProvider(ctx)
+	}
 	if err != nil {
 		return *new(ResultT), RunMeta{}, fmt.Errorf("new provider: %w", err)
 	}
+	if b.Logger != nil {
+		llm.WarnDeprecated(b.Logger, model)
+	}
+	if p.Hedge != nil {
+		secondary, err := p.Hedge.Model.NewProvider(ctx)
+		if err != nil {
+			return *new(ResultT), RunMeta{}, fmt.Errorf("new hedge provider: %w", err)
+		}
+		provider = &llm.HedgedProvider{Primary: provider, Secondary: secondary, Delay: p.Hedge.Delay}
+	}
+
+	var critique *core.CritiqueParams
+	if p.Critique != nil {
+		critique = &core.CritiqueParams{
+			Criteria:     p.Critique.Criteria,
+			MaxRevisions: p.Critique.MaxRevisions,
+		}
+		if p.Critique.Model != nil {
+			critique.LLM, err = p.Critique.Model.NewProvider(ctx)
+			if err != nil {
+				return *new(ResultT), RunMeta{}, fmt.Errorf("new critique provider: %w", err)
+			}
+		}
+	}
 
+	var systemBlocks []llm.SystemPromptBlock
+	if b.WorkspaceDir != "" {
+		// The workspace snapshot is kept out of the cached instructions.
+		systemBlocks = []llm.SystemPromptBlock{
+			{Text: p.System, Cacheable: true},
+			{Text: b.workspaceContext(ctx).Render()},
+		}
+	}
+
+	timebox := b.Timebox
+	if p.Timebox > 0 {
+		timebox = p.Timebox
+	}
 	var timeboxedUntil time.Time
-	if b.Timebox > 0 {
-		timeboxedUntil = time.Now().Add(b.Timebox)
-	}
-	agentInstance, err := core.NewAgent[ResultT](core.NewAgentParams{
-		AgentID:          p.PreviousMeta.AgentID,
-		SystemPrompt:     p.System,
-		LLM:              provider,
-		SessionFilePath:  b.SessionFilePath,
-		MaxToolLogLength: b.MaxToolLogLength,
-		Tools:            p.Tools,
-		Logger:           b.Logger,
-		TimeboxedUntil:   timeboxedUntil,
-		MaxTokenUsage:    b.MaxTokenUsage - int(b.LLMUsage().Total()),
-		CacheBust:        b.CacheBust,
-		LLMMessages:      p.PreviousMeta.Messages,
-		InitialUsage:     p.PreviousMeta.Usage,
-	})
+	if timebox > 0 {
+		timeboxedUntil = clock.Or(b.Clock).Now().Add(timebox)
+	}
+	maxTokenUsage := b.MaxTokenUsage - int(b.LLMUsage().Total())
+	if p.MaxTokenUsage > 0 {
+		// The usage of the agent includes the usage of the previous runs.
+		runLimit := int(p.PreviousMeta.Usage.Total()) + p.MaxTokenUsage
+		if b.MaxTokenUsage <= 0 || runLimit < maxTokenUsage {
+			maxTokenUsage = runLimit
+		}
+	}
+	seed := p.Seed
+	if seed == nil && p.PreviousMeta.Seed != 0 {
+		seed = &p.PreviousMeta.Seed
+	}
+	params := b.agentParams()
+	params.AgentID = p.PreviousMeta.AgentID
+	params.RunID = p.RunID
+	params.SystemPrompt = p.System
+	params.SystemPromptBlocks = systemBlocks
+	params.LLM = provider
+	params.SessionFilePath = sessionFilePath
+	params.Tools = p.Tools
+	params.TimeboxedUntil = timeboxedUntil
+	params.MaxTokenUsage = maxTokenUsage
+	params.LLMMessages = p.PreviousMeta.Messages
+	params.InitialUsage = p.PreviousMeta.Usage
+	params.Hooks = p.Hooks
+	params.EnablePlanning = p.Planning
+	params.PlanReminderTurns = p.PlanReminderTurns
+	params.EnableFindings = p.Findings
+	params.Critique = critique
+	params.ResultSchema = p.ResultSchema
+	params.Seed = seed
+	params.Policy = cmp.Or(p.Policy, params.Policy)
+	params.DryRun = params.DryRun || p.DryRun
+	if p.Priority != nil {
+		params.Priority = *p.Priority
+	}
+	params.FinalResultToolName = p.FinalResultToolName
+	params.FinalResultToolDescription = p.FinalResultToolDescription
+	params.FreeTextResult = p.FreeText
+	agentInstance, err := core.NewAgent[ResultT](params)
 	if err != nil {
 		return *new(ResultT), RunMeta{}, fmt.Errorf("new agent: %w", err)
 	}
-	res, err := agentInstance.Run(ctx, p.Prompt)
-	// Result could be nil in case of an error (e.g.: budget exceeded), or if the agent is canceled.
-	if res != nil {
-		additionalUsage := res.TotalUsage
-		additionalUsage.InputTokens -= p.PreviousMeta.Usage.InputTokens
-		additionalUsage.OutputTokens -= p.PreviousMeta.Usage.OutputTokens
-		additionalUsage.CacheCreationTokens -= p.PreviousMeta.Usage.CacheCreationTokens
-		additionalUsage.CacheReadTokens -= p.PreviousMeta.Usage.CacheReadTokens
-		b.addUsage(additionalUsage)
+	var res *core.RunResult[ResultT]
+	if p.Resume {
+		res, err = agentInstance.Resume(ctx, p.Prompt)
+	} else {
+		res, err = agentInstance.Run(ctx, p.Prompt)
 	}
+	// Usage is counted even if the run failed (e.g.: budget exceeded), or if the agent is canceled.
+	additionalUsage := agentInstance.Usage()
+	additionalUsage.InputTokens -= p.PreviousMeta.Usage.InputTokens
+	additionalUsage.OutputTokens -= p.PreviousMeta.Usage.OutputTokens
+	additionalUsage.CacheCreationTokens -= p.PreviousMeta.Usage.CacheCreationTokens
+	additionalUsage.CacheReadTokens -= p.PreviousMeta.Usage.CacheReadTokens
+	b.addUsage(additionalUsage)
 	if err != nil {
-		return *new(ResultT), RunMeta{}, fmt.Errorf("run agent: %w", err)
+		// The partial meta allows resuming the run (see RunParams.Resume).
+		return *new(ResultT), RunMeta{
+			AgentID:   agentInstance.AgentNum(),
+			RunID:     agentInstance.RunID(),
+			Usage:     agentInstance.Usage(),
+			Messages:  agentInstance.Messages(),
+			Seed:      agentInstance.Seed(),
+			ToolStats: agentInstance.ToolStats(),
+			Findings:  agentInstance.Findings(),
+		}, fmt.Errorf("run agent: %w", err)
 	}
 	if res == nil {
 		return *new(ResultT), RunMeta{}, fmt.Errorf("agent returned nil result")
 	}
 	return res.Data, RunMeta{
-		AgentID:  agentInstance.AgentNum(),
-		Usage:    res.TotalUsage,
-		Messages: res.Messages,
+		AgentID:        agentInstance.AgentNum(),
+		RunID:          res.RunID,
+		Usage:          res.TotalUsage,
+		Messages:       res.Messages,
+		Plan:           res.Plan,
+		Findings:       res.Findings,
+		UsageBreakdown: res.UsageBreakdown,
+		Timeline:       res.Timeline,
+		CacheStats:     res.CacheStats,
+		Seed:           res.Seed,
+		Fingerprints:   res.Fingerprints,
+		ToolStats:      res.ToolStats,
 	}, nil
 }
 
+// agentParams returns the parameters of the agents of the runs set by the
+// Base, the runs set the rest.
+func (b *Base) agentParams() core.NewAgentParams {
+	var sandboxConfig sandbox.Config
+	if b.Sandbox != nil {
+		sandboxConfig = *b.Sandbox
+	}
+	return core.NewAgentParams{
+		IDGenerator:            b.IDGenerator,
+		SessionFilePath:        b.SessionFilePath,
+		MaxToolLogLength:       b.MaxToolLogLength,
+		Logger:                 b.Logger,
+		FinalTurnBuffer:        b.FinalTurnBuffer,
+		TokenEfficientTools:    b.TokenEfficientTools,
+		DisableParallelToolUse: b.DisableParallelToolUse,
+		MaxTokenUsage:          b.MaxTokenUsage,
+		CacheBust:              b.CacheBust,
+		Sanitize:               b.Sanitize,
+		ReminderStrategy:       b.ReminderStrategy,
+		MaxEmptyResponseNudges: b.MaxEmptyResponseNudges,
+		MaxParallelTools:       b.MaxParallelTools,
+		MaxToolResultBytes:     b.MaxToolResultBytes,
+		StrictHistory:          b.StrictHistory,
+		SessionKeys:            b.SessionKeys,
+		SessionLock:            b.SessionLock,
+		SessionRetention:       b.SessionRetention,
+		SequentialToolCalls:    b.SequentialToolCalls,
+		SchemaFailurePolicy:    b.SchemaFailurePolicy,
+		AuditLog:               b.AuditLog,
+		Policy:                 b.Policy,
+		DryRun:                 b.DryRun,
+		EnableSandbox:          b.Sandbox != nil,
+		Sandbox:                sandboxConfig,
+		ToolExecutor:           b.ToolExecutor,
+		Secrets:                b.Secrets,
+		Limiter:                b.Limiter,
+		Priority:               b.Priority,
+		EnrichTools:            b.EnrichTools,
+		Clock:                  b.Clock,
+		SummarizeToolResults:   b.SummarizeToolResults,
+	}
+}
+
 func (b *Base) addUsage(u llm.TokenUsage) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.llmUsage.InputTokens += u.InputTokens
-	b.llmUsage.OutputTokens += u.OutputTokens
-	b.llmUsage.CacheCreationTokens += u.CacheCreationTokens
-	b.llmUsage.CacheReadTokens += u.CacheReadTokens
+	b.llmUsage = b.llmUsage.Add(u)
 }
 
 func (b *Base) LLMUsage() llm.TokenUsage {
@@ -107,3 +453,38 @@ func (b *Base) LLMUsage() llm.TokenUsage {
 	defer b.mu.Unlock()
 	return b.llmUsage
 }
+
+// workspaceContext returns the workspace snapshot, collected once per Base.
+// mu is not held while the commands of the collection run, so the
+// concurrent runs can account their usage meanwhile.
+func (b *Base) workspaceContext(ctx context.Context) workspace.Context {
+	b.workspaceMu.Lock()
+	defer b.workspaceMu.Unlock()
+	b.mu.Lock()
+	ws := b.workspace
+	b.mu.Unlock()
+	if ws != nil {
+		return *ws
+	}
+
+	// The snapshot outlives the run, so it doesn't depend on the
+	// cancellation of its context. A snapshot cut short by the timeout is
+	// not kept, the next run collects it again.
+	collectCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), workspace.CollectTimeout)
+	defer cancel()
+	wc := workspace.Collect(collectCtx, b.WorkspaceDir)
+	if collectCtx.Err() == nil {
+		b.mu.Lock()
+		b.workspace = &wc
+		b.mu.Unlock()
+	}
+	return wc
+}
+
+// logger returns the Logger of the base, or slog.Default() if it's not set.
+func (b *Base) logger() *slog.Logger {
+	if b.Logger == nil {
+		return slog.Default()
+	}
+	return b.Logger
+}
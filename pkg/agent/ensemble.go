@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+type EnsembleParams[ResultT any] struct {
+	// Models run the same agent independently (mandatory, at least 2).
+	Models []llm.Model
+	// Judge resolves disagreements when no result reaches the quorum
+	// (optional). Without a judge, a missing quorum is an error.
+	Judge *llm.Model
+	// Quorum is the number of identical results needed to accept a result
+	// without the judge. Defaults to a strict majority of the successful runs.
+	Quorum int
+	// Key extracts the part of the result the models must agree on, e.g. a
+	// classification field. Defaults to the JSON encoding of the whole result.
+	Key func(ResultT) string
+}
+
+// EnsembleVote is the outcome of a single model's run.
+type EnsembleVote[ResultT any] struct {
+	Model llm.Model
+	Data  ResultT
+	Meta  RunMeta
+	Err   error
+}
+
+type EnsembleResult[ResultT any] struct {
+	Data  ResultT
+	Votes []EnsembleVote[ResultT]
+	// Agreement is the ratio of successful runs which returned the accepted
+	// result (by Key).
+	Agreement float64
+	// Judged is set if the judge model decided the result.
+	Judged    bool
+	JudgeMeta RunMeta
+}
+
+const systemJudge = "You are a judge. Several independent AI agents worked on the same task and returned different results. " +
+	"Weigh their results against the task, decide which one is correct (or combine them if needed) " +
+	"and return the final result."
+
+// RunEnsemble runs the agent described by p with every model in parallel,
+// and returns the majority result, or the judge's decision if the models
+// disagree. Session files are not used by ensemble runs.
+func RunEnsemble[ResultT any](ctx context.Context, b *Base, p RunParams, e EnsembleParams[ResultT]) (EnsembleResult[ResultT], error) {
+	if len(e.Models) < 2 {
+		return EnsembleResult[ResultT]{}, fmt.Errorf("ensemble needs at least 2 models, got %d", len(e.Models))
+	}
+	key := e.Key
+	if key == nil {
+		key = func(v ResultT) string {
+			out, _ := json.Marshal(v)
+			return string(out)
+		}
+	}
+
+	p.Router, p.Hedge = nil, nil // the models are given by the ensemble
+
+	votes := make([]EnsembleVote[ResultT], len(e.Models))
+	var wg sync.WaitGroup
+	for i, model := range e.Models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, meta, err := run[ResultT](ctx, b, model, "", p)
+			votes[i] = EnsembleVote[ResultT]{Model: model, Data: data, Meta: meta, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	var successful []EnsembleVote[ResultT]
+	var bestKey string
+	for _, v := range votes {
+		if v.Err != nil {
+			b.logger().Warn("ensemble run failed", "model", v.Model.Name, "error", v.Err)
+			continue
+		}
+		successful = append(successful, v)
+		k := key(v.Data)
+		counts[k]++
+		if counts[k] > counts[bestKey] {
+			bestKey = k
+		}
+	}
+	if len(successful) == 0 {
+		errs := make([]error, len(votes))
+		for i, v := range votes {
+			errs[i] = fmt.Errorf("%s: %w", v.Model.Name, v.Err)
+		}
+		return EnsembleResult[ResultT]{Votes: votes}, fmt.Errorf("all ensemble runs failed: %w", errors.Join(errs...))
+	}
+
+	res := EnsembleResult[ResultT]{
+		Votes:     votes,
+		Agreement: float64(counts[bestKey]) / float64(len(successful)),
+	}
+	quorum := e.Quorum
+	if quorum <= 0 {
+		quorum = len(successful)/2 + 1
+	}
+	if counts[bestKey] >= quorum {
+		for _, v := range successful {
+			if key(v.Data) == bestKey {
+				res.Data = v.Data
+				break
+			}
+		}
+		return res, nil
+	}
+
+	if e.Judge == nil {
+		return res, fmt.Errorf("no quorum: the most common result has %d of %d votes, %d needed", counts[bestKey], len(successful), quorum)
+	}
+	// The judge returns a result of the same shape as the runs.
+	judgeParams := RunParams{
+		System:                     systemJudge,
+		Prompt:                     promptJudge(p, successful),
+		Hooks:                      p.Hooks,
+		ResultSchema:               p.ResultSchema,
+		FinalResultToolName:        p.FinalResultToolName,
+		FinalResultToolDescription: p.FinalResultToolDescription,
+		FreeText:                   p.FreeText,
+	}
+	data, meta, err := run[ResultT](ctx, b, *e.Judge, "", judgeParams)
+	if err != nil {
+		return res, fmt.Errorf("run judge: %w", err)
+	}
+	res.Data = data
+	res.Judged = true
+	res.JudgeMeta = meta
+	return res, nil
+}
+
+func promptJudge[ResultT any](p RunParams, votes []EnsembleVote[ResultT]) string {
+	var results []string
+	for i, v := range votes {
+		b, _ := json.Marshal(v.Data)
+		results = append(results, fmt.Sprintf("<result agent=\"%d\">%s</result>", i+1, b))
+	}
+	instruction := fmt.Sprintf("Decide on the correct result and return it by calling the %q tool.",
+		cmp.Or(p.FinalResultToolName, tool.FinalResultToolName))
+	if p.FreeText {
+		instruction = "Decide on the correct result and respond with it."
+	}
+	return fmt.Sprintf(
+		"<task_instructions>%s</task_instructions>\n\n<task>%s</task>\n\n%s\n\n%s",
+		p.System, p.Prompt, strings.Join(results, "\n"), instruction,
+	)
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/invopop/jsonschema"
+)
+
+// RunOption sets an optional field of RunParams. Options are an alternative
+// to RunParams literals which keep compiling as new knobs are added.
+type RunOption func(*RunParams)
+
+// NewRunParams creates the RunParams of a prompt with the given options.
+func NewRunParams(prompt string, opts ...RunOption) RunParams {
+	p := RunParams{Prompt: prompt}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p
+}
+
+// RunWith runs the base agent with the given prompt and options, see Run.
+func RunWith[ResultT any](ctx context.Context, b *Base, prompt string, opts ...RunOption) (ResultT, RunMeta, error) {
+	return Run[ResultT](ctx, b, NewRunParams(prompt, opts...))
+}
+
+// WithSystem sets the system prompt.
+func WithSystem(system string) RunOption {
+	return func(p *RunParams) { p.System = system }
+}
+
+// WithTools adds tools to the run.
+func WithTools(tools ...tool.Definition) RunOption {
+	return func(p *RunParams) { p.Tools = append(p.Tools, tools...) }
+}
+
+// WithHooks sets the hooks of the run.
+func WithHooks(hooks core.Hooks) RunOption {
+	return func(p *RunParams) { p.Hooks = hooks }
+}
+
+// WithTimebox limits the duration of the run, overriding Base.Timebox.
+func WithTimebox(d time.Duration) RunOption {
+	return func(p *RunParams) { p.Timebox = d }
+}
+
+// WithBudget limits the tokens the run may use.
+func WithBudget(maxTokenUsage int) RunOption {
+	return func(p *RunParams) { p.MaxTokenUsage = maxTokenUsage }
+}
+
+// WithPrevious continues the conversation of a previous run.
+func WithPrevious(meta RunMeta) RunOption {
+	return func(p *RunParams) { p.PreviousMeta = meta }
+}
+
+// WithRunID sets the ID correlating the run with external systems.
+func WithRunID(runID string) RunOption {
+	return func(p *RunParams) { p.RunID = runID }
+}
+
+// WithPlanning enables the UpdatePlan tool, the plan is re-injected after
+// reminderTurns turns without an update (0 disables the reminder).
+func WithPlanning(reminderTurns int) RunOption {
+	return func(p *RunParams) {
+		p.Planning = true
+		p.PlanReminderTurns = reminderTurns
+	}
+}
+
+// WithFindings enables the EmitFinding tool.
+func WithFindings() RunOption {
+	return func(p *RunParams) { p.Findings = true }
+}
+
+// WithCritique enables a review of the final result.
+func WithCritique(critique CritiqueParams) RunOption {
+	return func(p *RunParams) { p.Critique = &critique }
+}
+
+// WithRecovery resumes the run automatically after a failure.
+func WithRecovery(recovery RecoveryParams) RunOption {
+	return func(p *RunParams) { p.Recovery = &recovery }
+}
+
+// WithRouter selects the model with a router.
+func WithRouter(router *Router) RunOption {
+	return func(p *RunParams) { p.Router = router }
+}
+
+// WithPolicy overrides the tool policy of the Base.
+func WithPolicy(policy *tool.Policy) RunOption {
+	return func(p *RunParams) { p.Policy = policy }
+}
+
+// WithDryRun previews the run without calling the mutating tools.
+func WithDryRun() RunOption {
+	return func(p *RunParams) { p.DryRun = true }
+}
+
+// WithPriority overrides the priority of the run when waiting for the
+// limiter of the Base.
+func WithPriority(priority core.Priority) RunOption {
+	return func(p *RunParams) { p.Priority = &priority }
+}
+
+// WithHedge sends the requests to the given model too if the model of the
+// run hasn't responded after delay.
+func WithHedge(model llm.Model, delay time.Duration) RunOption {
+	return func(p *RunParams) { p.Hedge = &HedgeParams{Model: model, Delay: delay} }
+}
+
+// WithResultSchema overrides the schema of the result.
+func WithResultSchema(schema *jsonschema.Schema) RunOption {
+	return func(p *RunParams) { p.ResultSchema = schema }
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/audit"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// BaseOverrides are the settings of a Base derived for a tenant, zero values
+// keep the settings of the parent.
+type BaseOverrides struct {
+	Model         *llm.Model
+	Logger        *slog.Logger
+	MaxTokenUsage int
+	Timebox       time.Duration
+	// SessionFilePath and SessionKeys select the session store of the tenant.
+	SessionFilePath string
+	SessionKeys     core.SessionKeyProvider
+	WorkspaceDir    string
+	Policy          *tool.Policy
+	Secrets         secrets.Secrets
+	AuditLog        *audit.Log
+	ToolExecutor    tool.Executor
+	Priority        *core.Priority
+}
+
+// WithOverrides returns a Base derived from b with the overrides applied,
+// e.g. to serve several tenants from one configured Base. The derived Base
+// has its own usage counter and budget, starting from zero, so the usage of
+// the tenants is not shared. Deriving is cheap, the settings are copied
+// shallowly.
+func (b *Base) WithOverrides(o BaseOverrides) *Base {
+	d := b.clone()
+	if o.Model != nil {
+		d.Model = *o.Model
+	}
+	if o.Logger != nil {
+		d.Logger = o.Logger
+	}
+	if o.MaxTokenUsage != 0 {
+		d.MaxTokenUsage = o.MaxTokenUsage
+	}
+	if o.Timebox != 0 {
+		d.Timebox = o.Timebox
+	}
+	if o.SessionFilePath != "" {
+		d.SessionFilePath = o.SessionFilePath
+	}
+	if o.SessionKeys != nil {
+		d.SessionKeys = o.SessionKeys
+	}
+	if o.WorkspaceDir != "" && o.WorkspaceDir != b.WorkspaceDir {
+		d.WorkspaceDir = o.WorkspaceDir
+		d.workspace = nil // collected for the new directory
+	}
+	if o.Policy != nil {
+		d.Policy = o.Policy
+	}
+	if o.Secrets != nil {
+		d.Secrets = o.Secrets
+	}
+	if o.AuditLog != nil {
+		d.AuditLog = o.AuditLog
+	}
+	if o.ToolExecutor != nil {
+		d.ToolExecutor = o.ToolExecutor
+	}
+	if o.Priority != nil {
+		d.Priority = *o.Priority
+	}
+	return d
+}
+
+// clone copies the settings of b, but not its usage. Keep it in sync with
+// the fields of Base.
+func (b *Base) clone() *Base {
+	b.mu.Lock()
+	ws := b.workspace
+	b.mu.Unlock()
+	return &Base{
+		Model:                  b.Model,
+		MaxToolLogLength:       b.MaxToolLogLength,
+		Logger:                 b.Logger,
+		CacheBust:              b.CacheBust,
+		SessionFilePath:        b.SessionFilePath,
+		MaxTokenUsage:          b.MaxTokenUsage,
+		Timebox:                b.Timebox,
+		FinalTurnBuffer:        b.FinalTurnBuffer,
+		WorkspaceDir:           b.WorkspaceDir,
+		TokenEfficientTools:    b.TokenEfficientTools,
+		DisableParallelToolUse: b.DisableParallelToolUse,
+		Sanitize:               b.Sanitize,
+		ReminderStrategy:       b.ReminderStrategy,
+		MaxEmptyResponseNudges: b.MaxEmptyResponseNudges,
+		IDGenerator:            b.IDGenerator,
+		MaxParallelTools:       b.MaxParallelTools,
+		MaxToolResultBytes:     b.MaxToolResultBytes,
+		StrictHistory:          b.StrictHistory,
+		SessionKeys:            b.SessionKeys,
+		SessionLock:            b.SessionLock,
+		SessionRetention:       b.SessionRetention,
+		SequentialToolCalls:    b.SequentialToolCalls,
+		SchemaFailurePolicy:    b.SchemaFailurePolicy,
+		AuditLog:               b.AuditLog,
+		Policy:                 b.Policy,
+		DryRun:                 b.DryRun,
+		Sandbox:                b.Sandbox,
+		ToolExecutor:           b.ToolExecutor,
+		Secrets:                b.Secrets,
+		Limiter:                b.Limiter, // shared, like the process
+		Priority:               b.Priority,
+		EnrichTools:            b.EnrichTools,
+		Clock:                  b.Clock,
+		SummarizeToolResults:   b.SummarizeToolResults,
+		workspace:              ws, // immutable once collected
+	}
+}
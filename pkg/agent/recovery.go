@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+type RecoveryParams struct {
+	// MaxAttempts is the number of times a failed run is resumed (mandatory).
+	MaxAttempts int
+	// CoolDown is waited before each resume (optional).
+	CoolDown time.Duration
+	// FallbackModels are used for the resumes in order, the last one is kept
+	// for the remaining attempts (optional). Defaults to the model of the run,
+	// or the expensive model of the Router after its MaxRetries.
+	FallbackModels []llm.Model
+	// Retryable decides whether a failure can be recovered from (optional).
+	// By default every error is retried, except context cancellation and an
+	// exceeded token budget, see core.RunError.Retryable.
+	Retryable func(error) bool
+}
+
+// runWithRecovery runs the agent, and resumes it from its last message history
+// if it fails, so the progress of the previous turns is not lost.
+func runWithRecovery[ResultT any](ctx context.Context, b *Base, model llm.Model, sessionFilePath string, p RunParams) (ResultT, RunMeta, error) {
+	r := p.Recovery
+	retryable := r.Retryable
+	if retryable == nil {
+		retryable = defaultRetryable
+	}
+	p.Recovery = nil
+
+	data, meta, err := runOnce[ResultT](ctx, b, model, sessionFilePath, p)
+	for attempt := 1; err != nil && attempt <= r.MaxAttempts; attempt++ {
+		if ctx.Err() != nil || !retryable(err) || len(meta.Messages) == 0 {
+			break
+		}
+		switch {
+		case len(r.FallbackModels) > 0:
+			model = r.FallbackModels[min(attempt, len(r.FallbackModels))-1]
+		case p.Router != nil && !p.Router.PerTurn && p.Router.Escalate(RouteSignals{Retries: attempt}):
+			model = p.Router.Expensive
+		}
+		b.logger().Warn("run failed, resuming",
+			"attempt", attempt, "model", model.Name, "messages", len(meta.Messages), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return data, meta, fmt.Errorf("wait for cool-down: %w", ctx.Err())
+		case <-clock.Or(b.Clock).After(r.CoolDown):
+		}
+
+		p.PreviousMeta = meta
+		p.RunID = meta.RunID
+		p.Resume = true
+		var resumeMeta RunMeta
+		data, resumeMeta, err = runOnce[ResultT](ctx, b, model, sessionFilePath, p)
+		if len(resumeMeta.Messages) > 0 {
+			// The findings of the failed attempts are not in the history.
+			resumeMeta.Findings = append(meta.Findings, resumeMeta.Findings...)
+			meta = resumeMeta
+		}
+	}
+	return data, meta, err
+}
+
+func defaultRetryable(err error) bool {
+	var runErr *core.RunError
+	if errors.As(err, &runErr) {
+		return runErr.Retryable()
+	}
+	return !errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) &&
+		!errors.Is(err, core.ErrMaxTokenUsageExceeded)
+}
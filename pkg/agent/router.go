@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// Router picks between a cheap and an expensive model based on the complexity
+// of the task, to reduce the cost of simple tasks.
+type Router struct {
+	Cheap     llm.Model // mandatory
+	Expensive llm.Model // mandatory
+	// MaxPromptChars, MaxTools and MaxTurns are the limits of the cheap model,
+	// exceeding any of them selects the expensive model. Zero values use the
+	// defaults. MaxTurns only applies to per turn routing.
+	MaxPromptChars int
+	MaxTools       int
+	MaxTurns       int
+	// MaxRetries is the number of resumes (see RecoveryParams) allowed with the
+	// cheap model, after that the expensive model is used.
+	MaxRetries int
+	// Classifier asks the cheap model to rate the complexity of the task if
+	// the heuristics selected the cheap model (per run routing only).
+	Classifier bool
+	// PerTurn routes every turn instead of the whole run: the run starts with
+	// the cheap model and escalates to the expensive one once the conversation
+	// gets too long. It never switches back, to keep the prompt cache warm.
+	PerTurn bool
+}
+
+const (
+	DefaultRouterMaxPromptChars = 20000
+	DefaultRouterMaxTools       = 15
+	DefaultRouterMaxTurns       = 10
+)
+
+// RouteSignals are the inputs of the routing heuristics.
+type RouteSignals struct {
+	PromptChars int
+	Tools       int
+	Turns       int
+	Retries     int
+}
+
+// Escalate reports whether the expensive model should be used.
+func (r Router) Escalate(s RouteSignals) bool {
+	return s.PromptChars > withDefault(r.MaxPromptChars, DefaultRouterMaxPromptChars) ||
+		s.Tools > withDefault(r.MaxTools, DefaultRouterMaxTools) ||
+		s.Turns > withDefault(r.MaxTurns, DefaultRouterMaxTurns) ||
+		s.Retries > r.MaxRetries
+}
+
+type routeClassification struct {
+	Complex bool   `json:"complex" jsonschema_description:"Whether the task needs a more capable model"`
+	Reason  string `json:"reason" jsonschema_description:"Short justification"`
+}
+
+const systemRouteClassifier = "You decide whether a task given to an AI agent is simple enough for a small, fast model, " +
+	"or complex enough to need a more capable one. Complex tasks need multi-step reasoning, " +
+	"non-trivial code changes or investigating an unknown root cause. Do not solve the task."
+
+// route selects the model of a whole run.
+func (r Router) route(ctx context.Context, b *Base, p RunParams) (llm.Model, error) {
+	signals := RouteSignals{
+		PromptChars: len(p.System) + len(p.Prompt),
+		Tools:       len(p.Tools),
+	}
+	if r.Escalate(signals) {
+		b.logger().Info("router selected the expensive model", "model", r.Expensive.Name, "signals", signals)
+		return r.Expensive, nil
+	}
+	if !r.Classifier {
+		return r.Cheap, nil
+	}
+
+	c, _, err := run[routeClassification](ctx, b, r.Cheap, "", RunParams{
+		System: systemRouteClassifier,
+		Prompt: fmt.Sprintf(
+			"<task_instructions>%s</task_instructions>\n\n<task>%s</task>\n\n"+
+				"Classify the task and return your decision by calling the %q tool.",
+			p.System, p.Prompt, tool.FinalResultToolName,
+		),
+	})
+	if err != nil {
+		return llm.Model{}, fmt.Errorf("classify task: %w", err)
+	}
+	b.logger().Info("router classified the task", "complex", c.Complex, "reason", c.Reason)
+	if c.Complex {
+		return r.Expensive, nil
+	}
+	return r.Cheap, nil
+}
+
+// newProvider returns a provider which routes every turn.
+func (r Router) newProvider(ctx context.Context) (llm.Provider, error) {
+	cheap, err := r.Cheap.NewProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("new cheap provider: %w", err)
+	}
+	expensive, err := r.Expensive.NewProvider(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("new expensive provider: %w", err)
+	}
+	return &routedProvider{router: r, cheap: cheap, expensive: expensive}, nil
+}
+
+type routedProvider struct {
+	router    Router
+	cheap     llm.Provider
+	expensive llm.Provider
+	escalated bool
+}
+
+func (rp *routedProvider) NewMessage(ctx context.Context, params llm.NewMessageParams) (llm.Message, error) {
+	if !rp.escalated {
+		signals := RouteSignals{
+			PromptChars: len(params.SystemText()) + llm.History(params.History).Chars(),
+			Tools:       len(params.ToolDefinitions),
+		}
+		for _, msg := range params.History {
+			if msg.Role == llm.RoleAssistant {
+				signals.Turns++
+			}
+		}
+		if rp.router.Escalate(signals) {
+			params.Logger.Info("router escalated to the expensive model", "model", rp.router.Expensive.Name, "signals", signals)
+			rp.escalated = true
+		}
+	}
+	if rp.escalated {
+		return rp.expensive.NewMessage(ctx, params)
+	}
+	return rp.cheap.NewMessage(ctx, params)
+}
+
+// Capabilities are the ones supported by both models, the model may change
+// between turns.
+func (rp *routedProvider) Capabilities() llm.Capabilities {
+	cheap, expensive := llm.CapabilitiesOf(rp.cheap), llm.CapabilitiesOf(rp.expensive)
+	return llm.Capabilities{
+		Tools:             cheap.Tools && expensive.Tools,
+		ParallelToolCalls: cheap.ParallelToolCalls && expensive.ParallelToolCalls,
+		PromptCaching:     cheap.PromptCaching && expensive.PromptCaching,
+		Vision:            cheap.Vision && expensive.Vision,
+		StructuredOutput:  cheap.StructuredOutput && expensive.StructuredOutput,
+		MaxContextTokens:  min(cheap.MaxContextTokens, expensive.MaxContextTokens),
+	}
+}
+
+func withDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
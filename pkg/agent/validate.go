@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// Validate checks the base for misconfigurations which make the runs fail or
+// behave unexpectedly: the parameters of the agents of the runs are checked
+// with core.NewAgentParams.Validate. It returns all the problems found, each
+// with how to fix it.
+func (b *Base) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	p := b.agentParams()
+	p.LLM = modelProvider{}
+	if err := p.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if b.Timebox < 0 {
+		fail("Timebox is negative (%s): set it to 0 to use the deadline of the context", b.Timebox)
+	}
+	if b.Model.MaxOutputTokens > 0 && b.MaxTokenUsage > 0 && b.Model.MaxOutputTokens > b.MaxTokenUsage {
+		fail("MaxTokenUsage %d is less than the max output tokens %d of the model: a single response can exceed the budget, raise it",
+			b.MaxTokenUsage, b.Model.MaxOutputTokens)
+	}
+	return errors.Join(errs...)
+}
+
+// modelProvider stands in for the provider each run creates from the Model
+// of the Base when validating.
+type modelProvider struct {
+	llm.Provider
+}
+
+// Validate checks the parameters of a run, see Base.Validate. It returns all
+// the problems found, each with how to fix it.
+func (p RunParams) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if p.Prompt == "" {
+		fail("the prompt is empty: set Prompt to the task of the run")
+	}
+	if p.System == "" && len(p.Tools) > 0 {
+		fail("the system prompt is empty but %d tools are given: describe the task and when to use the tools in System", len(p.Tools))
+	}
+	if p.MaxTokenUsage < 0 || p.Timebox < 0 || p.PlanReminderTurns < 0 {
+		fail("MaxTokenUsage, Timebox and PlanReminderTurns must not be negative: set them to 0 for the defaults of the Base")
+	}
+	if p.PlanReminderTurns > 0 && !p.Planning {
+		fail("PlanReminderTurns is set without Planning: enable planning, or the reminders are never sent")
+	}
+	if p.Critique != nil && p.Critique.Criteria == "" {
+		fail("Critique has no Criteria: set the instructions the result is checked against")
+	}
+	if p.FreeText && p.ResultSchema != nil {
+		fail("FreeText is set with a ResultSchema: the free-text result is a string, remove the schema")
+	}
+	if p.Resume && len(p.PreviousMeta.Messages) == 0 {
+		fail("Resume is set without the messages of the failed run: pass the RunMeta returned with the error as PreviousMeta")
+	}
+	if p.Recovery != nil && p.Recovery.MaxAttempts <= 0 {
+		fail("Recovery has no MaxAttempts: set the number of times a failed run is resumed")
+	}
+	if p.Hedge != nil && p.Hedge.Delay <= 0 {
+		fail("Hedge has no Delay: every request would be sent to both models, set the delay after which the second one is asked")
+	}
+	names := map[string]bool{}
+	for _, t := range p.Tools {
+		if names[t.Name] {
+			fail("tool %q is given twice: remove the duplicate, the names must be unique", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return errors.Join(errs...)
+}
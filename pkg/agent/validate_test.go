@@ -0,0 +1,23 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+)
+
+// TestBaseValidate checks that the base is validated like the parameters of
+// the agents of its runs.
+func TestBaseValidate(t *testing.T) {
+	if err := (&Base{}).Validate(); err != nil {
+		t.Errorf("the zero base is invalid: %v", err)
+	}
+
+	err := (&Base{MaxToolLogLength: -1, Sandbox: &sandbox.Config{}, Timebox: -1}).Validate()
+	for _, want := range []string{"MaxToolLogLength is negative", "Sandbox.Image", "Timebox is negative"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v, want %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package audit writes an append-only, tamper-evident log of the tool
+// invocations of agents, so it can be proven what an agent did.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is a line of the audit log. The input and output are only recorded
+// as hashes, they may contain secrets.
+type Record struct {
+	Time       time.Time `json:"time"`
+	AgentID    int       `json:"agent_id"`
+	RunID      string    `json:"run_id"`
+	Tool       string    `json:"tool"`
+	ToolCallID string    `json:"tool_call_id"`
+	// InputSHA256 is the hash of the JSON input of the call, OutputSHA256 of
+	// the output of the tool (the error message if it failed).
+	InputSHA256  string `json:"input_sha256"`
+	OutputSHA256 string `json:"output_sha256"`
+	IsError      bool   `json:"is_error"`
+	DurationMS   int64  `json:"duration_ms"`
+	// PrevSHA256 is the hash of the previous line, chaining the records so
+	// removed, altered or reordered lines are detected by Verify.
+	PrevSHA256 string `json:"prev_sha256"`
+	// Signature signs the record without the signature (optional).
+	Signature string `json:"signature,omitempty"`
+}
+
+// Hash returns the hex SHA-256 hash of data, as used in the records.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Signer signs the records.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier verifies the signatures of the records.
+type Verifier interface {
+	Verify(data, signature []byte) error
+}
+
+// HMAC signs and verifies records with a shared key.
+type HMAC struct {
+	Key []byte
+}
+
+func (h HMAC) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, h.Key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (h HMAC) Verify(data, signature []byte) error {
+	expected, _ := h.Sign(data)
+	if !hmac.Equal(expected, signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Ed25519Signer signs records with a private key, so they can be verified
+// with the public key (Ed25519Verifier) by third parties.
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+}
+
+func (s Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.Key, data), nil
+}
+
+type Ed25519Verifier struct {
+	Key ed25519.PublicKey
+}
+
+func (v Ed25519Verifier) Verify(data, signature []byte) error {
+	if !ed25519.Verify(v.Key, data, signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Log appends records to a writer. It's safe for concurrent use, e.g. by the
+// tools of a turn running in parallel.
+type Log struct {
+	mu     sync.Mutex
+	w      io.Writer
+	signer Signer
+	prev   string
+}
+
+// NewLog creates a log writing to w. signer is optional.
+func NewLog(w io.Writer, signer Signer) *Log {
+	return &Log{w: w, signer: signer}
+}
+
+// OpenFile opens (or creates) a log file for appending, continuing the hash
+// chain of the existing records.
+func OpenFile(path string, signer Signer) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	var last []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	l := NewLog(file, signer)
+	if last != nil {
+		l.prev = Hash(last)
+	}
+	return l, nil
+}
+
+// Write appends a record, setting its chain hash and signature.
+func (l *Log) Write(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.PrevSHA256 = l.prev
+	r.Signature = ""
+	if l.signer != nil {
+		unsigned, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal record: %w", err)
+		}
+		sig, err := l.signer.Sign(unsigned)
+		if err != nil {
+			return fmt.Errorf("sign record: %w", err)
+		}
+		r.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	l.prev = Hash(line)
+	return nil
+}
+
+// Close closes the underlying writer if it's a Closer.
+func (l *Log) Close() error {
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Verify checks the hash chain of a log, and the signatures if verifier is
+// not nil. It returns the number of valid records.
+func Verify(r io.Reader, verifier Verifier) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var prev string
+	var n int
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return n, fmt.Errorf("record %d: %w", n+1, err)
+		}
+		if rec.PrevSHA256 != prev {
+			return n, fmt.Errorf("record %d: broken hash chain", n+1)
+		}
+		if verifier != nil {
+			sig, err := base64.StdEncoding.DecodeString(rec.Signature)
+			if err != nil || rec.Signature == "" {
+				return n, fmt.Errorf("record %d: missing signature", n+1)
+			}
+			rec.Signature = ""
+			unsigned, err := json.Marshal(rec)
+			if err != nil {
+				return n, fmt.Errorf("record %d: %w", n+1, err)
+			}
+			if err := verifier.Verify(unsigned, sig); err != nil {
+				return n, fmt.Errorf("record %d: %w", n+1, err)
+			}
+		}
+		prev = Hash(line)
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return n, fmt.Errorf("read audit log: %w", err)
+	}
+	return n, nil
+}
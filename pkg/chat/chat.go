@@ -0,0 +1,225 @@
+// Package chat provides interactive multi-prompt conversations on top of the
+// agent core, for CLI tools offering a chat UX.
+package chat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+type NewChatParams struct {
+	// Mandatory fields:
+	LLM llm.Provider
+	// Optional fields:
+	SystemPrompt     string
+	Tools            []tool.Definition
+	Logger           *slog.Logger
+	MaxToolLogLength int
+	MaxTokenUsage    int
+	Hooks            core.Hooks
+	// Output receives the text of the intermediate model responses (e.g. the
+	// reasoning between tool calls) as they arrive.
+	Output io.Writer
+	// Limiter is shared with the other agents of the process (optional), the
+	// chat waits for it with core.PriorityInteractive.
+	Limiter *core.Limiter
+}
+
+// Chat is a conversation with a model. Every prompt runs an agent on the
+// history of the previous prompts. It's not safe for concurrent use.
+type Chat struct {
+	params   NewChatParams
+	messages []llm.Message
+	usage    llm.TokenUsage
+	// summary replaces the compacted part of the history.
+	summary string
+}
+
+func NewChat(p NewChatParams) (*Chat, error) {
+	if p.LLM == nil {
+		return nil, fmt.Errorf("missing LLM provider")
+	}
+	if p.Logger == nil {
+		p.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Chat{params: p}, nil
+}
+
+// Send sends a prompt and returns the reply of the model. If the run fails,
+// the history is left unchanged (the usage is still counted).
+func (c *Chat) Send(ctx context.Context, prompt string) (string, error) {
+	hooks := c.params.Hooks
+	if c.params.Output != nil {
+		onMessage := hooks.OnMessage
+		hooks.OnMessage = func(agentID int, msg llm.Message) {
+			c.writeMessage(msg)
+			if onMessage != nil {
+				onMessage(agentID, msg)
+			}
+		}
+	}
+	agent, err := core.NewAgent[string](core.NewAgentParams{
+		SystemPromptBlocks: c.systemPromptBlocks(),
+		LLM:                c.params.LLM,
+		LLMMessages:        c.messages,
+		MaxToolLogLength:   c.params.MaxToolLogLength,
+		Tools:              c.params.Tools,
+		Logger:             c.params.Logger,
+		MaxTokenUsage:      c.params.MaxTokenUsage,
+		InitialUsage:       c.usage,
+		Hooks:              hooks,
+		Limiter:            c.params.Limiter,
+		Priority:           core.PriorityInteractive,
+	})
+	if err != nil {
+		return "", fmt.Errorf("new agent: %w", err)
+	}
+	res, err := agent.Run(ctx, prompt)
+	c.usage = agent.Usage()
+	if err != nil {
+		return "", fmt.Errorf("run agent: %w", err)
+	}
+	c.messages = res.Messages
+	return res.Data, nil
+}
+
+const compactPrompt = "Summarize the conversation so far for yourself, so it can be continued without the " +
+	"full history. Keep the facts, decisions, open questions and the details of the files, commands " +
+	"and results discussed. Return the summary only."
+
+// Compact replaces the history with a summary written by the model, to cut
+// the token usage of long conversations.
+func (c *Chat) Compact(ctx context.Context) error {
+	if len(c.messages) == 0 {
+		return nil
+	}
+	summary, err := c.Send(ctx, compactPrompt)
+	if err != nil {
+		return fmt.Errorf("summarize history: %w", err)
+	}
+	c.params.Logger.Info("history compacted", "messages", len(c.messages))
+	c.summary = summary
+	c.messages = nil
+	return nil
+}
+
+// Reset clears the history. The usage is kept.
+func (c *Chat) Reset() {
+	c.messages = nil
+	c.summary = ""
+}
+
+func (c *Chat) Messages() []llm.Message {
+	return c.messages
+}
+
+func (c *Chat) Usage() llm.TokenUsage {
+	return c.usage
+}
+
+func (c *Chat) systemPromptBlocks() []llm.SystemPromptBlock {
+	blocks := []llm.SystemPromptBlock{{Text: c.params.SystemPrompt, Cacheable: true}}
+	if c.summary != "" {
+		blocks = append(blocks, llm.SystemPromptBlock{
+			Text:      fmt.Sprintf("<conversation_summary>\n%s\n</conversation_summary>", c.summary),
+			Cacheable: true,
+		})
+	}
+	return blocks
+}
+
+func (c *Chat) writeMessage(msg llm.Message) {
+	for _, part := range msg.Parts {
+		switch v := part.(type) {
+		case llm.TextContent:
+			fmt.Fprintln(c.params.Output, v.Text)
+		case llm.ToolCall:
+			if v.Name != tool.FinalResultToolName {
+				fmt.Fprintf(c.params.Output, "> %s %s\n", v.Name, v.Input)
+			}
+		}
+	}
+}
+
+const replHelp = `Commands:
+  /usage    show the token usage
+  /history  show the number of messages in the history
+  /compact  replace the history with a summary
+  /reset    clear the history
+  /help     show this help
+  /exit     end the conversation
+`
+
+// REPL reads prompts from in line by line and writes the replies to out,
+// until in is exhausted, the /exit command or a canceled context.
+func (c *Chat) REPL(ctx context.Context, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			exit, err := c.command(ctx, line, out)
+			if err != nil {
+				fmt.Fprintf(out, "error: %s\n", err)
+			}
+			if exit {
+				return nil
+			}
+			continue
+		}
+		reply, err := c.Send(ctx, line)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+			continue
+		}
+		fmt.Fprintf(out, "%s\n\n", reply)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read input: %w", err)
+	}
+	return nil
+}
+
+func (c *Chat) command(ctx context.Context, line string, out io.Writer) (exit bool, err error) {
+	switch line {
+	case "/usage":
+		u := c.usage
+		fmt.Fprintf(out, "input: %d, output: %d, cache creation: %d, cache read: %d, total: %d\n",
+			u.InputTokens, u.OutputTokens, u.CacheCreationTokens, u.CacheReadTokens, u.Total())
+	case "/history":
+		fmt.Fprintf(out, "%d messages, summary: %t\n", len(c.messages), c.summary != "")
+	case "/compact":
+		if err := c.Compact(ctx); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(out, "history compacted")
+	case "/reset":
+		c.Reset()
+		fmt.Fprintln(out, "history cleared")
+	case "/help":
+		fmt.Fprint(out, replHelp)
+	case "/exit", "/quit":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %q, see /help", line)
+	}
+	return false, nil
+}
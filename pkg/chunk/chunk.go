@@ -0,0 +1,212 @@
+// Package chunk splits large inputs (files, logs, diffs) into model-sized
+// chunks, preferring the structural boundaries of the input: function
+// boundaries for code, step boundaries for Bitrise logs, file and hunk
+// boundaries for diffs.
+package chunk
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMaxBytes is the chunk size if Params.MaxBytes is not set.
+const DefaultMaxBytes = 16 * 1024
+
+// Kind selects the structural boundaries of the input.
+type Kind string
+
+const (
+	// KindText splits at paragraphs (blank lines).
+	KindText Kind = "text"
+	// KindCode splits before the top-level declarations.
+	KindCode Kind = "code"
+	// KindLog splits at the steps of Bitrise build logs.
+	KindLog Kind = "log"
+	// KindDiff splits at the files and hunks of unified diffs.
+	KindDiff Kind = "diff"
+)
+
+// Chunk is a part of the input.
+type Chunk struct {
+	Index int
+	Text  string
+	// StartLine and EndLine are the 1-based line numbers of the first and
+	// the last line of the chunk in the input.
+	StartLine int
+	EndLine   int
+}
+
+type Params struct {
+	Kind Kind
+	// MaxBytes is the size limit of a chunk, defaults to DefaultMaxBytes.
+	// Lines longer than that are split.
+	MaxBytes int
+	// OverlapLines are repeated from the end of the previous chunk at the
+	// start of the next one, so context isn't lost at the boundaries.
+	OverlapLines int
+}
+
+// Split splits s into chunks of at most MaxBytes. A chunk ends before the
+// last structural boundary fitting in it, or at the last fitting line if it
+// has none.
+func Split(s string, p Params) []Chunk {
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if s == "" {
+		return nil
+	}
+	lines := splitLines(s, maxBytes)
+	isBoundary := boundaries(p.Kind, lines)
+
+	var chunks []Chunk
+	start := 0
+	for start < len(lines) {
+		size, end, lastBoundary := 0, start, -1
+		for end < len(lines) && (end == start || size+len(lines[end].text) <= maxBytes) {
+			if end > start && isBoundary[end] {
+				lastBoundary = end
+			}
+			size += len(lines[end].text)
+			end++
+		}
+		if end < len(lines) && lastBoundary > start && !isBoundary[end] {
+			end = lastBoundary
+		}
+		var sb strings.Builder
+		for _, l := range lines[start:end] {
+			sb.WriteString(l.text)
+		}
+		chunks = append(chunks, Chunk{
+			Index:     len(chunks),
+			Text:      sb.String(),
+			StartLine: lines[start].num,
+			EndLine:   lines[end-1].num,
+		})
+		if end == len(lines) {
+			break
+		}
+		// The overlap must leave room for progress.
+		start = max(end-p.OverlapLines, start+1)
+		for start < end && overlapSize(lines[start:end]) > maxBytes/2 {
+			start++
+		}
+	}
+	return chunks
+}
+
+// KindOf guesses the kind of a file from its path.
+func KindOf(path string) Kind {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".diff", ".patch":
+		return KindDiff
+	case ".log":
+		return KindLog
+	case ".go", ".swift", ".kt", ".kts", ".java", ".m", ".h", ".c", ".cc", ".cpp",
+		".js", ".jsx", ".ts", ".tsx", ".py", ".rb", ".rs", ".dart", ".cs", ".php", ".scala":
+		return KindCode
+	}
+	return KindText
+}
+
+type line struct {
+	text string // with the line terminator
+	num  int
+}
+
+// splitLines splits s into lines, lines longer than maxBytes are split at
+// rune boundaries.
+func splitLines(s string, maxBytes int) []line {
+	var lines []line
+	for num := 1; s != ""; num++ {
+		end := strings.IndexByte(s, '\n') + 1
+		if end == 0 {
+			end = len(s)
+		}
+		text := s[:end]
+		s = s[end:]
+		for len(text) > maxBytes {
+			cut := maxBytes
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = maxBytes
+			}
+			lines = append(lines, line{text: text[:cut], num: num})
+			text = text[cut:]
+		}
+		lines = append(lines, line{text: text, num: num})
+	}
+	return lines
+}
+
+func overlapSize(lines []line) int {
+	size := 0
+	for _, l := range lines {
+		size += len(l.text)
+	}
+	return size
+}
+
+var (
+	// The steps of Bitrise logs start with a box like:
+	//
+	//	+------------------------------------------------------------------------------+
+	//	| (1) git-clone@8                                                              |
+	logStepTitle = regexp.MustCompile(`^\| \(\d+\) `)
+	logBoxLine   = regexp.MustCompile(`^\+-{10,}\+?\s*$`)
+	// Unindented declarations of the common languages.
+	codeDecl = regexp.MustCompile(`^(func|type|var|const|def|class|struct|enum|interface|protocol|extension|fn|impl|trait|mod|pub|public|private|internal|protected|open|final|abstract|static|export|async|function|object|data|sealed|@\w+)\b`)
+)
+
+// boundaries reports for each line whether a structural unit starts with it.
+func boundaries(kind Kind, lines []line) []bool {
+	isBoundary := make([]bool, len(lines))
+	for i, l := range lines {
+		if i > 0 && lines[i-1].num == l.num {
+			continue // the rest of a split line
+		}
+		text := strings.TrimRight(l.text, "\r\n")
+		switch kind {
+		case KindLog:
+			isBoundary[i] = logBoxLine.MatchString(text) && i+1 < len(lines) &&
+				logStepTitle.MatchString(lines[i+1].text)
+		case KindDiff:
+			isBoundary[i] = strings.HasPrefix(text, "diff --git ") || strings.HasPrefix(text, "@@ ")
+		case KindCode:
+			isBoundary[i] = codeDecl.MatchString(text) && (i == 0 || !isCodeDecl(lines[i-1].text))
+		default:
+			isBoundary[i] = i > 0 && strings.TrimSpace(lines[i-1].text) == "" && strings.TrimSpace(text) != ""
+		}
+	}
+	// Comments and annotations belong to the declaration below them.
+	if kind == KindCode {
+		for i := range lines {
+			if !isBoundary[i] {
+				continue
+			}
+			j := i
+			for j > 0 && isDocLine(lines[j-1].text) {
+				j--
+			}
+			if j < i {
+				isBoundary[i], isBoundary[j] = false, true
+			}
+		}
+	}
+	return isBoundary
+}
+
+func isCodeDecl(s string) bool {
+	return codeDecl.MatchString(strings.TrimRight(s, "\r\n"))
+}
+
+func isDocLine(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "//") || strings.HasPrefix(s, "#") || strings.HasPrefix(s, "/*") ||
+		strings.HasPrefix(s, "*")
+}
@@ -0,0 +1,282 @@
+// Package config loads the configuration of the services embedding the
+// agents, so they don't each define the same struct. The layers are applied
+// in this order, the later ones overriding the earlier ones:
+//
+//   - the defaults, see Default,
+//   - the YAML files, see LoadParams.Files,
+//   - the environment variables, see the env tags of Config,
+//   - the programmatic overrides, see LoadParams.Override, e.g. flags.
+//
+// The loaded configuration is validated.
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/agent"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/core"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the agents of a service. Example YAML:
+//
+//	model_provider: anthropic
+//	model: fast
+//	model_aliases: fast=anthropic:claude-haiku-4-5-20251001,smart=openai:gpt-5
+//	max_token_usage: 500000
+//	timebox: 5m
+//	run_timeout: 10m
+type Config struct {
+	// ModelProvider is the AI provider to use. Can be one of "anthropic",
+	// "openai", "gemini", "bedrock". Detected from the API keys if empty.
+	ModelProvider string `yaml:"model_provider" env:"MODEL_PROVIDER"`
+	// Model is the model to use for the AI agent, or an alias of
+	// ModelAliases.
+	Model string `yaml:"model" env:"MODEL"`
+	// ModelAliases is the alias table of the models, e.g.
+	// "fast=anthropic:claude-haiku-4-5-20251001,smart=openai:gpt-5".
+	ModelAliases string `yaml:"model_aliases" env:"MODEL_ALIASES"`
+	// MaxOutputTokens is the maximum number of output tokens of a response,
+	// the default of the model if 0.
+	MaxOutputTokens int `yaml:"max_output_tokens" env:"MAX_OUTPUT_TOKENS"`
+	// MaxToolLogLength is the maximum length of tool use logs to keep.
+	// Defaults to core.DefaultMaxToolLogLength.
+	MaxToolLogLength int `yaml:"max_tool_log_length" env:"MAX_TOOL_LOG_LENGTH"`
+	// MaxTokenUsage is the maximum number of total tokens that can be used in
+	// a run. If exceeded, the agent fails with an error. If 0, there is no
+	// limit.
+	MaxTokenUsage int `yaml:"max_token_usage" env:"MAX_TOKEN_USAGE"`
+	// Timebox is the maximum duration of a run using the tools, the agent
+	// must return its final result afterwards. If 0, there is no limit.
+	Timebox time.Duration `yaml:"timebox" env:"TIMEBOX"`
+	// RunTimeout is the deadline of the context of a run (optional), see
+	// Context. The Timebox must be shorter, so the agent has time to return
+	// its final result.
+	RunTimeout time.Duration `yaml:"run_timeout" env:"RUN_TIMEOUT"`
+	// CacheBust generates a unique message at the start of each
+	// conversation, enforcing a fresh conversation, e.g. to test the same
+	// input multiple times. Prompt caching is still enabled.
+	CacheBust bool `yaml:"cache_bust" env:"CACHE_BUST"`
+	// SessionFilePath is the path of the file to read the conversation
+	// history from and to write the conversation to (optional).
+	SessionFilePath string `yaml:"session_file_path" env:"SESSION_FILE_PATH"`
+	// MaxWorkers is the number of items processed at the same time by the
+	// orchestrations. If 0, orchestrate.DefaultMaxWorkers is used.
+	MaxWorkers int `yaml:"max_workers" env:"MAX_WORKERS"`
+}
+
+// Default returns the configuration with the defaults.
+func Default() Config {
+	return Config{MaxToolLogLength: core.DefaultMaxToolLogLength}
+}
+
+type LoadParams struct {
+	// Files are YAML files (optional), the later ones override the values
+	// set by the earlier ones. Unknown keys are errors.
+	Files []string
+	// EnvPrefix is prepended to the names of the environment variables,
+	// e.g. "REVIEWER_" for REVIEWER_MODEL (optional).
+	EnvPrefix string
+	// Override is called with the configuration before validating it, e.g.
+	// to apply the flags (optional).
+	Override func(*Config)
+}
+
+// Load loads the configuration from the layers and validates it.
+func Load(p LoadParams) (Config, error) {
+	c := Default()
+	for _, path := range p.Files {
+		if err := c.loadFile(path); err != nil {
+			return Config{}, fmt.Errorf("load %s: %w", path, err)
+		}
+	}
+	if err := c.loadEnv(p.EnvPrefix); err != nil {
+		return Config{}, fmt.Errorf("load environment: %w", err)
+	}
+	if p.Override != nil {
+		p.Override(&c)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// loadEnv sets the fields whose environment variables are set.
+func (c *Config) loadEnv(prefix string) error {
+	v := reflect.ValueOf(c).Elem()
+	var errs []error
+	for i := range v.NumField() {
+		name := v.Type().Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		name = prefix + name
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(v.Field(i), s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setField(f reflect.Value, s string) error {
+	switch {
+	case f.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		f.SetString(s)
+	case f.Kind() == reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
+
+// Validate checks the configuration, returning all the problems found.
+func (c Config) Validate() error {
+	var errs []error
+	switch llm.ProviderName(c.ModelProvider) {
+	case "", llm.ProviderOpenAI, llm.ProviderAnthropic, llm.ProviderGemini, llm.ProviderBedrock:
+	default:
+		errs = append(errs, fmt.Errorf("unknown model provider %q", c.ModelProvider))
+	}
+	if _, err := llm.ParseModelAliases(c.ModelAliases); err != nil {
+		errs = append(errs, fmt.Errorf("model aliases: %w", err))
+	}
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"max output tokens", c.MaxOutputTokens},
+		{"max tool log length", c.MaxToolLogLength},
+		{"max token usage", c.MaxTokenUsage},
+		{"max workers", c.MaxWorkers},
+	} {
+		if f.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", f.name, f.value))
+		}
+	}
+	if c.MaxTokenUsage > 0 && c.MaxOutputTokens > c.MaxTokenUsage {
+		errs = append(errs, fmt.Errorf(
+			"max token usage %d is less than max output tokens %d, a single response can exceed it",
+			c.MaxTokenUsage, c.MaxOutputTokens,
+		))
+	}
+	if c.Timebox < 0 || c.RunTimeout < 0 {
+		errs = append(errs, fmt.Errorf("timebox and run timeout must not be negative"))
+	}
+	if c.RunTimeout > 0 && c.Timebox >= c.RunTimeout {
+		errs = append(errs, fmt.Errorf(
+			"timebox %s must be shorter than the run timeout %s, the agent needs time for the final result",
+			c.Timebox, c.RunTimeout,
+		))
+	}
+	return errors.Join(errs...)
+}
+
+// CheckDeadline checks that the timebox ends before the deadline of ctx, e.g.
+// the deadline of a request handled by the service.
+func (c Config) CheckDeadline(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok || c.Timebox <= 0 {
+		return nil
+	}
+	if left := time.Until(deadline); c.Timebox >= left {
+		return fmt.Errorf("timebox %s must be shorter than the %s left until the deadline", c.Timebox, left.Round(time.Second))
+	}
+	return nil
+}
+
+// Context returns the context of a run, with the RunTimeout if set.
+func (c Config) Context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.RunTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.RunTimeout)
+}
+
+// LLMModel returns the model, resolving the aliases, with the defaults
+// applied.
+func (c Config) LLMModel() (llm.Model, error) {
+	aliases, err := llm.ParseModelAliases(c.ModelAliases)
+	if err != nil {
+		return llm.Model{}, fmt.Errorf("parse model aliases: %w", err)
+	}
+	model := llm.Model{
+		Provider:        llm.ProviderName(c.ModelProvider),
+		Name:            c.Model,
+		MaxOutputTokens: c.MaxOutputTokens,
+	}
+	if aliased, ok := aliases[c.Model]; ok {
+		model = aliased
+		if c.MaxOutputTokens > 0 {
+			model.MaxOutputTokens = c.MaxOutputTokens
+		}
+	}
+	if err := model.SetDefaults(); err != nil {
+		return llm.Model{}, fmt.Errorf("set defaults on model: %w", err)
+	}
+	return model, nil
+}
+
+// Base returns an agent base configured with the configuration, the other
+// fields of the base can be set on the returned value.
+func (c Config) Base(logger *slog.Logger) (*agent.Base, error) {
+	model, err := c.LLMModel()
+	if err != nil {
+		return nil, err
+	}
+	return &agent.Base{
+		Model:            model,
+		MaxToolLogLength: c.MaxToolLogLength,
+		Logger:           logger,
+		CacheBust:        c.CacheBust,
+		SessionFilePath:  c.SessionFilePath,
+		MaxTokenUsage:    c.MaxTokenUsage,
+		Timebox:          c.Timebox,
+	}, nil
+}
@@ -1,19 +1,39 @@
 package core
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/bitrise-io/bitrise-ai-core/pkg/audit"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/prompt/sanitize"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
 	"github.com/google/uuid"
+	"github.com/invopop/jsonschema"
 )
 
+// Agent runs a conversation with a model. An agent runs one conversation at
+// a time: Run and Resume fail with ErrAgentBusy while another run of the
+// agent is in progress. The accessors (Messages, Usage, ...) are safe to call
+// from other goroutines during a run, e.g. to report progress. The session
+// file is locked during a run, see SessionLockPolicy.
 type Agent[ResultT any] struct {
 	systemPrompt     string
+	systemBlocks     []llm.SystemPromptBlock
 	llm              llm.Provider
 	sessionFilePath  string
 	maxToolLogLength int
@@ -21,20 +41,114 @@ type Agent[ResultT any] struct {
 	toolBelt         *tool.Belt[ResultT]
 	llmMessages      []llm.Message
 	llmUsage         llm.TokenUsage
+	usageBreakdown   UsageBreakdown
+	toolStats        toolStatsRecorder
+	timeline         Timeline
 	agentNum         int
+	runID            string
 	maxTokenUsage    int
 	timeboxedUntil   time.Time
+	finalTurnBuffer  time.Duration
 	cacheBust        bool
+	providerFlags    providerFlags
+	sanitize         sanitize.Func
 	finalResult      ResultT
 	finalResultSet   bool
+	hooks            Hooks
+	plan             tool.Plan
+	findings         []tool.Finding
+	// planReminderTurns is the number of turns without a plan update after
+	// which the current plan is re-injected into the conversation.
+	planReminderTurns    int
+	turnsSincePlanUpdate int
+	critiqueParams       *CritiqueParams
+	critiques            []Critique
+	revisions            int
+	reminderStrategy     ReminderStrategy
+	pendingReminders     []string
+	// forceTool is the tool the model must call in the next turn.
+	forceTool string
+	// finalResultReminders counts the reminders to call FinalResult.
+	finalResultReminders int
+	// emptyResponses counts the consecutive empty responses of the model.
+	emptyResponses int
+	maxEmptyNudges int
+	// maxParallelTools limits the tools running at the same time in a turn.
+	maxParallelTools int
+	sequentialTools  bool
+	// maxResultBytes is the default size limit of the tool results.
+	maxResultBytes int
+	// cacheMisses counts the consecutive turns missing the prompt cache.
+	cacheMisses int
+	// schemaPolicy escalates the final result schema failures, schemaStep
+	// is the index of its next step. structuredOutput is set by
+	// SchemaStepStructuredOutput.
+	schemaPolicy     *SchemaFailurePolicy
+	schemaFailures   int
+	schemaStep       int
+	structuredOutput bool
+	// freeText is set if the result is the text of the last response, see
+	// NewAgentParams.FreeTextResult.
+	freeText bool
+	auditLog *audit.Log
+	policy   *tool.Policy
+	dryRun   bool
+	// sandboxConfig is set if EnableSandbox is, sandbox is the container
+	// of the current run.
+	sandboxConfig *sandbox.Config
+	sandbox       *sandbox.Sandbox
+	toolExecutor  tool.Executor
+	secrets       secrets.Secrets
+	limiter       *Limiter
+	priority      Priority
+	// enrichTools is applied to the tools added to the belt, see
+	// NewAgentParams.EnrichTools.
+	enrichTools *tool.EnrichParams
+	// clock measures the timebox, the timings and the timestamps.
+	clock clock.Clock
+	// summarizeResults condenses the large tool results, see
+	// NewAgentParams.SummarizeToolResults.
+	summarizeResults *ToolResultSummaryParams
+	// seed is sent with every request if set, see NewAgentParams.Seed.
+	seed *int64
+	// capabilities of the provider, see llm.CapabilitiesOf.
+	capabilities llm.Capabilities
+	// sessionKeys encrypts the session file if set.
+	sessionKeys   SessionKeyProvider
+	sessionLock   SessionLockPolicy
+	strictHistory bool
+	// sessionFile is the session file as last restored or saved by the
+	// agent, nil if it didn't exist. The history is reloaded if another
+	// agent replaced it since, see lockSession.
+	sessionFile os.FileInfo
+	// sessionRetention limits the size of the session file on disk.
+	sessionRetention SessionRetention
+	// events is the channel returned by Events, guarded by eventsMu.
+	events   chan Event
+	eventsMu sync.Mutex
+	// turn is the number of the current turn, read by the tool goroutines.
+	turn atomic.Int64
+	// running is set while a run is in progress.
+	running atomic.Bool
+	// mu guards the state which is read by the accessors or written by the
+	// tools running in parallel: llmMessages, llmUsage, finalResult,
+	// finalResultSet, plan, findings and turnsSincePlanUpdate.
+	mu sync.Mutex
 }
 
 type NewAgentParams struct {
-	AgentID           int
-	SystemPrompt      string
-	LLM               llm.Provider
-	LLMMessages       []llm.Message
-	SessionFilePath   string
+	AgentID int
+	// RunID correlates the run across logs, sessions and provider requests.
+	// Generated with the IDGenerator if empty.
+	RunID string
+	// IDGenerator generates the missing IDs. Defaults to DefaultIDGenerator.
+	IDGenerator     IDGenerator
+	SystemPrompt    string
+	LLM             llm.Provider
+	LLMMessages     []llm.Message
+	SessionFilePath string
+	// MaxToolLogLength is the length of the tool logs to keep. Defaults to
+	// DefaultMaxToolLogLength.
 	MaxToolLogLength  int
 	Tools             []tool.Definition
 	Logger            *slog.Logger
@@ -44,40 +158,253 @@ type NewAgentParams struct {
 	CacheBust         bool
 	EnableSandbox     bool
 	InitialUsage      llm.TokenUsage
-}
+	Hooks             Hooks
+	// EnablePlanning adds the UpdatePlan tool, the plan is tracked by the
+	// agent and returned in the RunResult.
+	EnablePlanning bool
+	// PlanReminderTurns re-injects the current plan as a system reminder
+	// after this many turns without a plan update. 0 disables it.
+	PlanReminderTurns int
+	// EnableFindings adds the EmitFinding tool, the model reports its
+	// findings with it as it goes (see Hooks.OnFinding and EventFinding),
+	// they are returned in the RunResult too.
+	EnableFindings bool
+	// Critique enables a review of the final result before it is accepted.
+	Critique *CritiqueParams
+	// FinalTurnBuffer is reserved for the final result turn before the
+	// deadline of the context passed to Run. The timebox is moved earlier if
+	// needed so the agent wraps up before the context is canceled.
+	// Defaults to DefaultFinalTurnBuffer.
+	FinalTurnBuffer time.Duration
+	// TokenEfficientTools and DisableParallelToolUse are passed to the
+	// provider, see llm.NewMessageParams.
+	TokenEfficientTools    bool
+	DisableParallelToolUse bool
+	// Sanitize pre-processes the user prompts and tool results before they
+	// are added to the conversation, e.g. sanitize.Default (optional).
+	Sanitize sanitize.Func
+	// SystemPromptBlocks replaces SystemPrompt if set, see
+	// llm.NewMessageParams.
+	SystemPromptBlocks []llm.SystemPromptBlock
+	// ReminderStrategy selects how system reminders are injected, defaults
+	// to ReminderUserMessage.
+	ReminderStrategy ReminderStrategy
+	// MaxEmptyResponseNudges is the number of consecutive empty responses
+	// after which the run fails. Defaults to DefaultMaxEmptyResponseNudges.
+	MaxEmptyResponseNudges int
+	// ResultSchema overrides the schema of the final result generated from
+	// ResultT, see tool.NewBeltParams.FinalResultSchema.
+	ResultSchema *jsonschema.Schema
+	// MaxParallelTools limits the number of tools running at the same time
+	// within a turn, unlimited if zero.
+	MaxParallelTools int
+	// MaxToolResultBytes truncates larger tool results before they are added
+	// to the history, unlimited if zero. Tools can override it with
+	// tool.Definition.MaxResultBytes.
+	MaxToolResultBytes int
+	// StrictHistory fails NewAgent if the restored history (LLMMessages or
+	// the session) violates the provider invariants, instead of repairing it
+	// (see llm.RepairHistory).
+	StrictHistory bool
+	// SessionKeys encrypts the session file with AES-GCM (optional). Session
+	// files may contain source code and secrets captured in tool outputs.
+	SessionKeys SessionKeyProvider
+	// SessionLock selects what happens if the session file is used by another
+	// agent, defaults to SessionLockFail.
+	SessionLock SessionLockPolicy
+	// SessionRetention rotates oversized session files and prunes the rotated
+	// ones (optional).
+	SessionRetention SessionRetention
+	// SequentialToolCalls gives the tool calls sequential semantics: the
+	// model is asked to call one tool per turn where the provider supports it
+	// (DisableParallelToolUse), and if it still calls several, they are
+	// executed one by one in order, skipping the rest after a failure.
+	SequentialToolCalls bool
+	// Seed is sent to the providers supporting it (OpenAI, Gemini) for
+	// best-effort reproducible runs (optional), e.g. to replay a run. No seed
+	// is sent if nil, the fingerprints of the responses are recorded either
+	// way (see RunResult.Fingerprints).
+	Seed *int64
+	// SchemaFailurePolicy escalates if the final results keep failing to
+	// parse (optional, e.g. DefaultSchemaFailurePolicy). By default the model
+	// retries with the error as the tool result until the budget runs out.
+	SchemaFailurePolicy *SchemaFailurePolicy
+	// AuditLog records every tool invocation (optional), see audit.Log.
+	AuditLog *audit.Log
+	// Policy permits or denies the tool calls (optional), denied calls get
+	// an error result without calling the tool. All calls are allowed if nil.
+	Policy *tool.Policy
+	// DryRun previews the run: the mutating tools (see tool.Definition.Mutating)
+	// describe their effect instead of changing anything.
+	DryRun bool
+	// Sandbox configures the container started for each run if EnableSandbox
+	// is set. The tools run their commands in it, see sandbox.FromContext.
+	Sandbox sandbox.Config
+	// ToolExecutor executes the tool calls instead of the UseFunc of the
+	// tools (optional), e.g. on a remote worker. The definitions of Tools
+	// only describe the tools then, see remote.Client.Definitions.
+	ToolExecutor tool.Executor
+	// Secrets provides the credentials of the tools (optional), they are
+	// injected into the context of the tool calls, see secrets.FromContext.
+	Secrets secrets.Secrets
+	// Limiter bounds the LLM requests and tool calls in flight, shared with
+	// other agents (optional).
+	Limiter *Limiter
+	// Priority of the requests and tool calls of the agent when waiting for
+	// the Limiter, e.g. PriorityInteractive for a chat with a user.
+	Priority Priority
+	// EnrichTools appends usage examples and size hints to the descriptions
+	// of Tools (optional), see tool.Enrich. Its MaxResultBytes defaults to
+	// MaxToolResultBytes.
+	EnrichTools *tool.EnrichParams
+	// FinalResultToolName and FinalResultToolDescription override the name
+	// and the description of the tool returning the final result (optional),
+	// see tool.NewBeltParams.FinalResultName.
+	FinalResultToolName        string
+	FinalResultToolDescription string
+	// FreeTextResult finishes the run when the model responds without tool
+	// calls, the text of the response is the result. The FinalResult tool is
+	// left out, saving its round-trip for conversational agents. ResultT
+	// must be string.
+	FreeTextResult bool
+	// Clock measures the timebox, the retry backoff of the provider and the
+	// timestamps of the run, defaults to clock.Real. Tests can pass a
+	// clock.Fake to expire the timebox without sleeping.
+	Clock clock.Clock
+	// SummarizeToolResults condenses the tool results larger than a
+	// threshold with a cheap model before they are added to the history
+	// (optional). Summarized results are not truncated by
+	// MaxToolResultBytes unless the summary exceeds it.
+	SummarizeToolResults *ToolResultSummaryParams
 
-var agentCounter atomic.Int64
+	// timebox is set by WithTimebox, NewAgentWith resolves it into
+	// TimeboxedUntil.
+	timebox time.Duration
+}
 
 // NewAgent creates a new Agent instance.
 func NewAgent[ResultT any](p NewAgentParams) (*Agent[ResultT], error) {
+	idGenerator := p.IDGenerator
+	if idGenerator == nil {
+		idGenerator = DefaultIDGenerator
+	}
 	currentAgentID := p.AgentID
 	if currentAgentID <= 0 {
-		currentAgentID = int(agentCounter.Add(1))
+		currentAgentID = idGenerator.NewAgentID()
+	}
+	runID := p.RunID
+	if runID == "" {
+		runID = idGenerator.NewRunID()
 	}
-	logger := p.Logger.With("agent-id", currentAgentID)
+	logger := p.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	logger = logger.With("agent-id", currentAgentID, "run-id", runID)
 
 	agent := &Agent[ResultT]{
-		systemPrompt:     p.SystemPrompt,
-		llm:              p.LLM,
-		llmMessages:      p.LLMMessages,
-		sessionFilePath:  p.SessionFilePath,
-		maxToolLogLength: p.MaxToolLogLength,
-		logger:           logger,
-		agentNum:         currentAgentID,
-		maxTokenUsage:    p.MaxTokenUsage,
-		timeboxedUntil:   p.TimeboxedUntil,
-		cacheBust:        p.CacheBust,
-		llmUsage:         p.InitialUsage,
+		systemPrompt:      p.SystemPrompt,
+		llm:               p.LLM,
+		llmMessages:       p.LLMMessages,
+		sessionFilePath:   p.SessionFilePath,
+		maxToolLogLength:  cmp.Or(p.MaxToolLogLength, DefaultMaxToolLogLength),
+		logger:            logger,
+		agentNum:          currentAgentID,
+		runID:             runID,
+		maxTokenUsage:     p.MaxTokenUsage,
+		timeboxedUntil:    p.TimeboxedUntil,
+		finalTurnBuffer:   p.FinalTurnBuffer,
+		cacheBust:         p.CacheBust,
+		llmUsage:          p.InitialUsage,
+		hooks:             p.Hooks,
+		planReminderTurns: p.PlanReminderTurns,
+		critiqueParams:    p.Critique,
+		sanitize:          p.Sanitize,
+		systemBlocks:      p.SystemPromptBlocks,
+		reminderStrategy:  p.ReminderStrategy,
+		maxEmptyNudges:    p.MaxEmptyResponseNudges,
+		maxParallelTools:  p.MaxParallelTools,
+		maxResultBytes:    p.MaxToolResultBytes,
+		providerFlags: providerFlags{
+			tokenEfficientTools:    p.TokenEfficientTools,
+			disableParallelToolUse: p.DisableParallelToolUse,
+		},
+	}
+
+	agent.clock = clock.Or(p.Clock)
+	agent.capabilities = llm.CapabilitiesOf(p.LLM)
+	if !agent.capabilities.Tools {
+		// The final result is returned with a tool call.
+		return nil, fmt.Errorf("the model does not support tool calls")
+	}
+	agent.sequentialTools = p.SequentialToolCalls
+	agent.schemaPolicy = p.SchemaFailurePolicy
+	agent.auditLog = p.AuditLog
+	agent.policy = p.Policy
+	agent.dryRun = p.DryRun
+	agent.toolExecutor = p.ToolExecutor
+	agent.secrets = p.Secrets
+	agent.limiter = p.Limiter
+	agent.priority = p.Priority
+	if p.EnableSandbox {
+		if p.Sandbox.Image == "" {
+			return nil, fmt.Errorf("sandbox image is required")
+		}
+		agent.sandboxConfig = &p.Sandbox
+	}
+	agent.seed = p.Seed
+	if p.SequentialToolCalls {
+		agent.providerFlags.disableParallelToolUse = true
+	}
+	agent.sessionKeys = p.SessionKeys
+	agent.sessionLock = p.SessionLock
+	agent.strictHistory = p.StrictHistory
+	agent.sessionRetention = p.SessionRetention
+	if p.FreeTextResult {
+		if _, ok := any(agent.finalResult).(string); !ok {
+			return nil, fmt.Errorf("free-text result requires a string result, got %T", agent.finalResult)
+		}
+		if p.ResultSchema != nil {
+			return nil, fmt.Errorf("free-text result can't have a result schema")
+		}
+		agent.freeText = true
+	}
+	if p.SummarizeToolResults != nil {
+		if p.SummarizeToolResults.LLM == nil {
+			return nil, fmt.Errorf("tool result summarizer requires an LLM")
+		}
+		agent.summarizeResults = p.SummarizeToolResults
 	}
 
+	if p.EnrichTools != nil {
+		enrich := *p.EnrichTools
+		if enrich.MaxResultBytes == 0 {
+			enrich.MaxResultBytes = p.MaxToolResultBytes
+		}
+		agent.enrichTools = &enrich
+	}
 	agent.toolBelt = tool.NewBelt(tool.NewBeltParams[ResultT]{
-		Agent: agent,
-		Tools: p.Tools,
+		Agent:                  agent,
+		Tools:                  agent.enrich(p.Tools),
+		EnablePlanning:         p.EnablePlanning,
+		EnableFindings:         p.EnableFindings,
+		FinalResultSchema:      p.ResultSchema,
+		FinalResultName:        p.FinalResultToolName,
+		FinalResultDescription: p.FinalResultToolDescription,
+		DisableFinalResult:     p.FreeTextResult,
 	})
+	for _, def := range p.Tools {
+		if agent.toolBelt.IsBuiltin(def.Name) {
+			agent.logger.Warn("the built-in tools can't be replaced, tool ignored", "tool", def.Name)
+		}
+	}
 
 	if err := agent.restoreSession(); err != nil {
 		return nil, fmt.Errorf("restore session: %w", err)
 	}
+	if err := agent.repairHistory(p.StrictHistory); err != nil {
+		return nil, err
+	}
 
 	if p.CacheBust {
 		agent.addSystemReminder(fmt.Sprintf(
@@ -89,13 +416,103 @@ func NewAgent[ResultT any](p NewAgentParams) (*Agent[ResultT], error) {
 	return agent, nil
 }
 
+// providerFlags are the provider specific efficiency flags of every request.
+type providerFlags struct {
+	tokenEfficientTools    bool
+	disableParallelToolUse bool
+}
+
 type RunResult[ResultT any] struct {
+	RunID      string
 	Data       ResultT
 	TotalUsage llm.TokenUsage
 	Messages   []llm.Message
+	// Plan is the last plan reported by the model, if planning is enabled.
+	Plan tool.Plan
+	// Findings are the findings reported by the model in order, if findings
+	// are enabled.
+	Findings []tool.Finding
+	// Critiques are the reviews of the final results, if critique is enabled.
+	Critiques []Critique
+	// UsageBreakdown attributes TotalUsage to phases and tools. Usage
+	// restored from a session (InitialUsage) is not included.
+	UsageBreakdown UsageBreakdown
+	Timeline       Timeline
+	// CacheStats are computed from TotalUsage.
+	CacheStats CacheStats
+	// Seed was sent with the requests, 0 if none was set (see
+	// NewAgentParams.Seed).
+	Seed int64
+	// Fingerprints are the distinct fingerprints of the responses (see
+	// llm.Message.Fingerprint). A change between runs with the same seed
+	// means the backend changed.
+	Fingerprints []string
+	// ToolStats are the statistics of the tool calls by tool name.
+	ToolStats map[string]ToolStats
 }
 
+// ErrAgentBusy is returned by Run and Resume if the agent is already running.
+var ErrAgentBusy = errors.New("agent is already running")
+
+// Run runs the agent with the prompt. A failed run returns a *RunError.
 func (agent *Agent[ResultT]) Run(ctx context.Context, prompt string) (*RunResult[ResultT], error) {
+	if !agent.running.CompareAndSwap(false, true) {
+		return nil, ErrAgentBusy
+	}
+	defer agent.running.Store(false)
+	unlock, err := agent.lockSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	agent.addUserPrompt(prompt)
+	res, err := agent.run(ctx, prompt)
+	if err != nil {
+		err = agent.runError(ctx, err)
+	}
+	if agent.hooks.OnToolStats != nil {
+		agent.hooks.OnToolStats(agent.agentNum, agent.toolStats.stats())
+	}
+	agent.finishEvents(ctx, err)
+	return res, err
+}
+
+// Resume continues a run which failed (e.g. the provider returned an error
+// after several turns) from the current message history, without adding the
+// prompt again. prompt is the original prompt of the run. A failed run
+// returns a *RunError.
+func (agent *Agent[ResultT]) Resume(ctx context.Context, prompt string) (*RunResult[ResultT], error) {
+	if !agent.running.CompareAndSwap(false, true) {
+		return nil, ErrAgentBusy
+	}
+	defer agent.running.Store(false)
+	unlock, err := agent.lockSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if len(agent.llmMessages) == 0 {
+		return nil, fmt.Errorf("nothing to resume: empty message history")
+	}
+	if agent.llmMessages[len(agent.llmMessages)-1].Role == llm.RoleAssistant {
+		// Providers expect the conversation to end with a user message.
+		agent.addSystemReminder("The previous request was interrupted. Continue the task where you left off.")
+	}
+	agent.logger.Info("resuming run", "messages", len(agent.llmMessages))
+	res, err := agent.run(ctx, prompt)
+	if err != nil {
+		err = agent.runError(ctx, err)
+	}
+	if agent.hooks.OnToolStats != nil {
+		agent.hooks.OnToolStats(agent.agentNum, agent.toolStats.stats())
+	}
+	agent.finishEvents(ctx, err)
+	return res, err
+}
+
+func (agent *Agent[ResultT]) run(ctx context.Context, prompt string) (*RunResult[ResultT], error) {
 	// TODO: we probably only want to do it on success. This is a temporary
 	// change to debug the weird MALFORMED_FUNCTION_CALL Gemini errors.
 	defer func() {
@@ -104,65 +521,347 @@ func (agent *Agent[ResultT]) Run(ctx context.Context, prompt string) (*RunResult
 		}
 	}()
 
-	agent.addUserPrompt(prompt)
+	if agent.sandboxConfig != nil {
+		sb, err := sandbox.Start(ctx, *agent.sandboxConfig)
+		if err != nil {
+			return nil, &classifiedError{class: FailureTool, err: err}
+		}
+		agent.logger.Debug("sandbox started", "container", sb.ID())
+		agent.sandbox = sb
+		defer func() {
+			if err := sb.Close(); err != nil {
+				agent.logger.Error("close sandbox", "error", err)
+			}
+		}()
+	}
+
+	agent.applyContextDeadline(ctx)
+	if agent.timeline.Started.IsZero() {
+		agent.timeline.Started = agent.clock.Now()
+	}
 	for {
 		res, err := agent.runTurn(ctx)
 		switch {
 		case err != nil:
 			return nil, fmt.Errorf("run turn: %w", err)
+		case res.empty:
+			if err := agent.nudgeEmptyResponse(); err != nil {
+				return nil, err
+			}
 		case !res.finished:
 			// not finished yet, continue running turns
-		case agent.finalResultSet:
+		case agent.hasFinalResult():
 			// finished and have a final result
+			accepted, err := agent.acceptFinalResult(ctx, prompt)
+			if err != nil {
+				return nil, fmt.Errorf("accept final result: %w", err)
+			}
+			if !accepted {
+				continue // revise the result
+			}
+			agent.timeline.Duration = clock.Since(agent.clock, agent.timeline.Started)
+			agent.mu.Lock()
+			defer agent.mu.Unlock()
 			return &RunResult[ResultT]{
-				Data:       agent.finalResult,
-				TotalUsage: agent.llmUsage,
-				Messages:   agent.llmMessages,
+				RunID:          agent.runID,
+				Data:           agent.finalResult,
+				TotalUsage:     agent.llmUsage,
+				Messages:       slices.Clone(agent.llmMessages),
+				Plan:           agent.plan,
+				Findings:       slices.Clone(agent.findings),
+				Critiques:      agent.critiques,
+				UsageBreakdown: agent.usageBreakdown,
+				Timeline:       agent.timeline,
+				CacheStats:     NewCacheStats(agent.llmUsage),
+				Seed:           agent.Seed(),
+				Fingerprints:   fingerprints(agent.llmMessages),
+				ToolStats:      agent.toolStats.stats(),
 			}, nil
 		default:
 			// finished and didn't return a final result (structured result specific message)
-			s := "You need to call the FinalResult tool to return a result. Please do so."
-			agent.addSystemReminder(s)
+			agent.remindFinalResult()
 		}
 	}
 }
 
+// ErrMaxTokenUsageExceeded is returned by Run if the token budget is used up.
+var ErrMaxTokenUsageExceeded = errors.New("maximum token usage exceeded")
+
+// DefaultFinalTurnBuffer is the time reserved for the final result turn
+// before the context deadline.
+const DefaultFinalTurnBuffer = 20 * time.Second
+
+// applyContextDeadline moves the timebox before the deadline of ctx (minus
+// the final turn buffer), so the model is asked to wrap up in time.
+func (agent *Agent[ResultT]) applyContextDeadline(ctx context.Context) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	buffer := agent.finalTurnBuffer
+	if buffer <= 0 {
+		buffer = DefaultFinalTurnBuffer
+	}
+	until := deadline.Add(-buffer)
+	if agent.timeboxedUntil.IsZero() || until.Before(agent.timeboxedUntil) {
+		agent.logger.Debug("timebox derived from context deadline", "until", until)
+		agent.timeboxedUntil = until
+	}
+}
+
 func (agent *Agent[ResultT]) AgentNum() int {
 	return agent.agentNum
 }
 
+func (agent *Agent[ResultT]) RunID() string {
+	return agent.runID
+}
+
+// Seed returns the seed sent with the requests, 0 if none was set (see
+// NewAgentParams.Seed).
+func (agent *Agent[ResultT]) Seed() int64 {
+	if agent.seed == nil {
+		return 0
+	}
+	return *agent.seed
+}
+
+// ToolStats returns the statistics of the tool calls, e.g. of a failed run.
+// It must not be called while the agent is running.
+func (agent *Agent[ResultT]) ToolStats() map[string]ToolStats {
+	return agent.toolStats.stats()
+}
+
+func fingerprints(messages []llm.Message) []string {
+	var fps []string
+	for _, msg := range messages {
+		if msg.Fingerprint != "" && !slices.Contains(fps, msg.Fingerprint) {
+			fps = append(fps, msg.Fingerprint)
+		}
+	}
+	return fps
+}
+
+// Messages returns a copy of the current message history. After a failed Run
+// it can be used to resume the run with another agent (see
+// NewAgentParams.LLMMessages).
+func (agent *Agent[ResultT]) Messages() []llm.Message {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return slices.Clone(agent.llmMessages)
+}
+
+// Usage returns the token usage of the agent so far.
+func (agent *Agent[ResultT]) Usage() llm.TokenUsage {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return agent.llmUsage
+}
+
+// Running reports whether a run is in progress.
+func (agent *Agent[ResultT]) Running() bool {
+	return agent.running.Load()
+}
+
+// AddTools makes tools available from the next turn on, e.g. to unlock a
+// tool from a hook once a plan is approved. Tools with the same name are
+// replaced, except the built-in tools (see tool.Belt.IsBuiltin): the
+// definitions with their names are ignored.
+func (agent *Agent[ResultT]) AddTools(defs ...tool.Definition) {
+	for _, def := range defs {
+		if agent.toolBelt.IsBuiltin(def.Name) {
+			agent.logger.Warn("the built-in tools can't be replaced, tool ignored", "tool", def.Name)
+		}
+	}
+	agent.toolBelt.AddTools(agent.enrich(defs)...)
+}
+
+// RemoveTools withdraws tools by name from the next turn on. Calls of
+// removed tools fail as calls of unknown tools. The built-in tools can't be
+// removed, their names are ignored.
+func (agent *Agent[ResultT]) RemoveTools(names ...string) {
+	agent.toolBelt.RemoveTools(names...)
+}
+
+// enrich applies NewAgentParams.EnrichTools to the tools.
+func (agent *Agent[ResultT]) enrich(defs []tool.Definition) []tool.Definition {
+	if agent.enrichTools == nil {
+		return defs
+	}
+	enriched := make([]tool.Definition, len(defs))
+	for i, def := range defs {
+		enriched[i] = tool.Enrich(def, *agent.enrichTools)
+	}
+	return enriched
+}
+
 func (agent *Agent[ResultT]) SetFinalResult(v ResultT) {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
 	agent.finalResult = v
 	agent.finalResultSet = true
 }
 
+func (agent *Agent[ResultT]) hasFinalResult() bool {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return agent.finalResultSet
+}
+
+// setTextResult sets the final result to the text of the response, in
+// free-text mode. Responses without text (e.g. only thinking) are ignored.
+func (agent *Agent[ResultT]) setTextResult(message llm.Message) {
+	text := llm.History{message}.FinalText()
+	if text == "" {
+		return
+	}
+	if v, ok := any(text).(ResultT); ok {
+		agent.SetFinalResult(v)
+	}
+}
+
+func (agent *Agent[ResultT]) SetPlan(plan tool.Plan) {
+	agent.logger.Info(fmt.Sprintf("plan updated:\n%s", plan))
+	agent.mu.Lock()
+	agent.plan = plan
+	agent.turnsSincePlanUpdate = 0
+	agent.mu.Unlock()
+	if agent.hooks.OnPlanUpdate != nil {
+		agent.hooks.OnPlanUpdate(agent.agentNum, plan)
+	}
+}
+
+// AddFinding records a finding reported with the EmitFinding tool.
+func (agent *Agent[ResultT]) AddFinding(ctx context.Context, f tool.Finding) {
+	agent.logger.Info("finding reported", "title", f.Title, "location", f.Location, "severity", f.Severity)
+	agent.mu.Lock()
+	agent.findings = append(agent.findings, f)
+	agent.mu.Unlock()
+	if agent.hooks.OnFinding != nil {
+		agent.hooks.OnFinding(agent.agentNum, f)
+	}
+	agent.emit(ctx, Event{Type: EventFinding, Finding: &f})
+}
+
+// Findings returns the findings reported so far, e.g. of a failed run.
+func (agent *Agent[ResultT]) Findings() []tool.Finding {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	return slices.Clone(agent.findings)
+}
+
 func (agent *Agent[ResultT]) addUserPrompt(prompt string) {
-	promptMessage := llm.NewUserMessage(llm.TextContent{Text: prompt})
-	agent.llmMessages = append(agent.llmMessages, promptMessage)
+	promptMessage := llm.NewUserMessage(llm.TextContent{Text: agent.sanitizeContent(prompt)})
+	agent.appendMessages(promptMessage)
+}
+
+// repairHistory fixes a restored history which would be rejected by the
+// providers, e.g. a session saved while tools were running or recorded with
+// another provider.
+func (agent *Agent[ResultT]) repairHistory(strict bool) error {
+	if len(agent.llmMessages) == 0 {
+		return nil
+	}
+	// The history may have been recorded with another provider.
+	ported, changes := llm.PortHistory(agent.llmMessages)
+	for _, change := range changes {
+		agent.logger.Info("ported history", "change", change)
+	}
+	agent.llmMessages = ported
+	repaired, repairs := llm.RepairHistory(agent.llmMessages)
+	if len(repairs) == 0 {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("invalid history: %s", strings.Join(repairs, "; "))
+	}
+	for _, repair := range repairs {
+		agent.logger.Warn("repaired history", "repair", repair)
+	}
+	agent.llmMessages = repaired
+	return nil
+}
+
+// appendMessages adds messages to the history. Only the running goroutine
+// modifies the history, so it can read it without locking.
+func (agent *Agent[ResultT]) appendMessages(messages ...llm.Message) {
+	annotated := make([]llm.Message, len(messages))
+	for i, msg := range messages {
+		annotated[i] = agent.annotate(msg)
+	}
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	agent.llmMessages = append(agent.llmMessages, annotated...)
+}
+
+// annotate sets the metadata of a message added to the history, see
+// llm.Message.Metadata.
+func (agent *Agent[ResultT]) annotate(msg llm.Message) llm.Message {
+	metadata := maps.Clone(msg.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[llm.MetadataTurn] = strconv.Itoa(len(agent.timeline.Turns) + 1)
+	metadata[llm.MetadataRunID] = agent.runID
+	metadata[llm.MetadataCreatedAt] = agent.clock.Now().UTC().Format(time.RFC3339Nano)
+	var tools []string
+	for _, part := range msg.Parts {
+		if res, ok := part.(llm.ToolResult); ok && !slices.Contains(tools, res.ToolName) {
+			tools = append(tools, res.ToolName)
+		}
+	}
+	if len(tools) > 0 {
+		metadata[llm.MetadataTools] = strings.Join(tools, ",")
+	}
+	msg.Metadata = metadata
+	return msg
 }
 
 func (agent *Agent[ResultT]) updateUsage(u llm.TokenUsage) error {
-	agent.llmUsage.InputTokens += u.InputTokens
-	agent.llmUsage.OutputTokens += u.OutputTokens
-	agent.llmUsage.CacheCreationTokens += u.CacheCreationTokens
-	agent.llmUsage.CacheReadTokens += u.CacheReadTokens
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	agent.llmUsage = agent.llmUsage.Add(u)
 
 	if agent.maxTokenUsage > 0 {
 		totalUsage := agent.llmUsage.Total()
 		if totalUsage > int64(agent.maxTokenUsage) {
 			return fmt.Errorf(
-				"maximum token usage exceeded: %d > %d",
-				totalUsage, agent.maxTokenUsage,
+				"%w: %d > %d",
+				ErrMaxTokenUsageExceeded, totalUsage, agent.maxTokenUsage,
 			)
 		}
 	}
 	return nil
 }
 
+func (agent *Agent[ResultT]) sanitizeContent(s string) string {
+	if agent.sanitize == nil {
+		return s
+	}
+	return agent.sanitize(s)
+}
+
 func (agent *Agent[ResultT]) addSystemReminder(content string) {
+	if agent.hasPendingReminder(content) {
+		agent.logger.Debug(fmt.Sprintf("skipping duplicate system reminder: %s", content))
+		return
+	}
 	agent.logger.Info(fmt.Sprintf("adding system reminder: %s", content))
 
-	prompt := "<system-reminder>" + content + "</system-reminder>"
+	switch agent.reminderStrategy {
+	case ReminderSystemPrompt:
+		// The conversation must end with a user message, so it is only
+		// possible after the tool results.
+		if n := len(agent.llmMessages); n > 0 && agent.llmMessages[n-1].Role.IsUserTurn() {
+			agent.pendingReminders = append(agent.pendingReminders, content)
+			return
+		}
+	case ReminderDeveloperMessage:
+		agent.appendMessages(llm.NewDeveloperMessage(content))
+		return
+	}
+
+	prompt := llm.SystemReminder{Text: content}.TaggedText()
 	promptMessage := llm.NewUserMessage(llm.TextContent{Text: prompt})
-	agent.llmMessages = append(agent.llmMessages, promptMessage)
+	agent.appendMessages(promptMessage)
 }
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// The benchmarks continue a large history, to measure the costs growing with
+// the conversation:
+//
+//	go test -run '^$' -bench . -benchmem -cpuprofile cpu.out ./pkg/core
+const (
+	benchHistoryLen = 1000
+	benchTurns      = 10
+)
+
+// BenchmarkRun measures runs continuing the history, the provider calls a
+// tool on every turn but the last one.
+func BenchmarkRun(b *testing.B) {
+	history := newBenchHistory(benchHistoryLen)
+	echo := tool.New("Echo", "Echoes the input.", func(_ context.Context, in struct {
+		Text string `json:"text"`
+	}) (string, error) {
+		return in.Text, nil
+	})
+	b.ReportAllocs()
+	for b.Loop() {
+		agent, err := NewAgent[string](NewAgentParams{
+			SystemPrompt: "You are a benchmark.",
+			LLM:          &benchProvider{turns: benchTurns},
+			LLMMessages:  history,
+			Tools:        []tool.Definition{echo},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := agent.Run(context.Background(), "Continue."); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// newBenchHistory returns a valid history of n messages (rounded up to whole
+// exchanges) alternating tool calls and results, like a long agent run.
+func newBenchHistory(n int) []llm.Message {
+	history := []llm.Message{llm.NewUserMessage(llm.TextContent{Text: "Review the changes."})}
+	result := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20)
+	for i := 0; len(history) < n; i++ {
+		id := fmt.Sprintf("call_%d", i)
+		history = append(history,
+			llm.Message{Role: llm.RoleAssistant, Parts: []llm.ContentPart{
+				llm.TextContent{Text: "Let me look at the next file."},
+				llm.ToolCall{ID: id, Name: "Echo", Input: json.RawMessage(fmt.Sprintf(`{"text":"file_%d.go"}`, i))},
+			}},
+			llm.NewUserMessage(llm.ToolResult{ToolName: "Echo", ToolCallID: id, Content: result}),
+		)
+	}
+	return history
+}
+
+// benchProvider responds instantly, calling the Echo tool until the last
+// turn.
+type benchProvider struct {
+	turns int
+	turn  int
+}
+
+func (p *benchProvider) NewMessage(_ context.Context, params llm.NewMessageParams) (llm.Message, error) {
+	p.turn++
+	usage := llm.TokenUsage{InputTokens: int64(len(params.History)) * 100, OutputTokens: 50}
+	id := fmt.Sprintf("bench_%d", p.turn)
+	if p.turn < p.turns {
+		return llm.Message{Role: llm.RoleAssistant, Usage: usage, Parts: []llm.ContentPart{
+			llm.ToolCall{ID: id, Name: "Echo", Input: json.RawMessage(`{"text":"hello"}`)},
+		}}, nil
+	}
+	return llm.Message{Role: llm.RoleAssistant, Usage: usage, Parts: []llm.ContentPart{
+		llm.ToolCall{ID: id, Name: tool.FinalResultToolName, Input: json.RawMessage(`{"response":"done"}`)},
+	}}, nil
+}
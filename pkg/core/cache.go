@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+const (
+	// CacheReadCostFactor and CacheWriteCostFactor are the prices of cache
+	// reads and writes relative to uncached input tokens (Anthropic pricing,
+	// OpenAI and Gemini discount reads less and don't charge writes).
+	CacheReadCostFactor  = 0.1
+	CacheWriteCostFactor = 1.25
+	// CacheMissTurns is the number of consecutive turns without cache reads
+	// after which Hooks.OnCacheMiss is called.
+	CacheMissTurns = 3
+	// minCacheablePromptTokens is the minimum prompt size providers cache.
+	minCacheablePromptTokens = 1024
+)
+
+// CacheStats summarizes the prompt caching of a run.
+type CacheStats struct {
+	// HitRate is the ratio of the prompt tokens read from the cache.
+	HitRate float64
+	// SavedInputTokens estimates the savings in uncached input token
+	// equivalents: the discount of the cache reads minus the surcharge of the
+	// cache writes. Negative if caching cost more than it saved.
+	SavedInputTokens float64
+}
+
+// NewCacheStats computes the cache statistics of a usage.
+func NewCacheStats(u llm.TokenUsage) CacheStats {
+	prompt := u.InputTokens + u.CacheCreationTokens + u.CacheReadTokens
+	if prompt == 0 {
+		return CacheStats{}
+	}
+	return CacheStats{
+		HitRate: float64(u.CacheReadTokens) / float64(prompt),
+		SavedInputTokens: float64(u.CacheReadTokens)*(1-CacheReadCostFactor) -
+			float64(u.CacheCreationTokens)*(CacheWriteCostFactor-1),
+	}
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("hit rate: %.1f%%, saved input tokens: %.0f", s.HitRate*100, s.SavedInputTokens)
+}
+
+// trackCacheMiss counts the consecutive turns which could have been served
+// from the cache but weren't, which usually means the prompt prefix changes
+// between turns (e.g. the history or the tools are mutated).
+func (agent *Agent[ResultT]) trackCacheMiss(u llm.TokenUsage) {
+	prompt := u.InputTokens + u.CacheCreationTokens + u.CacheReadTokens
+	if u.CacheReadTokens > 0 || prompt < minCacheablePromptTokens || len(agent.timeline.Turns) == 0 {
+		agent.cacheMisses = 0
+		return
+	}
+	agent.cacheMisses++
+	if agent.cacheMisses != CacheMissTurns {
+		return // report a streak once
+	}
+	agent.logger.Warn("prompt cache is consistently missing", "turns", agent.cacheMisses, "usage", u)
+	if agent.hooks.OnCacheMiss != nil {
+		agent.hooks.OnCacheMiss(agent.agentNum, agent.cacheMisses)
+	}
+}
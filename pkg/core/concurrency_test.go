@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// The tests of this file are meant to be run with -race.
+
+func TestRunRejectsConcurrentRun(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	block := testTool("Block", func(context.Context, json.RawMessage) (string, error) {
+		close(started)
+		<-release
+		return "ok", nil
+	})
+	agent, err := newTestAgent(scriptedLLM([]llm.ContentPart{toolCall("1", "Block", `{}`)}), block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		_, err := agent.Run(context.Background(), "Block.")
+		runErr <- err
+	}()
+	<-started
+	if !agent.Running() {
+		t.Error("Running = false during the run")
+	}
+	if _, err := agent.Run(context.Background(), "Again."); !errors.Is(err, ErrAgentBusy) {
+		t.Errorf("concurrent Run error = %v, want ErrAgentBusy", err)
+	}
+	if _, err := agent.Resume(context.Background(), "Again."); !errors.Is(err, ErrAgentBusy) {
+		t.Errorf("concurrent Resume error = %v, want ErrAgentBusy", err)
+	}
+	close(release)
+	if err := <-runErr; err != nil {
+		t.Fatal(err)
+	}
+	if agent.Running() {
+		t.Error("Running = true after the run")
+	}
+
+	// The agent can run again once the run finished.
+	if _, err := agent.Run(context.Background(), "Again."); err != nil {
+		t.Errorf("second Run error = %v", err)
+	}
+}
+
+func TestAccessorsDuringRun(t *testing.T) {
+	const turns = 5
+	use := func(context.Context, json.RawMessage) (string, error) { return "ok", nil }
+	var script [][]llm.ContentPart
+	for i := range turns {
+		script = append(script, []llm.ContentPart{
+			llm.TextContent{Text: fmt.Sprintf("Turn %d.", i)},
+			toolCall(fmt.Sprintf("a%d", i), "Work", `{}`),
+			toolCall(fmt.Sprintf("b%d", i), "Work", `{}`),
+		})
+	}
+	agent, err := newTestAgent(scriptedLLM(script...), testTool("Work", use))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_ = agent.Messages()
+				_ = agent.Usage()
+				_ = agent.Findings()
+				_ = agent.Running()
+			}
+		}()
+	}
+	res, err := agent.Run(context.Background(), "Work.")
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := agent.Usage(), res.TotalUsage; got != want {
+		t.Errorf("Usage = %+v, want %+v", got, want)
+	}
+	if got, want := len(agent.Messages()), len(res.Messages); got != want {
+		t.Errorf("%d messages, want %d", got, want)
+	}
+}
+
+func TestParallelToolWrites(t *testing.T) {
+	const findings = 8
+	plan, _ := json.Marshal(tool.Plan{Steps: []tool.PlanStep{{Description: "Check", Status: tool.PlanStepInProgress}}})
+	parts := []llm.ContentPart{toolCall("plan", tool.UpdatePlanToolName, string(plan))}
+	for i := range findings {
+		input, _ := json.Marshal(tool.Finding{Title: fmt.Sprintf("Finding %d", i)})
+		parts = append(parts, toolCall(fmt.Sprintf("f%d", i), tool.EmitFindingToolName, string(input)))
+	}
+	// The following turns leave the plan alone, so it's reminded.
+	script := [][]llm.ContentPart{parts}
+	for i := range 3 {
+		script = append(script, []llm.ContentPart{
+			toolCall(fmt.Sprintf("a%d", i), "Work", `{}`),
+			toolCall(fmt.Sprintf("b%d", i), "Work", `{}`),
+		})
+	}
+	provider := scriptedLLM(script...)
+
+	var hookMu sync.Mutex
+	var hookFindings int
+	p := testParams(provider, testTool("Work", func(context.Context, json.RawMessage) (string, error) { return "ok", nil }))
+	p.EnablePlanning = true
+	p.EnableFindings = true
+	p.PlanReminderTurns = 1
+	p.Hooks.OnFinding = func(int, tool.Finding) {
+		hookMu.Lock()
+		hookFindings++
+		hookMu.Unlock()
+	}
+	agent, err := NewAgent[string](p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := agent.Events()
+	eventsDone := make(chan int)
+	go func() {
+		var n int
+		for e := range events {
+			if e.Type == EventFinding {
+				n++
+			}
+		}
+		eventsDone <- n
+	}()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			_ = agent.Findings()
+			_ = agent.Messages()
+		}
+	}()
+	res, err := agent.Run(context.Background(), "Check.")
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Findings) != findings || hookFindings != findings {
+		t.Errorf("%d findings, %d reported to the hook, want %d", len(res.Findings), hookFindings, findings)
+	}
+	if n := <-eventsDone; n != findings {
+		t.Errorf("%d finding events, want %d", n, findings)
+	}
+	if len(res.Plan.Steps) != 1 {
+		t.Errorf("plan = %+v, want the updated plan", res.Plan)
+	}
+	var reminded bool
+	for _, msg := range res.Messages {
+		if msg.Role.IsUserTurn() && len(msg.Parts) > 0 {
+			if text, ok := msg.Parts[0].(llm.TextContent); ok && strings.Contains(text.Text, "This is your current plan") {
+				reminded = true
+			}
+		}
+	}
+	if !reminded {
+		t.Error("the plan was not reminded")
+	}
+}
+
+func TestSetPlanDuringRun(t *testing.T) {
+	var script [][]llm.ContentPart
+	for i := range 5 {
+		script = append(script, []llm.ContentPart{toolCall(fmt.Sprintf("w%d", i), "Work", `{}`)})
+	}
+	setting := make(chan struct{})
+	p := testParams(scriptedLLM(script...), testTool("Work", func(context.Context, json.RawMessage) (string, error) {
+		<-setting
+		return "ok", nil
+	}))
+	p.EnablePlanning = true
+	p.PlanReminderTurns = 1
+	agent, err := NewAgent[string](p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			// E.g. a hook restoring the plan of a previous run.
+			agent.SetPlan(tool.Plan{Steps: []tool.PlanStep{{Description: fmt.Sprintf("Step %d", i)}}})
+			if i == 1 {
+				close(setting)
+			}
+		}
+	}()
+	_, err = agent.Run(context.Background(), "Work.")
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+type CritiqueParams struct {
+	// LLM reviews the result. Defaults to the LLM of the agent, a cheaper
+	// model is usually good enough.
+	LLM llm.Provider
+	// Criteria are the instructions the result is checked against (mandatory).
+	Criteria string
+	// MaxRevisions is the number of times the model can revise its result
+	// after a rejection. If 0, critiques are only recorded in the RunResult.
+	MaxRevisions int
+}
+
+// Critique is the verdict of the reviewer on a final result.
+type Critique struct {
+	Approved bool   `json:"approved" jsonschema_description:"Whether the result meets all criteria"`
+	Feedback string `json:"feedback" jsonschema_description:"Concrete issues with the result and how to fix them. Empty if approved."`
+}
+
+const systemCritique = "You are a meticulous reviewer. You receive a task given to an AI agent, " +
+	"the result it produced, and the review criteria. Check the result strictly against the criteria and the task. " +
+	"Only reject it for real problems, and explain them concretely so the agent can fix them."
+
+// critique runs the reviewer on the current final result.
+func (agent *Agent[ResultT]) critique(ctx context.Context, prompt string) (Critique, error) {
+	agent.mu.Lock()
+	result := agent.finalResult
+	agent.mu.Unlock()
+	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return Critique{}, fmt.Errorf("marshal result: %w", err)
+	}
+
+	provider := agent.critiqueParams.LLM
+	if provider == nil {
+		provider = agent.llm
+	}
+	reviewer, err := NewAgent[Critique](NewAgentParams{
+		SystemPrompt:     systemCritique,
+		LLM:              provider,
+		MaxToolLogLength: agent.maxToolLogLength,
+		Logger:           agent.logger.With("critique", true),
+		Limiter:          agent.limiter,
+		Priority:         agent.priority,
+		Clock:            agent.clock,
+	})
+	if err != nil {
+		return Critique{}, fmt.Errorf("new reviewer agent: %w", err)
+	}
+	res, err := reviewer.Run(ctx, fmt.Sprintf(
+		"<task>%s</task>\n\n<result>%s</result>\n\n<criteria>%s</criteria>\n\n"+
+			"Review the result and return your verdict by calling the %q tool.",
+		prompt, resultJSON, agent.critiqueParams.Criteria, tool.FinalResultToolName,
+	))
+	if err != nil {
+		return Critique{}, fmt.Errorf("run reviewer: %w", err)
+	}
+	agent.usageBreakdown.addPhase(PhaseCritique, res.TotalUsage)
+	if err := agent.updateUsage(res.TotalUsage); err != nil {
+		return Critique{}, fmt.Errorf("update usage: %w", err)
+	}
+	return res.Data, nil
+}
+
+// acceptFinalResult runs the critique (if enabled) on the final result, and
+// returns false if the model should revise it.
+func (agent *Agent[ResultT]) acceptFinalResult(ctx context.Context, prompt string) (bool, error) {
+	if agent.critiqueParams == nil {
+		return true, nil
+	}
+	c, err := agent.critique(ctx, prompt)
+	if err != nil {
+		return false, fmt.Errorf("critique: %w", err)
+	}
+	agent.critiques = append(agent.critiques, c)
+	agent.logger.Info("critique of final result", "approved", c.Approved, "feedback", c.Feedback)
+	if c.Approved || agent.revisions >= agent.critiqueParams.MaxRevisions {
+		return true, nil
+	}
+
+	agent.revisions++
+	agent.mu.Lock()
+	agent.finalResultSet = false
+	agent.mu.Unlock()
+	if agent.freeText {
+		agent.addSystemReminder(fmt.Sprintf(
+			"A reviewer rejected your result with the following feedback:\n%s\n"+
+				"Address the feedback, then respond with the revised result.",
+			c.Feedback,
+		))
+		return false, nil
+	}
+	agent.addSystemReminder(fmt.Sprintf(
+		"A reviewer rejected your result with the following feedback:\n%s\n"+
+			"Address the feedback, then call the %s tool again with the revised result.",
+		c.Feedback, agent.toolBelt.FinalResultName(),
+	))
+	return false, nil
+}
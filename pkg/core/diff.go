@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ChangeKind is the kind of a ResultChange.
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeUpdated ChangeKind = "updated"
+)
+
+// ResultChange is a difference between two results at a JSON path, e.g.
+// "findings[2].severity". The path is empty for the root.
+type ResultChange struct {
+	Path string
+	Kind ChangeKind
+	// Old and New are the decoded JSON values, Old is nil for additions and
+	// New is nil for removals.
+	Old any
+	New any
+}
+
+func (c ResultChange) String() string {
+	path := c.Path
+	if path == "" {
+		path = "(root)"
+	}
+	switch c.Kind {
+	case ChangeAdded:
+		return fmt.Sprintf("+ %s: %s", path, diffValue(c.New))
+	case ChangeRemoved:
+		return fmt.Sprintf("- %s: %s", path, diffValue(c.Old))
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", path, diffValue(c.Old), diffValue(c.New))
+	}
+}
+
+// DiffResults compares the data of two run results field by field, e.g. to
+// compare the results of model versions or prompt revisions in evals. The
+// results are compared by their JSON encoding, so json tags and omitempty
+// apply. Array elements are compared by index.
+func DiffResults[ResultT any](before, after *RunResult[ResultT]) ([]ResultChange, error) {
+	beforeJSON, err := json.Marshal(before.Data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal before result: %w", err)
+	}
+	afterJSON, err := json.Marshal(after.Data)
+	if err != nil {
+		return nil, fmt.Errorf("marshal after result: %w", err)
+	}
+	return DiffJSON(beforeJSON, afterJSON)
+}
+
+// DiffJSON compares two JSON documents, see DiffResults.
+func DiffJSON(before, after []byte) ([]ResultChange, error) {
+	beforeValue, err := decodeDiffValue(before)
+	if err != nil {
+		return nil, fmt.Errorf("decode before: %w", err)
+	}
+	afterValue, err := decodeDiffValue(after)
+	if err != nil {
+		return nil, fmt.Errorf("decode after: %w", err)
+	}
+	var changes []ResultChange
+	diffValues("", beforeValue, afterValue, &changes)
+	return changes, nil
+}
+
+// FormatDiff renders the changes one per line, "+" for additions, "-" for
+// removals and "~" for updates.
+func FormatDiff(changes []ResultChange) string {
+	var sb strings.Builder
+	for _, c := range changes {
+		sb.WriteString(c.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func decodeDiffValue(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber() // compare numbers exactly
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func diffValues(path string, old, updated any, changes *[]ResultChange) {
+	switch o := old.(type) {
+	case map[string]any:
+		n, ok := updated.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		for k := range n {
+			if _, ok := o[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			switch {
+			case !inNew:
+				*changes = append(*changes, ResultChange{Path: childPath, Kind: ChangeRemoved, Old: ov})
+			case !inOld:
+				*changes = append(*changes, ResultChange{Path: childPath, Kind: ChangeAdded, New: nv})
+			default:
+				diffValues(childPath, ov, nv, changes)
+			}
+		}
+		return
+	case []any:
+		n, ok := updated.([]any)
+		if !ok {
+			break
+		}
+		for i := range max(len(o), len(n)) {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(n):
+				*changes = append(*changes, ResultChange{Path: childPath, Kind: ChangeRemoved, Old: o[i]})
+			case i >= len(o):
+				*changes = append(*changes, ResultChange{Path: childPath, Kind: ChangeAdded, New: n[i]})
+			default:
+				diffValues(childPath, o[i], n[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(old, updated) {
+		*changes = append(*changes, ResultChange{Path: path, Kind: ChangeUpdated, Old: old, New: updated})
+	}
+}
+
+func diffValue(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// EventTurnStarted is sent before the request of every turn.
+	EventTurnStarted EventType = "turn_started"
+	// EventTextDelta carries text written by the model. Providers which don't
+	// stream send the whole text of a response in one delta.
+	EventTextDelta EventType = "text_delta"
+	// EventToolCall is sent for every tool call of the model.
+	EventToolCall EventType = "tool_call"
+	// EventToolResult is sent when a tool call returns.
+	EventToolResult EventType = "tool_result"
+	// EventFinding is sent when the model reports a finding with the
+	// EmitFinding tool.
+	EventFinding EventType = "finding"
+	// EventFinished is the last event of a run, Err is set if it failed.
+	EventFinished EventType = "finished"
+)
+
+// EventBufferSize is the capacity of the channel returned by Agent.Events.
+const EventBufferSize = 256
+
+// Event is a step of a run, see Agent.Events.
+type Event struct {
+	Type    EventType
+	AgentID int
+	// Turn is the number of the turn of the agent, starting from 1.
+	Turn int
+	Time time.Time
+	// Text is set for EventTextDelta.
+	Text string
+	// ToolCall is set for EventToolCall.
+	ToolCall *llm.ToolCall
+	// ToolResult is set for EventToolResult.
+	ToolResult *llm.ToolResult
+	// Finding is set for EventFinding.
+	Finding *tool.Finding
+	// Err is set for EventFinished if the run failed.
+	Err error
+}
+
+// Events returns the events of the next (or current) run, e.g. to render
+// progress live. The channel is closed after the EventFinished event of the
+// run, call Events again for the following runs. The consumer must drain the
+// channel: the run blocks while the buffer is full.
+func (agent *Agent[ResultT]) Events() <-chan Event {
+	agent.eventsMu.Lock()
+	defer agent.eventsMu.Unlock()
+	if agent.events == nil {
+		agent.events = make(chan Event, EventBufferSize)
+	}
+	return agent.events
+}
+
+// emit sends an event if Events was called. It gives up if ctx is canceled,
+// the tool calls still in flight of a canceled run return after it finished.
+// eventsMu is held during the send, so the channel isn't closed meanwhile.
+func (agent *Agent[ResultT]) emit(ctx context.Context, e Event) {
+	agent.eventsMu.Lock()
+	defer agent.eventsMu.Unlock()
+	if agent.events == nil || ctx.Err() != nil {
+		return
+	}
+	e.AgentID = agent.agentNum
+	e.Time = agent.clock.Now()
+	e.Turn = int(agent.turn.Load())
+	select {
+	case agent.events <- e:
+	case <-ctx.Done():
+	}
+}
+
+// finishEvents sends EventFinished and closes the channel of the run. If ctx
+// is canceled, EventFinished is only sent if the buffer has room.
+func (agent *Agent[ResultT]) finishEvents(ctx context.Context, err error) {
+	agent.eventsMu.Lock()
+	defer agent.eventsMu.Unlock()
+	events := agent.events
+	if events == nil {
+		return
+	}
+	agent.events = nil
+	defer close(events)
+	e := Event{Type: EventFinished, AgentID: agent.agentNum, Turn: int(agent.turn.Load()), Time: agent.clock.Now(), Err: err}
+	if ctx.Err() != nil {
+		select {
+		case events <- e:
+		default:
+		}
+		return
+	}
+	select {
+	case events <- e:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+func TestEventsCanceledWithToolsInFlight(t *testing.T) {
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	returned := make(chan struct{}, 3)
+	// The tool ignores the cancellation, it returns after the run finished.
+	slow := testTool("Slow", func(context.Context, json.RawMessage) (string, error) {
+		started <- struct{}{}
+		<-release
+		defer func() { returned <- struct{}{} }()
+		return "ok", nil
+	})
+	provider := scriptedLLM([]llm.ContentPart{
+		toolCall("1", "Slow", `{}`),
+		toolCall("2", "Slow", `{}`),
+		toolCall("3", "Slow", `{}`),
+	})
+	agent, err := newTestAgent(provider, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := agent.Events()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() {
+		_, err := agent.Run(ctx, "Call the tools.")
+		runErr <- err
+	}()
+	for range 3 {
+		<-started
+	}
+	cancel()
+
+	var finished *Event
+	for e := range events {
+		if e.Type == EventFinished {
+			finished = &e
+		}
+	}
+	if err := <-runErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if finished == nil || finished.Err == nil {
+		t.Errorf("EventFinished = %+v, want it with the error of the run", finished)
+	}
+
+	// The tools emit their results after the channel was closed, it must
+	// not panic.
+	close(release)
+	for range 3 {
+		<-returned
+	}
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestEventsOrder(t *testing.T) {
+	echo := testTool("Echo", func(_ context.Context, input json.RawMessage) (string, error) {
+		return string(input), nil
+	})
+	provider := scriptedLLM([]llm.ContentPart{
+		llm.TextContent{Text: "Calling."},
+		toolCall("1", "Echo", `{"a":1}`),
+	})
+	agent, err := newTestAgent(provider, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := agent.Events()
+	if _, err := agent.Run(context.Background(), "Echo."); err != nil {
+		t.Fatal(err)
+	}
+
+	var types []EventType
+	for e := range events {
+		types = append(types, e.Type)
+	}
+	want := []EventType{
+		EventTurnStarted, EventTextDelta, EventToolCall, EventToolResult,
+		EventTurnStarted, EventToolCall, EventToolResult,
+		EventFinished,
+	}
+	if len(types) != len(want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("events = %v, want %v", types, want)
+		}
+	}
+}
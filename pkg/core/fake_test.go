@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// fakeLLM responds with the messages returned by respond, it records the
+// requests.
+type fakeLLM struct {
+	respond func(params llm.NewMessageParams) llm.Message
+
+	mu       sync.Mutex
+	requests []llm.NewMessageParams
+}
+
+func (f *fakeLLM) NewMessage(ctx context.Context, params llm.NewMessageParams) (llm.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return llm.Message{}, err
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, params)
+	f.mu.Unlock()
+	msg := f.respond(params)
+	msg.Role = llm.RoleAssistant
+	msg.Usage = llm.TokenUsage{InputTokens: 10, OutputTokens: 5}
+	return msg, nil
+}
+
+func (f *fakeLLM) Requests() []llm.NewMessageParams {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+// scriptedLLM responds with the turns in order, then with a final result.
+func scriptedLLM(turns ...[]llm.ContentPart) *fakeLLM {
+	return &fakeLLM{respond: func(params llm.NewMessageParams) llm.Message {
+		var turn int
+		for _, msg := range params.History {
+			if msg.Role == llm.RoleAssistant {
+				turn++
+			}
+		}
+		if turn < len(turns) {
+			return llm.Message{Parts: turns[turn]}
+		}
+		return llm.Message{Parts: []llm.ContentPart{finalResultCall(fmt.Sprintf("final-%d", turn), "done")}}
+	}}
+}
+
+func toolCall(id, name, input string) llm.ToolCall {
+	return llm.ToolCall{ID: id, Name: name, Input: json.RawMessage(input)}
+}
+
+func finalResultCall(id, response string) llm.ToolCall {
+	input, _ := json.Marshal(map[string]string{"response": response})
+	return llm.ToolCall{ID: id, Name: tool.FinalResultToolName, Input: input}
+}
+
+func testTool(name string, use func(context.Context, json.RawMessage) (string, error)) tool.Definition {
+	return tool.Definition{
+		ToolDefinition: llm.ToolDefinition{Name: name, Description: "A test tool."},
+		UseFunc:        use,
+	}
+}
+
+func testParams(provider llm.Provider, tools ...tool.Definition) NewAgentParams {
+	return NewAgentParams{
+		SystemPrompt:     "You are a test agent.",
+		LLM:              provider,
+		MaxToolLogLength: 500,
+		Tools:            tools,
+	}
+}
+
+func newTestAgent(provider llm.Provider, tools ...tool.Definition) (*Agent[string], error) {
+	return NewAgent[string](testParams(provider, tools...))
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// Hooks are optional callbacks invoked during a run. They are called
+// synchronously, so they should return quickly.
+type Hooks struct {
+	// OnPlanUpdate is called when the model updates its plan with the
+	// UpdatePlan tool.
+	OnPlanUpdate func(agentID int, plan tool.Plan)
+	// OnFinding is called when the model reports a finding with the
+	// EmitFinding tool. Findings reported by parallel tool calls may arrive
+	// concurrently.
+	OnFinding func(agentID int, finding tool.Finding)
+	// OnEmptyResponse is called when the model returns neither text nor tool
+	// calls, count is the number of consecutive empty responses.
+	OnEmptyResponse func(agentID int, count int)
+	// OnMessage is called with every non-empty response of the model, before
+	// its tool calls are executed.
+	OnMessage func(agentID int, msg llm.Message)
+	// OnToolPanic is called when a tool panics. The panic is recovered and
+	// returned to the model as an error result.
+	OnToolPanic func(agentID int, toolName string, recovered any, stack []byte)
+	// OnCacheMiss is called once the prompt cache missed in CacheMissTurns
+	// consecutive turns, which usually means the prompt prefix changes
+	// between turns and caching costs more than it saves.
+	OnCacheMiss func(agentID int, turns int)
+	// OnSchemaFailure is called when the SchemaFailurePolicy escalates after
+	// failures consecutive final results didn't match the schema.
+	OnSchemaFailure func(agentID int, failures int, step SchemaFailureStep)
+	// OnPolicyDenied is called when the Policy denies a tool call, err wraps
+	// tool.ErrPolicyDenied.
+	OnPolicyDenied func(agentID int, toolName string, err error)
+	// OnToolStats is called at the end of every run, including the failed
+	// ones, with the statistics of the tool calls by tool name.
+	OnToolStats func(agentID int, stats map[string]ToolStats)
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator generates the IDs identifying agents and runs. Inject a custom
+// implementation to correlate runs with IDs of other systems (e.g. request
+// IDs of a distributed trace).
+type IDGenerator interface {
+	NewAgentID() int
+	NewRunID() string
+}
+
+// DefaultIDGenerator numbers agents sequentially within the process and uses
+// random UUIDs for runs.
+var DefaultIDGenerator IDGenerator = &counterIDGenerator{}
+
+type counterIDGenerator struct {
+	agentCounter atomic.Int64
+}
+
+func (g *counterIDGenerator) NewAgentID() int {
+	return int(g.agentCounter.Add(1))
+}
+
+func (g *counterIDGenerator) NewRunID() string {
+	return uuid.NewString()
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Limiter bounds the in-flight LLM requests and tool executions of all the
+// agents sharing it (e.g. one Limiter per process), so a large fan-out of
+// agents doesn't exhaust the sockets or the rate limits of the providers.
+// A nil Limiter doesn't limit anything.
+//
+// Waiting agents get the free slots in the order of their Priority, then in
+// FIFO order, so a user-facing agent isn't starved by a batch of background
+// agents. The priorities are strict: lower priority agents wait as long as
+// higher priority ones are waiting.
+type Limiter struct {
+	llmRequests *semaphore
+	toolCalls   *semaphore
+}
+
+// Priority of the requests of an agent, see Limiter.
+type Priority int
+
+const (
+	PriorityBatch       Priority = -1
+	PriorityNormal      Priority = 0
+	PriorityInteractive Priority = 1
+)
+
+type NewLimiterParams struct {
+	// MaxLLMRequests and MaxToolCalls limit the requests and tool calls in
+	// flight at the same time, 0 means unlimited.
+	MaxLLMRequests int
+	MaxToolCalls   int
+}
+
+func NewLimiter(p NewLimiterParams) *Limiter {
+	l := &Limiter{}
+	if p.MaxLLMRequests > 0 {
+		l.llmRequests = &semaphore{max: p.MaxLLMRequests}
+	}
+	if p.MaxToolCalls > 0 {
+		l.toolCalls = &semaphore{max: p.MaxToolCalls}
+	}
+	return l
+}
+
+// InFlight returns the number of LLM requests and tool calls in flight (only
+// the limited ones are counted).
+func (l *Limiter) InFlight() (llmRequests, toolCalls int) {
+	if l == nil {
+		return 0, 0
+	}
+	return l.llmRequests.len(), l.toolCalls.len()
+}
+
+func (l *Limiter) acquireLLM(ctx context.Context, priority Priority) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	return l.llmRequests.acquire(ctx, priority)
+}
+
+func (l *Limiter) acquireTool(ctx context.Context, priority Priority) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	return l.toolCalls.acquire(ctx, priority)
+}
+
+// semaphore is a counting semaphore handing the released slots to the
+// waiters with the highest priority.
+type semaphore struct {
+	mu       sync.Mutex
+	max      int
+	inFlight int
+	// waiters are indexed by priority (batch, normal, interactive).
+	waiters [3][]chan struct{}
+}
+
+func (s *semaphore) acquire(ctx context.Context, priority Priority) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	s.mu.Lock()
+	if s.inFlight < s.max && !s.hasWaiters() {
+		s.inFlight++
+		s.mu.Unlock()
+		return s.release, nil
+	}
+	p := min(max(int(priority-PriorityBatch), 0), len(s.waiters)-1)
+	ready := make(chan struct{})
+	s.waiters[p] = append(s.waiters[p], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return s.release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if i := slices.Index(s.waiters[p], ready); i >= 0 {
+			s.waiters[p] = slices.Delete(s.waiters[p], i, i+1)
+		} else {
+			s.releaseLocked() // the slot was handed over meanwhile
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+// releaseLocked hands the slot over to the next waiter, if any.
+func (s *semaphore) releaseLocked() {
+	for p := len(s.waiters) - 1; p >= 0; p-- {
+		if len(s.waiters[p]) > 0 {
+			close(s.waiters[p][0])
+			s.waiters[p] = s.waiters[p][1:]
+			return
+		}
+	}
+	s.inFlight--
+}
+
+func (s *semaphore) hasWaiters() bool {
+	for _, w := range s.waiters {
+		if len(w) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *semaphore) len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// AgentOption sets an optional field of NewAgentParams. Options are an
+// alternative to NewAgentParams literals which keep compiling as new knobs
+// are added.
+type AgentOption func(*NewAgentParams)
+
+// DefaultMaxToolLogLength is the length of the tool logs kept by the agents
+// unless NewAgentParams.MaxToolLogLength is set.
+const DefaultMaxToolLogLength = 500
+
+// NewAgentWith creates an agent talking to the provider with the given
+// options, see NewAgent.
+func NewAgentWith[ResultT any](provider llm.Provider, opts ...AgentOption) (*Agent[ResultT], error) {
+	p := NewAgentParams{LLM: provider}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	if p.timebox > 0 {
+		p.TimeboxedUntil = clock.Or(p.Clock).Now().Add(p.timebox)
+	}
+	return NewAgent[ResultT](p)
+}
+
+// WithSystemPrompt sets the system prompt.
+func WithSystemPrompt(prompt string) AgentOption {
+	return func(p *NewAgentParams) { p.SystemPrompt = prompt }
+}
+
+// WithTools adds tools to the agent.
+func WithTools(tools ...tool.Definition) AgentOption {
+	return func(p *NewAgentParams) { p.Tools = append(p.Tools, tools...) }
+}
+
+// WithHooks sets the hooks of the agent.
+func WithHooks(hooks Hooks) AgentOption {
+	return func(p *NewAgentParams) { p.Hooks = hooks }
+}
+
+// WithTimebox timeboxes the agent to d from its creation, on the clock of
+// the agent (see WithClock).
+func WithTimebox(d time.Duration) AgentOption {
+	return func(p *NewAgentParams) { p.timebox = d }
+}
+
+// WithMaxToolLogLength sets the length of the tool logs to keep, defaults to
+// DefaultMaxToolLogLength.
+func WithMaxToolLogLength(n int) AgentOption {
+	return func(p *NewAgentParams) { p.MaxToolLogLength = n }
+}
+
+// WithClock sets the clock of the agent, e.g. a clock.Fake in tests.
+func WithClock(c clock.Clock) AgentOption {
+	return func(p *NewAgentParams) { p.Clock = c }
+}
+
+// WithBudget limits the token usage of the agent.
+func WithBudget(maxTokenUsage int) AgentOption {
+	return func(p *NewAgentParams) { p.MaxTokenUsage = maxTokenUsage }
+}
+
+// WithLogger sets the logger, the agent doesn't log by default.
+func WithLogger(logger *slog.Logger) AgentOption {
+	return func(p *NewAgentParams) { p.Logger = logger }
+}
+
+// WithSession continues the conversation of the session file and saves the
+// conversation into it.
+func WithSession(filePath string) AgentOption {
+	return func(p *NewAgentParams) { p.SessionFilePath = filePath }
+}
+
+// WithMessages continues a conversation.
+func WithMessages(messages []llm.Message) AgentOption {
+	return func(p *NewAgentParams) { p.LLMMessages = messages }
+}
+
+// WithPolicy permits or denies the tool calls, see tool.Policy.
+func WithPolicy(policy *tool.Policy) AgentOption {
+	return func(p *NewAgentParams) { p.Policy = policy }
+}
+
+// WithDryRun previews the run without calling the mutating tools.
+func WithDryRun() AgentOption {
+	return func(p *NewAgentParams) { p.DryRun = true }
+}
+
+// WithToolExecutor executes the tool calls with the executor, e.g. on a
+// remote worker.
+func WithToolExecutor(executor tool.Executor) AgentOption {
+	return func(p *NewAgentParams) { p.ToolExecutor = executor }
+}
+
+// WithSecrets provides the credentials of the tools.
+func WithSecrets(s secrets.Secrets) AgentOption {
+	return func(p *NewAgentParams) { p.Secrets = s }
+}
+
+// WithLimiter shares the limits of in-flight requests and tool calls.
+func WithLimiter(limiter *Limiter) AgentOption {
+	return func(p *NewAgentParams) { p.Limiter = limiter }
+}
+
+// WithPriority sets the priority of the agent when waiting for the Limiter.
+func WithPriority(priority Priority) AgentOption {
+	return func(p *NewAgentParams) { p.Priority = priority }
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+)
+
+func TestNewAgentWith(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The timebox is on the clock of the agent, wherever the options are.
+	agent, err := NewAgentWith[string](scriptedLLM(),
+		WithTimebox(time.Minute),
+		WithClock(clock.NewFake(start)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(time.Minute); !agent.timeboxedUntil.Equal(want) {
+		t.Errorf("timeboxed until %s, want %s", agent.timeboxedUntil, want)
+	}
+	if agent.maxToolLogLength != DefaultMaxToolLogLength {
+		t.Errorf("max tool log length %d, want %d", agent.maxToolLogLength, DefaultMaxToolLogLength)
+	}
+
+	agent, err = NewAgentWith[string](scriptedLLM(), WithMaxToolLogLength(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agent.maxToolLogLength != 100 || !agent.timeboxedUntil.IsZero() {
+		t.Errorf("max tool log length %d, timeboxed until %s, want 100 and no timebox", agent.maxToolLogLength, agent.timeboxedUntil)
+	}
+}
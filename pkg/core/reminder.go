@@ -0,0 +1,133 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// ReminderStrategy selects how system reminders (e.g. "call the FinalResult
+// tool") are injected into the conversation.
+type ReminderStrategy string
+
+const (
+	// ReminderUserMessage adds reminders as user messages wrapped in
+	// <system-reminder> tags.
+	ReminderUserMessage ReminderStrategy = "user_message"
+	// ReminderSystemPrompt appends reminders to the system prompt of the next
+	// request only, keeping them out of the history (and the cached prefix).
+	// Falls back to ReminderUserMessage if the conversation ends with an
+	// assistant message.
+	ReminderSystemPrompt ReminderStrategy = "system_prompt"
+	// ReminderDeveloperMessage adds reminders as llm.RoleDeveloper messages,
+	// which are sent as developer messages to OpenAI and as tagged user
+	// messages to the other providers.
+	ReminderDeveloperMessage ReminderStrategy = "developer_message"
+)
+
+// systemPromptBlocks returns the system prompt of the next request, with the
+// pending reminders in a separate, not cached block.
+func (agent *Agent[ResultT]) systemPromptBlocks() []llm.SystemPromptBlock {
+	if len(agent.pendingReminders) == 0 {
+		return agent.systemBlocks
+	}
+	blocks := agent.systemBlocks
+	if len(blocks) == 0 {
+		blocks = []llm.SystemPromptBlock{{Text: agent.systemPrompt, Cacheable: true}}
+	}
+	var reminders []string
+	for _, r := range agent.pendingReminders {
+		reminders = append(reminders, llm.SystemReminder{Text: r}.TaggedText())
+	}
+	reminderBlock := llm.SystemPromptBlock{Text: strings.Join(reminders, "\n")}
+	return append(blocks[:len(blocks):len(blocks)], reminderBlock)
+}
+
+// hasPendingReminder reports whether the reminder was already added and the
+// model didn't respond to it yet.
+func (agent *Agent[ResultT]) hasPendingReminder(content string) bool {
+	for _, r := range agent.pendingReminders {
+		if r == content {
+			return true
+		}
+	}
+	if len(agent.llmMessages) == 0 {
+		return false
+	}
+	last := agent.llmMessages[len(agent.llmMessages)-1]
+	return last.Role.IsUserTurn() && isReminder(last, content)
+}
+
+func isReminder(msg llm.Message, content string) bool {
+	for _, part := range msg.Parts {
+		switch v := part.(type) {
+		case llm.SystemReminder:
+			if v.Text == content {
+				return true
+			}
+		case llm.TextContent:
+			if v.Text == (llm.SystemReminder{Text: content}).TaggedText() ||
+				msg.Role == llm.RoleDeveloper && v.Text == content {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remindFinalResult asks the model to call the FinalResult tool. If the model
+// ignored the previous reminder, the wording gets stronger and the tool call
+// is forced instead of repeating the same reminder. In free-text mode it asks
+// for a text response instead.
+func (agent *Agent[ResultT]) remindFinalResult() {
+	agent.finalResultReminders++
+	if agent.freeText {
+		agent.addSystemReminder("You need to respond with your final answer as text. Please do so.")
+		return
+	}
+	finalResult := agent.toolBelt.FinalResultName()
+	if agent.finalResultReminders == 1 {
+		agent.addSystemReminder(fmt.Sprintf(
+			"You need to call the %s tool to return a result. Please do so.", finalResult,
+		))
+		return
+	}
+	agent.logger.Warn("model ignored the final result reminder, forcing the tool call", "reminders", agent.finalResultReminders)
+	agent.forceTool = finalResult
+	agent.addSystemReminder(fmt.Sprintf(
+		"IMPORTANT: you did not call the %s tool. Your response is only accepted through the %s tool. "+
+			"Call it now with your best result based on your current knowledge.",
+		finalResult, finalResult,
+	))
+}
+
+// DefaultMaxEmptyResponseNudges is the default number of consecutive empty
+// responses the agent nudges the model after.
+const DefaultMaxEmptyResponseNudges = 3
+
+// nudgeEmptyResponse asks the model to continue after an empty response, or
+// returns an error if it keeps returning empty responses.
+func (agent *Agent[ResultT]) nudgeEmptyResponse() error {
+	agent.emptyResponses++
+	if agent.hooks.OnEmptyResponse != nil {
+		agent.hooks.OnEmptyResponse(agent.agentNum, agent.emptyResponses)
+	}
+	maxNudges := agent.maxEmptyNudges
+	if maxNudges <= 0 {
+		maxNudges = DefaultMaxEmptyResponseNudges
+	}
+	if agent.emptyResponses > maxNudges {
+		return &classifiedError{class: FailureProvider, err: fmt.Errorf("model returned %d empty responses in a row", agent.emptyResponses)}
+	}
+	agent.logger.Warn("model returned an empty response, nudging", "empty-responses", agent.emptyResponses)
+	if agent.freeText {
+		agent.addSystemReminder("Your last response was empty. Please continue the task, or respond with your final answer if you are done.")
+		return nil
+	}
+	agent.addSystemReminder(fmt.Sprintf(
+		"Your last response was empty. Please continue the task, or call the %s tool if you are done.",
+		agent.toolBelt.FinalResultName(),
+	))
+	return nil
+}
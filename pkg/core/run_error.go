@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"errors"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// FailureClass is the cause of a failed run, e.g. to retry provider errors
+// but not an exceeded budget.
+type FailureClass string
+
+const (
+	// FailureProvider is an error of the LLM provider (after its retries),
+	// or a model returning empty responses.
+	FailureProvider FailureClass = "provider"
+	// FailureBudget is an exceeded token budget, see ErrMaxTokenUsageExceeded.
+	FailureBudget FailureClass = "budget"
+	// FailureTimebox is an exceeded deadline of the context of the run.
+	FailureTimebox FailureClass = "timebox"
+	// FailureTool is a failure of the tool environment, e.g. the sandbox
+	// couldn't be started. Failed tool calls are returned to the model
+	// instead of failing the run.
+	FailureTool FailureClass = "tool"
+	// FailureSchema is a final result not matching the schema, see
+	// ErrFinalResultSchema.
+	FailureSchema FailureClass = "schema"
+	// FailureCancelled is a canceled context.
+	FailureCancelled FailureClass = "cancelled"
+	FailureUnknown   FailureClass = "unknown"
+)
+
+// RunError is returned by Run and Resume when the run fails, with the
+// conversation up to the failure.
+type RunError struct {
+	Class FailureClass
+	Err   error
+	// Messages and Usage are the partial transcript and usage of the run,
+	// e.g. to resume it.
+	Messages []llm.Message
+	Usage    llm.TokenUsage
+}
+
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether running again may succeed: it's false for an
+// exceeded budget or deadline and a canceled context.
+func (e *RunError) Retryable() bool {
+	switch e.Class {
+	case FailureBudget, FailureTimebox, FailureCancelled:
+		return false
+	default:
+		return true
+	}
+}
+
+// FailureClassOf returns the class of the error of a run, FailureUnknown if
+// it isn't a RunError.
+func FailureClassOf(err error) FailureClass {
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return runErr.Class
+	}
+	return FailureUnknown
+}
+
+// classifiedError marks an error with its class where it occurs, for the
+// failures not identified by a sentinel error.
+type classifiedError struct {
+	class FailureClass
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (agent *Agent[ResultT]) runError(ctx context.Context, err error) *RunError {
+	return &RunError{
+		Class:    classify(ctx, err),
+		Err:      err,
+		Messages: agent.Messages(),
+		Usage:    agent.Usage(),
+	}
+}
+
+func classify(ctx context.Context, err error) FailureClass {
+	var classified *classifiedError
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return FailureCancelled
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return FailureTimebox
+	case errors.Is(err, ErrMaxTokenUsageExceeded):
+		return FailureBudget
+	case errors.Is(err, ErrFinalResultSchema):
+		return FailureSchema
+	case errors.As(err, &classified):
+		return classified.class
+	default:
+		return FailureUnknown
+	}
+}
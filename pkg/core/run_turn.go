@@ -4,82 +4,318 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/bitrise-io/bitrise-ai-core/pkg/audit"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
 	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+	"github.com/invopop/jsonschema"
 )
 
 type turnResult struct {
 	finished bool
+	// empty is set if the model returned neither text nor tool calls.
+	empty bool
 }
 
 func (agent *Agent[ResultT]) runTurn(ctx context.Context) (*turnResult, error) {
+	agent.remindPlan()
+
 	toolDefinitions := agent.toolBelt.LLMDefinitions()
-	if agent.timeboxExpired() {
-		toolDefinitions = []llm.ToolDefinition{
-			agent.toolBelt.FinalResultDefinition(),
-		}
+	switch {
+	case agent.timeboxExpired() && agent.freeText:
+		toolDefinitions = nil
 		agent.addSystemReminder(
 			"Your timebox has been exceeded. DO NOT mention the timebox to the user. " +
-				"You can only call the FinalResult tool, not any other tools. " +
-				"Please call the FinalResult tool to return the final result based on your current knowledge.",
+				"You can't call tools anymore. Please respond with your final answer based on your current knowledge.",
 		)
+	case agent.timeboxExpired():
+		toolDefinitions = []llm.ToolDefinition{
+			agent.toolBelt.FinalResultDefinition(),
+		}
+		agent.addSystemReminder(fmt.Sprintf(
+			"Your timebox has been exceeded. DO NOT mention the timebox to the user. "+
+				"You can only call the %[1]s tool, not any other tools. "+
+				"Please call the %[1]s tool to return the final result based on your current knowledge.",
+			agent.toolBelt.FinalResultName(),
+		))
+	}
+
+	var responseSchema *jsonschema.Schema
+	if agent.structuredOutput {
+		// The final result is the response itself, no tools are offered.
+		responseSchema = agent.toolBelt.FinalResultDefinition().Schema
+		toolDefinitions = nil
+	}
+
+	if err := llm.ValidateHistory(agent.llmMessages); err != nil {
+		// Fail fast with a precise diagnostic instead of a provider error.
+		return nil, fmt.Errorf("invalid history: %w", err)
+	}
+
+	release, err := agent.limiter.acquireLLM(ctx, agent.priority)
+	if err != nil {
+		return nil, fmt.Errorf("wait for the limiter: %w", err)
 	}
+	agent.turn.Store(int64(len(agent.timeline.Turns) + 1))
+	agent.emit(ctx, Event{Type: EventTurnStarted})
+	turn := TurnTiming{Started: agent.clock.Now()}
+	defer func() {
+		turn.Duration = clock.Since(agent.clock, turn.Started)
+		agent.timeline.Turns = append(agent.timeline.Turns, turn)
+	}()
 
 	message, err := agent.llm.NewMessage(ctx, llm.NewMessageParams{
-		SystemPrompt:    agent.systemPrompt,
-		ToolDefinitions: toolDefinitions,
-		History:         agent.llmMessages,
-		EnableCaching:   true,
-		Logger:          agent.logger,
+		SystemPrompt:           agent.systemPrompt,
+		SystemPromptBlocks:     agent.systemPromptBlocks(),
+		ToolDefinitions:        toolDefinitions,
+		History:                agent.llmMessages,
+		EnableCaching:          true,
+		Logger:                 agent.logger,
+		Clock:                  agent.clock,
+		RunID:                  agent.runID,
+		TokenEfficientTools:    agent.providerFlags.tokenEfficientTools,
+		DisableParallelToolUse: agent.providerFlags.disableParallelToolUse && agent.capabilities.ParallelToolCalls,
+		ForceTool:              agent.forceTool,
+		Seed:                   agent.seed,
+		ResponseSchema:         responseSchema,
+		ResponseName:           agent.toolBelt.FinalResultName(),
 	})
+	release()
+	turn.LLMLatency = clock.Since(agent.clock, turn.Started)
 	if err != nil {
-		return nil, fmt.Errorf("new llm message: %w", err)
+		return nil, &classifiedError{class: FailureProvider, err: fmt.Errorf("new llm message: %w", err)}
+	}
+	agent.pendingReminders = nil
+	agent.forceTool = ""
+	if isEmptyResponse(message) {
+		// Empty assistant messages are rejected by some providers, so it
+		// is not added to the history.
+		if err := agent.updateUsage(message.Usage); err != nil {
+			return nil, fmt.Errorf("update usage: %w", err)
+		}
+		return &turnResult{empty: true}, nil
 	}
-	agent.llmMessages = append(agent.llmMessages, message)
+	agent.emptyResponses = 0
+	agent.appendMessages(message)
 	if err := agent.updateUsage(message.Usage); err != nil {
 		return nil, fmt.Errorf("update usage: %w", err)
 	}
 	agent.logger.Debug("token usage of turn", "usage", message.Usage)
+	agent.trackCacheMiss(message.Usage)
+	if agent.hooks.OnMessage != nil {
+		agent.hooks.OnMessage(agent.agentNum, message)
+	}
 
 	var toolUses []toolUseParams
 	for _, part := range message.Parts {
 		switch v := part.(type) {
 		case llm.TextContent:
 			agent.logger.Info(v.Text)
+			agent.emit(ctx, Event{Type: EventTextDelta, Text: v.Text})
 		case llm.ToolCall:
 			agent.logger.Info(fmt.Sprintf("Use tool %q: %s", v.Name, v.Input))
+			agent.emit(ctx, Event{Type: EventToolCall, ToolCall: &v})
 			p := toolUseParams{ID: v.ID, Name: v.Name, Input: v.Input}
 			toolUses = append(toolUses, p)
 		}
 	}
 
-	if len(toolUses) > 1 {
-		agent.logger.Debug(fmt.Sprintf("using %d tools in parallel", len(toolUses)))
+	if agent.freeText && len(toolUses) == 0 {
+		agent.setTextResult(message)
 	}
-	chToolResults := make(chan llm.ToolResult)
-	for _, p := range toolUses {
-		go func(tool toolUseParams) {
-			chToolResults <- agent.useTool(ctx, tool)
-		}(p)
+
+	agent.usageBreakdown.addPhase(agent.usageBreakdown.turnPhase(toolUses, agent.toolBelt.FinalResultName()), message.Usage)
+
+	if agent.structuredOutput && len(toolUses) == 0 {
+		if err := agent.parseStructuredOutput(ctx, message); err != nil {
+			return nil, err
+		}
+		agent.mu.Lock()
+		defer agent.mu.Unlock()
+		return &turnResult{finished: agent.finalResultSet}, nil
 	}
-	var toolResults []llm.ContentPart
-	for i := 0; i < len(toolUses); i++ {
-		toolResults = append(toolResults, <-chToolResults)
+
+	toolResults, timings, err := agent.useTools(ctx, toolUses)
+	turn.Tools = timings
+	agent.toolStats.add(timings, toolResults)
+	if err != nil {
+		// Keep the history consistent (every tool call has a result), so
+		// the run can be resumed.
+		agent.appendMessages(llm.NewUserMessage(toolResults...))
+		return nil, fmt.Errorf("use tools: %w", err)
 	}
-	close(chToolResults)
+
+	agent.usageBreakdown.addToolResults(toolUses, toolResults)
 
 	if len(toolResults) > 0 {
 		toolResultsMessage := llm.NewUserMessage(toolResults...)
-		agent.llmMessages = append(agent.llmMessages, toolResultsMessage)
+		agent.appendMessages(toolResultsMessage)
+	}
+	if err := agent.checkFinalResultFailure(toolResults); err != nil {
+		return nil, err
 	}
 
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
 	return &turnResult{
 		finished: len(toolResults) == 0 || agent.finalResultSet,
 	}, nil
 }
 
+func isEmptyResponse(message llm.Message) bool {
+	for _, part := range message.Parts {
+		switch v := part.(type) {
+		case llm.TextContent:
+			if strings.TrimSpace(v.Text) != "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// useTools calls the tools in parallel (at most maxParallelTools at a time)
+// and returns their results in the order of the calls. If ctx is canceled
+// before all tools return, the calls still in flight get an error result and
+// ctx.Err() is returned; their goroutines end when the tools return.
+func (agent *Agent[ResultT]) useTools(ctx context.Context, toolUses []toolUseParams) ([]llm.ContentPart, []ToolTiming, error) {
+	if len(toolUses) == 0 {
+		return nil, nil, nil
+	}
+	if agent.sequentialTools {
+		return agent.useToolsSequentially(ctx, toolUses)
+	}
+	if len(toolUses) > 1 {
+		agent.logger.Debug(fmt.Sprintf("using %d tools in parallel", len(toolUses)))
+	}
+	limit := agent.maxParallelTools
+	if limit <= 0 || limit > len(toolUses) {
+		limit = len(toolUses)
+	}
+
+	var mu sync.Mutex
+	// The results are kept in the order of the calls, not of completion, so
+	// the history (and the cached prompt prefix) doesn't depend on timing.
+	outcomes := make([]*toolOutcome, len(toolUses))
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	responded := agent.clock.Now()
+	for i, p := range toolUses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				return
+			}
+			started := agent.clock.Now()
+			res := agent.useTool(ctx, p)
+			agent.emit(ctx, Event{Type: EventToolResult, ToolResult: &res})
+			mu.Lock()
+			defer mu.Unlock()
+			outcomes[i] = &toolOutcome{result: res, timing: ToolTiming{
+				Name:       p.Name,
+				ToolCallID: p.ID,
+				Wait:       started.Sub(responded),
+				Duration:   clock.Since(agent.clock, started),
+			}}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	var ctxErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		ctxErr = ctx.Err()
+		agent.logger.Warn("tool calls canceled", "error", ctxErr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var results []llm.ContentPart
+	var timings []ToolTiming
+	for i, outcome := range outcomes {
+		if outcome == nil {
+			p := toolUses[i]
+			results = append(results, llm.ToolResult{
+				ToolName:   p.Name,
+				ToolCallID: p.ID,
+				Content:    "tool call canceled",
+				IsError:    true,
+			})
+			continue
+		}
+		results = append(results, outcome.result)
+		timings = append(timings, outcome.timing)
+	}
+	return results, timings, ctxErr
+}
+
+// useToolsSequentially calls the tools one by one, in the order the model
+// requested them. After a failed call the remaining calls are skipped, as
+// they may depend on it. The results are returned in one message.
+func (agent *Agent[ResultT]) useToolsSequentially(ctx context.Context, toolUses []toolUseParams) ([]llm.ContentPart, []ToolTiming, error) {
+	var results []llm.ContentPart
+	var timings []ToolTiming
+	var failed string
+	responded := agent.clock.Now()
+	for _, p := range toolUses {
+		if err := ctx.Err(); err != nil {
+			results = append(results, llm.ToolResult{ToolName: p.Name, ToolCallID: p.ID, Content: "tool call canceled", IsError: true})
+			continue
+		}
+		if failed != "" {
+			results = append(results, llm.ToolResult{
+				ToolName:   p.Name,
+				ToolCallID: p.ID,
+				Content:    fmt.Sprintf("skipped: the previous %s call failed, tools are called sequentially", failed),
+				IsError:    true,
+			})
+			continue
+		}
+		started := agent.clock.Now()
+		res := agent.useTool(ctx, p)
+		agent.emit(ctx, Event{Type: EventToolResult, ToolResult: &res})
+		timings = append(timings, ToolTiming{
+			Name:       p.Name,
+			ToolCallID: p.ID,
+			Wait:       started.Sub(responded),
+			Duration:   clock.Since(agent.clock, started),
+		})
+		results = append(results, res)
+		if res.IsError {
+			failed = p.Name
+		}
+	}
+	return results, timings, ctx.Err()
+}
+
+// checkPolicy checks the call against the policy, the built-in tools of the
+// belt are always allowed (the FinalResult tool may have another name).
+func (agent *Agent[ResultT]) checkPolicy(name string, input json.RawMessage) error {
+	if agent.toolBelt.IsBuiltin(name) {
+		return nil
+	}
+	return agent.policy.Check(name, input)
+}
+
 type toolUseParams struct {
 	ID    string
 	Name  string
@@ -87,7 +323,7 @@ type toolUseParams struct {
 }
 
 func (agent *Agent[ResultT]) useTool(ctx context.Context, t toolUseParams) llm.ToolResult {
-	if agent.timeboxExpired() && t.Name != tool.FinalResultToolName {
+	if agent.timeboxExpired() && t.Name != agent.toolBelt.FinalResultName() {
 		s := "timebox expired, cannot use tool"
 		agent.logger.Warn(fmt.Sprintf("%s: %q", s, t.Name))
 		return llm.ToolResult{
@@ -98,7 +334,31 @@ func (agent *Agent[ResultT]) useTool(ctx context.Context, t toolUseParams) llm.T
 		}
 	}
 
-	res, err := agent.toolBelt.UseTool(ctx, t.Name, t.Input)
+	if err := agent.checkPolicy(t.Name, t.Input); err != nil {
+		agent.logger.Warn(fmt.Sprintf("%q tool call denied", t.Name), "error", err)
+		agent.auditToolCall(t, "", err, 0)
+		if agent.hooks.OnPolicyDenied != nil {
+			agent.hooks.OnPolicyDenied(agent.agentNum, t.Name, err)
+		}
+		return llm.ToolResult{
+			ToolName:   t.Name,
+			ToolCallID: t.ID,
+			Content:    err.Error(),
+			IsError:    true,
+		}
+	}
+
+	if !agent.toolBelt.IsBuiltin(t.Name) {
+		release, err := agent.limiter.acquireTool(ctx, agent.priority)
+		if err != nil {
+			return llm.ToolResult{ToolName: t.Name, ToolCallID: t.ID, Content: "tool call canceled", IsError: true}
+		}
+		defer release()
+	}
+
+	started := agent.clock.Now()
+	res, err := agent.callTool(ctx, t)
+	agent.auditToolCall(t, res, err, clock.Since(agent.clock, started))
 	if err != nil {
 		truncatedErr := agent.truncateLog(err.Error())
 		agent.logger.Warn(
@@ -107,7 +367,7 @@ func (agent *Agent[ResultT]) useTool(ctx context.Context, t toolUseParams) llm.T
 		return llm.ToolResult{
 			ToolName:   t.Name,
 			ToolCallID: t.ID,
-			Content:    err.Error(),
+			Content:    agent.truncateToolResult(t.Name, agent.sanitizeContent(err.Error())),
 			IsError:    true,
 		}
 	}
@@ -115,18 +375,133 @@ func (agent *Agent[ResultT]) useTool(ctx context.Context, t toolUseParams) llm.T
 	agent.logger.Debug(
 		fmt.Sprintf("%q tool result: %s", t.Name, agent.truncateLog(res)),
 	)
-	return llm.ToolResult{ToolName: t.Name, ToolCallID: t.ID, Content: res}
+	content := agent.summarizeToolResult(ctx, t, agent.sanitizeContent(res))
+	content = agent.truncateToolResult(t.Name, content)
+	return llm.ToolResult{ToolName: t.Name, ToolCallID: t.ID, Content: llm.Intern(content)}
 }
 
-func (agent *Agent[ResultT]) truncateLog(s string) string {
-	if len(s) <= agent.maxToolLogLength {
+// truncateToolResult cuts results exceeding the limit of the tool (or of the
+// agent), telling the model what was omitted.
+func (agent *Agent[ResultT]) truncateToolResult(name, s string) string {
+	limit := agent.maxResultBytes
+	if def, ok := agent.toolBelt.Definition(name); ok && def.MaxResultBytes > 0 {
+		limit = def.MaxResultBytes
+	}
+	if limit <= 0 || len(s) <= limit {
 		return s
 	}
-	firstPart := s[:agent.maxToolLogLength/2]
-	lastPart := s[len(s)-(agent.maxToolLogLength/2):]
-	return firstPart + "..." + lastPart
+	agent.logger.Debug("tool result truncated", "tool", name, "bytes", len(s), "limit", limit)
+	if json.Valid([]byte(s)) {
+		// Keep the JSON valid, so the model can still parse the structure.
+		return fmt.Sprintf(
+			"%s\n\n[TRUNCATED: long values of the %d bytes were elided. "+
+				"If you need them, call the tool again requesting a smaller part "+
+				"(e.g. with offset/limit, a narrower path or filter).]",
+			truncate.JSON(s, limit), len(s),
+		)
+	}
+	head := truncate.Head(s, limit)
+	return fmt.Sprintf(
+		"%s\n\n[TRUNCATED: showing the first %d of %d bytes, %d bytes omitted. "+
+			"If you need the rest, call the tool again requesting a smaller part "+
+			"(e.g. with offset/limit, a narrower path or filter).]",
+		head, len(head), len(s), len(s)-len(head),
+	)
+}
+
+// auditToolCall appends the call to the audit log, if any.
+func (agent *Agent[ResultT]) auditToolCall(t toolUseParams, res string, err error, d time.Duration) {
+	if agent.auditLog == nil {
+		return
+	}
+	output := res
+	if err != nil {
+		output = err.Error()
+	}
+	rec := audit.Record{
+		Time:         agent.clock.Now(),
+		AgentID:      agent.agentNum,
+		RunID:        agent.runID,
+		Tool:         t.Name,
+		ToolCallID:   t.ID,
+		InputSHA256:  audit.Hash(t.Input),
+		OutputSHA256: audit.Hash([]byte(output)),
+		IsError:      err != nil,
+		DurationMS:   d.Milliseconds(),
+	}
+	if err := agent.auditLog.Write(rec); err != nil {
+		agent.logger.Error("write audit log", "tool", t.Name, "error", err)
+	}
+}
+
+// callTool calls the tool, converting a panic into an error (with the stack
+// trace, for the transcript), so a buggy tool can't crash the process.
+func (agent *Agent[ResultT]) callTool(ctx context.Context, t toolUseParams) (res string, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stack := debug.Stack()
+		agent.logger.Error(fmt.Sprintf("%q tool panicked: %v", t.Name, r), "stack", string(stack))
+		if agent.hooks.OnToolPanic != nil {
+			agent.hooks.OnToolPanic(agent.agentNum, t.Name, r, stack)
+		}
+		res, err = "", fmt.Errorf("tool panicked: %v\n%s", r, stack)
+	}()
+	ctx = tool.WithRunID(ctx, agent.runID)
+	if agent.sandbox != nil {
+		ctx = sandbox.NewContext(ctx, agent.sandbox)
+	}
+	if agent.secrets != nil {
+		ctx = secrets.NewContext(ctx, agent.secrets)
+	}
+	if def, ok := agent.toolBelt.Definition(t.Name); ok && def.Mutating && agent.dryRun {
+		return agent.toolBelt.DryRunTool(ctx, t.Name, t.Input)
+	}
+	if agent.toolExecutor != nil && !agent.toolBelt.IsBuiltin(t.Name) {
+		return agent.toolExecutor.Execute(ctx, tool.Call{
+			RunID:      agent.runID,
+			ToolCallID: t.ID,
+			Name:       t.Name,
+			Input:      t.Input,
+		})
+	}
+	return agent.toolBelt.UseTool(ctx, t.Name, t.Input)
+}
+
+func (agent *Agent[ResultT]) truncateLog(s string) string {
+	return truncate.Middle(s, agent.maxToolLogLength, "...")
+}
+
+// remindPlan re-injects the current plan if it was not updated for a while,
+// so it doesn't get lost in a long context.
+func (agent *Agent[ResultT]) remindPlan() {
+	if agent.planReminderTurns <= 0 {
+		return
+	}
+	// The plan is updated by the UpdatePlan tool, maybe in parallel with
+	// other tools.
+	agent.mu.Lock()
+	plan := agent.plan
+	remind := false
+	if len(plan.Steps) > 0 {
+		agent.turnsSincePlanUpdate++
+		if agent.turnsSincePlanUpdate > agent.planReminderTurns {
+			agent.turnsSincePlanUpdate = 0
+			remind = true
+		}
+	}
+	agent.mu.Unlock()
+	if !remind {
+		return
+	}
+	agent.addSystemReminder(fmt.Sprintf(
+		"This is your current plan:\n%s\nKeep following it and update it with the %s tool when the status of a step changes.",
+		plan, tool.UpdatePlanToolName,
+	))
 }
 
 func (agent *Agent[ResultT]) timeboxExpired() bool {
-	return !agent.timeboxedUntil.IsZero() && time.Now().After(agent.timeboxedUntil)
+	return !agent.timeboxedUntil.IsZero() && agent.clock.Now().After(agent.timeboxedUntil)
 }
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// SchemaFailureStep is a step of the SchemaFailurePolicy ladder.
+type SchemaFailureStep string
+
+const (
+	// SchemaStepReformat repeats the schema and the error to the model.
+	SchemaStepReformat SchemaFailureStep = "reformat"
+	// SchemaStepForceTool forces the FinalResult tool call in the next turn.
+	SchemaStepForceTool SchemaFailureStep = "force_tool"
+	// SchemaStepStructuredOutput switches to the native structured output of
+	// the provider: the next responses are constrained to the schema and
+	// parsed as the final result. Skipped if the provider doesn't support it.
+	SchemaStepStructuredOutput SchemaFailureStep = "structured_output"
+	// SchemaStepFallbackModel switches to SchemaFailurePolicy.FallbackLLM
+	// for the rest of the run. Skipped if it's not set.
+	SchemaStepFallbackModel SchemaFailureStep = "fallback_model"
+)
+
+// SchemaFailurePolicy escalates when the model keeps returning final results
+// which don't match the schema: each consecutive failure takes the next step,
+// the run fails with ErrFinalResultSchema once the steps are used up.
+type SchemaFailurePolicy struct {
+	Steps []SchemaFailureStep
+	// FallbackLLM is used by SchemaStepFallbackModel, e.g. a stronger model.
+	FallbackLLM llm.Provider
+}
+
+// DefaultSchemaFailurePolicy returns the full ladder, with the fallback model
+// if it's not nil.
+func DefaultSchemaFailurePolicy(fallback llm.Provider) *SchemaFailurePolicy {
+	return &SchemaFailurePolicy{
+		Steps: []SchemaFailureStep{
+			SchemaStepReformat,
+			SchemaStepForceTool,
+			SchemaStepStructuredOutput,
+			SchemaStepFallbackModel,
+		},
+		FallbackLLM: fallback,
+	}
+}
+
+// ErrFinalResultSchema is returned by Run if the final result kept failing
+// to parse after the steps of the SchemaFailurePolicy.
+var ErrFinalResultSchema = errors.New("final result doesn't match the schema")
+
+// structuredOutputCallID is the ID of the FinalResult calls parsed from
+// native structured output responses.
+const structuredOutputCallID = "structured_output"
+
+// checkFinalResultFailure escalates if the FinalResult call of the turn
+// failed.
+func (agent *Agent[ResultT]) checkFinalResultFailure(results []llm.ContentPart) error {
+	for _, part := range results {
+		if res, ok := part.(llm.ToolResult); ok && res.ToolName == agent.toolBelt.FinalResultName() && res.IsError {
+			return agent.escalateSchemaFailure(res.Content)
+		}
+	}
+	return nil
+}
+
+// escalateSchemaFailure takes the next applicable step of the policy. Without
+// a policy the model just gets the error as the tool result.
+func (agent *Agent[ResultT]) escalateSchemaFailure(reason string) error {
+	agent.schemaFailures++
+	p := agent.schemaPolicy
+	if p == nil {
+		return nil
+	}
+	for agent.schemaStep < len(p.Steps) {
+		step := p.Steps[agent.schemaStep]
+		agent.schemaStep++
+		if !agent.applySchemaStep(step, reason) {
+			agent.logger.Debug("schema failure step not applicable, skipping", "step", step)
+			continue
+		}
+		agent.logger.Warn("final result doesn't match the schema, escalating",
+			"failures", agent.schemaFailures, "step", step, "reason", reason)
+		if agent.hooks.OnSchemaFailure != nil {
+			agent.hooks.OnSchemaFailure(agent.agentNum, agent.schemaFailures, step)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w after %d attempts: %s", ErrFinalResultSchema, agent.schemaFailures, reason)
+}
+
+func (agent *Agent[ResultT]) applySchemaStep(step SchemaFailureStep, reason string) bool {
+	schema, err := json.Marshal(agent.toolBelt.FinalResultDefinition().Schema)
+	if err != nil {
+		schema = []byte("(unavailable)")
+	}
+	finalResult := agent.toolBelt.FinalResultName()
+	switch step {
+	case SchemaStepReformat:
+		agent.addSystemReminder(fmt.Sprintf(
+			"Your %s call was rejected: %s. Call %s again, its input must be a JSON object matching this JSON schema exactly: %s",
+			finalResult, reason, finalResult, schema,
+		))
+	case SchemaStepForceTool:
+		agent.forceTool = finalResult
+		agent.addSystemReminder(fmt.Sprintf(
+			"IMPORTANT: your %s call was rejected again: %s. Call %s now with input matching the schema.",
+			finalResult, reason, finalResult,
+		))
+	case SchemaStepStructuredOutput:
+		if !agent.capabilities.StructuredOutput {
+			return false
+		}
+		agent.structuredOutput = true
+		agent.addSystemReminder(fmt.Sprintf(
+			"Your final result was rejected: %s. Respond with the final result only, as JSON matching this schema: %s",
+			reason, schema,
+		))
+	case SchemaStepFallbackModel:
+		p := agent.schemaPolicy
+		if p.FallbackLLM == nil {
+			return false
+		}
+		agent.llm = p.FallbackLLM
+		agent.capabilities = llm.CapabilitiesOf(p.FallbackLLM)
+		agent.structuredOutput = false
+		agent.addSystemReminder(fmt.Sprintf(
+			"The previous final result was rejected: %s. Call the %s tool with input matching its schema.",
+			reason, finalResult,
+		))
+	default:
+		return false
+	}
+	return true
+}
+
+// parseStructuredOutput sets the final result from a structured output
+// response, or escalates if it doesn't match the schema.
+func (agent *Agent[ResultT]) parseStructuredOutput(ctx context.Context, message llm.Message) error {
+	var text strings.Builder
+	for _, part := range message.Parts {
+		if v, ok := part.(llm.TextContent); ok {
+			text.WriteString(v.Text)
+		}
+	}
+	res := agent.useTool(ctx, toolUseParams{
+		ID:    structuredOutputCallID,
+		Name:  agent.toolBelt.FinalResultName(),
+		Input: json.RawMessage(text.String()),
+	})
+	if res.IsError {
+		return agent.escalateSchemaFailure(res.Content)
+	}
+	return nil
+}
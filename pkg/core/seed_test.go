@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+func TestSeed(t *testing.T) {
+	seed := int64(42)
+	for _, tc := range []struct {
+		name string
+		seed *int64
+	}{
+		{"unset", nil},
+		{"set", &seed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			provider := &fakeLLM{respond: func(llm.NewMessageParams) llm.Message {
+				return llm.Message{
+					Parts:       []llm.ContentPart{finalResultCall("1", "done")},
+					Fingerprint: "fp-1",
+				}
+			}}
+			p := testParams(provider)
+			p.Seed = tc.seed
+			agent, err := NewAgent[string](p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := agent.Run(context.Background(), "Answer.")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, req := range provider.Requests() {
+				switch {
+				case tc.seed == nil && req.Seed != nil:
+					t.Errorf("seed %d sent, want none", *req.Seed)
+				case tc.seed != nil && (req.Seed == nil || *req.Seed != *tc.seed):
+					t.Errorf("seed %v sent, want %d", req.Seed, *tc.seed)
+				}
+			}
+			var want int64
+			if tc.seed != nil {
+				want = *tc.seed
+			}
+			if res.Seed != want || agent.Seed() != want {
+				t.Errorf("seed %d, %d in the result, want %d", res.Seed, agent.Seed(), want)
+			}
+			// The fingerprints are recorded without a seed too.
+			if len(res.Fingerprints) != 1 || res.Fingerprints[0] != "fp-1" {
+				t.Errorf("fingerprints %v, want [fp-1]", res.Fingerprints)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package core
 
 import (
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
 )
@@ -12,24 +15,53 @@ func (agent *Agent[ResultT]) restoreSession() error {
 	if agent.sessionFilePath == "" {
 		return nil
 	}
-
 	agent.logger.Debug("restoring session", "file_path", agent.sessionFilePath)
-	file, err := os.Open(agent.sessionFilePath)
+	// Stat before reading: if the file is replaced in between, the history
+	// is reloaded when the session is locked.
+	agent.sessionFile = statSession(agent.sessionFilePath)
+	data, err := ReadSession(agent.sessionFilePath, agent.sessionKeys)
 	switch {
-	case os.IsNotExist(err):
+	case errors.Is(err, os.ErrNotExist):
 		agent.logger.Debug("session file does not exist, starting new session")
 		return nil
 	case err != nil:
-		return fmt.Errorf("open file: %w", err)
+		return err
+	}
+	if data.RunID != "" {
+		agent.logger.Debug("restored session", "previous-run-id", data.RunID)
+	}
+	llm.InternMessages(data.Messages)
+	agent.llmMessages = data.Messages
+	return nil
+}
+
+// ReadSession reads a session file written by an agent, e.g. to inspect or
+// replay the conversation. keys is required for encrypted sessions.
+func ReadSession(filePath string, keys SessionKeyProvider) (Session, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return Session{}, fmt.Errorf("open file: %w", err)
+	}
+	if b, err = decryptSession(b, keys); err != nil {
+		return Session{}, err
 	}
-	defer file.Close()
 
 	registerTypesForSession()
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&agent.llmMessages); err != nil {
-		return fmt.Errorf("gob decode: %w", err)
+	var data Session
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		// Fall back to the legacy format, which only contained the messages.
+		if legacyErr := gob.NewDecoder(bytes.NewReader(b)).Decode(&data.Messages); legacyErr != nil {
+			return Session{}, fmt.Errorf("gob decode: %w", err)
+		}
 	}
-	return nil
+	return data, nil
+}
+
+// Session is the content of a session file.
+type Session struct {
+	// RunID is the ID of the run which last saved the session.
+	RunID    string
+	Messages []llm.Message
 }
 
 func (agent *Agent[ResultT]) saveSession() error {
@@ -37,25 +69,86 @@ func (agent *Agent[ResultT]) saveSession() error {
 		return nil
 	}
 	agent.logger.Debug("saving session", "file_path", agent.sessionFilePath)
-	file, err := os.Create(agent.sessionFilePath)
+
+	registerTypesForSession()
+	var buf bytes.Buffer
+	data := Session{RunID: agent.runID, Messages: agent.Messages()}
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return fmt.Errorf("gob encode: %w", err)
+	}
+	b, err := encryptSession(buf.Bytes(), agent.sessionKeys)
+	if err != nil {
+		return err
+	}
+
+	// The file is replaced by renaming a complete temporary file, so the
+	// agents reading it without the lock (see NewAgent) never see a
+	// partially written session.
+	file, err := os.CreateTemp(filepath.Dir(agent.sessionFilePath), "."+filepath.Base(agent.sessionFilePath)+".tmp-*")
 	if err != nil {
 		return fmt.Errorf("create file: %w", err)
 	}
-	defer file.Sync()
+	defer os.Remove(file.Name())
 	defer file.Close()
-
-	registerTypesForSession()
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(agent.llmMessages); err != nil {
-		return fmt.Errorf("gob encode: %w", err)
+	if _, err := file.Write(b); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("sync file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close file: %w", err)
 	}
+	if err := os.Rename(file.Name(), agent.sessionFilePath); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	agent.sessionFile = statSession(agent.sessionFilePath)
 	return nil
 }
 
+// statSession returns the info of the session file, nil if it doesn't exist
+// or can't be read.
+func statSession(filePath string) os.FileInfo {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+// sameSessionFile reports whether the session file wasn't replaced or
+// modified between the two stats.
+func sameSessionFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
+
+// RegisterSessionPart registers a content part type defined by the
+// application (see llm.CustomPart), so the sessions containing it can be saved
+// and restored. Call it from an init function, before the first agent runs.
+// The part is registered under its package path and type name: renaming them
+// breaks restoring the existing sessions, keep the old name with
+// RegisterSessionPartName.
+func RegisterSessionPart(part llm.ContentPart) {
+	gob.Register(part)
+}
+
+// RegisterSessionPartName is RegisterSessionPart with the name the part is
+// recorded with. It panics if the name is already used by another type.
+func RegisterSessionPartName(name string, part llm.ContentPart) {
+	gob.RegisterName(name, part)
+}
+
+// registerTypesForSession registers the built-in types, the custom parts are
+// registered by the application.
 func registerTypesForSession() {
 	gob.Register(llm.Message{})
 	gob.Register(llm.TextContent{})
+	gob.Register(llm.SystemReminder{})
 	gob.Register(llm.ToolCall{})
 	gob.Register(llm.ToolResult{})
+	gob.Register(llm.SearchResults{})
 	gob.Register(llm.TokenUsage{})
 }
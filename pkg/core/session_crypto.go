@@ -0,0 +1,108 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// SessionKeyProvider provides the AES key (16, 24 or 32 bytes) encrypting
+// session files at rest. Implement it to fetch or unwrap the key with a KMS.
+type SessionKeyProvider interface {
+	SessionKey() ([]byte, error)
+}
+
+// SessionKeyFunc adapts a function to SessionKeyProvider.
+type SessionKeyFunc func() ([]byte, error)
+
+func (f SessionKeyFunc) SessionKey() ([]byte, error) {
+	return f()
+}
+
+// DefaultSessionKeyEnv is the environment variable conventionally holding the
+// session key.
+const DefaultSessionKeyEnv = "BITRISE_AI_SESSION_KEY"
+
+// SessionKeyFromEnv reads a base64 encoded key from an environment variable.
+func SessionKeyFromEnv(name string) SessionKeyProvider {
+	return SessionKeyFunc(func() ([]byte, error) {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil, fmt.Errorf("%s is not set", name)
+		}
+		key, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", name, err)
+		}
+		return key, nil
+	})
+}
+
+// ErrSessionEncrypted is returned when reading an encrypted session without a
+// key.
+var ErrSessionEncrypted = errors.New("session is encrypted, a key is required")
+
+// encryptedSessionHeader starts encrypted session files, followed by the
+// nonce and the AES-GCM sealed gob data.
+var encryptedSessionHeader = []byte("BITRISE-AI-SESSION-AES-GCM-1\n")
+
+func encryptSession(plaintext []byte, keys SessionKeyProvider) ([]byte, error) {
+	if keys == nil {
+		return plaintext, nil
+	}
+	aead, err := newSessionAEAD(keys)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	out := append(bytes.Clone(encryptedSessionHeader), nonce...)
+	return aead.Seal(out, nonce, plaintext, encryptedSessionHeader), nil
+}
+
+// decryptSession returns unencrypted sessions as is.
+func decryptSession(b []byte, keys SessionKeyProvider) ([]byte, error) {
+	sealed, ok := bytes.CutPrefix(b, encryptedSessionHeader)
+	if !ok {
+		return b, nil
+	}
+	if keys == nil {
+		return nil, ErrSessionEncrypted
+	}
+	aead, err := newSessionAEAD(keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("decrypt session: truncated file")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, encryptedSessionHeader)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt session (wrong key?): %w", err)
+	}
+	return plaintext, nil
+}
+
+func newSessionAEAD(keys SessionKeyProvider) (cipher.AEAD, error) {
+	key, err := keys.SessionKey()
+	if err != nil {
+		return nil, fmt.Errorf("session key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("new cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("new gcm: %w", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SessionLockPolicy selects what happens if the session file is used by
+// another agent, possibly in another process.
+type SessionLockPolicy int
+
+const (
+	// SessionLockFail fails the run with ErrSessionLocked.
+	SessionLockFail SessionLockPolicy = iota
+	// SessionLockWait waits until the session is released, then continues
+	// from the history written by the other agent.
+	SessionLockWait
+	// SessionLockNone disables locking, concurrent agents overwrite each
+	// other's session.
+	SessionLockNone
+)
+
+// ErrSessionLocked is returned by Run and Resume if the session file is used
+// by another agent.
+var ErrSessionLocked = errors.New("session is used by another agent")
+
+// sessionLockPollInterval is the interval of retrying the lock with
+// SessionLockWait.
+const sessionLockPollInterval = 200 * time.Millisecond
+
+// lockSession takes the advisory lock of the session file (<session>.lock)
+// for the duration of a run and returns its release function. The session
+// is rotated (see SessionRetention) once the lock is held.
+func (agent *Agent[ResultT]) lockSession(ctx context.Context) (func(), error) {
+	if agent.sessionFilePath == "" {
+		return func() {}, nil
+	}
+	if agent.sessionLock == SessionLockNone {
+		if err := agent.prepareSession(false); err != nil {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+	lockPath := agent.sessionFilePath + ".lock"
+	waited := false
+	for {
+		release, ok, err := tryLockFile(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("lock session: %w", err)
+		}
+		if ok {
+			// Another agent may have extended the conversation since it was
+			// restored.
+			changed := waited || !sameSessionFile(agent.sessionFile, statSession(agent.sessionFilePath))
+			if err := agent.prepareSession(changed); err != nil {
+				release()
+				return nil, err
+			}
+			return release, nil
+		}
+		if agent.sessionLock != SessionLockWait {
+			return nil, fmt.Errorf("%w: %s%s", ErrSessionLocked, agent.sessionFilePath, lockHolder(lockPath))
+		}
+		if !waited {
+			agent.logger.Info("waiting for the session lock", "file_path", agent.sessionFilePath)
+			waited = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("wait for session lock: %w", ctx.Err())
+		case <-time.After(sessionLockPollInterval):
+		}
+	}
+}
+
+// prepareSession rotates the session file if it's too large, and reloads
+// the history if it was rotated or reload is set.
+func (agent *Agent[ResultT]) prepareSession(reload bool) error {
+	rotated, err := agent.rotateSession()
+	if err != nil {
+		return err
+	}
+	if !rotated && !reload {
+		return nil
+	}
+	if err := agent.reloadSession(); err != nil {
+		return fmt.Errorf("reload session: %w", err)
+	}
+	return nil
+}
+
+// reloadSession replaces the history with the one of the session file, the
+// history is empty if the file doesn't exist (e.g. it was rotated).
+func (agent *Agent[ResultT]) reloadSession() error {
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+	agent.llmMessages = nil
+	if err := agent.restoreSession(); err != nil {
+		return err
+	}
+	return agent.repairHistory(agent.strictHistory)
+}
+
+// lockHolder describes the process holding the lock, the holder writes its
+// PID into the lock file.
+func lockHolder(lockPath string) string {
+	b, err := os.ReadFile(lockPath)
+	if err != nil || len(strings.TrimSpace(string(b))) == 0 {
+		return ""
+	}
+	return " (held by pid " + strings.TrimSpace(string(b)) + ")"
+}
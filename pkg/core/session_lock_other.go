@@ -0,0 +1,24 @@
+//go:build !unix
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// tryLockFile creates the lock file exclusively. Unlike flock, the lock is
+// left behind if the process dies, it has to be removed manually.
+func tryLockFile(path string) (func(), bool, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("create lock file: %w", err)
+	}
+	_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+	file.Close()
+	return func() { _ = os.Remove(path) }, true, nil
+}
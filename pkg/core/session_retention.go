@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+)
+
+// SessionRetention limits the disk usage of session files, e.g. in long-lived
+// services reusing the same session paths.
+type SessionRetention struct {
+	// MaxBytes rotates the session file when a run starts if it's larger,
+	// the agent starts a new conversation. Unlimited if zero.
+	MaxBytes int64
+	// MaxBackups is the number of rotated session files kept, <session>.1 is
+	// the newest. Rotated sessions are deleted if zero.
+	MaxBackups int
+	// TTL deletes the rotated sessions older than this when rotating.
+	// Unlimited if zero.
+	TTL time.Duration
+}
+
+// rotateSession moves the session file aside if it's too large, it reports
+// whether it did. It must be called with the session locked, see
+// lockSession.
+func (agent *Agent[ResultT]) rotateSession() (bool, error) {
+	r := agent.sessionRetention
+	if agent.sessionFilePath == "" || r.MaxBytes <= 0 {
+		return false, nil
+	}
+	info, err := os.Stat(agent.sessionFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("stat session: %w", err)
+	}
+	if info.Size() <= r.MaxBytes {
+		return false, nil
+	}
+	agent.logger.Info("rotating session", "file_path", agent.sessionFilePath, "size", info.Size(), "max_bytes", r.MaxBytes)
+
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", agent.sessionFilePath, n) }
+	if r.MaxBackups <= 0 {
+		if err := os.Remove(agent.sessionFilePath); err != nil {
+			return false, fmt.Errorf("remove session: %w", err)
+		}
+		return true, nil
+	}
+	if err := os.Remove(backup(r.MaxBackups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("remove oldest session: %w", err)
+	}
+	for n := r.MaxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("rotate session: %w", err)
+		}
+	}
+	if err := os.Rename(agent.sessionFilePath, backup(1)); err != nil {
+		return false, fmt.Errorf("rotate session: %w", err)
+	}
+	if r.TTL > 0 {
+		pruned, err := PruneSessions(agent.sessionFilePath+".*[0-9]", r.TTL, agent.clock)
+		if err != nil {
+			return true, err
+		}
+		if len(pruned) > 0 {
+			agent.logger.Debug("pruned expired sessions", "files", pruned)
+		}
+	}
+	return true, nil
+}
+
+// PruneSessions deletes the session files matching the glob pattern (see
+// filepath.Match) which weren't modified for ttl on the clock c (nil for the
+// real clock), e.g. the rotated sessions with
+// PruneSessions("/var/lib/agent/sessions/*.gob.[0-9]*", 7*24*time.Hour, nil).
+// The lock files of the sessions are never deleted. It returns the deleted
+// files.
+func PruneSessions(pattern string, ttl time.Duration, c clock.Clock) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("glob sessions: %w", err)
+	}
+	cutoff := clock.Or(c).Now().Add(-ttl)
+	var pruned []string
+	for _, file := range files {
+		if strings.HasSuffix(file, ".lock") {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return pruned, fmt.Errorf("remove session: %w", err)
+		}
+		pruned = append(pruned, file)
+	}
+	return pruned, nil
+}
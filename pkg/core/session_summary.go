@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+// DefaultMaxSummaryInputBytes is the size of the transcript sent to the model
+// by Session.Summarize if SummarizeParams.MaxInputBytes is not set.
+const DefaultMaxSummaryInputBytes = 50_000
+
+// SessionSummary is a short description of a session, e.g. for dashboards
+// listing many agent runs.
+type SessionSummary struct {
+	Title   string
+	Summary string
+	// Usage is the token usage of the generation.
+	Usage llm.TokenUsage
+}
+
+type SummarizeParams struct {
+	// Model generates the summary, a cheap model is enough (mandatory).
+	Model llm.Model
+	// MaxInputBytes limits the transcript sent to the model, the middle of
+	// longer conversations is left out. Defaults to
+	// DefaultMaxSummaryInputBytes.
+	MaxInputBytes int
+}
+
+const summarizeSessionPrompt = `Write a title and a summary of the conversation of an AI agent below.
+The title is at most 8 words, the summary is 1-3 sentences about the task and its outcome.
+Answer in this format, without anything else:
+
+Title: <title>
+Summary: <summary>
+
+<conversation>
+%s
+</conversation>`
+
+// Summarize generates a title and a summary of the session with a single
+// completion.
+func (s Session) Summarize(ctx context.Context, p SummarizeParams) (SessionSummary, error) {
+	if len(s.Messages) == 0 {
+		return SessionSummary{}, fmt.Errorf("empty session")
+	}
+	maxBytes := p.MaxInputBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxSummaryInputBytes
+	}
+	transcript := truncate.Middle(llm.History(s.Messages).Markdown(), maxBytes, "\n[...]\n")
+	text, usage, err := llm.Complete(ctx, p.Model, "", fmt.Sprintf(summarizeSessionPrompt, transcript))
+	if err != nil {
+		return SessionSummary{}, fmt.Errorf("complete: %w", err)
+	}
+	summary := parseSessionSummary(text)
+	summary.Usage = usage
+	if summary.Title == "" {
+		return summary, fmt.Errorf("no title in the response: %q", text)
+	}
+	return summary, nil
+}
+
+// SummarizeSession reads a session file and summarizes it, see
+// Session.Summarize.
+func SummarizeSession(ctx context.Context, filePath string, keys SessionKeyProvider, p SummarizeParams) (SessionSummary, error) {
+	session, err := ReadSession(filePath, keys)
+	if err != nil {
+		return SessionSummary{}, fmt.Errorf("read session: %w", err)
+	}
+	return session.Summarize(ctx, p)
+}
+
+func parseSessionSummary(text string) SessionSummary {
+	var summary SessionSummary
+	var summaryLines []string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		switch {
+		case strings.HasPrefix(line, "Title:"):
+			summary.Title = strings.TrimSpace(strings.TrimPrefix(line, "Title:"))
+		case strings.HasPrefix(line, "Summary:"):
+			summaryLines = append(summaryLines, strings.TrimSpace(strings.TrimPrefix(line, "Summary:")))
+		case len(summaryLines) > 0:
+			summaryLines = append(summaryLines, strings.TrimSpace(line))
+		}
+	}
+	summary.Title = strings.Trim(summary.Title, `"*`)
+	summary.Summary = strings.TrimSpace(strings.Join(summaryLines, " "))
+	return summary
+}
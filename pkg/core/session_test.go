@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// TestSessionReloadedIfReplaced runs an agent created before another agent
+// saved the shared session: it must continue from the saved conversation
+// instead of overwriting it.
+func TestSessionReloadedIfReplaced(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "session.gob")
+	newAgent := func() (*Agent[string], *fakeLLM) {
+		provider := scriptedLLM()
+		p := testParams(provider)
+		p.SessionFilePath = sessionPath
+		agent, err := NewAgent[string](p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return agent, provider
+	}
+
+	first, _ := newAgent()
+	second, provider := newAgent()
+	if _, err := first.Run(context.Background(), "First prompt."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Run(context.Background(), "Second prompt."); err != nil {
+		t.Fatal(err)
+	}
+
+	history := provider.Requests()[0].History
+	if len(history) == 0 || !containsText(history[0], "First prompt.") {
+		t.Errorf("the second run doesn't continue the first one, its history starts with %+v", history)
+	}
+	saved, err := ReadSession(sessionPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsText(saved.Messages[0], "First prompt.") {
+		t.Errorf("the first conversation was overwritten, the session starts with %+v", saved.Messages[0])
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(sessionPath)); len(entries) != 2 {
+		t.Errorf("%d files next to the session, want the session and its lock", len(entries))
+	}
+}
+
+func containsText(msg llm.Message, text string) bool {
+	for _, part := range msg.Parts {
+		if tc, ok := part.(llm.TextContent); ok && tc.Text == text {
+			return true
+		}
+	}
+	return false
+}
+
+// TestSessionRotatedWhenLocked rotates an oversized session: creating an
+// agent must not touch the file, the rotation happens when a run holds the
+// lock and the run starts a new conversation.
+func TestSessionRotatedWhenLocked(t *testing.T) {
+	sessionPath := filepath.Join(t.TempDir(), "session.gob")
+	newAgent := func(retention SessionRetention) (*Agent[string], *fakeLLM) {
+		provider := scriptedLLM()
+		p := testParams(provider)
+		p.SessionFilePath = sessionPath
+		p.SessionRetention = retention
+		agent, err := NewAgent[string](p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return agent, provider
+	}
+	first, _ := newAgent(SessionRetention{})
+	if _, err := first.Run(context.Background(), "First prompt."); err != nil {
+		t.Fatal(err)
+	}
+
+	second, provider := newAgent(SessionRetention{MaxBytes: 1, MaxBackups: 1})
+	if _, err := os.Stat(sessionPath + ".1"); err == nil {
+		t.Fatal("the session was rotated by NewAgent")
+	}
+	if _, err := second.Run(context.Background(), "Second prompt."); err != nil {
+		t.Fatal(err)
+	}
+	backup, err := ReadSession(sessionPath+".1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsText(backup.Messages[0], "First prompt.") {
+		t.Errorf("the backup starts with %+v, want the first conversation", backup.Messages[0])
+	}
+	if history := provider.Requests()[0].History; len(history) != 1 || !containsText(history[0], "Second prompt.") {
+		t.Errorf("the run after the rotation sent the history %+v, want a new conversation", history)
+	}
+}
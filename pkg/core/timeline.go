@@ -0,0 +1,39 @@
+package core
+
+import (
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// Timeline is the timing data of a run, to diagnose slow agents.
+type Timeline struct {
+	Started time.Time
+	// Duration is the wall clock time of the run, including the critiques.
+	Duration time.Duration
+	Turns    []TurnTiming
+}
+
+type TurnTiming struct {
+	Started time.Time
+	// LLMLatency is the time spent waiting for the model response,
+	// including the retries of the provider.
+	LLMLatency time.Duration
+	Tools      []ToolTiming
+	// Duration is the time of the whole turn, including the tool calls.
+	Duration time.Duration
+}
+
+type ToolTiming struct {
+	Name       string
+	ToolCallID string
+	// Wait is the time between the model response and the start of the tool.
+	Wait     time.Duration
+	Duration time.Duration
+}
+
+// toolOutcome is the result of a tool call with its timing.
+type toolOutcome struct {
+	result llm.ToolResult
+	timing ToolTiming
+}
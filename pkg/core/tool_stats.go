@@ -0,0 +1,83 @@
+package core
+
+import (
+	"slices"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// ToolStats are the statistics of the calls of a tool in a run, e.g. to see
+// which tools the model struggles with.
+type ToolStats struct {
+	Calls  int
+	Errors int
+	// Total, Min, Max, P50 and P95 describe the latency of the calls. Calls
+	// canceled before they started are not included.
+	Total time.Duration
+	Min   time.Duration
+	Max   time.Duration
+	P50   time.Duration
+	P95   time.Duration
+}
+
+// SuccessRate is the ratio of the calls without an error, 1 without calls.
+func (s ToolStats) SuccessRate() float64 {
+	if s.Calls == 0 {
+		return 1
+	}
+	return float64(s.Calls-s.Errors) / float64(s.Calls)
+}
+
+// toolStatsRecorder collects the outcomes of the tool calls of a run.
+type toolStatsRecorder struct {
+	calls     map[string]int
+	errors    map[string]int
+	latencies map[string][]time.Duration
+}
+
+func (r *toolStatsRecorder) add(timings []ToolTiming, results []llm.ContentPart) {
+	if r.calls == nil {
+		r.calls, r.errors, r.latencies = map[string]int{}, map[string]int{}, map[string][]time.Duration{}
+	}
+	for _, part := range results {
+		res, ok := part.(llm.ToolResult)
+		if !ok {
+			continue
+		}
+		r.calls[res.ToolName]++
+		if res.IsError {
+			r.errors[res.ToolName]++
+		}
+	}
+	for _, t := range timings {
+		r.latencies[t.Name] = append(r.latencies[t.Name], t.Duration)
+	}
+}
+
+func (r *toolStatsRecorder) stats() map[string]ToolStats {
+	if len(r.calls) == 0 {
+		return nil
+	}
+	stats := map[string]ToolStats{}
+	for name, calls := range r.calls {
+		s := ToolStats{Calls: calls, Errors: r.errors[name]}
+		latencies := slices.Sorted(slices.Values(r.latencies[name]))
+		if len(latencies) > 0 {
+			for _, d := range latencies {
+				s.Total += d
+			}
+			s.Min, s.Max = latencies[0], latencies[len(latencies)-1]
+			s.P50 = percentile(latencies, 50)
+			s.P95 = percentile(latencies, 95)
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/prompt/compress"
+)
+
+// DefaultMaxSummaryInputTokens is the size of the tool result sent to the
+// summarizer if ToolResultSummaryParams.MaxInputTokens is not set.
+const DefaultMaxSummaryInputTokens = 100_000
+
+// ToolResultSummaryParams condenses the huge tool results (e.g. full build
+// logs) with a cheap model before they enter the context of the agent, see
+// NewAgentParams.SummarizeToolResults.
+type ToolResultSummaryParams struct {
+	// LLM writes the summaries, a cheap model is enough (mandatory).
+	LLM llm.Provider
+	// AboveTokens is the size of the results to summarize, in estimated
+	// tokens (see compress.EstimateTokens). Tools can override it with
+	// tool.Definition.SummarizeAboveTokens. If 0, only the results of the
+	// tools setting it are summarized.
+	AboveTokens int
+	// MaxInputTokens limits the result sent to the summarizer, longer
+	// results are compressed first (see compress.Compress). Defaults to
+	// DefaultMaxSummaryInputTokens.
+	MaxInputTokens int
+}
+
+const systemToolSummary = "You condense the output of a tool called by an AI agent. " +
+	"The agent only sees your summary instead of the output, so keep every detail it needs for its task: " +
+	"errors, failures, warnings, results and the values it asked for, quoted exactly. Leave out the noise " +
+	"(progress, repeated and successful steps). Add pointers to the relevant parts of the output (the L<n> " +
+	"line numbers, file paths, identifiers), so the agent can request them from the tool if needed. " +
+	"Answer with the summary only."
+
+const toolSummaryPrompt = `<tool>%s</tool>
+
+<input>%s</input>
+
+<output lines="%d">
+%s
+</output>`
+
+// summarizeToolResult replaces a result larger than the threshold of the tool
+// with its summary. It returns the result as is if summarizing fails, it's
+// truncated then.
+func (agent *Agent[ResultT]) summarizeToolResult(ctx context.Context, t toolUseParams, s string) string {
+	p := agent.summarizeResults
+	if p == nil {
+		return s
+	}
+	threshold := p.AboveTokens
+	if def, ok := agent.toolBelt.Definition(t.Name); ok && def.SummarizeAboveTokens > 0 {
+		threshold = def.SummarizeAboveTokens
+	}
+	tokens := compress.EstimateTokens(s)
+	if threshold <= 0 || tokens <= threshold {
+		return s
+	}
+
+	// The lines are numbered before compressing, so the pointers match the
+	// original output.
+	lines := strings.Split(s, "\n")
+	numbered := make([]string, len(lines))
+	for i, line := range lines {
+		numbered[i] = fmt.Sprintf("L%d: %s", i+1, line)
+	}
+	input := strings.Join(numbered, "\n")
+	maxInputTokens := cmp.Or(p.MaxInputTokens, DefaultMaxSummaryInputTokens)
+	if compress.EstimateTokens(input) > maxInputTokens {
+		input = compress.Compress(input, compress.Options{MaxTokens: maxInputTokens})
+	}
+	prompt := fmt.Sprintf(toolSummaryPrompt, t.Name, t.Input, len(lines), input)
+
+	release, err := agent.limiter.acquireLLM(ctx, agent.priority)
+	if err != nil {
+		return s
+	}
+	summary, usage, err := llm.CompleteWith(ctx, p.LLM, systemToolSummary, prompt)
+	release()
+	agent.mu.Lock()
+	agent.usageBreakdown.addPhase(PhaseToolSummary, usage)
+	agent.mu.Unlock()
+	if usageErr := agent.updateUsage(usage); usageErr != nil {
+		// The budget is checked again after the next turn.
+		agent.logger.Warn("tool result summary exceeded the token budget", "tool", t.Name, "error", usageErr)
+	}
+	if err != nil {
+		agent.logger.Warn("summarize tool result", "tool", t.Name, "error", err)
+		return s
+	}
+	agent.logger.Debug("tool result summarized", "tool", t.Name, "tokens", tokens, "summary_tokens", compress.EstimateTokens(summary))
+	return fmt.Sprintf(
+		"[SUMMARIZED: the %d bytes of the output were condensed, the L<n> pointers are its line numbers. "+
+			"If you need the exact content, call the tool again requesting a smaller part.]\n\n%s",
+		len(s), summary,
+	)
+}
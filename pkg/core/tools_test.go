@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+func TestAddRemoveToolsNextTurn(t *testing.T) {
+	use := func(context.Context, json.RawMessage) (string, error) { return "ok", nil }
+	provider := scriptedLLM(
+		[]llm.ContentPart{toolCall("1", "Keep", `{}`)},
+		[]llm.ContentPart{toolCall("2", "Added", `{}`)},
+	)
+	agent, err := newTestAgent(provider, testTool("Keep", use), testTool("Removed", use))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addedCalled bool
+	agent.hooks.OnMessage = func(int, llm.Message) {
+		if agent.turn.Load() != 1 {
+			return
+		}
+		agent.AddTools(
+			testTool("Added", func(context.Context, json.RawMessage) (string, error) {
+				addedCalled = true
+				return "ok", nil
+			}),
+			// The built-in tools can't be replaced or removed.
+			testTool(tool.FinalResultToolName, use),
+		)
+		agent.RemoveTools("Removed", tool.FinalResultToolName)
+	}
+	res, err := agent.Run(context.Background(), "Use the tools.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Data != "done" {
+		t.Errorf("result = %q, want the result of the built-in FinalResult tool", res.Data)
+	}
+	if !addedCalled {
+		t.Error("the added tool was not called")
+	}
+
+	requests := provider.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(requests))
+	}
+	want := [][]string{
+		{tool.FinalResultToolName, "Keep", "Removed"},
+		{"Added", tool.FinalResultToolName, "Keep"},
+		{"Added", tool.FinalResultToolName, "Keep"},
+	}
+	for i, request := range requests {
+		var names []string
+		for _, def := range request.ToolDefinitions {
+			names = append(names, def.Name)
+			if def.Name == tool.FinalResultToolName && def.Description == "A test tool." {
+				t.Errorf("request %d: the FinalResult tool was replaced", i)
+			}
+		}
+		slices.Sort(names)
+		if !slices.Equal(names, want[i]) {
+			t.Errorf("request %d: tools = %v, want %v", i, names, want[i])
+		}
+	}
+}
+
+// TestToolErrorTruncated fails a tool with a huge error, it must be sent to
+// the model truncated like a result.
+func TestToolErrorTruncated(t *testing.T) {
+	provider := scriptedLLM([]llm.ContentPart{toolCall("1", "Fail", `{}`)})
+	p := testParams(provider, testTool("Fail", func(context.Context, json.RawMessage) (string, error) {
+		return "", errors.New(strings.Repeat("error ", 1000))
+	}))
+	p.MaxToolResultBytes = 100
+	agent, err := NewAgent[string](p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.Run(context.Background(), "Use the tool."); err != nil {
+		t.Fatal(err)
+	}
+	history := provider.Requests()[1].History
+	for _, part := range history[len(history)-1].Parts {
+		if res, ok := part.(llm.ToolResult); ok && (!res.IsError || !strings.Contains(res.Content, "[TRUNCATED") || len(res.Content) > 500) {
+			t.Errorf("the tool error was sent as %d bytes: %.200q", len(res.Content), res.Content)
+		}
+	}
+}
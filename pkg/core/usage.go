@@ -0,0 +1,93 @@
+package core
+
+import (
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// Phase is the part of a run the tokens are attributed to.
+type Phase string
+
+const (
+	// PhasePlanning are the turns before the first tool call (other than
+	// UpdatePlan).
+	PhasePlanning Phase = "planning"
+	// PhaseToolLoop are the turns calling tools.
+	PhaseToolLoop Phase = "tool_loop"
+	// PhaseFinalResult are the turns returning the final result.
+	PhaseFinalResult Phase = "final_result"
+	// PhaseCritique is the review of the final result.
+	PhaseCritique Phase = "critique"
+	// PhaseToolSummary are the summaries of the large tool results, see
+	// NewAgentParams.SummarizeToolResults.
+	PhaseToolSummary Phase = "tool_summary"
+)
+
+// UsageBreakdown attributes the token usage of a run to phases and tools.
+type UsageBreakdown struct {
+	Phases map[Phase]llm.TokenUsage
+	Tools  map[string]ToolUsage
+}
+
+// ToolUsage is the usage of a tool. Its output stays in the context of
+// every later turn, so tools with large outputs inflate the input tokens.
+type ToolUsage struct {
+	Calls       int
+	Errors      int
+	InputBytes  int
+	OutputBytes int
+}
+
+func (u *UsageBreakdown) addPhase(phase Phase, usage llm.TokenUsage) {
+	if u.Phases == nil {
+		u.Phases = map[Phase]llm.TokenUsage{}
+	}
+	u.Phases[phase] = u.Phases[phase].Add(usage)
+}
+
+func (u *UsageBreakdown) addToolResults(toolUses []toolUseParams, results []llm.ContentPart) {
+	if u.Tools == nil {
+		u.Tools = map[string]ToolUsage{}
+	}
+	for _, t := range toolUses {
+		tu := u.Tools[t.Name]
+		tu.Calls++
+		tu.InputBytes += len(t.Input)
+		u.Tools[t.Name] = tu
+	}
+	for _, part := range results {
+		res, ok := part.(llm.ToolResult)
+		if !ok {
+			continue
+		}
+		tu := u.Tools[res.ToolName]
+		tu.OutputBytes += len(res.Content)
+		if res.IsError {
+			tu.Errors++
+		}
+		u.Tools[res.ToolName] = tu
+	}
+}
+
+// turnPhase returns the phase of a turn based on the tools called in it,
+// finalResult is the name of the FinalResult tool.
+func (u *UsageBreakdown) turnPhase(toolUses []toolUseParams, finalResult string) Phase {
+	onlyPlan := true
+	for _, t := range toolUses {
+		if t.Name == finalResult {
+			return PhaseFinalResult
+		}
+		if t.Name != tool.UpdatePlanToolName {
+			onlyPlan = false
+		}
+	}
+	for name := range u.Tools {
+		if name != tool.UpdatePlanToolName {
+			onlyPlan = false
+		}
+	}
+	if onlyPlan {
+		return PhasePlanning
+	}
+	return PhaseToolLoop
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+// Validate checks the parameters for misconfigurations NewAgent accepts but
+// which make the runs fail or behave unexpectedly, e.g. PlanReminderTurns
+// without planning. It returns all the problems found,
+// each with how to fix it. NewAgent doesn't call it, call it at startup to
+// catch the misconfigurations early.
+func (p NewAgentParams) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if p.LLM == nil {
+		fail("LLM is missing: set it to the provider of the model, see llm.Model.NewProvider")
+	}
+	if p.SystemPrompt == "" && len(p.SystemPromptBlocks) == 0 && len(p.Tools) > 0 {
+		fail("the system prompt is empty but %d tools are given: describe the task and when to use the tools in SystemPrompt", len(p.Tools))
+	}
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"MaxToolLogLength", p.MaxToolLogLength},
+		{"MaxTokenUsage", p.MaxTokenUsage},
+		{"MaxToolResultBytes", p.MaxToolResultBytes},
+		{"MaxParallelTools", p.MaxParallelTools},
+		{"MaxEmptyResponseNudges", p.MaxEmptyResponseNudges},
+		{"PlanReminderTurns", p.PlanReminderTurns},
+	} {
+		if f.value < 0 {
+			fail("%s is negative (%d): set it to 0 for the default or no limit", f.name, f.value)
+		}
+	}
+	if p.FinalTurnBuffer < 0 {
+		fail("FinalTurnBuffer is negative (%s): set it to 0 for DefaultFinalTurnBuffer", p.FinalTurnBuffer)
+	}
+	if !p.TimeboxedUntil.IsZero() && !p.TimeboxedUntil.After(clock.Or(p.Clock).Now()) {
+		fail("the timebox expired at %s before the run started: set TimeboxedUntil to a time in the future, or leave it zero", p.TimeboxedUntil)
+	}
+	if p.PlanReminderTurns > 0 && !p.EnablePlanning {
+		fail("PlanReminderTurns is set without EnablePlanning: enable planning, or the reminders are never sent")
+	}
+	if p.EnableSandbox && p.Sandbox.Image == "" {
+		fail("EnableSandbox is set without Sandbox.Image: set the image of the container")
+	}
+	if p.FreeTextResult && p.ResultSchema != nil {
+		fail("FreeTextResult is set with a ResultSchema: the free-text result is a string, remove the schema")
+	}
+	if p.Critique != nil && p.Critique.Criteria == "" {
+		fail("Critique has no Criteria: set the instructions the result is checked against")
+	}
+	if p.SummarizeToolResults != nil && p.SummarizeToolResults.LLM == nil {
+		fail("SummarizeToolResults has no LLM: set the provider of a cheap model")
+	}
+
+	builtins := map[string]bool{}
+	if !p.FreeTextResult {
+		builtins[cmp.Or(p.FinalResultToolName, tool.FinalResultToolName)] = true
+	}
+	builtins[tool.UpdatePlanToolName] = p.EnablePlanning
+	builtins[tool.EmitFindingToolName] = p.EnableFindings
+
+	names := map[string]bool{}
+	for _, t := range p.Tools {
+		switch {
+		case t.Name == "":
+			fail("a tool has no name")
+		case builtins[t.Name]:
+			fail("tool %q has the name of a built-in tool, it's ignored: rename it", t.Name)
+		case names[t.Name]:
+			fail("tool %q is given twice: remove the duplicate, the names must be unique", t.Name)
+		case t.UseFunc == nil && p.ToolExecutor == nil:
+			fail("tool %q has no UseFunc: set it, or set ToolExecutor to execute the tools", t.Name)
+		}
+		names[t.Name] = true
+	}
+
+	if p.SessionFilePath != "" {
+		if err := CheckSessionFile(p.SessionFilePath); err != nil {
+			fail("session file: %w", err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckSessionFile checks that a session file can be written at the path: its
+// directory exists and is writable, and the file, if it exists, is a
+// writable regular file.
+func CheckSessionFile(filePath string) error {
+	info, err := os.Stat(filePath)
+	switch {
+	case err == nil && !info.Mode().IsRegular():
+		return fmt.Errorf("%s is not a regular file: use the path of a file", filePath)
+	case err == nil:
+		file, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %w", filePath, err)
+		}
+		return file.Close()
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("stat %s: %w", filePath, err)
+	}
+
+	dir := filepath.Dir(filePath)
+	file, err := os.CreateTemp(dir, ".session-check-*")
+	if err != nil {
+		return fmt.Errorf("can't create files in %s, create the directory or fix its permissions: %w", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,158 @@
+// Package jail confines the file access of tools to a workspace root, like a
+// chroot: paths are resolved relative to the root, symlinks are followed only
+// as long as they stay inside it and traversal attempts are rejected.
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideRoot is returned (wrapped) for paths escaping the root.
+var ErrOutsideRoot = errors.New("path is outside of the workspace")
+
+// Jail resolves the paths of the tools within a root directory. The file
+// operations go through os.Root, so a symlink swapped in after resolving a
+// path can't escape the root either.
+type Jail struct {
+	root string
+}
+
+// New creates a jail for the root directory, defaulting to the current
+// directory.
+func New(root string) (*Jail, error) {
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve root: %w", err)
+	}
+	abs, err = filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, fmt.Errorf("resolve root: %w", err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("stat root: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("root %s is not a directory", abs)
+	}
+	return &Jail{root: abs}, nil
+}
+
+// Root returns the absolute root directory, with symlinks resolved.
+func (j *Jail) Root() string {
+	return j.root
+}
+
+// Rel returns the slash separated path of p relative to the root. p is
+// either relative to the root or absolute, and it may not exist yet (e.g. a
+// file to create). Symlinks of the existing part of the path are resolved
+// and must point inside the root.
+func (j *Jail) Rel(p string) (string, error) {
+	abs := filepath.FromSlash(p)
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(j.root, abs)
+	}
+	abs = filepath.Clean(abs)
+
+	// Resolve the symlinks of the longest existing prefix.
+	existing, rest := abs, ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			abs = filepath.Join(resolved, rest)
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("resolve %s: %w", p, err)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	rel, ok := j.within(abs)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrOutsideRoot, p)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Resolve returns the absolute path of p, see Rel.
+func (j *Jail) Resolve(p string) (string, error) {
+	rel, err := j.Rel(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(j.root, filepath.FromSlash(rel)), nil
+}
+
+func (j *Jail) within(abs string) (string, bool) {
+	rel, err := filepath.Rel(j.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// open opens the root and returns the path of p relative to it.
+func (j *Jail) open(p string) (*os.Root, string, error) {
+	rel, err := j.Rel(p)
+	if err != nil {
+		return nil, "", err
+	}
+	root, err := os.OpenRoot(j.root)
+	if err != nil {
+		return nil, "", fmt.Errorf("open root: %w", err)
+	}
+	return root, filepath.FromSlash(rel), nil
+}
+
+func (j *Jail) ReadFile(p string) ([]byte, error) {
+	root, rel, err := j.open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.ReadFile(rel)
+}
+
+func (j *Jail) Stat(p string) (fs.FileInfo, error) {
+	root, rel, err := j.open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return root.Stat(rel)
+}
+
+// WriteFile writes the file, creating its parent directories.
+func (j *Jail) WriteFile(p string, data []byte, perm fs.FileMode) error {
+	root, rel, err := j.open(p)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := root.MkdirAll(filepath.Dir(rel), 0o755); err != nil {
+		return err
+	}
+	return root.WriteFile(rel, data, perm)
+}
+
+func (j *Jail) Remove(p string) error {
+	root, rel, err := j.open(p)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Remove(rel)
+}
@@ -0,0 +1,160 @@
+// Package journal records the original content of the files changed by the
+// tools of a run, so the changes of a failed or rejected run can be reverted.
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// Journal keeps the original state of the changed files per run ID, in
+// memory. It's safe for concurrent use.
+type Journal struct {
+	mu   sync.Mutex
+	runs map[string][]entry
+}
+
+// entry is the state of a file before the first change of the run.
+type entry struct {
+	path    string
+	existed bool
+	content []byte
+	mode    fs.FileMode
+}
+
+func New() *Journal {
+	return &Journal{runs: map[string][]entry{}}
+}
+
+// Record saves the current state of the file (including its absence) before
+// a tool changes it. Only the first call per path and run is recorded, so a
+// rollback restores the state from before the run.
+func (j *Journal) Record(runID, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("journal %s: %w", path, err)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if slices.ContainsFunc(j.runs[runID], func(e entry) bool { return e.path == path }) {
+		return nil
+	}
+
+	e := entry{path: path}
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("journal %s: %w", path, err)
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("journal %s: not a regular file", path)
+	default:
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("journal %s: %w", path, err)
+		}
+		e.existed, e.content, e.mode = true, content, info.Mode().Perm()
+	}
+	j.runs[runID] = append(j.runs[runID], e)
+	return nil
+}
+
+// Paths returns the absolute paths of the files recorded for the run.
+func (j *Journal) Paths(runID string) []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var paths []string
+	for _, e := range j.runs[runID] {
+		paths = append(paths, e.path)
+	}
+	return paths
+}
+
+// Forget drops the records of a run, e.g. after its changes were accepted.
+func (j *Journal) Forget(runID string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.runs, runID)
+}
+
+// RollbackRun restores the files changed by the run to their state before
+// the run: the original contents are first written to temporary files next
+// to the targets, and only renamed into place if all of them could be
+// written. Files created by the run are removed. The records of the run are
+// dropped if the rollback succeeds.
+func (j *Journal) RollbackRun(runID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := j.runs[runID]
+
+	temps := make([]string, len(entries))
+	cleanup := func() {
+		for _, tmp := range temps {
+			if tmp != "" {
+				_ = os.Remove(tmp)
+			}
+		}
+	}
+	for i, e := range entries {
+		if !e.existed {
+			continue
+		}
+		tmp, err := writeTemp(e)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("rollback %s: %w", e.path, err)
+		}
+		temps[i] = tmp
+	}
+
+	var errs []error
+	for i, e := range slices.Backward(entries) {
+		if !e.existed {
+			if err := os.Remove(e.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("rollback %s: %w", e.path, err))
+			}
+			continue
+		}
+		if err := os.Rename(temps[i], e.path); err != nil {
+			errs = append(errs, fmt.Errorf("rollback %s: %w", e.path, err))
+			continue
+		}
+		temps[i] = ""
+	}
+	cleanup()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	delete(j.runs, runID)
+	return nil
+}
+
+func writeTemp(e entry) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(e.path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(e.path), "."+filepath.Base(e.path)+".rollback-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(e.content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Chmod(e.mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
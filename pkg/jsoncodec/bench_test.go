@@ -0,0 +1,164 @@
+package jsoncodec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The benchmarks compare the codecs on large tool inputs and results:
+//
+//	go test -run '^$' -bench . -benchmem ./pkg/jsoncodec
+//
+// Add the engine to evaluate to benchCodecs, e.g.
+// {"jsoniter", jsoniter.ConfigCompatibleWithStandardLibrary}.
+var benchCodecs = []struct {
+	name  string
+	codec Codec
+}{
+	{"std", Std{}},
+	{"pooled", pooledCodec{}},
+}
+
+// pooledCodec is a plugged-in codec reusing the encoding buffers, without the
+// HTML escaping of encoding/json.
+type pooledCodec struct{}
+
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+func (pooledCodec) Marshal(v any) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer bufferPool.Put(buf)
+	buf.Reset()
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode appends a newline, Marshal doesn't.
+	return bytes.Clone(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))), nil
+}
+
+func (pooledCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// largeResult is a large tool input or result, e.g. a patch or a page of
+// build logs.
+type largeResult struct {
+	Path  string      `json:"path"`
+	Files []largeFile `json:"files"`
+}
+
+type largeFile struct {
+	Name    string   `json:"name"`
+	Content string   `json:"content"`
+	Lines   []int    `json:"lines"`
+	Labels  []string `json:"labels,omitempty"`
+}
+
+func newLargeResult() largeResult {
+	result := largeResult{Path: "src"}
+	result.Files = make([]largeFile, 200)
+	for i := range result.Files {
+		result.Files[i].Name = fmt.Sprintf("file_%d.go", i)
+		result.Files[i].Content = strings.Repeat("func main() { fmt.Println(\"<hello>\") }\n", 30)
+		result.Files[i].Lines = []int{1, 2, 3, i}
+		result.Files[i].Labels = []string{"go", "review"}
+	}
+	return result
+}
+
+// withCodec runs the benchmark with the codec set, restoring encoding/json
+// afterwards.
+func withCodec(b *testing.B, c Codec, fn func(b *testing.B)) {
+	Set(c)
+	defer Set(nil)
+	b.ReportAllocs()
+	fn(b)
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	result := newLargeResult()
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			withCodec(b, bc.codec, func(b *testing.B) {
+				for b.Loop() {
+					if _, err := Marshal(result); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, err := json.Marshal(newLargeResult())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			withCodec(b, bc.codec, func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				for b.Loop() {
+					var result largeResult
+					if err := Unmarshal(data, &result); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkIndirection measures the cost of the pluggable codec over calling
+// encoding/json directly.
+func BenchmarkIndirection(b *testing.B) {
+	data := []byte(`{"path":"main.go","line":12}`)
+	var input struct {
+		Path string `json:"path"`
+		Line int    `json:"line"`
+	}
+	b.Run("direct", func(b *testing.B) {
+		for b.Loop() {
+			if err := json.Unmarshal(data, &input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("codec", func(b *testing.B) {
+		for b.Loop() {
+			if err := Unmarshal(data, &input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestCodecsCompatible checks that the benchmarked codecs are compatible with
+// encoding/json, so their results are comparable.
+func TestCodecsCompatible(t *testing.T) {
+	result := newLargeResult()
+	for _, bc := range benchCodecs {
+		t.Run(bc.name, func(t *testing.T) {
+			data, err := bc.codec.Marshal(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded largeResult
+			if err := bc.codec.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			want, _ := json.Marshal(result)
+			got, _ := json.Marshal(decoded)
+			if !bytes.Equal(got, want) {
+				t.Error("the round-trip changed the result")
+			}
+		})
+	}
+}
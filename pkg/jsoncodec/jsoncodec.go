@@ -0,0 +1,63 @@
+// Package jsoncodec is the JSON engine of the hot paths: decoding the tool
+// inputs and encoding the tool results. It defaults to encoding/json, a
+// faster compatible engine can be plugged in at startup, e.g. jsoniter:
+//
+//	jsoncodec.Set(jsoniter.ConfigCompatibleWithStandardLibrary)
+//
+// or go-json with a small adapter. The engine must be compatible with
+// encoding/json (struct tags, json.RawMessage, json.Marshaler).
+package jsoncodec
+
+import (
+	"encoding/json"
+	"sync/atomic"
+)
+
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Std is the encoding/json codec.
+type Std struct{}
+
+func (Std) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (Std) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+var codec atomic.Value
+
+func init() {
+	codec.Store(holder{Std{}})
+}
+
+// holder keeps the concrete type stored in the atomic.Value the same.
+type holder struct {
+	Codec
+}
+
+// Set replaces the codec, nil restores encoding/json. It should be called
+// before the agents are started.
+func Set(c Codec) {
+	if c == nil {
+		c = Std{}
+	}
+	codec.Store(holder{c})
+}
+
+// Get returns the current codec.
+func Get() Codec {
+	return codec.Load().(holder).Codec
+}
+
+func Marshal(v any) ([]byte, error) {
+	return Get().Marshal(v)
+}
+
+func Unmarshal(data []byte, v any) error {
+	return Get().Unmarshal(data, v)
+}
@@ -0,0 +1,168 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelAliases map names like "fast" or "smart" to models, so the model
+// snapshots are configured in one place instead of being hard-coded by the
+// products.
+type ModelAliases map[string]Model
+
+// ParseModelAliases parses an alias table like
+// "fast=anthropic:claude-haiku-4-5-20251001,smart=openai:gpt-5", e.g. from an
+// environment variable.
+func ParseModelAliases(s string) (ModelAliases, error) {
+	aliases := ModelAliases{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		alias, ref, ok := strings.Cut(entry, "=")
+		alias = strings.TrimSpace(alias)
+		if !ok || alias == "" {
+			return nil, fmt.Errorf("invalid model alias %q, expected alias=provider:model", entry)
+		}
+		model, err := ParseModelRef(strings.TrimSpace(ref))
+		if err != nil {
+			return nil, fmt.Errorf("model alias %q: %w", alias, err)
+		}
+		aliases[alias] = model
+	}
+	return aliases, nil
+}
+
+// ParseModelRef parses a "provider:model" reference, e.g.
+// "anthropic:claude-haiku-4-5-20251001". The model name is optional, the
+// default model of the provider is used without it.
+func ParseModelRef(ref string) (Model, error) {
+	provider, name, _ := strings.Cut(ref, ":")
+	if provider == "" {
+		return Model{}, fmt.Errorf("no provider in model reference %q", ref)
+	}
+	model := Model{Provider: ProviderName(provider), Name: name}
+	if err := model.SetDefaults(); err != nil {
+		return Model{}, fmt.Errorf("model reference %q: %w", ref, err)
+	}
+	return model, nil
+}
+
+// Resolve returns the model of the alias. Names which are not aliases are
+// parsed as "provider:model" references, so the configuration can pin a
+// model directly too.
+func (a ModelAliases) Resolve(name string) (Model, error) {
+	if model, ok := a[name]; ok {
+		return model, nil
+	}
+	if strings.Contains(name, ":") {
+		return ParseModelRef(name)
+	}
+	return Model{}, fmt.Errorf("unknown model alias %q (known: %s)", name, strings.Join(a.names(), ", "))
+}
+
+func (a ModelAliases) names() []string {
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Deprecation describes a model snapshot deprecated by its provider.
+type Deprecation struct {
+	// Retirement is the date the provider stops serving the model, zero if
+	// not announced.
+	Retirement time.Time
+	// Replacement is the model recommended by the provider (optional).
+	Replacement string
+}
+
+// Deprecations are the known deprecated model snapshots by name. Bedrock
+// model IDs match the name they contain. Products can add the deprecations
+// announced since this release.
+var Deprecations = map[string]Deprecation{
+	"claude-2.1": {
+		Retirement:  time.Date(2025, 7, 21, 0, 0, 0, 0, time.UTC),
+		Replacement: "claude-sonnet-4-5",
+	},
+	"claude-3-sonnet-20240229": {
+		Retirement:  time.Date(2025, 7, 21, 0, 0, 0, 0, time.UTC),
+		Replacement: "claude-sonnet-4-5",
+	},
+	"claude-3-5-sonnet-20240620": {
+		Retirement:  time.Date(2025, 10, 22, 0, 0, 0, 0, time.UTC),
+		Replacement: "claude-sonnet-4-5",
+	},
+	"claude-3-5-sonnet-20241022": {
+		Retirement:  time.Date(2025, 10, 22, 0, 0, 0, 0, time.UTC),
+		Replacement: "claude-sonnet-4-5",
+	},
+	"claude-3-opus-20240229": {
+		Retirement:  time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC),
+		Replacement: "claude-opus-4-1",
+	},
+	"gpt-4.5-preview": {
+		Retirement:  time.Date(2025, 7, 14, 0, 0, 0, 0, time.UTC),
+		Replacement: "gpt-4.1",
+	},
+	"o1-preview": {
+		Retirement:  time.Date(2025, 7, 28, 0, 0, 0, 0, time.UTC),
+		Replacement: "o3",
+	},
+	"o1-mini": {
+		Retirement:  time.Date(2025, 10, 27, 0, 0, 0, 0, time.UTC),
+		Replacement: "o4-mini",
+	},
+	"gemini-1.5-pro": {
+		Retirement:  time.Date(2025, 9, 24, 0, 0, 0, 0, time.UTC),
+		Replacement: "gemini-2.5-pro",
+	},
+	"gemini-1.5-flash": {
+		Retirement:  time.Date(2025, 9, 24, 0, 0, 0, 0, time.UTC),
+		Replacement: "gemini-2.5-flash",
+	},
+}
+
+// Deprecation returns the deprecation of the model, if it's deprecated.
+func (m *Model) Deprecation() (Deprecation, bool) {
+	if d, ok := Deprecations[m.Name]; ok {
+		return d, true
+	}
+	if m.Provider == ProviderBedrock {
+		for name, d := range Deprecations {
+			if strings.Contains(m.Name, name) {
+				return d, true
+			}
+		}
+	}
+	return Deprecation{}, false
+}
+
+var warnedDeprecations sync.Map
+
+// WarnDeprecated logs a warning if the model is deprecated, once per model
+// in the process.
+func WarnDeprecated(logger *slog.Logger, m Model) {
+	d, ok := m.Deprecation()
+	if !ok {
+		return
+	}
+	if _, warned := warnedDeprecations.LoadOrStore(m.Name, true); warned {
+		return
+	}
+	args := []any{"provider", m.Provider, "model", m.Name}
+	if !d.Retirement.IsZero() {
+		args = append(args, "retirement", d.Retirement.Format(time.DateOnly))
+	}
+	if d.Replacement != "" {
+		args = append(args, "replacement", d.Replacement)
+	}
+	logger.Warn("the model is deprecated by the provider", args...)
+}
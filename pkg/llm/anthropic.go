@@ -4,9 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"time"
+	"slices"
 
 	"github.com/anthropics/anthropic-sdk-go"
+	anthropic_option "github.com/anthropics/anthropic-sdk-go/option"
 	backoff "github.com/cenkalti/backoff/v4"
 )
 
@@ -14,6 +15,12 @@ type AnthropicProvider struct {
 	Client          anthropic.Client
 	Model           string
 	MaxOutputTokens int
+	// WebSearch enables the web search tool (optional), not available on
+	// Bedrock.
+	WebSearch *HostedSearchConfig
+
+	tools   toolCache[anthropic.ToolUnionParam]
+	history historyCache[anthropic.MessageParam]
 }
 
 func (ap *AnthropicProvider) NewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
@@ -23,56 +30,91 @@ func (ap *AnthropicProvider) NewMessage(ctx context.Context, params NewMessagePa
 	fn := func() (Message, error) {
 		return ap.tryNewMessage(ctx, params)
 	}
-	opts := backoff.WithContext(backoff.NewExponentialBackOff(
-		backoff.WithMaxElapsedTime(30*time.Second), // Retry for up to 30 seconds
-	), ctx)
-	notify := func(err error, d time.Duration) {
-		params.Logger.Warn("retrying tryNewMessage", "delay", d, "error", err)
-	}
-	message, err := backoff.RetryNotifyWithData(fn, opts, notify)
-	if err != nil {
-		return Message{}, fmt.Errorf("new message with retries: %w", err)
-	}
-	return message, nil
+	return retryNewMessage(ctx, params, fn)
 }
 
 func (ap *AnthropicProvider) tryNewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
-	systemPrompt := anthropic.TextBlockParam{Text: params.SystemPrompt}
-	tools := ap.convertTools(params.ToolDefinitions)
-	messages, err := ap.convertMessages(params.History, params.Logger)
+	var systemPrompt []anthropic.TextBlockParam
+	lastCacheable := -1
+	for i, block := range params.SystemBlocks() {
+		systemPrompt = append(systemPrompt, anthropic.TextBlockParam{Text: block.Text})
+		if block.Cacheable {
+			lastCacheable = i
+		}
+	}
+	tools, err := ap.tools.get(params.ToolDefinitions, ap.convertTools)
+	if err != nil {
+		return Message{}, backoff.Permanent(fmt.Errorf("convert tools: %w", err))
+	}
+	messages, err := ap.history.get(params.History, func(messages []Message) ([]anthropic.MessageParam, error) {
+		return ap.convertMessages(messages, params.Logger)
+	})
 	if err != nil {
 		return Message{}, fmt.Errorf("convert messages: %w", err)
 	}
 
 	if params.EnableCaching {
 		cacheFlag := anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
-		systemPrompt.CacheControl = cacheFlag
-		if len(tools) > 0 {
-			tools[len(tools)-1].OfTool.CacheControl = cacheFlag
+		// The cached prefix ends with the last stable block.
+		if lastCacheable >= 0 {
+			systemPrompt[lastCacheable].CacheControl = cacheFlag
+		}
+		if len(tools) > 0 && tools[len(tools)-1].OfTool != nil {
+			// The tools are cached by the provider, flag a copy of the last one.
+			tools = slices.Clone(tools)
+			last := *tools[len(tools)-1].OfTool
+			last.CacheControl = cacheFlag
+			tools[len(tools)-1].OfTool = &last
 		}
 		if err := ap.setCachedParams(messages); err != nil {
 			return Message{}, fmt.Errorf("set cached params: %w", err)
 		}
 	}
 
+	if ap.WebSearch != nil {
+		// First, so the last tool can still be flagged for caching.
+		tools = append([]anthropic.ToolUnionParam{anthropicWebSearchTool(ap.WebSearch)}, tools...)
+	}
+
 	messageParams := anthropic.MessageNewParams{
 		Model:       anthropic.Model(ap.Model),
-		System:      []anthropic.TextBlockParam{systemPrompt},
+		System:      systemPrompt,
 		Tools:       tools,
 		Messages:    messages,
 		MaxTokens:   int64(ap.MaxOutputTokens),
 		Temperature: anthropic.Float(0.0),
 	}
+	switch {
+	case params.ForceTool != "":
+		toolChoice := &anthropic.ToolChoiceToolParam{Name: params.ForceTool}
+		if params.DisableParallelToolUse {
+			toolChoice.DisableParallelToolUse = anthropic.Bool(true)
+		}
+		messageParams.ToolChoice = anthropic.ToolChoiceUnionParam{OfTool: toolChoice}
+	case params.DisableParallelToolUse && len(tools) > 0:
+		messageParams.ToolChoice = anthropic.ToolChoiceUnionParam{
+			OfAuto: &anthropic.ToolChoiceAutoParam{DisableParallelToolUse: anthropic.Bool(true)},
+		}
+	}
+
+	var opts []anthropic_option.RequestOption
+	if params.RunID != "" {
+		opts = append(opts, anthropic_option.WithHeader(RunIDHeader, params.RunID))
+	}
+	if params.TokenEfficientTools {
+		opts = append(opts, anthropic_option.WithHeaderAdd("anthropic-beta", string(anthropic.AnthropicBetaTokenEfficientTools2025_02_19)))
+	}
 
 	// By default the client retries all transient errors 2 times.
 	// Can be overridden using option.WithMaxRetries.
-	message, err := ap.Client.Messages.New(ctx, messageParams)
+	message, err := ap.Client.Messages.New(ctx, messageParams, opts...)
 	if err != nil {
 		return Message{}, fmt.Errorf("new message: %w", err)
 	}
 
 	resultMessage := Message{
-		Role: RoleAssistant,
+		Role:        RoleAssistant,
+		Fingerprint: string(message.Model),
 		Usage: TokenUsage{
 			InputTokens:         message.Usage.InputTokens,
 			OutputTokens:        message.Usage.OutputTokens,
@@ -80,11 +122,13 @@ func (ap *AnthropicProvider) tryNewMessage(ctx context.Context, params NewMessag
 			CacheReadTokens:     message.Usage.CacheReadInputTokens,
 		},
 	}
+	var searchQuery string
 	for _, block := range message.Content {
 		switch variant := block.AsAny().(type) {
 		case anthropic.TextBlock:
 			resultMessage.Parts = append(resultMessage.Parts, TextContent{
-				Text: variant.Text,
+				Text:      variant.Text,
+				Citations: anthropicCitations(variant.Citations),
 			})
 		case anthropic.ToolUseBlock:
 			resultMessage.Parts = append(resultMessage.Parts, ToolCall{
@@ -92,11 +136,44 @@ func (ap *AnthropicProvider) tryNewMessage(ctx context.Context, params NewMessag
 				Name:  variant.Name,
 				Input: block.Input,
 			})
+		case anthropic.ServerToolUseBlock:
+			searchQuery = anthropicSearchQuery(variant)
+		case anthropic.WebSearchToolResultBlock:
+			resultMessage.Parts = append(resultMessage.Parts, anthropicSearchResults(variant, searchQuery))
+			searchQuery = ""
 		}
 	}
 	return resultMessage, nil
 }
 
+func anthropicCitations(citations []anthropic.TextCitationUnion) []Citation {
+	var result []Citation
+	for _, c := range citations {
+		citation := Citation{
+			CitedText:     c.CitedText,
+			Title:         c.DocumentTitle,
+			DocumentIndex: int(c.DocumentIndex),
+			Location:      c.Type,
+		}
+		switch c.Type {
+		case "char_location":
+			citation.Start, citation.End = int(c.StartCharIndex), int(c.EndCharIndex)
+		case "page_location":
+			citation.Start, citation.End = int(c.StartPageNumber), int(c.EndPageNumber)
+		case "content_block_location":
+			citation.Start, citation.End = int(c.StartBlockIndex), int(c.EndBlockIndex)
+		case "web_search_result_location":
+			citation.Title, citation.URL = c.Title, c.URL
+		case "search_result_location":
+			citation.Title, citation.URL = c.Title, c.Source
+			citation.DocumentIndex = int(c.SearchResultIndex)
+			citation.Start, citation.End = int(c.StartBlockIndex), int(c.EndBlockIndex)
+		}
+		result = append(result, citation)
+	}
+	return result
+}
+
 func (ap *AnthropicProvider) convertMessages(messages []Message, logger *slog.Logger) ([]anthropic.MessageParam, error) {
 	var anthropicMessages []anthropic.MessageParam
 
@@ -109,16 +186,32 @@ func (ap *AnthropicProvider) convertMessages(messages []Message, logger *slog.Lo
 				case TextContent:
 					block := anthropic.NewTextBlock(v.Text)
 					blocks = append(blocks, block)
+				case SystemReminder:
+					block := anthropic.NewTextBlock(v.TaggedText())
+					blocks = append(blocks, block)
 				case ToolResult:
 					block := anthropic.NewToolResultBlock(v.ToolCallID, v.Content, v.IsError)
 					blocks = append(blocks, block)
+				case customPart:
+					// Not sent to the providers.
 				default:
 					return nil, fmt.Errorf("unknown user message part type %T", v)
 				}
 			}
+			if len(blocks) == 0 {
+				logger.Warn("skipping user message with no content")
+				continue
+			}
 			message := anthropic.NewUserMessage(blocks...)
 			anthropicMessages = append(anthropicMessages, message)
 
+		case RoleDeveloper:
+			text, err := developerText(msg)
+			if err != nil {
+				return nil, err
+			}
+			anthropicMessages = append(anthropicMessages, anthropic.NewUserMessage(anthropic.NewTextBlock(text)))
+
 		case RoleAssistant:
 			var blocks []anthropic.ContentBlockParamUnion
 			for _, part := range msg.Parts {
@@ -127,8 +220,12 @@ func (ap *AnthropicProvider) convertMessages(messages []Message, logger *slog.Lo
 					block := anthropic.NewTextBlock(v.Text)
 					blocks = append(blocks, block)
 				case ToolCall:
-					block := anthropic.NewToolUseBlock(v.ID, v.Input, v.Name)
+					block := anthropic.NewToolUseBlock(v.ID, objectInput(v.Input), v.Name)
 					blocks = append(blocks, block)
+				case SearchResults:
+					// Only recorded, the results are in the text.
+				case customPart:
+					// Not sent to the providers.
 				default:
 					return nil, fmt.Errorf("unknown assistant message part type %T", v)
 				}
@@ -145,24 +242,31 @@ func (ap *AnthropicProvider) convertMessages(messages []Message, logger *slog.Lo
 	return anthropicMessages, nil
 }
 
-func (ap *AnthropicProvider) convertTools(tools []ToolDefinition) []anthropic.ToolUnionParam {
+func (ap *AnthropicProvider) convertTools(tools []ToolDefinition) ([]anthropic.ToolUnionParam, error) {
 	var anthropicTools []anthropic.ToolUnionParam
 
 	for _, tool := range tools {
+		schema, err := NormalizeSchema(tool.Schema, ProviderAnthropic)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
 		toolParam := anthropic.ToolParam{
 			Name:        tool.Name,
 			Description: anthropic.String(tool.Description),
 			InputSchema: anthropic.ToolInputSchemaParam{
-				Properties: tool.Schema.Properties,
-				Required:   tool.Schema.Required,
+				Properties: schema.Properties,
+				Required:   schema.Required,
 			},
 		}
+		if schema.AdditionalProperties != nil {
+			toolParam.InputSchema.ExtraFields = map[string]any{"additionalProperties": schema.AdditionalProperties}
+		}
 		anthropicTools = append(anthropicTools, anthropic.ToolUnionParam{
 			OfTool: &toolParam,
 		})
 	}
 
-	return anthropicTools
+	return anthropicTools, nil
 }
 
 func (ap *AnthropicProvider) setCachedParams(messages []anthropic.MessageParam) error {
@@ -179,16 +283,25 @@ func (ap *AnthropicProvider) setCachedParams(messages []anthropic.MessageParam)
 			return fmt.Errorf("empty user message")
 		}
 
+		// The converted messages are cached by the provider, flag a copy of
+		// the block.
 		content := msg.Content[len(msg.Content)-1]
 		cacheFlag := anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
 		switch {
 		case content.OfText != nil:
-			content.OfText.CacheControl = cacheFlag
+			block := *content.OfText
+			block.CacheControl = cacheFlag
+			content.OfText = &block
 		case content.OfToolResult != nil:
-			content.OfToolResult.CacheControl = cacheFlag
+			block := *content.OfToolResult
+			block.CacheControl = cacheFlag
+			content.OfToolResult = &block
 		default:
 			return fmt.Errorf("unknown user message content type %T", content)
 		}
+		msg.Content = slices.Clone(msg.Content)
+		msg.Content[len(msg.Content)-1] = content
+		messages[n] = msg
 
 		numCached++
 		if numCached >= 2 {
@@ -1,5 +1,78 @@
 package llm
 
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/bedrock"
+	anthropic_option "github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
 type BedrockProvider struct {
 	*AnthropicProvider
 }
+
+// BedrockConfig configures the AWS access of the Bedrock provider. Unset
+// fields fall back to the default AWS configuration (environment, shared
+// config files, instance role).
+type BedrockConfig struct {
+	Region  string
+	Profile string
+	// RoleARN is assumed with STS using the base credentials (optional).
+	RoleARN string
+	// RoleSessionName defaults to "bitrise-ai-core".
+	RoleSessionName string
+	// InferenceProfile is the ID or ARN of a (cross-region) inference profile,
+	// used instead of the model name (optional).
+	InferenceProfile string
+}
+
+func newBedrockClient(ctx context.Context, c BedrockConfig, p clientParams) (anthropic.Client, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if p.HTTPClient != nil {
+		loadOpts = append(loadOpts, config.WithHTTPClient(p.HTTPClient))
+	}
+	if c.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(c.Region))
+	}
+	if c.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(c.Profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return anthropic.Client{}, fmt.Errorf("load aws config: %w", err)
+	}
+
+	if c.RoleARN != "" {
+		sessionName := c.RoleSessionName
+		if sessionName == "" {
+			sessionName = "bitrise-ai-core"
+		}
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), c.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	apiKey := p.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("BEDROCK_API_KEY")
+	}
+	opts := []anthropic_option.RequestOption{
+		bedrock.WithConfig(cfg),
+		anthropic_option.WithAPIKey(apiKey),
+	}
+	if p.HTTPClient != nil {
+		opts = append(opts, anthropic_option.WithHTTPClient(p.HTTPClient))
+	}
+	if p.BaseURL != "" {
+		opts = append(opts, anthropic_option.WithBaseURL(p.BaseURL))
+	}
+	return anthropic.NewClient(opts...), nil
+}
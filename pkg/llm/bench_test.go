@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// benchHistoryLen is the size of the history converted by the benchmarks,
+// large to measure the costs growing with the conversation.
+const benchHistoryLen = 1000
+
+// BenchmarkConvertHistory measures the conversion of the history to the
+// format of each provider, done before every request.
+func BenchmarkConvertHistory(b *testing.B) {
+	history := newBenchHistory(benchHistoryLen)
+	for _, provider := range []ProviderName{ProviderAnthropic, ProviderOpenAI, ProviderGemini} {
+		b.Run(string(provider), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := ExportMessages(history, provider); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// newBenchHistory returns a valid history of n messages (rounded up to whole
+// exchanges) alternating tool calls and results, like a long agent run.
+func newBenchHistory(n int) []Message {
+	history := []Message{NewUserMessage(TextContent{Text: "Review the changes."})}
+	result := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20)
+	for i := 0; len(history) < n; i++ {
+		id := fmt.Sprintf("call_%d", i)
+		history = append(history,
+			Message{Role: RoleAssistant, Parts: []ContentPart{
+				TextContent{Text: "Let me look at the next file."},
+				ToolCall{ID: id, Name: "Echo", Input: json.RawMessage(fmt.Sprintf(`{"text":"file_%d.go"}`, i))},
+			}},
+			NewUserMessage(ToolResult{ToolName: "Echo", ToolCallID: id, Content: result}),
+		)
+	}
+	return history
+}
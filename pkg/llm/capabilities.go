@@ -0,0 +1,97 @@
+package llm
+
+import "strings"
+
+// Capabilities describes what a provider and its model support, so generic
+// code can adapt instead of failing at runtime.
+type Capabilities struct {
+	// Tools is true if the model can call tools.
+	Tools bool
+	// ParallelToolCalls is true if the model can call several tools in a
+	// turn and the provider accepts NewMessageParams.DisableParallelToolUse.
+	ParallelToolCalls bool
+	// PromptCaching is true if the provider caches prompt prefixes.
+	PromptCaching bool
+	// Vision is true if the model accepts images.
+	Vision bool
+	// StructuredOutput is true if the provider can constrain the response to
+	// a JSON schema natively.
+	StructuredOutput bool
+	// MaxContextTokens is the size of the context window, 0 if unknown.
+	MaxContextTokens int
+}
+
+// CapabilityReporter is implemented by the providers which report their
+// capabilities. It's separate from Provider so existing implementations of
+// Provider keep compiling.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// DefaultCapabilities are assumed for providers which don't report their
+// capabilities: everything is supported, the context size is unknown.
+var DefaultCapabilities = Capabilities{
+	Tools:             true,
+	ParallelToolCalls: true,
+	PromptCaching:     true,
+	Vision:            true,
+	StructuredOutput:  true,
+}
+
+// CapabilitiesOf returns the capabilities of the provider.
+func CapabilitiesOf(p Provider) Capabilities {
+	if r, ok := p.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	return DefaultCapabilities
+}
+
+// Capabilities of the Claude models, also used by Bedrock.
+func (ap *AnthropicProvider) Capabilities() Capabilities {
+	return Capabilities{
+		Tools:             true,
+		ParallelToolCalls: true,
+		PromptCaching:     true,
+		Vision:            true,
+		MaxContextTokens:  200_000,
+	}
+}
+
+func (oaip *OpenAIProvider) Capabilities() Capabilities {
+	c := Capabilities{
+		Tools:             true,
+		ParallelToolCalls: true,
+		PromptCaching:     true,
+		Vision:            true,
+		StructuredOutput:  true,
+		MaxContextTokens:  128_000,
+	}
+	model := oaip.Model
+	switch {
+	case strings.HasPrefix(model, "o1-mini"), strings.HasPrefix(model, "o1-preview"):
+		c = Capabilities{PromptCaching: true, MaxContextTokens: 128_000}
+	case isGPT5Model(model):
+		c.MaxContextTokens = 400_000
+	case isReasoningModel(model):
+		// The o-series models reject the parallel_tool_calls parameter.
+		c.ParallelToolCalls = false
+		c.MaxContextTokens = 200_000
+	case strings.HasPrefix(model, "gpt-4.1"):
+		c.MaxContextTokens = 1_047_576
+	case strings.HasPrefix(model, "gpt-3.5"):
+		c.Vision = false
+		c.MaxContextTokens = 16_385
+	}
+	return c
+}
+
+func (gp *GeminiProvider) Capabilities() Capabilities {
+	return Capabilities{
+		Tools:             true,
+		ParallelToolCalls: true,
+		PromptCaching:     true,
+		Vision:            true,
+		StructuredOutput:  true,
+		MaxContextTokens:  1_048_576,
+	}
+}
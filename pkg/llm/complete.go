@@ -0,0 +1,36 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Complete sends a single prompt to the model and returns the text of the
+// response with the token usage, without the tool loop of an agent. The
+// requests are retried by the provider.
+func Complete(ctx context.Context, model Model, system, prompt string) (string, TokenUsage, error) {
+	provider, err := model.NewProvider(ctx)
+	if err != nil {
+		return "", TokenUsage{}, fmt.Errorf("new provider: %w", err)
+	}
+	return CompleteWith(ctx, provider, system, prompt)
+}
+
+// CompleteWith is Complete with an existing provider, e.g. to reuse its
+// clients.
+func CompleteWith(ctx context.Context, provider Provider, system, prompt string) (string, TokenUsage, error) {
+	msg, err := provider.NewMessage(ctx, NewMessageParams{
+		SystemPrompt: system,
+		History:      []Message{NewUserMessage(TextContent{Text: prompt})},
+		Logger:       slog.New(slog.DiscardHandler),
+	})
+	if err != nil {
+		return "", TokenUsage{}, fmt.Errorf("new message: %w", err)
+	}
+	text := History{msg}.FinalText()
+	if text == "" {
+		return "", msg.Usage, fmt.Errorf("empty response")
+	}
+	return text, msg.Usage, nil
+}
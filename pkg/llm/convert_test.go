@@ -0,0 +1,139 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// conversionHistories are histories whose conversion must be deterministic:
+// the requests of the turns share the prefix cached by the providers.
+var conversionHistories = map[string]func() []Message{
+	"maps in tool call args": func() []Message {
+		return []Message{
+			NewUserMessage(TextContent{Text: "Review the changes."}),
+			{Role: RoleAssistant, Parts: []ContentPart{
+				TextContent{Text: "Let me look."},
+				ToolCall{ID: "call_1", Name: "search", Input: json.RawMessage(
+					`{"query":"main","filters":{"z":1,"a":{"y":[1,2],"b":null},"m":"x"},"limit":12345678901234567890,"ratio":0.10}`,
+				)},
+			}},
+			NewUserMessage(ToolResult{ToolCallID: "call_1", ToolName: "search", Content: "main.go"}),
+		}
+	},
+	"parallel tool results": func() []Message {
+		var calls, results []ContentPart
+		for i := range 5 {
+			id := fmt.Sprintf("call_%d", i)
+			calls = append(calls, ToolCall{ID: id, Name: "read", Input: json.RawMessage(fmt.Sprintf(`{"path":"file_%d.go","opts":{"b":1,"a":2}}`, i))})
+			results = append(results, ToolResult{ToolCallID: id, ToolName: "read", Content: fmt.Sprintf("content %d", i), IsError: i == 3})
+		}
+		return []Message{
+			NewUserMessage(TextContent{Text: "Read the files."}),
+			{Role: RoleAssistant, Parts: calls},
+			NewUserMessage(results...),
+			{Role: RoleAssistant, Parts: []ContentPart{TextContent{Text: "Done reading."}}},
+			NewUserMessage(SystemReminder{Text: "Return the final result."}),
+		}
+	},
+	"developer messages": func() []Message {
+		return []Message{
+			NewUserMessage(TextContent{Text: "Start."}),
+			{Role: RoleAssistant, Parts: []ContentPart{ToolCall{ID: "call_1", Name: "list", Input: json.RawMessage(`{}`)}}},
+			NewUserMessage(ToolResult{ToolCallID: "call_1", ToolName: "list", Content: ""}),
+			NewDeveloperMessage("The timebox is almost over."),
+		}
+	},
+}
+
+// TestConversionDeterministic sends the same history twice to a provider
+// (converted again or from its cache) and once to a new provider, the
+// request bodies must be the same.
+func TestConversionDeterministic(t *testing.T) {
+	for _, provider := range []ProviderName{ProviderAnthropic, ProviderOpenAI, ProviderGemini} {
+		for name, history := range conversionHistories {
+			t.Run(string(provider)+"/"+name, func(t *testing.T) {
+				server := newStubServer(t, provider)
+				first, second := server.newProvider(t), server.newProvider(t)
+				params := func() NewMessageParams {
+					return NewMessageParams{
+						SystemPrompt:    "You are a test assistant.",
+						History:         history(),
+						ToolDefinitions: []ToolDefinition{stubTool("search"), stubTool("read"), stubTool("list")},
+						EnableCaching:   true,
+					}
+				}
+				sent := params()
+				for _, p := range []struct {
+					provider Provider
+					params   NewMessageParams
+				}{
+					{first, sent},
+					{first, sent},
+					{first, params()},
+					{second, params()},
+				} {
+					if _, err := p.provider.NewMessage(context.Background(), p.params); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				requests := server.requests()
+				if len(requests) != 4 {
+					t.Fatalf("%d requests, want 4", len(requests))
+				}
+				for i, body := range requests[1:] {
+					if !bytes.Equal(body, requests[0]) {
+						t.Errorf("request %d differs from the first one:\n%s\n%s", i+1, body, requests[0])
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestConversionMutatedHistory modifies the parts of a sent history in
+// place, the providers must convert them again instead of reusing their
+// cache.
+func TestConversionMutatedHistory(t *testing.T) {
+	for _, provider := range []ProviderName{ProviderAnthropic, ProviderOpenAI, ProviderGemini} {
+		t.Run(string(provider), func(t *testing.T) {
+			server := newStubServer(t, provider)
+			p := server.newProvider(t)
+			history := conversionHistories["parallel tool results"]()
+			send := func() {
+				t.Helper()
+				_, err := p.NewMessage(context.Background(), NewMessageParams{
+					SystemPrompt:    "You are a test assistant.",
+					History:         history,
+					ToolDefinitions: []ToolDefinition{stubTool("search"), stubTool("read"), stubTool("list")},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			send()
+			history[0].Parts[0] = TextContent{Text: "Replaced prompt."}
+			result := history[2].Parts[0].(ToolResult)
+			result.Content = "replaced-result"
+			history[2].Parts[0] = result
+			send()
+			call := history[1].Parts[0].(ToolCall)
+			copy(call.Input[len(`{"path":"`):], "zzzz")
+			send()
+
+			requests := server.requests()
+			for _, want := range []string{"Replaced prompt.", "replaced-result"} {
+				if !bytes.Contains(requests[1], []byte(want)) {
+					t.Errorf("the second request has no %q:\n%s", want, requests[1])
+				}
+			}
+			if !bytes.Contains(requests[2], []byte("zzzz_0.go")) {
+				t.Errorf("the third request has no modified tool input:\n%s", requests[2])
+			}
+		})
+	}
+}
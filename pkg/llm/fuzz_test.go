@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+var toolInputSeeds = []string{
+	`{"path":"main.go","line":12}`,
+	`{"id":12345678901234567890,"ratio":0.1,"exp":1e400}`,
+	`{"nested":{"list":[1,"two",null,{"three":3}]},"empty":{}}`,
+	`{"a":1,"a":2}`,
+	`{"unicode":"é\ud800"}`,
+	`  {"padded":true}  `,
+	`{"truncated":`,
+	`[1,2]`,
+	`"string"`,
+	`null`,
+	``,
+}
+
+// FuzzGeminiArgs checks the conversion of the tool call inputs to the
+// arguments of the Gemini function calls: any input recorded from a model
+// must convert, and the arguments must be stable, so the replayed history
+// (and its cached prefix) doesn't change.
+func FuzzGeminiArgs(f *testing.F) {
+	for _, seed := range toolInputSeeds {
+		f.Add([]byte(seed))
+	}
+	gp := &GeminiProvider{}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		history := []Message{
+			NewUserMessage(TextContent{Text: "Call the tool."}),
+			{Role: RoleAssistant, Parts: []ContentPart{ToolCall{ID: "1", Name: "tool", Input: input}}},
+			NewUserMessage(ToolResult{ToolCallID: "1", ToolName: "tool", Content: "ok"}),
+		}
+		contents, err := gp.convertMessages(history)
+		if err != nil {
+			t.Fatalf("convert input %q: %v", input, err)
+		}
+		args := contents[1].Parts[0].FunctionCall.Args
+		if args == nil {
+			t.Fatalf("input %q: nil args", input)
+		}
+		first, err := json.Marshal(args)
+		if err != nil {
+			t.Fatalf("marshal the args of %q: %v", input, err)
+		}
+		again, err := unmarshalArgs(first)
+		if err != nil {
+			t.Fatalf("unmarshal the args %q of %q: %v", first, input, err)
+		}
+		second, err := json.Marshal(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("input %q: args not stable: %s != %s", input, first, second)
+		}
+	})
+}
+
+// FuzzAnthropicToolInput checks that any tool call input converts to a
+// valid Anthropic request.
+func FuzzAnthropicToolInput(f *testing.F) {
+	for _, seed := range toolInputSeeds {
+		f.Add([]byte(seed))
+	}
+	ap := &AnthropicProvider{}
+	logger := slog.New(slog.DiscardHandler)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		history := []Message{
+			NewUserMessage(TextContent{Text: "Call the tool."}),
+			{Role: RoleAssistant, Parts: []ContentPart{ToolCall{ID: "1", Name: "tool", Input: input}}},
+			NewUserMessage(ToolResult{ToolCallID: "1", ToolName: "tool", Content: "ok"}),
+		}
+		messages, err := ap.convertMessages(history, logger)
+		if err != nil {
+			t.Fatalf("convert input %q: %v", input, err)
+		}
+		b, err := json.Marshal(messages)
+		if err != nil {
+			t.Fatalf("marshal the request of %q: %v", input, err)
+		}
+		if !json.Valid(b) {
+			t.Errorf("input %q: invalid request %s", input, b)
+		}
+	})
+}
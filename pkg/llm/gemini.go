@@ -1,13 +1,16 @@
 package llm
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"time"
+	"net/http"
+	"slices"
 
+	"cloud.google.com/go/auth/credentials"
+	"cloud.google.com/go/auth/httptransport"
 	backoff "github.com/cenkalti/backoff/v4"
-	"github.com/google/uuid"
 	"google.golang.org/genai"
 )
 
@@ -15,6 +18,112 @@ type GeminiProvider struct {
 	Client          *genai.Client
 	Model           string
 	MaxOutputTokens int
+	Generation      *GeminiGenerationConfig
+	// GoogleSearch enables the grounding with Google Search (optional).
+	GoogleSearch bool
+
+	tools   toolCache[*genai.Tool]
+	history historyCache[*genai.Content]
+}
+
+// GeminiGenerationConfig holds the Gemini specific generation settings.
+type GeminiGenerationConfig struct {
+	// ThinkingConfig controls the thinking budget of the model, e.g. a zero
+	// ThinkingBudget disables thinking (optional).
+	ThinkingConfig *genai.ThinkingConfig
+	// SafetySettings override the default safety thresholds (optional), see
+	// GeminiSafetyBlockNone.
+	SafetySettings []*genai.SafetySetting
+	// CandidateCount is the number of generated candidates, only the first
+	// one is used (optional).
+	CandidateCount int32
+}
+
+// GeminiSafetyBlockNone returns safety settings which don't block any
+// content, for inputs like CI logs which trigger false positives.
+func GeminiSafetyBlockNone() []*genai.SafetySetting {
+	var settings []*genai.SafetySetting
+	for _, category := range []genai.HarmCategory{
+		genai.HarmCategoryHarassment,
+		genai.HarmCategoryHateSpeech,
+		genai.HarmCategorySexuallyExplicit,
+		genai.HarmCategoryDangerousContent,
+	} {
+		settings = append(settings, &genai.SafetySetting{
+			Category:  category,
+			Threshold: genai.HarmBlockThresholdBlockNone,
+		})
+	}
+	return settings
+}
+
+type GeminiBackend string
+
+const (
+	GeminiBackendAPI      GeminiBackend = "gemini_api"
+	GeminiBackendVertexAI GeminiBackend = "vertex_ai"
+)
+
+// GeminiConfig configures the Gemini client explicitly. Unset fields fall
+// back to the genai defaults, which are read from the environment.
+type GeminiConfig struct {
+	Backend GeminiBackend
+	// Project and Location are used by the Vertex AI backend.
+	Project  string
+	Location string
+	// CredentialsFile is the path of a service account (or other Google
+	// credentials) JSON file, used by the Vertex AI backend (optional).
+	// Backend must be set explicitly to Vertex AI when a custom HTTP client
+	// is used, so the client gets authorized.
+	CredentialsFile string
+}
+
+func newGeminiClient(ctx context.Context, c GeminiConfig, p clientParams) (*genai.Client, error) {
+	clientConfig := &genai.ClientConfig{
+		Project:     c.Project,
+		Location:    c.Location,
+		APIKey:      p.APIKey,
+		HTTPOptions: genai.HTTPOptions{BaseURL: p.BaseURL},
+	}
+	switch c.Backend {
+	case "":
+	case GeminiBackendAPI:
+		clientConfig.Backend = genai.BackendGeminiAPI
+	case GeminiBackendVertexAI:
+		clientConfig.Backend = genai.BackendVertexAI
+	default:
+		return nil, fmt.Errorf("unknown gemini backend %q", c.Backend)
+	}
+	if c.CredentialsFile != "" {
+		creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+			Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+			CredentialsFile: c.CredentialsFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("load credentials file: %w", err)
+		}
+		clientConfig.Credentials = creds
+	}
+	if p.HTTPClient != nil {
+		// A copy, as the authorization middleware modifies the transport.
+		client := *p.HTTPClient
+		clientConfig.HTTPClient = &client
+		// genai only authorizes its own HTTP client for Vertex AI.
+		if c.Backend == GeminiBackendVertexAI && p.APIKey == "" {
+			if clientConfig.Credentials == nil {
+				if err := clientConfig.UseDefaultCredentials(); err != nil {
+					return nil, fmt.Errorf("use default credentials: %w", err)
+				}
+			} else if err := httptransport.AddAuthorizationMiddleware(clientConfig.HTTPClient, clientConfig.Credentials); err != nil {
+				return nil, fmt.Errorf("add authorization middleware: %w", err)
+			}
+		}
+	}
+	client, err := genai.NewClient(ctx, clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("new genai client: %w", err)
+	}
+	return client, nil
 }
 
 func (gp *GeminiProvider) NewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
@@ -22,31 +131,59 @@ func (gp *GeminiProvider) NewMessage(ctx context.Context, params NewMessageParam
 	fn := func() (Message, error) {
 		return gp.tryNewMessage(ctx, params)
 	}
-	opts := backoff.WithContext(backoff.NewExponentialBackOff(
-		backoff.WithMaxElapsedTime(30*time.Second), // Retry for up to 30 seconds
-	), ctx)
-	notify := func(err error, d time.Duration) {
-		params.Logger.Warn("retrying tryNewMessage", "delay", d, "error", err)
-	}
-	message, err := backoff.RetryNotifyWithData(fn, opts, notify)
-	if err != nil {
-		return Message{}, fmt.Errorf("new message with retries: %w", err)
-	}
-	return message, nil
+	return retryNewMessage(ctx, params, fn)
 }
 
 func (gp *GeminiProvider) tryNewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
+	var systemParts []*genai.Part
+	for _, block := range params.SystemBlocks() {
+		systemParts = append(systemParts, &genai.Part{Text: block.Text})
+	}
+	tools, err := gp.tools.get(params.ToolDefinitions, gp.convertTools)
+	if err != nil {
+		return Message{}, backoff.Permanent(fmt.Errorf("convert tools: %w", err))
+	}
+	if gp.GoogleSearch {
+		tools = append(slices.Clone(tools), &genai.Tool{GoogleSearch: &genai.GoogleSearch{}})
+	}
 	config := &genai.GenerateContentConfig{
 		SystemInstruction: &genai.Content{
-			Parts: []*genai.Part{{
-				Text: params.SystemPrompt,
-			}},
+			Parts: systemParts,
 		},
 		MaxOutputTokens: int32(gp.MaxOutputTokens),
-		Tools:           gp.convertTools(params.ToolDefinitions),
+		Tools:           tools,
+	}
+	if g := gp.Generation; g != nil {
+		config.ThinkingConfig = g.ThinkingConfig
+		config.SafetySettings = g.SafetySettings
+		config.CandidateCount = g.CandidateCount
+	}
+	if params.Seed != nil {
+		config.Seed = genai.Ptr(int32(*params.Seed))
+	}
+	if params.ResponseSchema != nil {
+		schema, err := NormalizeSchema(params.ResponseSchema, ProviderGemini)
+		if err != nil {
+			return Message{}, backoff.Permanent(fmt.Errorf("response schema: %w", err))
+		}
+		config.ResponseMIMEType = "application/json"
+		config.ResponseJsonSchema = schema
+	}
+	if params.ForceTool != "" {
+		config.ToolConfig = &genai.ToolConfig{
+			FunctionCallingConfig: &genai.FunctionCallingConfig{
+				Mode:                 genai.FunctionCallingConfigModeAny,
+				AllowedFunctionNames: []string{params.ForceTool},
+			},
+		}
+	}
+	if params.RunID != "" {
+		config.HTTPOptions = &genai.HTTPOptions{
+			Headers: http.Header{RunIDHeader: []string{params.RunID}},
+		}
 	}
 
-	allMessages, err := gp.convertMessages(params.History)
+	allMessages, err := gp.history.get(params.History, gp.convertMessages)
 	if err != nil {
 		return Message{}, fmt.Errorf("convert messages: %w", err)
 	}
@@ -86,23 +223,31 @@ func (gp *GeminiProvider) tryNewMessage(ctx context.Context, params NewMessagePa
 		}
 	}
 	resultMessage := Message{
-		Role:  RoleAssistant,
-		Usage: tokenUsage,
+		Role:        RoleAssistant,
+		Usage:       tokenUsage,
+		Fingerprint: result.ModelVersion,
 	}
 
-	for _, part := range result.Candidates[0].Content.Parts {
+	candidate := result.Candidates[0]
+	if search := geminiSearchResults(candidate.GroundingMetadata); search != nil {
+		resultMessage.Parts = append(resultMessage.Parts, *search)
+	}
+	for i, part := range candidate.Content.Parts {
 		switch {
 		case part.Text != "":
-			v := TextContent{Text: part.Text}
+			v := TextContent{Text: part.Text, Citations: geminiCitations(candidate.GroundingMetadata, i)}
 			resultMessage.Parts = append(resultMessage.Parts, v)
 		case part.FunctionCall != nil:
-			args, err := json.Marshal(part.FunctionCall.Args)
-			if err != nil {
-				return Message{}, fmt.Errorf("marshal function args: %w", err)
+			args := json.RawMessage("{}")
+			if part.FunctionCall.Args != nil {
+				args, err = json.Marshal(part.FunctionCall.Args)
+				if err != nil {
+					return Message{}, fmt.Errorf("marshal function args: %w", err)
+				}
 			}
 			v := ToolCall{
 				// For some reason the ID field is not set in the response.
-				ID:    "call_" + uuid.New().String(),
+				ID:    NewToolCallID(),
 				Name:  part.FunctionCall.Name,
 				Input: args,
 			}
@@ -124,6 +269,8 @@ func (gp *GeminiProvider) convertMessages(messages []Message) ([]*genai.Content,
 				switch v := part.(type) {
 				case TextContent:
 					gParts = append(gParts, &genai.Part{Text: v.Text})
+				case SystemReminder:
+					gParts = append(gParts, &genai.Part{Text: v.TaggedText()})
 				case ToolResult:
 					response := map[string]any{}
 					if v.IsError {
@@ -146,6 +293,8 @@ func (gp *GeminiProvider) convertMessages(messages []Message) ([]*genai.Content,
 							Response: response,
 						},
 					})
+				case customPart:
+					// Not sent to the providers.
 				default:
 					return nil, fmt.Errorf("unknown user message part type %T", v)
 				}
@@ -157,6 +306,16 @@ func (gp *GeminiProvider) convertMessages(messages []Message) ([]*genai.Content,
 				})
 			}
 
+		case RoleDeveloper:
+			text, err := developerText(msg)
+			if err != nil {
+				return nil, err
+			}
+			gMessages = append(gMessages, &genai.Content{
+				Parts: []*genai.Part{{Text: text}},
+				Role:  "user",
+			})
+
 		case RoleAssistant:
 			var gParts []*genai.Part
 			for _, part := range msg.Parts {
@@ -164,8 +323,8 @@ func (gp *GeminiProvider) convertMessages(messages []Message) ([]*genai.Content,
 				case TextContent:
 					gParts = append(gParts, &genai.Part{Text: v.Text})
 				case ToolCall:
-					args := map[string]any{}
-					if err := json.Unmarshal(v.Input, &args); err != nil {
+					args, err := unmarshalArgs(v.Input)
+					if err != nil {
 						return nil, fmt.Errorf("unmarshal tool call args: %w", err)
 					}
 					gParts = append(gParts, &genai.Part{
@@ -175,6 +334,10 @@ func (gp *GeminiProvider) convertMessages(messages []Message) ([]*genai.Content,
 							Args: args,
 						},
 					})
+				case SearchResults:
+					// Only recorded, the results are in the text.
+				case customPart:
+					// Not sent to the providers.
 				default:
 					return nil, fmt.Errorf("unknown assistant message part type %T", v)
 				}
@@ -191,16 +354,66 @@ func (gp *GeminiProvider) convertMessages(messages []Message) ([]*genai.Content,
 	return gMessages, nil
 }
 
-func (gp *GeminiProvider) convertTools(tools []ToolDefinition) []*genai.Tool {
+func (gp *GeminiProvider) convertTools(tools []ToolDefinition) ([]*genai.Tool, error) {
 	gTool := &genai.Tool{}
 
 	for _, tool := range tools {
+		schema, err := NormalizeSchema(tool.Schema, ProviderGemini)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
 		v := &genai.FunctionDeclaration{
 			Description:          tool.Description,
 			Name:                 tool.Name,
-			ParametersJsonSchema: tool.Schema,
+			ParametersJsonSchema: schema,
 		}
 		gTool.FunctionDeclarations = append(gTool.FunctionDeclarations, v)
 	}
-	return []*genai.Tool{gTool}
+	return []*genai.Tool{gTool}, nil
+}
+
+// geminiCitations returns the grounding sources of a part of the response.
+func geminiCitations(metadata *genai.GroundingMetadata, partIndex int) []Citation {
+	if metadata == nil {
+		return nil
+	}
+	var citations []Citation
+	for _, support := range metadata.GroundingSupports {
+		if support == nil || support.Segment == nil || int(support.Segment.PartIndex) != partIndex {
+			continue
+		}
+		for _, index := range support.GroundingChunkIndices {
+			if int(index) >= len(metadata.GroundingChunks) || metadata.GroundingChunks[index] == nil {
+				continue
+			}
+			citation := Citation{
+				CitedText: support.Segment.Text,
+				Location:  "segment",
+				Start:     int(support.Segment.StartIndex),
+				End:       int(support.Segment.EndIndex),
+			}
+			switch chunk := metadata.GroundingChunks[index]; {
+			case chunk.Web != nil:
+				citation.Title, citation.URL = chunk.Web.Title, chunk.Web.URI
+			case chunk.RetrievedContext != nil:
+				citation.Title, citation.URL = chunk.RetrievedContext.Title, chunk.RetrievedContext.URI
+			}
+			citations = append(citations, citation)
+		}
+	}
+	return citations
+}
+
+// unmarshalArgs decodes the input of a tool call keeping the numbers as
+// they were sent by the model. Note that the genai SDK re-encodes the
+// request through float64, large integers are still rounded on the wire, but
+// the same way in every request, so the cached prefix doesn't change.
+func unmarshalArgs(input json.RawMessage) (map[string]any, error) {
+	args := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(objectInput(input)))
+	dec.UseNumber()
+	if err := dec.Decode(&args); err != nil {
+		return nil, err
+	}
+	return args, nil
 }
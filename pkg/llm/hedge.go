@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HedgedProvider sends each request to Primary and, if it hasn't responded
+// after Delay, the same request to Secondary too, returning the first
+// successful response and cancelling the other request. It trades cost for
+// a lower tail latency, e.g. for interactive agents: the usage of a
+// cancelled request is billed by the provider but not counted.
+//
+// A failed request doesn't wait for the Delay, the other provider is asked
+// immediately.
+type HedgedProvider struct {
+	Primary   Provider
+	Secondary Provider
+	Delay     time.Duration
+}
+
+type hedgeResult struct {
+	msg       Message
+	err       error
+	secondary bool
+}
+
+func (h *HedgedProvider) NewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // cancels the slower request
+
+	results := make(chan hedgeResult, 2)
+	send := func(p Provider, secondary bool) {
+		msg, err := p.NewMessage(ctx, params)
+		results <- hedgeResult{msg: msg, err: err, secondary: secondary}
+	}
+	go send(h.Primary, false)
+
+	timer := time.NewTimer(h.Delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	hedge := func() {
+		if !hedged {
+			hedged = true
+			pending++
+			go send(h.Secondary, true)
+		}
+	}
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if params.Logger != nil {
+				params.Logger.Debug("hedging the request", "delay", h.Delay)
+			}
+			hedge()
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.secondary && params.Logger != nil {
+					params.Logger.Info("hedged request answered first")
+				}
+				return r.msg, nil
+			}
+			if r.secondary {
+				errs = append(errs, fmt.Errorf("secondary: %w", r.err))
+			} else {
+				errs = append(errs, fmt.Errorf("primary: %w", r.err))
+			}
+			if ctx.Err() == nil {
+				hedge()
+			}
+		}
+	}
+	return Message{}, errors.Join(errs...)
+}
+
+// Capabilities are the ones supported by both providers, either may answer.
+func (h *HedgedProvider) Capabilities() Capabilities {
+	primary, secondary := CapabilitiesOf(h.Primary), CapabilitiesOf(h.Secondary)
+	return Capabilities{
+		Tools:             primary.Tools && secondary.Tools,
+		ParallelToolCalls: primary.ParallelToolCalls && secondary.ParallelToolCalls,
+		PromptCaching:     primary.PromptCaching && secondary.PromptCaching,
+		Vision:            primary.Vision && secondary.Vision,
+		StructuredOutput:  primary.StructuredOutput && secondary.StructuredOutput,
+		MaxContextTokens:  min(primary.MaxContextTokens, secondary.MaxContextTokens),
+	}
+}
@@ -0,0 +1,242 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HistoryError is a violation of the invariants providers expect from the
+// message history.
+type HistoryError struct {
+	// Index is the index of the offending message.
+	Index  int
+	Reason string
+}
+
+func (e *HistoryError) Error() string {
+	return fmt.Sprintf("message %d: %s", e.Index, e.Reason)
+}
+
+// ValidateHistory checks the history before it is sent to a provider: it
+// must start and end with a user (or developer) message, have no empty or consecutive
+// assistant messages, and every tool call must have exactly one result in the
+// next message. All violations are returned, joined.
+func ValidateHistory(messages []Message) error {
+	if len(messages) == 0 {
+		return &HistoryError{Index: 0, Reason: "empty history"}
+	}
+	var errs []error
+	fail := func(i int, format string, args ...any) {
+		errs = append(errs, &HistoryError{Index: i, Reason: fmt.Sprintf(format, args...)})
+	}
+	if !messages[0].Role.IsUserTurn() {
+		fail(0, "the first message must be a user message, got %s", messages[0].Role)
+	}
+	if last := len(messages) - 1; !messages[last].Role.IsUserTurn() {
+		fail(last, "the last message must be a user message, got %s", messages[last].Role)
+	}
+	seenCalls := map[string]bool{}
+	for i, msg := range messages {
+		if len(msg.Parts) == 0 {
+			fail(i, "%s message has no content", msg.Role)
+			continue
+		}
+		switch msg.Role {
+		case RoleAssistant:
+			if isBlankMessage(msg) {
+				fail(i, "assistant message has no content")
+			}
+			if i > 0 && messages[i-1].Role == RoleAssistant {
+				fail(i, "consecutive assistant messages")
+			}
+			var next Message
+			if i+1 < len(messages) {
+				next = messages[i+1]
+			}
+			results := toolResultIDs(next)
+			for _, call := range toolCalls(msg) {
+				if !ValidToolCallID(call.ID) {
+					fail(i, "tool call ID %q is not accepted by every provider", call.ID)
+				}
+				if seenCalls[call.ID] {
+					fail(i, "duplicate tool call ID %q", call.ID)
+				}
+				seenCalls[call.ID] = true
+				if !results[call.ID] {
+					fail(i, "tool call %q (%s) has no result in the next message", call.ID, call.Name)
+				}
+			}
+		case RoleUser:
+			var calls map[string]bool
+			if i > 0 && messages[i-1].Role == RoleAssistant {
+				calls = toolCallIDs(messages[i-1])
+			}
+			seenResults := map[string]bool{}
+			for _, part := range msg.Parts {
+				res, ok := part.(ToolResult)
+				if !ok {
+					continue
+				}
+				if !calls[res.ToolCallID] {
+					fail(i, "tool result %q (%s) has no matching tool call in the previous message", res.ToolCallID, res.ToolName)
+				}
+				if seenResults[res.ToolCallID] {
+					fail(i, "duplicate tool result %q", res.ToolCallID)
+				}
+				seenResults[res.ToolCallID] = true
+			}
+		case RoleDeveloper:
+			for _, part := range msg.Parts {
+				if _, ok := part.(ToolResult); ok {
+					fail(i, "developer message contains a tool result")
+				}
+			}
+		default:
+			fail(i, "unknown role %q", msg.Role)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RepairHistory fixes the violations ValidateHistory reports, where possible:
+// empty and leading assistant messages are dropped, consecutive assistant
+// messages are merged, tool calls without a result get an error result and
+// orphaned or duplicate tool results are dropped, invalid and duplicate tool
+// call IDs are replaced (see NormalizeToolCallIDs). It returns the repaired
+// copy of the history and the description of the repairs. A history ending
+// with an assistant text message is left as is.
+func RepairHistory(messages []Message) ([]Message, []string) {
+	var repairs []string
+	messages, renamed := NormalizeToolCallIDs(messages)
+	for from, to := range renamed {
+		repairs = append(repairs, fmt.Sprintf("replaced tool call ID %q with %q", from, to))
+	}
+	sort.Strings(repairs)
+	repaired := make([]Message, 0, len(messages))
+	for i, msg := range messages {
+		switch {
+		case len(msg.Parts) == 0 || (msg.Role == RoleAssistant && isBlankMessage(msg)):
+			repairs = append(repairs, fmt.Sprintf("message %d: dropped empty %s message", i, msg.Role))
+		case msg.Role == RoleAssistant && len(repaired) == 0:
+			repairs = append(repairs, fmt.Sprintf("message %d: dropped leading assistant message", i))
+		case msg.Role == RoleAssistant && repaired[len(repaired)-1].Role == RoleAssistant:
+			prev := &repaired[len(repaired)-1]
+			prev.Parts = append(append([]ContentPart{}, prev.Parts...), msg.Parts...)
+			prev.Usage = prev.Usage.Add(msg.Usage)
+			repairs = append(repairs, fmt.Sprintf("message %d: merged consecutive assistant messages", i))
+		default:
+			repaired = append(repaired, msg)
+		}
+	}
+
+	for i := 0; i < len(repaired); i++ {
+		msg := repaired[i]
+		if msg.Role != RoleUser {
+			continue
+		}
+		var calls map[string]bool
+		if i > 0 && repaired[i-1].Role == RoleAssistant {
+			calls = toolCallIDs(repaired[i-1])
+		}
+		var parts []ContentPart
+		seen := map[string]bool{}
+		for _, part := range msg.Parts {
+			if res, ok := part.(ToolResult); ok {
+				if !calls[res.ToolCallID] || seen[res.ToolCallID] {
+					repairs = append(repairs, fmt.Sprintf("dropped orphaned tool result %q", res.ToolCallID))
+					continue
+				}
+				seen[res.ToolCallID] = true
+			}
+			parts = append(parts, part)
+		}
+		if len(parts) == 0 {
+			repaired = append(repaired[:i], repaired[i+1:]...)
+			i--
+			continue
+		}
+		repaired[i].Parts = parts
+	}
+
+	for i := 0; i < len(repaired); i++ {
+		msg := repaired[i]
+		if msg.Role != RoleAssistant {
+			continue
+		}
+		var results map[string]bool
+		if i+1 < len(repaired) {
+			results = toolResultIDs(repaired[i+1])
+		}
+		var missing []ContentPart
+		for _, call := range toolCalls(msg) {
+			if !results[call.ID] {
+				missing = append(missing, ToolResult{
+					ToolName:   call.Name,
+					ToolCallID: call.ID,
+					Content:    "The tool call was interrupted, it has no result.",
+					IsError:    true,
+				})
+				repairs = append(repairs, fmt.Sprintf("added missing result of tool call %q", call.ID))
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if i+1 < len(repaired) && repaired[i+1].Role == RoleUser {
+			// Tool results must come first in the user message.
+			repaired[i+1].Parts = append(missing, repaired[i+1].Parts...)
+		} else {
+			repaired = append(repaired[:i+1], append([]Message{NewUserMessage(missing...)}, repaired[i+1:]...)...)
+		}
+	}
+	return repaired, repairs
+}
+
+func isBlankMessage(msg Message) bool {
+	for _, part := range msg.Parts {
+		switch v := part.(type) {
+		case TextContent:
+			if strings.TrimSpace(v.Text) != "" {
+				return false
+			}
+		case SearchResults, customPart:
+			// Not sent to the providers.
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func toolCalls(msg Message) []ToolCall {
+	var calls []ToolCall
+	for _, part := range msg.Parts {
+		if call, ok := part.(ToolCall); ok {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func toolCallIDs(msg Message) map[string]bool {
+	ids := map[string]bool{}
+	for _, call := range toolCalls(msg) {
+		ids[call.ID] = true
+	}
+	return ids
+}
+
+func toolResultIDs(msg Message) map[string]bool {
+	ids := map[string]bool{}
+	if msg.Role != RoleUser {
+		return ids
+	}
+	for _, part := range msg.Parts {
+		if res, ok := part.(ToolResult); ok {
+			ids[res.ToolCallID] = true
+		}
+	}
+	return ids
+}
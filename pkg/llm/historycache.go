@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// historyCache keeps the messages converted by a provider, so only the
+// messages appended since the previous request are converted, instead of
+// the whole history on every turn. Messages are matched by a hash of their
+// role and parts, so a message modified since the previous request (e.g. a
+// part replaced in place) is converted again, along with the rest of the
+// history after it.
+//
+// The converted messages are shared between requests, providers must copy
+// them before modifying them (e.g. to set cache breakpoints).
+type historyCache[T any] struct {
+	mu        sync.Mutex
+	hashes    []uint64
+	converted [][]T
+}
+
+// get converts the messages one by one, reusing the conversions of the
+// longest common prefix with the previous request.
+func (c *historyCache[T]) get(messages []Message, convert func([]Message) ([]T, error)) ([]T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hashes := make([]uint64, len(messages))
+	for i, msg := range messages {
+		hashes[i] = hashMessage(msg)
+	}
+	n := 0
+	for n < len(messages) && n < len(c.hashes) && hashes[n] == c.hashes[n] {
+		n++
+	}
+	converted := make([][]T, n, len(messages))
+	copy(converted, c.converted[:n])
+	for _, msg := range messages[n:] {
+		out, err := convert([]Message{msg})
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, out)
+	}
+	c.hashes = hashes
+	c.converted = converted
+
+	var size int
+	for _, out := range converted {
+		size += len(out)
+	}
+	all := make([]T, 0, size)
+	for _, out := range converted {
+		all = append(all, out...)
+	}
+	return all, nil
+}
+
+// hashMessage hashes the content of the message sent to the providers, the
+// usage and the metadata are left out.
+func hashMessage(msg Message) uint64 {
+	h := fnv.New64a()
+	writeField(h, string(msg.Role))
+	for _, part := range msg.Parts {
+		switch v := part.(type) {
+		case TextContent:
+			writeField(h, "text")
+			writeField(h, v.Text)
+			writeField(h, fmt.Sprintf("%#v", v.Citations))
+		case SystemReminder:
+			writeField(h, "reminder")
+			writeField(h, v.Text)
+		case ToolCall:
+			writeField(h, "call")
+			writeField(h, v.ID)
+			writeField(h, v.Name)
+			writeField(h, string(v.Input))
+		case ToolResult:
+			writeField(h, "result")
+			writeField(h, v.ToolCallID)
+			writeField(h, v.ToolName)
+			writeField(h, v.Content)
+			writeField(h, strconv.FormatBool(v.IsError))
+		default:
+			writeField(h, fmt.Sprintf("%T %#v", v, v))
+		}
+	}
+	return h.Sum64()
+}
+
+// writeField writes the string prefixed with its length, so the boundaries
+// of the fields are part of the hash.
+func writeField(h hash.Hash64, s string) {
+	h.Write(strconv.AppendInt(nil, int64(len(s)), 10))
+	h.Write([]byte{':'})
+	h.Write([]byte(s))
+}
@@ -0,0 +1,147 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// History is a message history with helpers to inspect it, e.g. to log or
+// display the activity of an agent:
+//
+//	for _, use := range llm.History(meta.Messages).ToolUses() { ... }
+type History []Message
+
+// charsPerToken is the ratio used to estimate the tokens of a text.
+const charsPerToken = 4
+
+// Chars returns the size of the contents of the history: texts, tool inputs
+// and tool results.
+func (h History) Chars() int {
+	var n int
+	for _, msg := range h {
+		for _, part := range msg.Parts {
+			switch v := part.(type) {
+			case TextContent:
+				n += len(v.Text)
+			case SystemReminder:
+				n += len(v.Text)
+			case ToolCall:
+				n += len(v.Input)
+			case ToolResult:
+				n += len(v.Content)
+			}
+		}
+	}
+	return n
+}
+
+// EstimateTokens returns a rough estimate of the tokens of the history, e.g.
+// to decide when to compact it. The Usage of the messages has the actual
+// counts of the provider.
+func (h History) EstimateTokens() int {
+	return (h.Chars() + charsPerToken - 1) / charsPerToken
+}
+
+// Usage returns the total usage of the messages.
+func (h History) Usage() TokenUsage {
+	var usage TokenUsage
+	for _, msg := range h {
+		usage = usage.Add(msg.Usage)
+	}
+	return usage
+}
+
+// ToolUse is a tool call and its result.
+type ToolUse struct {
+	Call ToolCall
+	// Result is nil if the call has no result (yet).
+	Result *ToolResult
+}
+
+// ToolUses returns the tool calls of the history in order, with their
+// results.
+func (h History) ToolUses() []ToolUse {
+	results := map[string]ToolResult{}
+	for _, msg := range h {
+		for _, part := range msg.Parts {
+			if res, ok := part.(ToolResult); ok {
+				results[res.ToolCallID] = res
+			}
+		}
+	}
+	var uses []ToolUse
+	for _, msg := range h {
+		for _, call := range toolCalls(msg) {
+			use := ToolUse{Call: call}
+			if res, ok := results[call.ID]; ok {
+				use.Result = &res
+			}
+			uses = append(uses, use)
+		}
+	}
+	return uses
+}
+
+// FinalText returns the text of the last assistant message with text, e.g.
+// the answer of a chat, or "" if there is none.
+func (h History) FinalText() string {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Role != RoleAssistant {
+			continue
+		}
+		var texts []string
+		for _, part := range h[i].Parts {
+			if text, ok := part.(TextContent); ok && strings.TrimSpace(text.Text) != "" {
+				texts = append(texts, text.Text)
+			}
+		}
+		if len(texts) > 0 {
+			return strings.Join(texts, "\n")
+		}
+	}
+	return ""
+}
+
+// Markdown renders the history with a section per message.
+func (h History) Markdown() string {
+	var sb strings.Builder
+	for i, msg := range h {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "### %s\n", msg.Role)
+		for _, part := range msg.Parts {
+			switch v := part.(type) {
+			case TextContent:
+				fmt.Fprintf(&sb, "\n%s\n", v.Text)
+				if len(v.Citations) > 0 {
+					sb.WriteString("\nSources:\n")
+					for _, c := range v.Citations {
+						fmt.Fprintf(&sb, "- %s\n", c.source())
+					}
+				}
+			case SystemReminder:
+				fmt.Fprintf(&sb, "\n> **System reminder**: %s\n", v.Text)
+			case ToolCall:
+				fmt.Fprintf(&sb, "\n**Tool call** `%s`:\n\n```json\n%s\n```\n", v.Name, v.Input)
+			case ToolResult:
+				status := "result"
+				if v.IsError {
+					status = "error"
+				}
+				fmt.Fprintf(&sb, "\n**Tool %s** `%s`:\n\n```\n%s\n```\n", status, v.ToolName, v.Content)
+			case SearchResults:
+				fmt.Fprintf(&sb, "\n**Web search** %q:\n", strings.Join(v.Queries, `", "`))
+				if v.Error != "" {
+					fmt.Fprintf(&sb, "\nFailed: %s\n", v.Error)
+				}
+				for _, r := range v.Results {
+					fmt.Fprintf(&sb, "- [%s](%s)\n", r.Title, r.URL)
+				}
+			case customPart:
+				fmt.Fprintf(&sb, "\n**%T**: `%+v`\n", v, v)
+			}
+		}
+	}
+	return sb.String()
+}
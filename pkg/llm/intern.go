@@ -0,0 +1,35 @@
+package llm
+
+import "unique"
+
+// internMinBytes is the size from which contents are interned, smaller ones
+// aren't worth the lookup.
+const internMinBytes = 1024
+
+// Intern returns a canonical copy of a large content (e.g. a tool result),
+// so identical contents kept by many agents, e.g. the same file read by
+// every agent of a fan-out, share their memory. Small contents are returned
+// as is.
+func Intern(s string) string {
+	if len(s) < internMinBytes {
+		return s
+	}
+	return unique.Make(s).Value()
+}
+
+// InternMessages interns the tool results and texts of the messages in
+// place, e.g. after loading a session.
+func InternMessages(messages []Message) {
+	for _, msg := range messages {
+		for i, part := range msg.Parts {
+			switch v := part.(type) {
+			case ToolResult:
+				v.Content = Intern(v.Content)
+				msg.Parts[i] = v
+			case TextContent:
+				v.Text = Intern(v.Text)
+				msg.Parts[i] = v
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package llmtest is a conformance suite for llm.Provider implementations.
+// It checks the behavior the agents rely on (tool call round-trips, parallel
+// tool calls, empty content, usage accounting), so new providers and SDK
+// upgrades don't silently break the agents. The checks send real requests,
+// run them from a test of the provider with its credentials:
+//
+//	func TestConformance(t *testing.T) {
+//		llmtest.Run(t, llmtest.Params{Provider: provider})
+//	}
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/invopop/jsonschema"
+)
+
+// DefaultTimeout limits each check if Params.Timeout is not set.
+const DefaultTimeout = 2 * time.Minute
+
+// The names of the checks, see Params.Skip.
+const (
+	CheckText              = "Text"
+	CheckToolCallRoundTrip = "ToolCallRoundTrip"
+	CheckParallelToolCalls = "ParallelToolCalls"
+	CheckEmptyContent      = "EmptyContent"
+	CheckUsage             = "Usage"
+	CheckStructuredOutput  = "StructuredOutput"
+)
+
+type Params struct {
+	// Provider is the provider under test (mandatory).
+	Provider llm.Provider
+	// Skip lists the checks to skip, e.g. CheckParallelToolCalls for a
+	// model which reliably calls one tool at a time. The checks of the
+	// capabilities the provider doesn't report are skipped anyway, see
+	// llm.CapabilitiesOf.
+	Skip []string
+	// Timeout limits each check, defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Logger receives the logs of the provider (optional).
+	Logger *slog.Logger
+}
+
+// Run runs the checks as subtests of t.
+func Run(t *testing.T, p Params) {
+	t.Helper()
+	if p.Provider == nil {
+		t.Fatal("llmtest: Provider is required")
+	}
+	s := &suite{params: p, capabilities: llm.CapabilitiesOf(p.Provider)}
+	checks := []struct {
+		name string
+		fn   func(t *testing.T, ctx context.Context)
+		// supported reports whether the provider has the capabilities of
+		// the check.
+		supported bool
+	}{
+		{CheckText, s.text, true},
+		{CheckToolCallRoundTrip, s.toolCallRoundTrip, s.capabilities.Tools},
+		{CheckParallelToolCalls, s.parallelToolCalls, s.capabilities.Tools && s.capabilities.ParallelToolCalls},
+		{CheckEmptyContent, s.emptyContent, s.capabilities.Tools},
+		{CheckUsage, s.usage, true},
+		{CheckStructuredOutput, s.structuredOutput, s.capabilities.StructuredOutput},
+	}
+	for _, c := range checks {
+		t.Run(c.name, func(t *testing.T) {
+			switch {
+			case slices.Contains(p.Skip, c.name):
+				t.Skip("skipped by Params.Skip")
+			case !c.supported:
+				t.Skip("not supported by the provider")
+			}
+			timeout := p.Timeout
+			if timeout <= 0 {
+				timeout = DefaultTimeout
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			c.fn(t, ctx)
+		})
+	}
+}
+
+type suite struct {
+	params       Params
+	capabilities llm.Capabilities
+}
+
+const system = "You are a test assistant. Follow the instructions exactly and keep your answers short."
+
+var weatherTool = llm.ToolDefinition{
+	Name:        "get_weather",
+	Description: "Returns the current weather of a city.",
+	Schema: mustSchema(`{
+		"type": "object",
+		"properties": {"city": {"type": "string", "description": "Name of the city"}},
+		"required": ["city"],
+		"additionalProperties": false
+	}`),
+}
+
+func mustSchema(s string) *jsonschema.Schema {
+	var schema jsonschema.Schema
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		panic(err)
+	}
+	return &schema
+}
+
+// send sends the history and checks the invariants of every response.
+func (s *suite) send(t *testing.T, ctx context.Context, p llm.NewMessageParams) llm.Message {
+	t.Helper()
+	if p.SystemPrompt == "" {
+		p.SystemPrompt = system
+	}
+	p.Logger = s.params.Logger
+	if p.Logger == nil {
+		p.Logger = slog.New(slog.DiscardHandler)
+	}
+	if err := llm.ValidateHistory(p.History); err != nil {
+		t.Fatalf("invalid history of the check: %v", err)
+	}
+	msg, err := s.params.Provider.NewMessage(ctx, p)
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	if msg.Role != llm.RoleAssistant {
+		t.Errorf("role of the response is %q, want %q", msg.Role, llm.RoleAssistant)
+	}
+	for _, call := range toolCalls(msg) {
+		if !llm.ValidToolCallID(call.ID) {
+			t.Errorf("tool call ID %q is not accepted by every provider", call.ID)
+		}
+		var input map[string]any
+		if err := json.Unmarshal(call.Input, &input); err != nil {
+			t.Errorf("input of tool call %q is not a JSON object: %s", call.ID, call.Input)
+		}
+	}
+	return msg
+}
+
+func (s *suite) text(t *testing.T, ctx context.Context) {
+	msg := s.send(t, ctx, llm.NewMessageParams{
+		History: []llm.Message{llm.NewUserMessage(llm.TextContent{Text: "Reply with the word PONG and nothing else."})},
+	})
+	text := llm.History{msg}.FinalText()
+	if !strings.Contains(strings.ToUpper(text), "PONG") {
+		t.Errorf("response text is %q, want PONG", text)
+	}
+}
+
+func (s *suite) toolCallRoundTrip(t *testing.T, ctx context.Context) {
+	history := []llm.Message{llm.NewUserMessage(llm.TextContent{Text: "What's the weather in Budapest? Use the tool."})}
+	msg := s.send(t, ctx, llm.NewMessageParams{
+		History:         history,
+		ToolDefinitions: []llm.ToolDefinition{weatherTool},
+		ForceTool:       weatherTool.Name,
+	})
+	calls := toolCalls(msg)
+	if len(calls) == 0 {
+		t.Fatalf("no tool call in the response with a forced tool: %+v", msg.Parts)
+	}
+	call := calls[0]
+	if call.Name != weatherTool.Name {
+		t.Errorf("tool call name is %q, want %q", call.Name, weatherTool.Name)
+	}
+	if !strings.Contains(strings.ToLower(string(call.Input)), "budapest") {
+		t.Errorf("tool call input is %s, want the city", call.Input)
+	}
+
+	// The results of every call must be accepted, followed by a text answer.
+	var results []llm.ContentPart
+	for _, c := range calls {
+		results = append(results, llm.ToolResult{ToolCallID: c.ID, ToolName: c.Name, Content: "Sunny, 23 °C"})
+	}
+	history = append(history, msg, llm.NewUserMessage(results...))
+	answer := s.send(t, ctx, llm.NewMessageParams{
+		History:         history,
+		ToolDefinitions: []llm.ToolDefinition{weatherTool},
+	})
+	text := llm.History{answer}.FinalText()
+	if !strings.Contains(text, "23") {
+		t.Errorf("answer is %q, want the result of the tool", text)
+	}
+}
+
+func (s *suite) parallelToolCalls(t *testing.T, ctx context.Context) {
+	history := []llm.Message{llm.NewUserMessage(llm.TextContent{
+		Text: "What's the weather in Budapest and in Lisbon? Call the tool for both cities at once, in this turn.",
+	})}
+	msg := s.send(t, ctx, llm.NewMessageParams{
+		History:         history,
+		ToolDefinitions: []llm.ToolDefinition{weatherTool},
+	})
+	calls := toolCalls(msg)
+	if len(calls) < 2 {
+		t.Fatalf("got %d tool calls, want one per city", len(calls))
+	}
+	ids := map[string]bool{}
+	var results []llm.ContentPart
+	for _, c := range calls {
+		if ids[c.ID] {
+			t.Errorf("duplicate tool call ID %q", c.ID)
+		}
+		ids[c.ID] = true
+		results = append(results, llm.ToolResult{ToolCallID: c.ID, ToolName: c.Name, Content: "Rainy, 12 °C"})
+	}
+	history = append(history, msg, llm.NewUserMessage(results...))
+	s.send(t, ctx, llm.NewMessageParams{
+		History:         history,
+		ToolDefinitions: []llm.ToolDefinition{weatherTool},
+	})
+
+	// Disabling parallel tool use must be accepted.
+	msg = s.send(t, ctx, llm.NewMessageParams{
+		History:                history[:1],
+		ToolDefinitions:        []llm.ToolDefinition{weatherTool},
+		DisableParallelToolUse: true,
+	})
+	if n := len(toolCalls(msg)); n > 1 {
+		t.Errorf("got %d tool calls with parallel tool use disabled", n)
+	}
+}
+
+// emptyContent checks that empty tool results and tool calls without
+// arguments, both common in real histories, are accepted.
+func (s *suite) emptyContent(t *testing.T, ctx context.Context) {
+	history := []llm.Message{
+		llm.NewUserMessage(llm.TextContent{Text: "Check the weather, then tell me whether it worked."}),
+		{Role: llm.RoleAssistant, Parts: []llm.ContentPart{
+			llm.ToolCall{ID: llm.NewToolCallID(), Name: weatherTool.Name, Input: json.RawMessage(`{}`)},
+		}},
+	}
+	history = append(history, llm.NewUserMessage(llm.ToolResult{
+		ToolCallID: history[1].Parts[0].(llm.ToolCall).ID,
+		ToolName:   weatherTool.Name,
+		Content:    "",
+	}))
+	msg := s.send(t, ctx, llm.NewMessageParams{
+		History:         history,
+		ToolDefinitions: []llm.ToolDefinition{weatherTool},
+	})
+	if len(msg.Parts) == 0 {
+		t.Errorf("empty response")
+	}
+}
+
+func (s *suite) usage(t *testing.T, ctx context.Context) {
+	msg := s.send(t, ctx, llm.NewMessageParams{
+		History:       []llm.Message{llm.NewUserMessage(llm.TextContent{Text: "Count from 1 to 5."})},
+		EnableCaching: true,
+	})
+	u := msg.Usage
+	if u.InputTokens+u.CacheCreationTokens+u.CacheReadTokens <= 0 {
+		t.Errorf("no input tokens reported: %+v", u)
+	}
+	if u.OutputTokens <= 0 {
+		t.Errorf("no output tokens reported: %+v", u)
+	}
+	if u.Total() != u.InputTokens+u.OutputTokens+u.CacheCreationTokens+u.CacheReadTokens {
+		t.Errorf("total %d doesn't add up: %+v", u.Total(), u)
+	}
+}
+
+func (s *suite) structuredOutput(t *testing.T, ctx context.Context) {
+	msg := s.send(t, ctx, llm.NewMessageParams{
+		History: []llm.Message{llm.NewUserMessage(llm.TextContent{Text: "Return the capital of Hungary."})},
+		ResponseSchema: mustSchema(`{
+			"type": "object",
+			"properties": {"capital": {"type": "string"}},
+			"required": ["capital"],
+			"additionalProperties": false
+		}`),
+		ResponseName: "answer",
+	})
+	var answer struct {
+		Capital string `json:"capital"`
+	}
+	text := llm.History{msg}.FinalText()
+	if err := json.Unmarshal([]byte(text), &answer); err != nil {
+		t.Fatalf("response %q doesn't match the schema: %v", text, err)
+	}
+	if !strings.Contains(answer.Capital, "Budapest") {
+		t.Errorf("capital is %q, want Budapest", answer.Capital)
+	}
+}
+
+func toolCalls(msg llm.Message) []llm.ToolCall {
+	var calls []llm.ToolCall
+	for _, part := range msg.Parts {
+		if call, ok := part.(llm.ToolCall); ok {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
@@ -0,0 +1,283 @@
+package llmtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// TestConformance runs the checks against the providers talking to a stub
+// model, which answers the prompts of the checks in the wire format of each
+// provider. It tests the suite and the conversions of the providers, without
+// credentials.
+func TestConformance(t *testing.T) {
+	for _, provider := range []llm.ProviderName{llm.ProviderAnthropic, llm.ProviderOpenAI, llm.ProviderGemini} {
+		t.Run(string(provider), func(t *testing.T) {
+			server := httptest.NewServer(stubModel(t, provider))
+			defer server.Close()
+			m := llm.Model{
+				Provider: provider,
+				Name:     "stub-model",
+				APIKey:   "stub-key",
+				BaseURL:  server.URL,
+				Gemini:   &llm.GeminiConfig{Backend: llm.GeminiBackendAPI},
+			}
+			p, err := m.NewProvider(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var skip []string
+			if provider == llm.ProviderGemini {
+				// Gemini has no parameter to disable the parallel tool
+				// calls, see llm.NewMessageParams.DisableParallelToolUse.
+				skip = append(skip, CheckParallelToolCalls)
+			}
+			Run(t, Params{Provider: p, Skip: skip})
+		})
+	}
+}
+
+// stubRequest is what the stub model understands of a request.
+type stubRequest struct {
+	// text is the text of the last user message, results are the tool
+	// results following the last tool calls.
+	text    string
+	results []string
+	tools   bool
+	// single is set if parallel tool use is disabled.
+	single bool
+	schema bool
+}
+
+type stubCall struct {
+	id   string
+	name string
+	args map[string]any
+}
+
+// answer responds to the prompts of the checks.
+func (r stubRequest) answer() (string, []stubCall) {
+	switch {
+	case len(r.results) > 0:
+		return "The weather: " + strings.Join(r.results, ", "), nil
+	case r.schema:
+		return `{"capital":"Budapest"}`, nil
+	case r.tools && strings.Contains(r.text, "weather"):
+		var calls []stubCall
+		for _, city := range []string{"Budapest", "Lisbon"} {
+			if strings.Contains(r.text, city) && !(r.single && len(calls) > 0) {
+				calls = append(calls, stubCall{
+					id:   fmt.Sprintf("call_%d", len(calls)+1),
+					name: "get_weather",
+					args: map[string]any{"city": city},
+				})
+			}
+		}
+		return "", calls
+	case strings.Contains(r.text, "PONG"):
+		return "PONG", nil
+	}
+	return "1, 2, 3, 4, 5", nil
+}
+
+func stubModel(t *testing.T, provider llm.ProviderName) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var response any
+		switch provider {
+		case llm.ProviderAnthropic:
+			response, err = anthropicStub(body)
+		case llm.ProviderOpenAI:
+			response, err = openAIStub(body)
+		case llm.ProviderGemini:
+			response, err = geminiStub(body)
+		}
+		if err != nil {
+			t.Errorf("stub %s: %v: %s", provider, err, body)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func anthropicStub(body []byte) (any, error) {
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type    string          `json:"type"`
+				Text    string          `json:"text"`
+				Content json.RawMessage `json:"content"`
+			} `json:"content"`
+		} `json:"messages"`
+		Tools      []json.RawMessage `json:"tools"`
+		ToolChoice struct {
+			DisableParallelToolUse bool `json:"disable_parallel_tool_use"`
+		} `json:"tool_choice"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Messages) == 0 {
+		return nil, fmt.Errorf("no messages")
+	}
+	s := stubRequest{tools: len(req.Tools) > 0, single: req.ToolChoice.DisableParallelToolUse}
+	for _, block := range req.Messages[len(req.Messages)-1].Content {
+		switch block.Type {
+		case "text":
+			s.text = block.Text
+		case "tool_result":
+			s.results = append(s.results, resultText(block.Content))
+		}
+	}
+
+	text, calls := s.answer()
+	var content []any
+	if text != "" {
+		content = append(content, map[string]any{"type": "text", "text": text})
+	}
+	for _, c := range calls {
+		content = append(content, map[string]any{"type": "tool_use", "id": "toolu_" + c.id, "name": c.name, "input": c.args})
+	}
+	stopReason := "end_turn"
+	if len(calls) > 0 {
+		stopReason = "tool_use"
+	}
+	return map[string]any{
+		"id": "msg_stub", "type": "message", "role": "assistant", "model": "stub-model",
+		"content": content, "stop_reason": stopReason,
+		"usage": map[string]any{"input_tokens": 20, "output_tokens": 5},
+	}, nil
+}
+
+// resultText returns the text of an Anthropic tool result, a string or text
+// blocks.
+func resultText(content json.RawMessage) string {
+	var s string
+	if json.Unmarshal(content, &s) == nil {
+		return s
+	}
+	var blocks []struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(content, &blocks)
+	var texts []string
+	for _, b := range blocks {
+		texts = append(texts, b.Text)
+	}
+	return strings.Join(texts, "")
+}
+
+func openAIStub(body []byte) (any, error) {
+	var req struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+		Tools             []json.RawMessage `json:"tools"`
+		ParallelToolCalls *bool             `json:"parallel_tool_calls"`
+		ResponseFormat    *struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	s := stubRequest{
+		tools:  len(req.Tools) > 0,
+		single: req.ParallelToolCalls != nil && !*req.ParallelToolCalls,
+		schema: req.ResponseFormat != nil && req.ResponseFormat.Type == "json_schema",
+	}
+	// The tool results are the tool messages at the end.
+	for i := len(req.Messages) - 1; i >= 0 && req.Messages[i].Role == "tool"; i-- {
+		s.results = append(s.results, resultText(req.Messages[i].Content))
+	}
+	for _, msg := range req.Messages {
+		if msg.Role == "user" {
+			s.text = resultText(msg.Content)
+		}
+	}
+
+	text, calls := s.answer()
+	message := map[string]any{"role": "assistant", "content": text}
+	finishReason := "stop"
+	if len(calls) > 0 {
+		var toolCalls []any
+		for _, c := range calls {
+			args, _ := json.Marshal(c.args)
+			toolCalls = append(toolCalls, map[string]any{
+				"id": c.id, "type": "function",
+				"function": map[string]any{"name": c.name, "arguments": string(args)},
+			})
+		}
+		message = map[string]any{"role": "assistant", "content": nil, "tool_calls": toolCalls}
+		finishReason = "tool_calls"
+	}
+	return map[string]any{
+		"id": "chatcmpl-stub", "object": "chat.completion", "created": 0, "model": "stub-model",
+		"choices": []any{map[string]any{"index": 0, "finish_reason": finishReason, "message": message}},
+		"usage":   map[string]any{"prompt_tokens": 20, "completion_tokens": 5, "total_tokens": 25},
+	}, nil
+}
+
+func geminiStub(body []byte) (any, error) {
+	var req struct {
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text             string `json:"text"`
+				FunctionResponse *struct {
+					Response map[string]any `json:"response"`
+				} `json:"functionResponse"`
+			} `json:"parts"`
+		} `json:"contents"`
+		Tools            []json.RawMessage `json:"tools"`
+		GenerationConfig struct {
+			ResponseMIMEType string `json:"responseMimeType"`
+		} `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Contents) == 0 {
+		return nil, fmt.Errorf("no contents")
+	}
+	s := stubRequest{tools: len(req.Tools) > 0, schema: req.GenerationConfig.ResponseMIMEType == "application/json"}
+	for _, part := range req.Contents[len(req.Contents)-1].Parts {
+		switch {
+		case part.FunctionResponse != nil:
+			s.results = append(s.results, fmt.Sprint(part.FunctionResponse.Response["output"]))
+		default:
+			s.text = part.Text
+		}
+	}
+
+	text, calls := s.answer()
+	var parts []any
+	if text != "" {
+		parts = append(parts, map[string]any{"text": text})
+	}
+	for _, c := range calls {
+		parts = append(parts, map[string]any{"functionCall": map[string]any{"name": c.name, "args": c.args}})
+	}
+	return map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": parts},
+			"finishReason": "STOP",
+		}},
+		"usageMetadata": map[string]any{"promptTokenCount": 20, "candidatesTokenCount": 5, "totalTokenCount": 25},
+	}, nil
+}
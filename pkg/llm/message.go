@@ -3,31 +3,147 @@ package llm
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"strings"
 )
 
 type Message struct {
 	Role  MessageRole
 	Parts []ContentPart
 	Usage TokenUsage
+	// Fingerprint identifies the backend configuration which generated an
+	// assistant message, for reproducibility: the OpenAI system fingerprint,
+	// the Gemini model version or the Anthropic model.
+	Fingerprint string
+	// Metadata annotates the message for debugging and transcript analysis,
+	// e.g. the turn and the run which added it (see the Metadata keys). It
+	// is persisted in the sessions, but never sent to the providers.
+	Metadata map[string]string
+}
+
+// The keys of Message.Metadata set by the agent.
+const (
+	// MetadataTurn is the number of the turn which added the message,
+	// messages added between turns (e.g. the prompt) get the number of the
+	// next turn.
+	MetadataTurn = "turn"
+	// MetadataRunID is the ID of the run which added the message, it
+	// distinguishes the runs of a resumed session.
+	MetadataRunID = "run_id"
+	// MetadataCreatedAt is the time the message was added, in RFC 3339
+	// format.
+	MetadataCreatedAt = "created_at"
+	// MetadataTools are the comma separated names of the tools whose results
+	// are in the message.
+	MetadataTools = "tools"
+)
+
+// WithMetadata returns a copy of the message with the metadata set, the
+// metadata of the original message is not modified.
+func (m Message) WithMetadata(key, value string) Message {
+	metadata := maps.Clone(m.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[key] = value
+	m.Metadata = metadata
+	return m
 }
 
 func NewUserMessage(parts ...ContentPart) Message {
 	return Message{Role: RoleUser, Parts: parts}
 }
 
+// NewDeveloperMessage creates an instruction of the orchestration, as opposed
+// to the input of the end user.
+func NewDeveloperMessage(text string) Message {
+	return Message{Role: RoleDeveloper, Parts: []ContentPart{TextContent{Text: text}}}
+}
+
 type MessageRole string
 
 const (
 	RoleAssistant MessageRole = "assistant"
 	RoleUser      MessageRole = "user"
+	// RoleDeveloper messages are sent as developer messages to OpenAI and as
+	// user messages wrapped in <system-reminder> tags to the other providers.
+	RoleDeveloper MessageRole = "developer"
 )
 
+// IsUserTurn reports whether the messages of the role are on the user side of
+// the conversation (user and developer messages).
+func (r MessageRole) IsUserTurn() bool {
+	return r == RoleUser || r == RoleDeveloper
+}
+
 type ContentPart interface {
 	isPart()
 }
 
+// CustomPart is embedded by the content parts defined by applications, e.g.
+// images or citations of their own, to implement ContentPart:
+//
+//	type Image struct {
+//		llm.CustomPart
+//		URL string
+//	}
+//
+// Custom parts are recorded in the history but not sent to the providers,
+// add a TextContent alongside to show them to the model. Register them with
+// core.RegisterSessionPart to persist them in sessions.
+type CustomPart struct{}
+
+func (CustomPart) isPart()       {}
+func (CustomPart) isCustomPart() {}
+
+// customPart is implemented by the types embedding CustomPart.
+type customPart interface {
+	isCustomPart()
+}
+
 type TextContent struct {
 	Text string
+	// Citations attribute parts of the text to their sources, populated
+	// from the providers returning them (Anthropic citations, Gemini
+	// grounding).
+	Citations []Citation
+}
+
+// Citation attributes a part of a text to a source, e.g. a document or a web
+// page.
+type Citation struct {
+	// CitedText is the cited part of the source (Anthropic) or the part of
+	// the text supported by the source (Gemini).
+	CitedText string
+	// Title and URL identify the source, URL is empty for the documents
+	// sent in the request.
+	Title string
+	URL   string
+	// DocumentIndex is the index of the cited document in the request,
+	// 0 if not applicable.
+	DocumentIndex int
+	// Location is the type of Start and End, e.g. "char_location" or
+	// "page_location" for Anthropic, "segment" for Gemini, where they are
+	// the byte offsets of the supported part of the text.
+	Location   string
+	Start, End int
+}
+
+// source describes the source of the citation in a line.
+func (c Citation) source() string {
+	name := c.Title
+	switch {
+	case c.URL != "" && name != "":
+		name = fmt.Sprintf("[%s](%s)", name, c.URL)
+	case c.URL != "":
+		name = c.URL
+	case name == "":
+		name = fmt.Sprintf("document %d", c.DocumentIndex)
+	}
+	if c.CitedText != "" {
+		return fmt.Sprintf("%s: %q", name, c.CitedText)
+	}
+	return name
 }
 
 func (tc TextContent) String() string {
@@ -36,6 +152,39 @@ func (tc TextContent) String() string {
 
 func (TextContent) isPart() {}
 
+// SystemReminder is an instruction of the orchestration injected into a
+// user message. Providers send it as a developer message where supported
+// (OpenAI), and as text wrapped in <system-reminder> tags otherwise.
+type SystemReminder struct {
+	Text string
+}
+
+func (SystemReminder) isPart() {}
+
+// TaggedText returns the reminder wrapped in <system-reminder> tags.
+func (sr SystemReminder) TaggedText() string {
+	return "<system-reminder>" + sr.Text + "</system-reminder>"
+}
+
+// developerText converts a developer message for the providers without
+// developer messages: the text is wrapped in <system-reminder> tags.
+func developerText(msg Message) (string, error) {
+	var texts []string
+	for _, part := range msg.Parts {
+		switch v := part.(type) {
+		case TextContent:
+			texts = append(texts, SystemReminder{Text: v.Text}.TaggedText())
+		case SystemReminder:
+			texts = append(texts, v.TaggedText())
+		case customPart:
+			// Not sent to the providers.
+		default:
+			return "", fmt.Errorf("unknown developer message part type %T", v)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
 type ToolCall struct {
 	ID    string
 	Name  string
@@ -67,6 +216,16 @@ func (ts TokenUsage) String() string {
 	)
 }
 
+// Add returns the sum of the usages.
+func (ts TokenUsage) Add(other TokenUsage) TokenUsage {
+	return TokenUsage{
+		InputTokens:         ts.InputTokens + other.InputTokens,
+		OutputTokens:        ts.OutputTokens + other.OutputTokens,
+		CacheCreationTokens: ts.CacheCreationTokens + other.CacheCreationTokens,
+		CacheReadTokens:     ts.CacheReadTokens + other.CacheReadTokens,
+	}
+}
+
 func (ts TokenUsage) Total() int64 {
 	return ts.InputTokens + ts.OutputTokens + ts.CacheCreationTokens + ts.CacheReadTokens
 }
@@ -3,13 +3,13 @@ package llm
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/anthropics/anthropic-sdk-go"
-	"github.com/anthropics/anthropic-sdk-go/bedrock"
 	anthropic_option "github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/openai/openai-go/v2"
-	"google.golang.org/genai"
+	openai_option "github.com/openai/openai-go/v2/option"
 )
 
 type ProviderName string
@@ -25,8 +25,48 @@ type Model struct {
 	Provider        ProviderName
 	Name            string
 	MaxOutputTokens int
+	// ReasoningEffort and Verbosity trade latency for quality on OpenAI
+	// reasoning models (o-series and gpt-5 family), ignored otherwise.
+	ReasoningEffort ReasoningEffort
+	Verbosity       Verbosity
+	// Bedrock configures the AWS access of the Bedrock provider (optional).
+	Bedrock *BedrockConfig
+	// Gemini configures the backend and credentials of the Gemini provider
+	// (optional).
+	Gemini *GeminiConfig
+	// GeminiGeneration configures thinking and safety settings of Gemini
+	// models (optional).
+	GeminiGeneration *GeminiGenerationConfig
+	// HTTPClient is used for the requests of the provider, e.g. to use a
+	// proxy, mTLS or to instrument the requests (optional).
+	HTTPClient *http.Client
+	// APIKey and BaseURL override the ones read from the environment by the
+	// provider SDKs (optional), e.g. to use per-customer keys.
+	APIKey  string
+	BaseURL string
+	// HostedSearch enables the web search run by the provider (optional,
+	// Anthropic and Gemini only), the results are recorded as SearchResults
+	// parts.
+	HostedSearch *HostedSearchConfig
 }
 
+type ReasoningEffort string
+
+const (
+	ReasoningEffortMinimal ReasoningEffort = "minimal"
+	ReasoningEffortLow     ReasoningEffort = "low"
+	ReasoningEffortMedium  ReasoningEffort = "medium"
+	ReasoningEffortHigh    ReasoningEffort = "high"
+)
+
+type Verbosity string
+
+const (
+	VerbosityLow    Verbosity = "low"
+	VerbosityMedium Verbosity = "medium"
+	VerbosityHigh   Verbosity = "high"
+)
+
 var defaultModels = map[ProviderName]Model{
 	ProviderAnthropic: {
 		Provider:        ProviderAnthropic,
@@ -51,39 +91,91 @@ var defaultModels = map[ProviderName]Model{
 func (m *Model) NewProvider(ctx context.Context) (Provider, error) {
 	switch m.Provider {
 	case ProviderAnthropic:
+		var opts []anthropic_option.RequestOption
+		if m.HTTPClient != nil {
+			opts = append(opts, anthropic_option.WithHTTPClient(m.HTTPClient))
+		}
+		if m.APIKey != "" {
+			opts = append(opts, anthropic_option.WithAPIKey(m.APIKey))
+		}
+		if m.BaseURL != "" {
+			opts = append(opts, anthropic_option.WithBaseURL(m.BaseURL))
+		}
 		return &AnthropicProvider{
-			Client:          anthropic.NewClient(),
+			Client:          anthropic.NewClient(opts...),
 			Model:           m.Name,
 			MaxOutputTokens: m.MaxOutputTokens,
+			WebSearch:       m.HostedSearch,
 		}, nil
 	case ProviderBedrock:
+		var bedrockConfig BedrockConfig
+		if m.Bedrock != nil {
+			bedrockConfig = *m.Bedrock
+		}
+		client, err := newBedrockClient(ctx, bedrockConfig, m.clientParams())
+		if err != nil {
+			return nil, fmt.Errorf("new bedrock client: %w", err)
+		}
+		modelID := m.Name
+		if bedrockConfig.InferenceProfile != "" {
+			modelID = bedrockConfig.InferenceProfile
+		}
 		return &BedrockProvider{
 			AnthropicProvider: &AnthropicProvider{
-				Client:          anthropic.NewClient(bedrock.WithLoadDefaultConfig(ctx), anthropic_option.WithAPIKey(os.Getenv("BEDROCK_API_KEY"))),
-				Model:           m.Name,
+				Client:          client,
+				Model:           modelID,
 				MaxOutputTokens: m.MaxOutputTokens,
 			},
 		}, nil
 	case ProviderOpenAI:
+		var opts []openai_option.RequestOption
+		if m.HTTPClient != nil {
+			opts = append(opts, openai_option.WithHTTPClient(m.HTTPClient))
+		}
+		if m.APIKey != "" {
+			opts = append(opts, openai_option.WithAPIKey(m.APIKey))
+		}
+		if m.BaseURL != "" {
+			opts = append(opts, openai_option.WithBaseURL(m.BaseURL))
+		}
 		return &OpenAIProvider{
-			Client:          openai.NewClient(),
+			Client:          openai.NewClient(opts...),
 			Model:           m.Name,
 			MaxOutputTokens: m.MaxOutputTokens,
+			ReasoningEffort: m.ReasoningEffort,
+			Verbosity:       m.Verbosity,
 		}, nil
 	case ProviderGemini:
-		client, err := genai.NewClient(ctx, &genai.ClientConfig{})
+		var geminiConfig GeminiConfig
+		if m.Gemini != nil {
+			geminiConfig = *m.Gemini
+		}
+		client, err := newGeminiClient(ctx, geminiConfig, m.clientParams())
 		if err != nil {
-			return nil, fmt.Errorf("new genai client: %w", err)
+			return nil, err
 		}
 		return &GeminiProvider{
 			Client:          client,
 			Model:           m.Name,
 			MaxOutputTokens: m.MaxOutputTokens,
+			Generation:      m.GeminiGeneration,
+			GoogleSearch:    m.HostedSearch != nil,
 		}, nil
 	}
 	return nil, fmt.Errorf("unknown provider %q", m.Provider)
 }
 
+// clientParams are the common client settings of the providers.
+type clientParams struct {
+	HTTPClient *http.Client
+	APIKey     string
+	BaseURL    string
+}
+
+func (m *Model) clientParams() clientParams {
+	return clientParams{HTTPClient: m.HTTPClient, APIKey: m.APIKey, BaseURL: m.BaseURL}
+}
+
 func (m *Model) SetDefaults() error {
 	if err := m.setDefaultProvider(); err != nil {
 		return fmt.Errorf("set default provider: %w", err)
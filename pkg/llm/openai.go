@@ -1,13 +1,15 @@
 package llm
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"time"
+	"strings"
 
 	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/openai/openai-go/v2"
+	openai_option "github.com/openai/openai-go/v2/option"
 	"github.com/openai/openai-go/v2/packages/param"
 )
 
@@ -20,6 +22,24 @@ type OpenAIProvider struct {
 	Client          openai.Client
 	Model           string
 	MaxOutputTokens int
+	// ReasoningEffort and Verbosity are only applied to the models which
+	// support them (o-series and gpt-5 family). Optional.
+	ReasoningEffort ReasoningEffort
+	Verbosity       Verbosity
+
+	tools   toolCache[openai.ChatCompletionToolUnionParam]
+	history historyCache[openai.ChatCompletionMessageParamUnion]
+}
+
+// isReasoningModel reports whether the model is an o-series or gpt-5 family
+// reasoning model.
+func isReasoningModel(model string) bool {
+	return isGPT5Model(model) ||
+		len(model) > 1 && model[0] == 'o' && model[1] >= '0' && model[1] <= '9'
+}
+
+func isGPT5Model(model string) bool {
+	return strings.HasPrefix(model, "gpt-5") && !strings.HasPrefix(model, "gpt-5-chat")
 }
 
 func (oaip *OpenAIProvider) NewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
@@ -29,25 +49,18 @@ func (oaip *OpenAIProvider) NewMessage(ctx context.Context, params NewMessagePar
 	fn := func() (Message, error) {
 		return oaip.tryNewMessage(ctx, params)
 	}
-	opts := backoff.WithContext(backoff.NewExponentialBackOff(
-		backoff.WithMaxElapsedTime(30*time.Second), // Retry for up to 30 seconds
-	), ctx)
-	notify := func(err error, d time.Duration) {
-		params.Logger.Warn("retrying tryNewMessage", "delay", d, "error", err)
-	}
-	message, err := backoff.RetryNotifyWithData(fn, opts, notify)
-	if err != nil {
-		return Message{}, fmt.Errorf("new message with retries: %w", err)
-	}
-	return message, nil
+	return retryNewMessage(ctx, params, fn)
 }
 
 func (oaip *OpenAIProvider) tryNewMessage(ctx context.Context, params NewMessageParams) (Message, error) {
-	tools := oaip.convertTools(params.ToolDefinitions)
+	tools, err := oaip.tools.get(params.ToolDefinitions, oaip.convertTools)
+	if err != nil {
+		return Message{}, backoff.Permanent(fmt.Errorf("convert tools: %w", err))
+	}
 	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(params.SystemPrompt),
+		openai.SystemMessage(params.SystemText()),
 	}
-	history, err := oaip.convertMessages(params.History)
+	history, err := oaip.history.get(params.History, oaip.convertMessages)
 	if err != nil {
 		return Message{}, fmt.Errorf("convert messages: %w", err)
 	}
@@ -63,14 +76,50 @@ func (oaip *OpenAIProvider) tryNewMessage(ctx context.Context, params NewMessage
 		Tools:               tools,
 		MaxCompletionTokens: maxTokens,
 	}
+	if params.DisableParallelToolUse && len(tools) > 0 && oaip.Capabilities().ParallelToolCalls {
+		completionParams.ParallelToolCalls = openai.Bool(false)
+	}
+	if params.Seed != nil {
+		completionParams.Seed = openai.Int(*params.Seed)
+	}
+	if params.ResponseSchema != nil {
+		schema, err := NormalizeSchema(params.ResponseSchema, ProviderOpenAI)
+		if err != nil {
+			return Message{}, backoff.Permanent(fmt.Errorf("response schema: %w", err))
+		}
+		completionParams.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+				JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   cmp.Or(params.ResponseName, "response"),
+					Schema: schema,
+				},
+			},
+		}
+	}
+	if params.ForceTool != "" {
+		completionParams.ToolChoice = openai.ToolChoiceOptionFunctionToolChoice(
+			openai.ChatCompletionNamedToolChoiceFunctionParam{Name: params.ForceTool},
+		)
+	}
 
 	if reasoningEffort, ok := reasoningEffortDefaults[oaip.Model]; ok {
 		completionParams.ReasoningEffort = reasoningEffort
 	}
+	if oaip.ReasoningEffort != "" && isReasoningModel(oaip.Model) {
+		completionParams.ReasoningEffort = openai.ReasoningEffort(oaip.ReasoningEffort)
+	}
+	if oaip.Verbosity != "" && isGPT5Model(oaip.Model) {
+		completionParams.Verbosity = openai.ChatCompletionNewParamsVerbosity(oaip.Verbosity)
+	}
+
+	var opts []openai_option.RequestOption
+	if params.RunID != "" {
+		opts = append(opts, openai_option.WithHeader(RunIDHeader, params.RunID))
+	}
 
 	// By default the client retries all transient errors 2 times.
 	// Can be overridden using option.WithMaxRetries.
-	completion, err := oaip.Client.Chat.Completions.New(ctx, completionParams)
+	completion, err := oaip.Client.Chat.Completions.New(ctx, completionParams, opts...)
 	if err != nil {
 		return Message{}, fmt.Errorf("new chat completion: %w", err)
 	}
@@ -82,7 +131,8 @@ func (oaip *OpenAIProvider) tryNewMessage(ctx context.Context, params NewMessage
 	cachedTokens := completion.Usage.PromptTokensDetails.CachedTokens
 	inputTokens := completion.Usage.PromptTokens - cachedTokens
 	resultMessage := Message{
-		Role: RoleAssistant,
+		Role:        RoleAssistant,
+		Fingerprint: completion.SystemFingerprint,
 		Usage: TokenUsage{
 			InputTokens:         inputTokens,
 			OutputTokens:        completion.Usage.CompletionTokens,
@@ -116,24 +166,44 @@ func (oaip *OpenAIProvider) convertMessages(messages []Message) ([]openai.ChatCo
 				case TextContent:
 					message := openai.UserMessage(v.Text)
 					oaiMessages = append(oaiMessages, message)
+				case SystemReminder:
+					message := openai.DeveloperMessage(v.Text)
+					oaiMessages = append(oaiMessages, message)
 				case ToolResult:
 					message := openai.ToolMessage(v.Content, v.ToolCallID)
 					oaiMessages = append(oaiMessages, message)
+				case customPart:
+					// Not sent to the providers.
 				default:
 					return nil, fmt.Errorf("unknown user message part type %T", v)
 				}
 			}
 
+		case RoleDeveloper:
+			for _, part := range msg.Parts {
+				switch v := part.(type) {
+				case TextContent:
+					oaiMessages = append(oaiMessages, openai.DeveloperMessage(v.Text))
+				case SystemReminder:
+					oaiMessages = append(oaiMessages, openai.DeveloperMessage(v.Text))
+				case customPart:
+					// Not sent to the providers.
+				default:
+					return nil, fmt.Errorf("unknown developer message part type %T", v)
+				}
+			}
+
 		case RoleAssistant:
 			assistantMsg := openai.ChatCompletionAssistantMessageParam{
 				Role: "assistant",
 			}
+			// Other providers (e.g. Anthropic) may have recorded several
+			// texts, OpenAI takes only one.
+			var texts []string
 			for _, part := range msg.Parts {
 				switch v := part.(type) {
 				case TextContent:
-					assistantMsg.Content = openai.ChatCompletionAssistantMessageParamContentUnion{
-						OfString: openai.String(v.Text),
-					}
+					texts = append(texts, v.Text)
 				case ToolCall:
 					fn := openai.ChatCompletionMessageToolCallUnionParam{
 						OfFunction: &openai.ChatCompletionMessageFunctionToolCallParam{
@@ -145,10 +215,19 @@ func (oaip *OpenAIProvider) convertMessages(messages []Message) ([]openai.ChatCo
 						},
 					}
 					assistantMsg.ToolCalls = append(assistantMsg.ToolCalls, fn)
+				case SearchResults:
+					// Recorded by other providers, the results are in the text.
+				case customPart:
+					// Not sent to the providers.
 				default:
 					return nil, fmt.Errorf("unknown assistant message part type %T", v)
 				}
 			}
+			if len(texts) > 0 {
+				assistantMsg.Content = openai.ChatCompletionAssistantMessageParamContentUnion{
+					OfString: openai.String(strings.Join(texts, "\n\n")),
+				}
+			}
 			message := openai.ChatCompletionMessageParamUnion{
 				OfAssistant: &assistantMsg,
 			}
@@ -159,27 +238,32 @@ func (oaip *OpenAIProvider) convertMessages(messages []Message) ([]openai.ChatCo
 	return oaiMessages, nil
 }
 
-func (oaip *OpenAIProvider) convertTools(tools []ToolDefinition) []openai.ChatCompletionToolUnionParam {
+func (oaip *OpenAIProvider) convertTools(tools []ToolDefinition) ([]openai.ChatCompletionToolUnionParam, error) {
 	var oaiTools []openai.ChatCompletionToolUnionParam
 
 	for _, tool := range tools {
+		schema, err := NormalizeSchema(tool.Schema, ProviderOpenAI)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
 		oaiTool := openai.ChatCompletionToolUnionParam{
 			OfFunction: &openai.ChatCompletionFunctionToolParam{
 				Function: openai.FunctionDefinitionParam{
 					Name:        tool.Name,
 					Description: openai.String(tool.Description),
 					Parameters: openai.FunctionParameters{
-						"type":       "object",
-						"properties": tool.Schema.Properties,
+						"type":                 "object",
+						"properties":           schema.Properties,
+						"additionalProperties": false,
 					},
 				},
 			},
 		}
-		if len(tool.Schema.Required) > 0 {
-			oaiTool.OfFunction.Function.Parameters["required"] = tool.Schema.Required
+		if len(schema.Required) > 0 {
+			oaiTool.OfFunction.Function.Parameters["required"] = schema.Required
 		}
 		oaiTools = append(oaiTools, oaiTool)
 	}
 
-	return oaiTools
+	return oaiTools, nil
 }
@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PortHistory prepares a history recorded with one provider to be continued
+// with any other, e.g. a session of Anthropic continued with OpenAI after a
+// failover. The quirks some providers reject are converted or elided:
+//
+//   - tool call IDs are normalized, see NormalizeToolCallIDs,
+//   - tool call inputs which are not JSON objects (e.g. empty arguments of
+//     OpenAI) are replaced with an empty object, Anthropic and Gemini
+//     require objects,
+//   - tool results without a tool name get the name of their call, Gemini
+//     requires it,
+//   - empty text parts are dropped, Anthropic rejects them.
+//
+// It returns the ported copy of the history and the description of the
+// changes. Histories recorded with a single provider are usually returned
+// unchanged. Run RepairHistory afterwards, dropping parts can leave empty
+// messages behind.
+func PortHistory(messages []Message) ([]Message, []string) {
+	var changes []string
+	ported, renamed := NormalizeToolCallIDs(messages)
+	if len(renamed) > 0 {
+		changes = append(changes, fmt.Sprintf("replaced %d tool call IDs", len(renamed)))
+	}
+
+	copied := len(renamed) > 0
+	callNames := map[string]string{}
+	for i, msg := range ported {
+		var parts []ContentPart
+		changed := false
+		for _, part := range msg.Parts {
+			switch v := part.(type) {
+			case TextContent:
+				if strings.TrimSpace(v.Text) == "" {
+					changes = append(changes, fmt.Sprintf("message %d: dropped empty text", i))
+					changed = true
+					continue
+				}
+			case ToolCall:
+				callNames[v.ID] = v.Name
+				if !isJSONObject(v.Input) {
+					changes = append(changes, fmt.Sprintf("message %d: replaced input %q of tool call %q with an empty object", i, v.Input, v.ID))
+					v.Input = json.RawMessage("{}")
+					part, changed = v, true
+				}
+			case ToolResult:
+				if v.ToolName == "" && callNames[v.ToolCallID] != "" {
+					v.ToolName = callNames[v.ToolCallID]
+					changes = append(changes, fmt.Sprintf("message %d: added the tool name of result %q", i, v.ToolCallID))
+					part, changed = v, true
+				}
+			}
+			parts = append(parts, part)
+		}
+		if !changed {
+			continue
+		}
+		if !copied {
+			ported = append([]Message(nil), ported...)
+			copied = true
+		}
+		ported[i].Parts = parts
+	}
+	return ported, changes
+}
+
+// objectInput returns the input of a tool call sent to the providers which
+// require a JSON object: empty, null and malformed inputs (e.g. truncated
+// arguments of OpenAI) are replaced with an empty object, so they don't fail
+// the whole request.
+func objectInput(input json.RawMessage) json.RawMessage {
+	if !isJSONObject(input) {
+		return json.RawMessage("{}")
+	}
+	return input
+}
+
+func isJSONObject(input json.RawMessage) bool {
+	// Only the JSON whitespace, bytes.TrimSpace would accept e.g. "\v{}".
+	trimmed := bytes.TrimLeft(input, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(input)
+}
@@ -3,16 +3,78 @@ package llm
 import (
 	"context"
 	"log/slog"
+	"strings"
 
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
 	"github.com/invopop/jsonschema"
 )
 
 type NewMessageParams struct {
-	SystemPrompt    string
-	ToolDefinitions []ToolDefinition
-	History         []Message
-	EnableCaching   bool
-	Logger          *slog.Logger
+	SystemPrompt string
+	// SystemPromptBlocks replaces SystemPrompt if set, so stable and volatile
+	// parts of the system prompt can be cached separately.
+	SystemPromptBlocks []SystemPromptBlock
+	ToolDefinitions    []ToolDefinition
+	History            []Message
+	EnableCaching      bool
+	Logger             *slog.Logger
+	// Clock measures the retry backoff, defaults to clock.Real.
+	Clock clock.Clock
+	// RunID is sent to the provider in the RunIDHeader request header, so
+	// requests can be correlated with the agent run (optional).
+	RunID string
+	// TokenEfficientTools enables token-efficient tool use where the
+	// provider supports it (Anthropic beta), reducing the tokens of tool calls.
+	TokenEfficientTools bool
+	// DisableParallelToolUse makes the model call at most one tool per turn
+	// (supported by Anthropic and OpenAI).
+	DisableParallelToolUse bool
+	// ForceTool makes the model call the tool with this name (optional).
+	ForceTool string
+	// Seed makes the sampling reproducible on a best-effort basis (OpenAI
+	// and Gemini, optional).
+	Seed *int64
+	// ResponseSchema constrains the text of the response to JSON matching
+	// the schema (native structured output, see
+	// Capabilities.StructuredOutput). ResponseName names the schema.
+	ResponseSchema *jsonschema.Schema
+	ResponseName   string
+}
+
+const RunIDHeader = "X-Run-ID"
+
+// SystemPromptBlock is a part of the system prompt. Stable blocks (e.g.
+// instructions) should come first and be marked Cacheable, followed by the
+// volatile ones (e.g. date, workspace facts), so changing those doesn't
+// invalidate the cached prefix.
+type SystemPromptBlock struct {
+	Text      string
+	Cacheable bool
+}
+
+// SystemBlocks returns the non-empty system prompt blocks. A plain
+// SystemPrompt is a single cacheable block.
+func (p NewMessageParams) SystemBlocks() []SystemPromptBlock {
+	if len(p.SystemPromptBlocks) == 0 {
+		return []SystemPromptBlock{{Text: p.SystemPrompt, Cacheable: true}}
+	}
+	var blocks []SystemPromptBlock
+	for _, b := range p.SystemPromptBlocks {
+		if b.Text != "" {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// SystemText returns the whole system prompt, for providers which don't
+// support blocks.
+func (p NewMessageParams) SystemText() string {
+	var texts []string
+	for _, b := range p.SystemBlocks() {
+		texts = append(texts, b.Text)
+	}
+	return strings.Join(texts, "\n\n")
 }
 
 type ToolDefinition struct {
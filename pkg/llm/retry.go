@@ -0,0 +1,38 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+	backoff "github.com/cenkalti/backoff/v4"
+)
+
+// retryNewMessage calls fn with exponential backoff for up to 30 seconds,
+// waiting on params.Clock.
+func retryNewMessage(ctx context.Context, params NewMessageParams, fn func() (Message, error)) (Message, error) {
+	clk := clock.Or(params.Clock)
+	opts := backoff.WithContext(backoff.NewExponentialBackOff(
+		backoff.WithMaxElapsedTime(30*time.Second), // Retry for up to 30 seconds
+		backoff.WithClockProvider(clk),
+	), ctx)
+	notify := func(err error, d time.Duration) {
+		params.Logger.Warn("retrying tryNewMessage", "delay", d, "error", err)
+	}
+	message, err := backoff.RetryNotifyWithTimerAndData(fn, opts, notify, &backoffTimer{clock: clk})
+	if err != nil {
+		return Message{}, fmt.Errorf("new message with retries: %w", err)
+	}
+	return message, nil
+}
+
+// backoffTimer is the backoff.Timer of a clock.
+type backoffTimer struct {
+	clock clock.Clock
+	c     <-chan time.Time
+}
+
+func (t *backoffTimer) Start(d time.Duration) { t.c = t.clock.After(d) }
+func (t *backoffTimer) Stop()                 {}
+func (t *backoffTimer) C() <-chan time.Time   { return t.c }
@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// NormalizeSchema translates a tool input schema into the subset of JSON
+// schema the provider supports: references are inlined, oneOf and const are
+// rewritten where needed, and the type of enums is made explicit. Constructs
+// which can't be translated faithfully are rejected with an error naming the
+// offending property. The input schema is not modified.
+func NormalizeSchema(schema *jsonschema.Schema, provider ProviderName) (*jsonschema.Schema, error) {
+	if schema == nil {
+		return nil, fmt.Errorf("missing schema")
+	}
+	n := schemaNormalizer{provider: provider, defs: schema.Definitions}
+	normalized, err := n.normalize(schema, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if normalized.Type != "object" {
+		return nil, fmt.Errorf("tool input must be an object, got %q", normalized.Type)
+	}
+	return normalized, nil
+}
+
+type schemaNormalizer struct {
+	provider ProviderName
+	defs     jsonschema.Definitions
+}
+
+func (n schemaNormalizer) normalize(s *jsonschema.Schema, path string, refs []string) (*jsonschema.Schema, error) {
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/$defs/")
+		if !ok {
+			return nil, schemaError(path, "only local $defs references are supported, got %q", s.Ref)
+		}
+		for _, ref := range refs {
+			if ref == name {
+				return nil, schemaError(path, "recursive reference %q is not supported", s.Ref)
+			}
+		}
+		def, ok := n.defs[name]
+		if !ok {
+			return nil, schemaError(path, "undefined reference %q", s.Ref)
+		}
+		return n.normalize(def, path, append(refs, name))
+	}
+
+	switch {
+	case s.Not != nil:
+		return nil, schemaError(path, "not is not supported")
+	case s.If != nil || s.Then != nil || s.Else != nil:
+		return nil, schemaError(path, "if/then/else is not supported")
+	case len(s.DependentSchemas) > 0 || len(s.DependentRequired) > 0:
+		return nil, schemaError(path, "dependent schemas are not supported")
+	case len(s.PatternProperties) > 0:
+		return nil, schemaError(path, "patternProperties is not supported")
+	case len(s.PrefixItems) > 0:
+		return nil, schemaError(path, "prefixItems (tuples) are not supported")
+	case len(s.AllOf) > 1:
+		return nil, schemaError(path, "allOf with more than one schema is not supported")
+	case len(s.AllOf) == 1:
+		return n.normalize(s.AllOf[0], path, refs)
+	}
+
+	out := *s
+	out.Version = ""
+	out.ID = ""
+	out.Definitions = nil
+	out.Comments = ""
+
+	if s.Properties != nil {
+		out.Properties = jsonschema.NewProperties()
+		for pair := s.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			prop, err := n.normalize(pair.Value, joinSchemaPath(path, pair.Key), refs)
+			if err != nil {
+				return nil, err
+			}
+			out.Properties.Set(pair.Key, prop)
+		}
+	}
+	if s.Items != nil {
+		items, err := n.normalize(s.Items, path+"[]", refs)
+		if err != nil {
+			return nil, err
+		}
+		out.Items = items
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties != jsonschema.FalseSchema && s.AdditionalProperties != jsonschema.TrueSchema {
+		additional, err := n.normalize(s.AdditionalProperties, path+"{}", refs)
+		if err != nil {
+			return nil, err
+		}
+		out.AdditionalProperties = additional
+	}
+	var err error
+	if out.AnyOf, err = n.normalizeAll(s.AnyOf, path, refs); err != nil {
+		return nil, err
+	}
+	if out.OneOf, err = n.normalizeAll(s.OneOf, path, refs); err != nil {
+		return nil, err
+	}
+
+	if n.provider != ProviderAnthropic && n.provider != ProviderBedrock {
+		// OpenAI and Gemini don't support oneOf and const in tool schemas,
+		// anyOf and single value enums are the closest equivalents.
+		if len(out.OneOf) > 0 {
+			out.AnyOf = append(out.AnyOf, out.OneOf...)
+			out.OneOf = nil
+		}
+		if out.Const != nil {
+			out.Enum = []any{out.Const}
+			out.Const = nil
+		}
+	}
+	if len(out.Enum) > 0 && out.Type == "" {
+		out.Type = enumType(out.Enum)
+		if out.Type == "" {
+			return nil, schemaError(path, "enum values must have the same primitive type")
+		}
+	}
+	if out.Type == "object" && out.Properties == nil {
+		out.Properties = jsonschema.NewProperties()
+	}
+	return &out, nil
+}
+
+func (n schemaNormalizer) normalizeAll(schemas []*jsonschema.Schema, path string, refs []string) ([]*jsonschema.Schema, error) {
+	var out []*jsonschema.Schema
+	for i, s := range schemas {
+		normalized, err := n.normalize(s, fmt.Sprintf("%s(%d)", path, i), refs)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, normalized)
+	}
+	return out, nil
+}
+
+func enumType(values []any) string {
+	var t string
+	for _, v := range values {
+		var vt string
+		switch v.(type) {
+		case string:
+			vt = "string"
+		case bool:
+			vt = "boolean"
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			vt = "integer"
+		case float32, float64:
+			vt = "number"
+		default:
+			return ""
+		}
+		if t != "" && t != vt {
+			return ""
+		}
+		t = vt
+	}
+	return t
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func schemaError(path, format string, args ...any) error {
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Errorf("schema of %s: %s", path, fmt.Sprintf(format, args...))
+}
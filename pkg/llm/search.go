@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"github.com/anthropics/anthropic-sdk-go"
+	"google.golang.org/genai"
+)
+
+// HostedSearchConfig enables the web search run by the provider: the web
+// search tool of Anthropic and the Google Search grounding of Gemini. The
+// other providers ignore it.
+type HostedSearchConfig struct {
+	// MaxUses limits the searches per request (optional, Anthropic only).
+	MaxUses int
+	// AllowedDomains and BlockedDomains filter the results (optional,
+	// Anthropic only).
+	AllowedDomains []string
+	BlockedDomains []string
+}
+
+// SearchResults are the results of a search run by the provider, see
+// Model.HostedSearch. They are recorded for the report of the run and not
+// sent back to the providers.
+type SearchResults struct {
+	Queries []string
+	Results []SearchResult
+	// Error is the error code of a failed search, e.g. "max_uses_exceeded".
+	Error string
+}
+
+func (SearchResults) isPart() {}
+
+type SearchResult struct {
+	Title string
+	URL   string
+	// PageAge is the age of the page reported by the provider (optional).
+	PageAge string
+}
+
+func anthropicWebSearchTool(config *HostedSearchConfig) anthropic.ToolUnionParam {
+	tool := &anthropic.WebSearchTool20250305Param{
+		AllowedDomains: config.AllowedDomains,
+		BlockedDomains: config.BlockedDomains,
+	}
+	if config.MaxUses > 0 {
+		tool.MaxUses = anthropic.Int(int64(config.MaxUses))
+	}
+	return anthropic.ToolUnionParam{OfWebSearchTool20250305: tool}
+}
+
+// anthropicSearchResults converts a web search result block, query is the
+// input of the server tool use block preceding it.
+func anthropicSearchResults(block anthropic.WebSearchToolResultBlock, query string) SearchResults {
+	results := SearchResults{}
+	if query != "" {
+		results.Queries = []string{query}
+	}
+	if block.Content.ErrorCode != "" {
+		results.Error = string(block.Content.ErrorCode)
+		return results
+	}
+	for _, r := range block.Content.AsWebSearchResultBlockArray() {
+		results.Results = append(results.Results, SearchResult{Title: r.Title, URL: r.URL, PageAge: r.PageAge})
+	}
+	return results
+}
+
+func anthropicSearchQuery(block anthropic.ServerToolUseBlock) string {
+	if input, ok := block.Input.(map[string]any); ok {
+		if query, ok := input["query"].(string); ok {
+			return query
+		}
+	}
+	return ""
+}
+
+// geminiSearchResults converts the grounding metadata of a response, nil if
+// no search was run.
+func geminiSearchResults(metadata *genai.GroundingMetadata) *SearchResults {
+	if metadata == nil || len(metadata.WebSearchQueries) == 0 {
+		return nil
+	}
+	results := &SearchResults{Queries: metadata.WebSearchQueries}
+	for _, chunk := range metadata.GroundingChunks {
+		if chunk != nil && chunk.Web != nil {
+			results.Results = append(results.Results, SearchResult{Title: chunk.Web.Title, URL: chunk.Web.URI})
+		}
+	}
+	return results
+}
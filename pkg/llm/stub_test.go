@@ -0,0 +1,113 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+// stubServer serves the API of a provider with canned responses, recording
+// the bodies of the requests, so the providers can be tested without
+// credentials.
+type stubServer struct {
+	provider ProviderName
+	server   *httptest.Server
+
+	mu     sync.Mutex
+	bodies [][]byte
+}
+
+func newStubServer(t *testing.T, provider ProviderName) *stubServer {
+	t.Helper()
+	s := &stubServer{provider: provider}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(stubResponse(provider, "ok"))
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// newProvider returns a provider sending its requests to the server.
+func (s *stubServer) newProvider(t *testing.T) Provider {
+	t.Helper()
+	m := Model{
+		Provider: s.provider,
+		Name:     "stub-model",
+		APIKey:   "stub-key",
+		BaseURL:  s.server.URL,
+		Gemini:   &GeminiConfig{Backend: GeminiBackendAPI},
+	}
+	provider, err := m.NewProvider(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func (s *stubServer) requests() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies
+}
+
+// stubResponse is a text response in the format of the provider.
+func stubResponse(provider ProviderName, text string) []byte {
+	var response any
+	switch provider {
+	case ProviderAnthropic:
+		response = map[string]any{
+			"id": "msg_stub", "type": "message", "role": "assistant", "model": "stub-model",
+			"content":     []any{map[string]any{"type": "text", "text": text}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]any{"input_tokens": 10, "output_tokens": 2},
+		}
+	case ProviderOpenAI:
+		response = map[string]any{
+			"id": "chatcmpl-stub", "object": "chat.completion", "created": 0, "model": "stub-model",
+			"choices": []any{map[string]any{
+				"index": 0, "finish_reason": "stop",
+				"message": map[string]any{"role": "assistant", "content": text},
+			}},
+			"usage": map[string]any{"prompt_tokens": 10, "completion_tokens": 2, "total_tokens": 12},
+		}
+	case ProviderGemini:
+		response = map[string]any{
+			"candidates": []any{map[string]any{
+				"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": text}}},
+				"finishReason": "STOP",
+			}},
+			"usageMetadata": map[string]any{"promptTokenCount": 10, "candidatesTokenCount": 2, "totalTokenCount": 12},
+		}
+	}
+	b, _ := json.Marshal(response)
+	return b
+}
+
+// stubTool is a tool with a path input.
+func stubTool(name string) ToolDefinition {
+	var schema jsonschema.Schema
+	if err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {"path": {"type": "string"}},
+		"required": ["path"],
+		"additionalProperties": false
+	}`), &schema); err != nil {
+		panic(err)
+	}
+	return ToolDefinition{Name: name, Description: "A stub tool.", Schema: &schema}
+}
@@ -0,0 +1,32 @@
+package llm
+
+import (
+	"slices"
+	"sync"
+)
+
+// toolCache keeps the tools converted by a provider, so they aren't
+// normalized and converted again on every turn. The tools of an agent rarely
+// change between turns, so only the last conversion is kept: it's reused as
+// long as the definitions are the same (same names, descriptions and schema
+// pointers, in the same order), and replaced when they change. Schemas must
+// not be modified once passed to a provider.
+type toolCache[T any] struct {
+	mu    sync.Mutex
+	defs  []ToolDefinition
+	tools []T
+}
+
+func (c *toolCache[T]) get(defs []ToolDefinition, convert func([]ToolDefinition) ([]T, error)) ([]T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.defs != nil && slices.Equal(c.defs, defs) {
+		return c.tools, nil
+	}
+	tools, err := convert(defs)
+	if err != nil {
+		return nil, err
+	}
+	c.defs, c.tools = slices.Clone(defs), tools
+	return tools, nil
+}
@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"crypto/rand"
+	"regexp"
+)
+
+// MaxToolCallIDLength is the longest tool call ID every provider accepts
+// (OpenAI rejects longer ones).
+const MaxToolCallIDLength = 40
+
+// Anthropic only accepts these characters, OpenAI limits the length.
+var toolCallIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,40}$`)
+
+// ValidToolCallID reports whether the ID is accepted by every provider, so a
+// history can be sent to another provider than the one which recorded it.
+func ValidToolCallID(id string) bool {
+	return toolCallIDPattern.MatchString(id)
+}
+
+// NewToolCallID generates a tool call ID accepted by every provider, for the
+// providers which don't return IDs (Gemini).
+func NewToolCallID() string {
+	return "call_" + rand.Text()
+}
+
+// NormalizeToolCallIDs replaces the tool call IDs which are not accepted by
+// every provider or are not unique in the history, e.g. in a session
+// recorded with another provider. The results of the calls are updated
+// accordingly. It returns the normalized copy of the history and the
+// mapping of the replaced IDs to the new ones, nil if nothing was replaced.
+// Only the assistant messages with replaced IDs and the next messages are
+// copied.
+func NormalizeToolCallIDs(messages []Message) ([]Message, map[string]string) {
+	var mapping map[string]string
+	normalized := messages
+	seen := map[string]bool{}
+	for i, msg := range messages {
+		if msg.Role != RoleAssistant {
+			continue
+		}
+		renamed := map[string]string{}
+		for _, call := range toolCalls(msg) {
+			if _, ok := renamed[call.ID]; !ok && (!ValidToolCallID(call.ID) || seen[call.ID]) {
+				renamed[call.ID] = NewToolCallID()
+			}
+		}
+		for _, call := range toolCalls(msg) {
+			seen[call.ID] = true
+		}
+		if len(renamed) == 0 {
+			continue
+		}
+		if mapping == nil {
+			mapping = map[string]string{}
+			normalized = append([]Message(nil), messages...)
+		}
+		normalized[i] = renameToolCallIDs(msg, renamed)
+		if i+1 < len(messages) && messages[i+1].Role == RoleUser {
+			normalized[i+1] = renameToolCallIDs(messages[i+1], renamed)
+		}
+		for from, to := range renamed {
+			mapping[from] = to
+			seen[to] = true
+		}
+	}
+	return normalized, mapping
+}
+
+// renameToolCallIDs returns a copy of the message with the tool calls and
+// results renamed.
+func renameToolCallIDs(msg Message, renamed map[string]string) Message {
+	parts := make([]ContentPart, len(msg.Parts))
+	for i, part := range msg.Parts {
+		switch v := part.(type) {
+		case ToolCall:
+			if id, ok := renamed[v.ID]; ok {
+				v.ID = id
+			}
+			parts[i] = v
+		case ToolResult:
+			if id, ok := renamed[v.ToolCallID]; ok {
+				v.ToolCallID = id
+			}
+			parts[i] = v
+		default:
+			parts[i] = part
+		}
+	}
+	msg.Parts = parts
+	return msg
+}
@@ -0,0 +1,287 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// ExportMessages converts the history to the native JSON format of a
+// provider: Anthropic (and Bedrock) Messages API messages, OpenAI Chat
+// Completions messages or Gemini contents. It's meant for debugging, e.g. to
+// replay a transcript in the console of the provider.
+func ExportMessages(messages []Message, format ProviderName) ([]byte, error) {
+	var native any
+	var err error
+	switch format {
+	case ProviderAnthropic, ProviderBedrock:
+		native, err = (&AnthropicProvider{}).convertMessages(messages, slog.New(slog.DiscardHandler))
+	case ProviderOpenAI:
+		native, err = (&OpenAIProvider{}).convertMessages(messages)
+	case ProviderGemini:
+		native, err = (&GeminiProvider{}).convertMessages(messages)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("convert messages: %w", err)
+	}
+	// Keep the <system-reminder> tags readable: the SDK types escape HTML
+	// when marshaling themselves, so the JSON is decoded and encoded again
+	// without escaping.
+	b, err := json.Marshal(native)
+	if err != nil {
+		return nil, fmt.Errorf("marshal messages: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("decode messages: %w", err)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(generic); err != nil {
+		return nil, fmt.Errorf("marshal messages: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// ImportMessages converts messages in the native JSON format of a provider
+// (see ExportMessages) back to the history. The conversion is lossy where
+// the formats differ, e.g. OpenAI tool messages have no error flag.
+func ImportMessages(data []byte, format ProviderName) ([]Message, error) {
+	switch format {
+	case ProviderAnthropic, ProviderBedrock:
+		return importAnthropic(data)
+	case ProviderOpenAI:
+		return importOpenAI(data)
+	case ProviderGemini:
+		return importGemini(data)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// importedText restores the system reminders, which are sent as tagged text.
+func importedText(text string) ContentPart {
+	if inner, ok := strings.CutPrefix(text, "<system-reminder>"); ok {
+		if inner, ok := strings.CutSuffix(inner, "</system-reminder>"); ok {
+			return SystemReminder{Text: inner}
+		}
+	}
+	return TextContent{Text: text}
+}
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	IsError   bool            `json:"is_error"`
+}
+
+func importAnthropic(data []byte) ([]Message, error) {
+	var native []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &native); err != nil {
+		return nil, fmt.Errorf("unmarshal anthropic messages: %w", err)
+	}
+	toolNames := map[string]string{}
+	var messages []Message
+	for i, m := range native {
+		blocks, err := anthropicBlocks(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		msg := Message{Role: MessageRole(m.Role)}
+		for _, b := range blocks {
+			switch b.Type {
+			case "text":
+				if msg.Role == RoleAssistant {
+					msg.Parts = append(msg.Parts, TextContent{Text: b.Text})
+				} else {
+					msg.Parts = append(msg.Parts, importedText(b.Text))
+				}
+			case "tool_use":
+				toolNames[b.ID] = b.Name
+				var input bytes.Buffer
+				if err := json.Compact(&input, b.Input); err != nil {
+					return nil, fmt.Errorf("message %d: tool call %q input: %w", i, b.ID, err)
+				}
+				msg.Parts = append(msg.Parts, ToolCall{ID: b.ID, Name: b.Name, Input: input.Bytes()})
+			case "tool_result":
+				content, err := anthropicBlocks(b.Content)
+				if err != nil {
+					return nil, fmt.Errorf("message %d: tool result %q: %w", i, b.ToolUseID, err)
+				}
+				var texts []string
+				for _, c := range content {
+					texts = append(texts, c.Text)
+				}
+				msg.Parts = append(msg.Parts, ToolResult{
+					ToolCallID: b.ToolUseID,
+					ToolName:   toolNames[b.ToolUseID],
+					Content:    strings.Join(texts, "\n"),
+					IsError:    b.IsError,
+				})
+			default:
+				return nil, fmt.Errorf("message %d: unsupported content block type %q", i, b.Type)
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// anthropicBlocks parses content which is either a string or a list of
+// blocks.
+func anthropicBlocks(content json.RawMessage) ([]anthropicBlock, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(content, &blocks); err != nil {
+		return nil, fmt.Errorf("unmarshal content: %w", err)
+	}
+	return blocks, nil
+}
+
+func importOpenAI(data []byte) ([]Message, error) {
+	var native []struct {
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		ToolCallID string          `json:"tool_call_id"`
+		ToolCalls  []struct {
+			ID       string `json:"id"`
+			Function struct {
+				Name      string `json:"name"`
+				Arguments string `json:"arguments"`
+			} `json:"function"`
+		} `json:"tool_calls"`
+	}
+	if err := json.Unmarshal(data, &native); err != nil {
+		return nil, fmt.Errorf("unmarshal openai messages: %w", err)
+	}
+	toolNames := map[string]string{}
+	var messages []Message
+	// appendUser merges the consecutive user side parts (tool messages and
+	// the reminders following them) into one user message.
+	appendUser := func(part ContentPart) {
+		if n := len(messages); n > 0 && messages[n-1].Role == RoleUser {
+			messages[n-1].Parts = append(messages[n-1].Parts, part)
+			return
+		}
+		messages = append(messages, NewUserMessage(part))
+	}
+	for i, m := range native {
+		text, err := openAIText(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		switch m.Role {
+		case "user":
+			appendUser(importedText(text))
+		case "developer", "system":
+			appendUser(SystemReminder{Text: text})
+		case "tool":
+			appendUser(ToolResult{ToolCallID: m.ToolCallID, ToolName: toolNames[m.ToolCallID], Content: text})
+		case "assistant":
+			msg := Message{Role: RoleAssistant}
+			if text != "" {
+				msg.Parts = append(msg.Parts, TextContent{Text: text})
+			}
+			for _, call := range m.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+				msg.Parts = append(msg.Parts, ToolCall{
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: json.RawMessage(call.Function.Arguments),
+				})
+			}
+			messages = append(messages, msg)
+		default:
+			return nil, fmt.Errorf("message %d: unsupported role %q", i, m.Role)
+		}
+	}
+	return messages, nil
+}
+
+// openAIText parses content which is either a string or a list of text
+// parts.
+func openAIText(content json.RawMessage) (string, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", fmt.Errorf("unmarshal content: %w", err)
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type != "text" {
+			return "", fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func importGemini(data []byte) ([]Message, error) {
+	var native []*genai.Content
+	if err := json.Unmarshal(data, &native); err != nil {
+		return nil, fmt.Errorf("unmarshal gemini contents: %w", err)
+	}
+	var messages []Message
+	for i, c := range native {
+		msg := Message{Role: RoleUser}
+		if c.Role == "model" {
+			msg.Role = RoleAssistant
+		}
+		for _, p := range c.Parts {
+			switch {
+			case p.FunctionCall != nil:
+				args, err := json.Marshal(p.FunctionCall.Args)
+				if err != nil {
+					return nil, fmt.Errorf("message %d: marshal args: %w", i, err)
+				}
+				msg.Parts = append(msg.Parts, ToolCall{ID: p.FunctionCall.ID, Name: p.FunctionCall.Name, Input: args})
+			case p.FunctionResponse != nil:
+				res := ToolResult{ToolCallID: p.FunctionResponse.ID, ToolName: p.FunctionResponse.Name}
+				if errMsg, ok := p.FunctionResponse.Response["error"]; ok {
+					res.Content, res.IsError = fmt.Sprint(errMsg), true
+				} else {
+					res.Content = fmt.Sprint(p.FunctionResponse.Response["output"])
+				}
+				msg.Parts = append(msg.Parts, res)
+			case msg.Role == RoleAssistant:
+				msg.Parts = append(msg.Parts, TextContent{Text: p.Text})
+			default:
+				msg.Parts = append(msg.Parts, importedText(p.Text))
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
@@ -0,0 +1,31 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExportMessagesUnescaped exports a text with tags and a literal escape
+// sequence, the tags must stay readable and the sequence unchanged.
+func TestExportMessagesUnescaped(t *testing.T) {
+	const text = `<system-reminder>a & b</system-reminder> \u003c`
+	history := []Message{NewUserMessage(TextContent{Text: text})}
+	for _, format := range []ProviderName{ProviderAnthropic, ProviderOpenAI, ProviderGemini} {
+		t.Run(string(format), func(t *testing.T) {
+			b, err := ExportMessages(history, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(b); !strings.Contains(got, `<system-reminder>a & b</system-reminder> \\u003c`) {
+				t.Errorf("exported:\n%s", got)
+			}
+			imported, err := ImportMessages(b, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imported) != 1 || !strings.Contains(imported[0].Parts[0].(TextContent).Text, text) {
+				t.Errorf("imported %+v, want %q", imported, text)
+			}
+		})
+	}
+}
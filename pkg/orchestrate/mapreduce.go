@@ -0,0 +1,117 @@
+package orchestrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// DefaultReduceShare is the part of the budget reserved for the reduce step
+// if MapReduceParams.ReduceShare is not set.
+const DefaultReduceShare = 0.25
+
+// MapReduceParams configure MapReduce. The map and reduce functions get
+// their token budget, 0 if unlimited, e.g. to pass it to
+// agent.RunParams.MaxTokenUsage.
+type MapReduceParams[T, R, S any] struct {
+	MapParams
+	// Map processes an item (mandatory).
+	Map func(ctx context.Context, item T, maxTokens int) (R, llm.TokenUsage, error)
+	// Reduce combines the results of the successful items (mandatory).
+	Reduce func(ctx context.Context, mapped []Result[T, R], maxTokens int) (S, llm.TokenUsage, error)
+	// MaxTokenUsage is the budget of the whole map-reduce, unlimited if
+	// zero. The map budget is split evenly between the items, the reduce
+	// step gets what's left of the budget.
+	MaxTokenUsage int
+	// ReduceShare is the part of MaxTokenUsage reserved for the reduce
+	// step, defaults to DefaultReduceShare.
+	ReduceShare float64
+	// Progress is called after each mapped item (optional). The calls are
+	// serialized.
+	Progress func(Progress)
+}
+
+// Progress reports the state of the map step.
+type Progress struct {
+	Done   int // including the failed items
+	Failed int
+	Total  int
+	Usage  llm.TokenUsage
+}
+
+// MapReduceResult is the outcome of MapReduce.
+type MapReduceResult[T, R, S any] struct {
+	Value S
+	// Mapped are the results of the map step, including the failed items.
+	Mapped Results[T, R]
+	// Usage is the total usage of the map and reduce steps.
+	Usage llm.TokenUsage
+}
+
+// MapReduce runs Map over the items with Map's concurrency (see MapParams),
+// then Reduce over the results of the successful items, e.g. an agent
+// reviewing each file and another one summarizing the reviews. Failed items
+// are left out of the reduce step, they are reported in Mapped. It fails if
+// no item succeeded, or if any item failed with FailFast.
+func MapReduce[T, R, S any](ctx context.Context, items []T, p MapReduceParams[T, R, S]) (MapReduceResult[T, R, S], error) {
+	var result MapReduceResult[T, R, S]
+	if p.Map == nil || p.Reduce == nil {
+		return result, fmt.Errorf("map and reduce are required")
+	}
+	if len(items) == 0 {
+		return result, fmt.Errorf("no items")
+	}
+	reduceShare := p.ReduceShare
+	if reduceShare <= 0 || reduceShare >= 1 {
+		reduceShare = DefaultReduceShare
+	}
+	var itemBudget int
+	if p.MaxTokenUsage > 0 {
+		itemBudget = max(int(float64(p.MaxTokenUsage)*(1-reduceShare))/len(items), 1)
+	}
+
+	var mu sync.Mutex
+	progress := Progress{Total: len(items)}
+	mapFn := func(ctx context.Context, item T) (R, llm.TokenUsage, error) {
+		value, usage, err := p.Map(ctx, item, itemBudget)
+		if p.Progress != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			progress.Done++
+			if err != nil {
+				progress.Failed++
+			}
+			progress.Usage = progress.Usage.Add(usage)
+			p.Progress(progress)
+		}
+		return value, usage, err
+	}
+	mapped, mapErr := Map(ctx, items, mapFn, p.MapParams)
+	result.Mapped = mapped
+	result.Usage = mapped.Usage
+	succeeded := mapped.Succeeded()
+	switch {
+	case mapErr != nil && p.FailFast:
+		return result, fmt.Errorf("map: %w", mapErr)
+	case len(succeeded) == 0:
+		return result, fmt.Errorf("map: every item failed: %w", mapErr)
+	}
+
+	var reduceBudget int
+	if p.MaxTokenUsage > 0 {
+		reduceBudget = p.MaxTokenUsage - int(mapped.Usage.Total())
+		if reduceBudget <= 0 {
+			return result, errors.New("reduce: the map step used the whole token budget")
+		}
+	}
+	value, usage, err := p.Reduce(ctx, succeeded, reduceBudget)
+	result.Usage = result.Usage.Add(usage)
+	if err != nil {
+		return result, fmt.Errorf("reduce: %w", err)
+	}
+	result.Value = value
+	return result, nil
+}
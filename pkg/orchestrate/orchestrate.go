@@ -0,0 +1,150 @@
+// Package orchestrate runs agents over many items, e.g. reviewing every file
+// of a directory, without hand-written goroutine loops.
+package orchestrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// DefaultMaxWorkers is the number of items processed at the same time if
+// MapParams.MaxWorkers is not set.
+const DefaultMaxWorkers = 8
+
+type MapParams struct {
+	// MaxWorkers bounds the items processed at the same time, defaults to
+	// DefaultMaxWorkers.
+	MaxWorkers int
+	// ItemTimeout bounds the processing of each item (optional).
+	ItemTimeout time.Duration
+	// FailFast cancels the remaining items after the first failure, by
+	// default every item is processed.
+	FailFast bool
+}
+
+// Func processes an item, returning its result and the tokens used, e.g.
+// the Usage of the RunMeta of an agent run.
+type Func[T, R any] func(ctx context.Context, item T) (R, llm.TokenUsage, error)
+
+// Result is the outcome of an item.
+type Result[T, R any] struct {
+	Item     T
+	Value    R
+	Usage    llm.TokenUsage
+	Err      error
+	Duration time.Duration
+}
+
+// Results are the outcomes of the items, in the order of the items.
+type Results[T, R any] struct {
+	Items []Result[T, R]
+	// Usage is the total usage of the items, including the failed ones.
+	Usage llm.TokenUsage
+}
+
+// Succeeded returns the results of the successful items.
+func (r Results[T, R]) Succeeded() []Result[T, R] {
+	var results []Result[T, R]
+	for _, res := range r.Items {
+		if res.Err == nil {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// Failed returns the results of the failed items.
+func (r Results[T, R]) Failed() []Result[T, R] {
+	var results []Result[T, R]
+	for _, res := range r.Items {
+		if res.Err != nil {
+			results = append(results, res)
+		}
+	}
+	return results
+}
+
+// Map calls fn for each item with at most MaxWorkers calls at the same time.
+// The results of all the items are returned, the error joins the errors of
+// the failed items (nil if all succeeded), so partial results can be used.
+// Items not started because the context was canceled (or after a failure
+// with FailFast) fail with the error of the context. A panic in fn fails
+// its item.
+func Map[T, R any](ctx context.Context, items []T, fn Func[T, R], p MapParams) (Results[T, R], error) {
+	workers := p.MaxWorkers
+	if workers <= 0 {
+		workers = DefaultMaxWorkers
+	}
+	workers = min(workers, len(items))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]Result[T, R], len(items))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = process(ctx, items[i], fn, p.ItemTimeout)
+				if results[i].Err != nil && p.FailFast {
+					cancel()
+				}
+			}
+		}()
+	}
+	next := 0
+dispatch:
+	for ; next < len(items); next++ {
+		select {
+		case indexes <- next:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	for i := next; i < len(items); i++ {
+		results[i] = Result[T, R]{Item: items[i], Err: fmt.Errorf("not started: %w", context.Cause(ctx))}
+	}
+
+	var usage llm.TokenUsage
+	var errs []error
+	for i, res := range results {
+		usage = usage.Add(res.Usage)
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", i, res.Err))
+		}
+	}
+	return Results[T, R]{Items: results, Usage: usage}, errors.Join(errs...)
+}
+
+func process[T, R any](ctx context.Context, item T, fn Func[T, R], timeout time.Duration) (res Result[T, R]) {
+	res.Item = item
+	if err := ctx.Err(); err != nil {
+		res.Err = fmt.Errorf("not started: %w", err)
+		return res
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	defer func() {
+		res.Duration = time.Since(started)
+		if r := recover(); r != nil {
+			res.Err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	res.Value, res.Usage, res.Err = fn(ctx, item)
+	return res
+}
@@ -0,0 +1,109 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// WriteMarkdown writes the result as a Markdown section, followed by the
+// transcript if messages is not empty. Struct results are rendered field by
+// field using their JSON names.
+func WriteMarkdown(w io.Writer, result any, messages []llm.Message) error {
+	var sb strings.Builder
+	sb.WriteString("## Result\n\n")
+	if err := writeMarkdownValue(&sb, result); err != nil {
+		return err
+	}
+
+	if len(messages) > 0 {
+		sb.WriteString("\n")
+		writeMarkdownTranscript(&sb, messages)
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("write markdown: %w", err)
+	}
+	return nil
+}
+
+// WriteTranscriptMarkdown writes the messages as a Markdown transcript.
+func WriteTranscriptMarkdown(w io.Writer, messages []llm.Message) error {
+	var sb strings.Builder
+	writeMarkdownTranscript(&sb, messages)
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("write markdown: %w", err)
+	}
+	return nil
+}
+
+func writeMarkdownTranscript(sb *strings.Builder, messages []llm.Message) {
+	sb.WriteString("## Transcript\n\n")
+	sb.WriteString(llm.History(messages).Markdown())
+}
+
+func writeMarkdownValue(sb *strings.Builder, v any) error {
+	if s, ok := v.(string); ok {
+		sb.WriteString(s + "\n")
+		return nil
+	}
+
+	// Go through JSON to use the same field names as the model.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal result: %w", err)
+	}
+	keys, fields, ok := objectFields(b)
+	if !ok {
+		fmt.Fprintf(sb, "```json\n%s\n```\n", b)
+		return nil
+	}
+
+	for _, k := range keys {
+		var val any
+		_ = json.Unmarshal(fields[k], &val)
+		switch val := val.(type) {
+		case map[string]any, []any:
+			nested, _ := json.MarshalIndent(val, "", "  ")
+			fmt.Fprintf(sb, "- **%s**:\n\n```json\n%s\n```\n", k, nested)
+		case string:
+			if strings.Contains(val, "\n") {
+				fmt.Fprintf(sb, "- **%s**:\n\n%s\n\n", k, val)
+			} else {
+				fmt.Fprintf(sb, "- **%s**: %s\n", k, val)
+			}
+		default:
+			fmt.Fprintf(sb, "- **%s**: %v\n", k, val)
+		}
+	}
+	return nil
+}
+
+// objectFields decodes a JSON object keeping the order of its keys, which is
+// the order of the struct fields for marshaled structs.
+func objectFields(b []byte) ([]string, map[string]json.RawMessage, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if tok, err := decoder.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, false
+	}
+	var keys []string
+	fields := map[string]json.RawMessage{}
+	for decoder.More() {
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, nil, false
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, nil, false
+		}
+		keys = append(keys, key)
+		fields[key] = raw
+	}
+	return keys, fields, true
+}
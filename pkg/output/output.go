@@ -0,0 +1,126 @@
+// Package output renders run results and transcripts into formats consumed by
+// other tools: JSON, Markdown and SARIF.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// TranscriptMessage is the JSON friendly representation of an llm.Message.
+type TranscriptMessage struct {
+	Role  llm.MessageRole  `json:"role"`
+	Parts []TranscriptPart `json:"parts"`
+	Usage *llm.TokenUsage  `json:"usage,omitempty"`
+	// Metadata is the metadata of the message, see llm.Message.Metadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type TranscriptPart struct {
+	Type       string          `json:"type"` // "text", "system_reminder", "tool_call", "tool_result" or "search_results"
+	Text       string          `json:"text,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	ToolName   string          `json:"tool_name,omitempty"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	IsError    bool            `json:"is_error,omitempty"`
+	// Citations are the sources of the text, see llm.TextContent.Citations.
+	Citations []TranscriptCitation `json:"citations,omitempty"`
+	// Queries and Results are the web searches run by the provider, see
+	// llm.SearchResults.
+	Queries []string           `json:"queries,omitempty"`
+	Results []llm.SearchResult `json:"results,omitempty"`
+}
+
+type TranscriptCitation struct {
+	CitedText     string `json:"cited_text,omitempty"`
+	Title         string `json:"title,omitempty"`
+	URL           string `json:"url,omitempty"`
+	DocumentIndex int    `json:"document_index,omitempty"`
+	Location      string `json:"location,omitempty"`
+	Start         int    `json:"start,omitempty"`
+	End           int    `json:"end,omitempty"`
+}
+
+// Transcript converts messages into their JSON friendly representation.
+func Transcript(messages []llm.Message) []TranscriptMessage {
+	var transcript []TranscriptMessage
+	for _, msg := range messages {
+		tm := TranscriptMessage{Role: msg.Role, Metadata: msg.Metadata}
+		if msg.Usage != (llm.TokenUsage{}) {
+			usage := msg.Usage
+			tm.Usage = &usage
+		}
+		for _, part := range msg.Parts {
+			switch v := part.(type) {
+			case llm.TextContent:
+				tp := TranscriptPart{Type: "text", Text: v.Text}
+				for _, c := range v.Citations {
+					tp.Citations = append(tp.Citations, TranscriptCitation(c))
+				}
+				tm.Parts = append(tm.Parts, tp)
+			case llm.SystemReminder:
+				tm.Parts = append(tm.Parts, TranscriptPart{Type: "system_reminder", Text: v.Text})
+			case llm.ToolCall:
+				tm.Parts = append(tm.Parts, TranscriptPart{
+					Type:       "tool_call",
+					ToolCallID: v.ID,
+					ToolName:   v.Name,
+					Input:      validJSON(v.Input),
+				})
+			case llm.ToolResult:
+				tm.Parts = append(tm.Parts, TranscriptPart{
+					Type:       "tool_result",
+					ToolCallID: v.ToolCallID,
+					ToolName:   v.ToolName,
+					Text:       v.Content,
+					IsError:    v.IsError,
+				})
+			case llm.SearchResults:
+				tm.Parts = append(tm.Parts, TranscriptPart{
+					Type:    "search_results",
+					Text:    v.Error,
+					Queries: v.Queries,
+					Results: v.Results,
+				})
+			default:
+				tm.Parts = append(tm.Parts, TranscriptPart{Type: fmt.Sprintf("%T", v)})
+			}
+		}
+		transcript = append(transcript, tm)
+	}
+	return transcript
+}
+
+// WriteJSON writes the result and the transcript (if messages is not empty)
+// as an indented JSON document.
+func WriteJSON(w io.Writer, result any, messages []llm.Message) error {
+	doc := struct {
+		Result     any                 `json:"result"`
+		Transcript []TranscriptMessage `json:"transcript,omitempty"`
+	}{
+		Result:     result,
+		Transcript: Transcript(messages),
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("encode json: %w", err)
+	}
+	return nil
+}
+
+// validJSON makes sure malformed tool inputs produced by the model don't
+// break the encoding of the whole document.
+func validJSON(b json.RawMessage) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	if !json.Valid(b) {
+		quoted, _ := json.Marshal(string(b))
+		return quoted
+	}
+	return b
+}
@@ -0,0 +1,158 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+type Level string
+
+const (
+	LevelError   Level = "error"
+	LevelWarning Level = "warning"
+	LevelNote    Level = "note"
+)
+
+// Finding is a single issue reported by a review-style agent. Result types
+// can expose their findings by implementing Findinger.
+type Finding struct {
+	RuleID    string `json:"rule_id" jsonschema_description:"Short identifier of the kind of issue, e.g. 'hardcoded-secret'"`
+	Level     Level  `json:"level" jsonschema:"enum=error,enum=warning,enum=note" jsonschema_description:"Severity of the issue"`
+	Message   string `json:"message" jsonschema_description:"Description of the issue and how to fix it"`
+	Path      string `json:"path,omitempty" jsonschema_description:"Path of the affected file, relative to the repository root"`
+	StartLine int    `json:"start_line,omitempty" jsonschema_description:"First affected line (1-based)"`
+	EndLine   int    `json:"end_line,omitempty" jsonschema_description:"Last affected line"`
+}
+
+type Findinger interface {
+	Findings() []Finding
+}
+
+type SARIFParams struct {
+	ToolName       string // mandatory
+	ToolVersion    string
+	InformationURI string
+	// RuleDescriptions optionally describes the rule IDs used in findings.
+	RuleDescriptions map[string]string
+}
+
+// WriteSARIF writes the findings as a SARIF 2.1.0 log, which can be uploaded
+// to code scanning UIs (e.g. GitHub code scanning).
+func WriteSARIF(w io.Writer, p SARIFParams, findings []Finding) error {
+	ruleIDs := map[string]bool{}
+	var results []sarifResult
+	for _, f := range findings {
+		ruleID := f.RuleID
+		if ruleID == "" {
+			ruleID = "finding"
+		}
+		ruleIDs[ruleID] = true
+		level := f.Level
+		if level == "" {
+			level = LevelWarning
+		}
+		res := sarifResult{
+			RuleID:  ruleID,
+			Level:   level,
+			Message: sarifText{Text: f.Message},
+		}
+		if f.Path != "" {
+			loc := sarifLocation{}
+			loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(f.Path)
+			if f.StartLine > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{StartLine: f.StartLine, EndLine: max(f.EndLine, f.StartLine)}
+			}
+			res.Locations = []sarifLocation{loc}
+		}
+		results = append(results, res)
+	}
+
+	var rules []sarifRule
+	for id := range ruleIDs {
+		rule := sarifRule{ID: id}
+		if desc := p.RuleDescriptions[id]; desc != "" {
+			rule.ShortDescription = &sarifText{Text: desc}
+		}
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:           p.ToolName,
+				Version:        p.ToolVersion,
+				InformationURI: p.InformationURI,
+				Rules:          rules,
+			}},
+			Results: results,
+		}},
+	}
+	if log.Runs[0].Results == nil {
+		log.Runs[0].Results = []sarifResult{} // SARIF requires the array
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(log); err != nil {
+		return fmt.Errorf("encode sarif: %w", err)
+	}
+	return nil
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID               string     `json:"id"`
+	ShortDescription *sarifText `json:"shortDescription,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     Level           `json:"level"`
+	Message   sarifText       `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+	EndLine   int `json:"endLine,omitempty"`
+}
@@ -0,0 +1,78 @@
+// Package buildlog provides a ready-made agent that analyzes failed CI build
+// logs and returns a structured diagnosis.
+package buildlog
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/agent"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+type FailureCategory string
+
+const (
+	CategoryCompilation    FailureCategory = "compilation"
+	CategoryTest           FailureCategory = "test"
+	CategoryDependency     FailureCategory = "dependency"
+	CategoryCodeSigning    FailureCategory = "code_signing"
+	CategoryConfiguration  FailureCategory = "configuration"
+	CategoryInfrastructure FailureCategory = "infrastructure"
+	CategoryTimeout        FailureCategory = "timeout"
+	CategoryUnknown        FailureCategory = "unknown"
+)
+
+type Result struct {
+	FailureCategory FailureCategory `json:"failure_category" jsonschema:"enum=compilation,enum=test,enum=dependency,enum=code_signing,enum=configuration,enum=infrastructure,enum=timeout,enum=unknown" jsonschema_description:"The category of the build failure."`
+	RootCause       string          `json:"root_cause" jsonschema_description:"Concise explanation of the root cause of the failure, referencing the relevant log lines."`
+	SuggestedFix    string          `json:"suggested_fix" jsonschema_description:"Actionable steps the user should take to fix the failure."`
+	Confidence      float64         `json:"confidence" jsonschema:"minimum=0,maximum=1" jsonschema_description:"Confidence in the diagnosis between 0 and 1."`
+	RelevantLines   []int           `json:"relevant_lines,omitempty" jsonschema_description:"Line numbers (1-based) of the log lines supporting the diagnosis."`
+}
+
+// DefaultChunkSize is the default number of bytes returned by a single
+// ReadLogChunk tool call.
+const DefaultChunkSize = 32 * 1024
+
+type Analyzer struct {
+	*agent.Base
+	// ChunkSize is the size of a log chunk in bytes. Defaults to DefaultChunkSize.
+	ChunkSize int
+}
+
+func NewAnalyzer(b *agent.Base) Analyzer {
+	return Analyzer{Base: b, ChunkSize: DefaultChunkSize}
+}
+
+// Run analyzes the build log at logPath. The log is never loaded into memory
+// as a whole, the agent navigates it with the log tools instead.
+func (a Analyzer) Run(ctx context.Context, logPath string) (Result, agent.RunMeta, error) {
+	info, err := os.Stat(logPath)
+	if err != nil {
+		return Result{}, agent.RunMeta{}, fmt.Errorf("stat log: %w", err)
+	}
+	chunkSize := a.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	logFile := NewLogFile(logPath, chunkSize)
+
+	return agent.Run[Result](ctx, a.Base, agent.RunParams{
+		System: systemAnalyzer,
+		Prompt: promptAnalyzer(info.Size(), logFile.NumChunks(info.Size())),
+		Tools:  logFile.Tools(),
+	})
+}
+
+const systemAnalyzer = "You are an expert CI/CD engineer. Your task is to find out why a build failed by analyzing its log. " +
+	"Logs can be very large, so do not read them from the start: search for errors first, " +
+	"then read the chunks around the matches. The end of the log usually contains the failing step."
+
+func promptAnalyzer(size int64, numChunks int) string {
+	return fmt.Sprintf(
+		"Analyze the build log (%d bytes, %d chunks) and return the diagnosis by calling the %q tool.",
+		size, numChunks, tool.FinalResultToolName,
+	)
+}
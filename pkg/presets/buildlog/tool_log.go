@@ -0,0 +1,171 @@
+package buildlog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+const (
+	defaultMaxMatches = 50
+	maxMatchLength    = 300
+	maxLineLength     = 64 * 1024
+)
+
+// LogFile gives the agent bounded-memory access to a potentially huge log
+// file: it is read chunk by chunk with ReadAt and searched line by line.
+type LogFile struct {
+	path      string
+	chunkSize int
+}
+
+func NewLogFile(path string, chunkSize int) *LogFile {
+	return &LogFile{path: path, chunkSize: chunkSize}
+}
+
+func (lf *LogFile) NumChunks(size int64) int {
+	return int((size + int64(lf.chunkSize) - 1) / int64(lf.chunkSize))
+}
+
+// Tools returns the tool definitions operating on the log file.
+func (lf *LogFile) Tools() []tool.Definition {
+	return []tool.Definition{
+		tool.New(
+			"ReadLogChunk",
+			fmt.Sprintf("Reads a chunk of the build log. Chunks are %d bytes long. "+
+				"Negative chunk indexes count from the end of the log (-1 is the last chunk).", lf.chunkSize),
+			lf.readChunk,
+		),
+		tool.New(
+			"SearchLog",
+			"Searches the build log line by line with a regular expression (RE2 syntax). "+
+				"Returns the matching lines with their line numbers and the index of the chunk containing them.",
+			lf.search,
+		),
+	}
+}
+
+type readChunkInput struct {
+	Chunk int `json:"chunk" jsonschema_description:"Index of the chunk to read (0-based). Negative values count from the end."`
+}
+
+func (lf *LogFile) readChunk(_ context.Context, input readChunkInput) (string, error) {
+	file, err := os.Open(lf.path)
+	if err != nil {
+		return "", fmt.Errorf("open log: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat log: %w", err)
+	}
+	numChunks := lf.NumChunks(info.Size())
+	chunk := input.Chunk
+	if chunk < 0 {
+		chunk += numChunks
+	}
+	if chunk < 0 || chunk >= numChunks {
+		return "", fmt.Errorf("chunk %d out of range, the log has %d chunks", input.Chunk, numChunks)
+	}
+
+	buf := make([]byte, lf.chunkSize)
+	start := int64(chunk) * int64(lf.chunkSize)
+	n, err := file.ReadAt(buf, start)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read log: %w", err)
+	}
+	return fmt.Sprintf(
+		"chunk %d/%d (bytes %d-%d of %d):\n%s",
+		chunk, numChunks-1, start, start+int64(n), info.Size(), strings.ToValidUTF8(string(buf[:n]), "�"),
+	), nil
+}
+
+type searchInput struct {
+	Pattern    string `json:"pattern" jsonschema_description:"The regular expression to search for, e.g. '(?i)error|failed'"`
+	MaxMatches int    `json:"max_matches,omitempty" jsonschema_description:"Maximum number of matches to return. Defaults to 50."`
+}
+
+func (lf *LogFile) search(ctx context.Context, input searchInput) (string, error) {
+	re, err := regexp.Compile(input.Pattern)
+	if err != nil {
+		return "", fmt.Errorf("compile pattern: %w", err)
+	}
+	maxMatches := input.MaxMatches
+	if maxMatches <= 0 {
+		maxMatches = defaultMaxMatches
+	}
+
+	file, err := os.Open(lf.path)
+	if err != nil {
+		return "", fmt.Errorf("open log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var matches []string
+	var offset int64
+	var total int
+	for lineNum := 1; ; lineNum++ {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		line, n, err := readLine(reader)
+		if n == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("read log: %w", err)
+		}
+		if re.Match(line) {
+			total++
+			if len(matches) < maxMatches {
+				s := strings.ToValidUTF8(string(line), "�")
+				if len(s) > maxMatchLength {
+					s = truncate.Head(s, maxMatchLength) + "..."
+				}
+				matches = append(matches, fmt.Sprintf("%d (chunk %d): %s", lineNum, offset/int64(lf.chunkSize), s))
+			}
+		}
+		offset += n
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	if total == 0 {
+		return "No matches found.", nil
+	}
+	result := strings.Join(matches, "\n")
+	if total > len(matches) {
+		result += fmt.Sprintf("\n\n%d more matches omitted, refine the pattern to narrow the results.", total-len(matches))
+	}
+	return result, nil
+}
+
+// readLine reads the next line without the line terminator, and returns it
+// along with the number of bytes consumed. Lines longer than maxLineLength are
+// cut, but fully consumed.
+func readLine(r *bufio.Reader) ([]byte, int64, error) {
+	var line []byte
+	var n int64
+	for {
+		fragment, err := r.ReadSlice('\n')
+		n += int64(len(fragment))
+		if len(line) < maxLineLength {
+			line = append(line, fragment[:min(len(fragment), maxLineLength-len(line))]...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		return []byte(strings.TrimRight(string(line), "\r\n")), n, err
+	}
+}
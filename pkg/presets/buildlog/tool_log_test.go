@@ -0,0 +1,27 @@
+package buildlog
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestSearchLongLine matches a line cut inside a multi-byte character, the
+// match must stay valid UTF-8.
+func TestSearchLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.log")
+	line := "error: " + strings.Repeat("ő", maxMatchLength)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewLogFile(path, 1024).search(context.Background(), searchInput{Pattern: "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.ValidString(got) || strings.Contains(got, "�") {
+		t.Errorf("the match is not valid UTF-8: %q", got)
+	}
+}
@@ -0,0 +1,158 @@
+// Package compress shrinks large artifacts (build logs, diffs) before they are
+// injected into a prompt, keeping the parts most relevant to the model.
+package compress
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/prompt/sanitize"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+const (
+	DefaultHeadLines      = 50
+	DefaultTailLines      = 200
+	DefaultErrorContext   = 5
+	charsPerTokenEstimate = 4
+)
+
+// DefaultErrorPattern matches the lines worth keeping from the middle of a log.
+var DefaultErrorPattern = regexp.MustCompile(`(?i)\b(error|fail(ed|ure)?|fatal|panic|exception|traceback)\b`)
+
+type Options struct {
+	// MaxTokens is the target token budget of the result (mandatory). Tokens
+	// are estimated, see EstimateTokens.
+	MaxTokens int
+	// HeadLines and TailLines are kept from the beginning and the end of the
+	// input. Default to DefaultHeadLines and DefaultTailLines.
+	HeadLines int
+	TailLines int
+	// ErrorContext is the number of lines kept around error lines. Defaults
+	// to DefaultErrorContext.
+	ErrorContext int
+	// ErrorPattern selects the error lines. Defaults to DefaultErrorPattern.
+	ErrorPattern *regexp.Regexp
+	// KeepTimestamps disables stripping the timestamps at the start of lines.
+	KeepTimestamps bool
+}
+
+var (
+	timestampPattern = regexp.MustCompile(`^\[?\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?\]?\s*|^\[?\d{2}:\d{2}:\d{2}(\.\d+)?\]?\s+`)
+)
+
+// EstimateTokens returns a rough estimate of the number of tokens of s.
+func EstimateTokens(s string) int {
+	return (len(s) + charsPerTokenEstimate - 1) / charsPerTokenEstimate
+}
+
+// Compress cleans up s (ANSI codes, timestamps, repeated lines) and, if it is
+// still over the token budget, keeps its head, tail and the windows around
+// error lines. Omitted parts are replaced with a marker line.
+func Compress(s string, o Options) string {
+	lines := Clean(s, o.KeepTimestamps)
+	if o.MaxTokens <= 0 || EstimateTokens(strings.Join(lines, "\n")) <= o.MaxTokens {
+		return strings.Join(lines, "\n")
+	}
+
+	head := withDefault(o.HeadLines, DefaultHeadLines)
+	tail := withDefault(o.TailLines, DefaultTailLines)
+	errorContext := withDefault(o.ErrorContext, DefaultErrorContext)
+	errorPattern := o.ErrorPattern
+	if errorPattern == nil {
+		errorPattern = DefaultErrorPattern
+	}
+
+	// Shrink the windows until the result fits the budget.
+	for {
+		result := render(lines, selectLines(lines, head, tail, errorContext, errorPattern))
+		if EstimateTokens(result) <= o.MaxTokens {
+			return result
+		}
+		if head <= 1 && tail <= 1 && errorContext == 0 {
+			return truncate.Middle(result, o.MaxTokens*charsPerTokenEstimate, "\n[... truncated ...]\n")
+		}
+		head /= 2
+		tail /= 2
+		errorContext /= 2
+	}
+}
+
+// Clean sanitizes s (see sanitize.NormalizeEncoding and sanitize.StripANSI),
+// strips leading timestamps (unless keepTimestamps is set), then collapses
+// consecutive repeated lines.
+func Clean(s string, keepTimestamps bool) []string {
+	var lines []string
+	var last string
+	var repeated int
+	flush := func() {
+		if repeated > 0 {
+			lines = append(lines, fmt.Sprintf("[previous line repeated %d more times]", repeated))
+			repeated = 0
+		}
+	}
+	s = sanitize.StripANSI(sanitize.NormalizeEncoding(s))
+	for i, line := range strings.Split(s, "\n") {
+		if !keepTimestamps {
+			line = timestampPattern.ReplaceAllString(line, "")
+		}
+		line = strings.TrimRight(line, " \t")
+		if i > 0 && line == last {
+			repeated++
+			continue
+		}
+		flush()
+		lines = append(lines, line)
+		last = line
+	}
+	flush()
+	return lines
+}
+
+func selectLines(lines []string, head, tail, errorContext int, errorPattern *regexp.Regexp) []bool {
+	keep := make([]bool, len(lines))
+	for i := 0; i < min(head, len(lines)); i++ {
+		keep[i] = true
+	}
+	for i := max(len(lines)-tail, 0); i < len(lines); i++ {
+		keep[i] = true
+	}
+	for i, line := range lines {
+		if keep[i] || !errorPattern.MatchString(line) {
+			continue
+		}
+		for j := max(i-errorContext, 0); j <= min(i+errorContext, len(lines)-1); j++ {
+			keep[j] = true
+		}
+	}
+	return keep
+}
+
+func render(lines []string, keep []bool) string {
+	var sb strings.Builder
+	var omitted int
+	for i, line := range lines {
+		if !keep[i] {
+			omitted++
+			continue
+		}
+		if omitted > 0 {
+			fmt.Fprintf(&sb, "[... %d lines omitted ...]\n", omitted)
+			omitted = 0
+		}
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&sb, "[... %d lines omitted ...]\n", omitted)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func withDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
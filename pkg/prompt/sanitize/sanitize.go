@@ -0,0 +1,78 @@
+// Package sanitize cleans up text (typically CI logs) before it is sent to a
+// model: escape sequences and broken encodings waste tokens and confuse models.
+package sanitize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Func transforms a text content.
+type Func func(string) string
+
+// Chain applies the functions in order.
+func Chain(fns ...Func) Func {
+	return func(s string) string {
+		for _, fn := range fns {
+			s = fn(s)
+		}
+		return s
+	}
+}
+
+// Default strips ANSI escape codes, normalizes the encoding and collapses
+// whitespace.
+var Default = Chain(NormalizeEncoding, StripANSI, CollapseWhitespace)
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[()][0-9A-Za-z]|\x1b[=>]`)
+
+// StripANSI removes ANSI escape sequences (colors, cursor movement,
+// hyperlinks).
+func StripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiPattern.ReplaceAllString(s, "")
+}
+
+// NormalizeEncoding replaces invalid UTF-8 sequences, removes the byte order
+// mark and control characters (except tabs, newlines and the escape character,
+// see StripANSI), converts CRLF line endings and resolves carriage return
+// overwrites (e.g. progress bars) to their final state.
+func NormalizeEncoding(s string) string {
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, string(utf8.RuneError))
+	}
+	s = strings.TrimPrefix(s, "\ufeff")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if strings.Contains(s, "\r") {
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if idx := strings.LastIndex(line, "\r"); idx >= 0 {
+				lines[i] = line[idx+1:]
+			}
+		}
+		s = strings.Join(lines, "\n")
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || r == '\x1b' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// CollapseWhitespace removes trailing whitespace from lines and collapses
+// runs of blank lines into a single one. Indentation is kept, as it is
+// meaningful in code and YAML.
+func CollapseWhitespace(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+}
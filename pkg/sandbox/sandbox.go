@@ -0,0 +1,226 @@
+// Package sandbox runs the commands of tools in a container (Docker or
+// Podman, optionally with gVisor), isolating agents executing untrusted,
+// model-generated commands from the host. The workspace is mounted into the
+// container, so the file tools working on the host (confined to the same
+// directory, see jail.Jail) and the commands see the same files.
+package sandbox
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jail"
+)
+
+type Runtime string
+
+const (
+	RuntimeDocker Runtime = "docker"
+	RuntimePodman Runtime = "podman"
+)
+
+// Network is the network mode of the container.
+type Network string
+
+const (
+	// NetworkNone disables the network, the default.
+	NetworkNone Network = "none"
+	// NetworkBridge gives the container access to the network of the host
+	// through NAT.
+	NetworkBridge Network = "bridge"
+)
+
+// DefaultMountPath is where the workspace is mounted in the container.
+const DefaultMountPath = "/workspace"
+
+type Config struct {
+	// Image of the container (mandatory), it must contain sh and the
+	// programs the tools run (e.g. git).
+	Image string
+	// Runtime is the container CLI, defaults to RuntimeDocker.
+	Runtime Runtime
+	// OCIRuntime selects the low-level runtime of the container (optional),
+	// e.g. "runsc" to run it in a gVisor kernel.
+	OCIRuntime string
+	// Workspace is the host directory mounted into the container, defaults
+	// to the current directory.
+	Workspace string
+	// MountPath is where Workspace is mounted, defaults to DefaultMountPath.
+	MountPath string
+	// ReadOnly mounts the workspace read-only.
+	ReadOnly bool
+	// Network defaults to NetworkNone.
+	Network Network
+	// Resource limits of the container (optional).
+	CPUs        float64
+	MemoryBytes int64
+	PidsLimit   int
+	// User runs the commands as "uid[:gid]" (optional), e.g. the owner of
+	// the workspace so the files written in the container are owned by it.
+	User string
+	// Env is set in the container ("KEY=value").
+	Env []string
+}
+
+// Sandbox is a running container. The commands run with `exec` in it, so
+// their state (e.g. installed dependencies) is kept until Close.
+type Sandbox struct {
+	cfg       Config
+	id        string
+	workspace *jail.Jail
+	// commands numbers the PID files of the commands, see Command.
+	commands atomic.Int64
+}
+
+// Start starts the container. It runs until Close is called.
+func Start(ctx context.Context, cfg Config) (*Sandbox, error) {
+	if cfg.Image == "" {
+		return nil, fmt.Errorf("sandbox image is required")
+	}
+	cfg.Runtime = cmp.Or(cfg.Runtime, RuntimeDocker)
+	cfg.MountPath = cmp.Or(cfg.MountPath, DefaultMountPath)
+	cfg.Network = cmp.Or(cfg.Network, NetworkNone)
+	workspace, err := jail.New(cfg.Workspace)
+	if err != nil {
+		return nil, fmt.Errorf("sandbox workspace: %w", err)
+	}
+
+	out, err := run(ctx, string(cfg.Runtime), cfg.runArgs(workspace.Root())...)
+	if err != nil {
+		return nil, fmt.Errorf("start sandbox: %w", err)
+	}
+	return &Sandbox{cfg: cfg, id: strings.TrimSpace(out), workspace: workspace}, nil
+}
+
+func (cfg Config) runArgs(workspace string) []string {
+	mount := workspace + ":" + cfg.MountPath
+	if cfg.ReadOnly {
+		mount += ":ro"
+	}
+	args := []string{
+		"run", "--detach", "--rm", "--init",
+		"--network", string(cfg.Network),
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges",
+		"--volume", mount,
+		"--workdir", cfg.MountPath,
+	}
+	if cfg.OCIRuntime != "" {
+		args = append(args, "--runtime", cfg.OCIRuntime)
+	}
+	if cfg.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(cfg.CPUs, 'f', -1, 64))
+	}
+	if cfg.MemoryBytes > 0 {
+		args = append(args, "--memory", strconv.FormatInt(cfg.MemoryBytes, 10))
+	}
+	if cfg.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(cfg.PidsLimit))
+	}
+	if cfg.User != "" {
+		args = append(args, "--user", cfg.User)
+	}
+	for _, env := range cfg.Env {
+		args = append(args, "--env", env)
+	}
+	return append(args, cfg.Image, "sleep", "infinity")
+}
+
+// ID returns the ID of the container.
+func (s *Sandbox) ID() string {
+	return s.id
+}
+
+// Command returns a command running name in the container. dir is the
+// working directory on the host, it must be inside the workspace (empty for
+// the workspace root).
+//
+// Canceling ctx kills the command in the container with its child
+// processes: killing the exec client alone would leave them running. The
+// command records its PID in a file under /tmp of the container for this.
+func (s *Sandbox) Command(ctx context.Context, dir string, name string, args ...string) (*exec.Cmd, error) {
+	rel, err := s.workspace.Rel(dir)
+	if err != nil {
+		return nil, err
+	}
+	workdir := path.Join(s.cfg.MountPath, rel)
+	pidFile := fmt.Sprintf("/tmp/sandbox-exec-%d.pid", s.commands.Add(1))
+	execArgs := append([]string{
+		"exec", "--interactive", "--workdir", workdir, s.id,
+		"sh", "-c", `echo $$ >"$0" && exec "$@"`, pidFile, name,
+	}, args...)
+	cmd := exec.CommandContext(ctx, string(s.cfg.Runtime), execArgs...)
+	cmd.Cancel = func() error {
+		s.kill(pidFile)
+		return cmd.Process.Kill()
+	}
+	return cmd, nil
+}
+
+// killScript kills the process whose PID is in the file $0 and its
+// descendants, stopping them first so they can't fork new ones meanwhile.
+const killScript = `pid=$(cat "$0" 2>/dev/null) || exit 0
+kill_tree() {
+	kill -STOP "$1" 2>/dev/null
+	for child in $(cat /proc/"$1"/task/*/children 2>/dev/null); do
+		kill_tree "$child"
+	done
+	kill -KILL "$1" 2>/dev/null
+}
+kill_tree "$pid"
+rm -f "$0"`
+
+// kill kills the command of the PID file in the container. It's best effort:
+// the command may have exited already.
+func (s *Sandbox) kill(pidFile string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	run(ctx, string(s.cfg.Runtime), "exec", s.id, "sh", "-c", killScript, pidFile)
+}
+
+// Close removes the container.
+func (s *Sandbox) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := run(ctx, string(s.cfg.Runtime), "rm", "--force", s.id); err != nil {
+		return fmt.Errorf("remove sandbox: %w", err)
+	}
+	return nil
+}
+
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s: %w: %s", name, args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the sandbox the tools should run
+// their commands in.
+func NewContext(ctx context.Context, s *Sandbox) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the sandbox of the run, or nil if the run is not
+// sandboxed.
+func FromContext(ctx context.Context) *Sandbox {
+	s, _ := ctx.Value(contextKey{}).(*Sandbox)
+	return s
+}
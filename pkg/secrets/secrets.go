@@ -0,0 +1,95 @@
+// Package secrets provides the credentials of the tools (e.g. the Bitrise API
+// or VCS tokens). The secrets of a run are injected into the context of the
+// tool calls (see core.NewAgentParams.Secrets), so the tools fetch them when
+// needed instead of reading the environment.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned (wrapped) for unknown secrets.
+var ErrNotFound = errors.New("secret not found")
+
+type Secrets interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// Env reads the secrets from environment variables.
+type Env struct {
+	// Prefix is prepended to the names (optional), e.g. "AGENT_".
+	Prefix string
+}
+
+func (e Env) Get(_ context.Context, name string) (string, error) {
+	v, ok := os.LookupEnv(e.Prefix + name)
+	if !ok || v == "" {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, e.Prefix+name)
+	}
+	return v, nil
+}
+
+// Files reads each secret from a file named after it, like the secrets
+// mounted by Docker or Kubernetes (e.g. /run/secrets/GITHUB_TOKEN).
+type Files struct {
+	Dir string
+}
+
+func (f Files) Get(_ context.Context, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(f.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("read secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Chain returns the secret of the first provider having it.
+func Chain(providers ...Secrets) Secrets {
+	return chain(providers)
+}
+
+type chain []Secrets
+
+func (c chain) Get(ctx context.Context, name string) (string, error) {
+	for _, s := range c {
+		v, err := s.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return v, err
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the secrets of the run.
+func NewContext(ctx context.Context, s Secrets) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the secrets of the run, or nil.
+func FromContext(ctx context.Context) Secrets {
+	s, _ := ctx.Value(contextKey{}).(Secrets)
+	return s
+}
+
+// Get fetches a secret from the secrets of the run.
+func Get(ctx context.Context, name string) (string, error) {
+	s := FromContext(ctx)
+	if s == nil {
+		return "", fmt.Errorf("%w: %s (no secrets provider)", ErrNotFound, name)
+	}
+	return s.Get(ctx, name)
+}
@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault reads the secrets from a HashiCorp Vault KV (version 2) secret, the
+// names are the keys of its data. The secret is read once and cached.
+type Vault struct {
+	// Address defaults to the VAULT_ADDR environment variable.
+	Address string
+	// Token defaults to the VAULT_TOKEN environment variable.
+	Token string
+	// Mount is the mount path of the KV engine, defaults to "secret".
+	Mount string
+	// Path of the secret within the mount (mandatory), e.g. "ci/agent".
+	Path       string
+	HTTPClient *http.Client
+
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (v *Vault) Get(ctx context.Context, name string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.data == nil {
+		data, err := v.read(ctx)
+		if err != nil {
+			return "", err
+		}
+		v.data = data
+	}
+	value, ok := v.data[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+func (v *Vault) read(ctx context.Context) (map[string]string, error) {
+	addr := cmp.Or(v.Address, os.Getenv("VAULT_ADDR"))
+	if addr == "" || v.Path == "" {
+		return nil, fmt.Errorf("vault address and path are required")
+	}
+	u := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(addr, "/"), cmp.Or(v.Mount, "secret"), strings.Trim(v.Path, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", cmp.Or(v.Token, os.Getenv("VAULT_TOKEN")))
+	httpClient := v.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("read vault secret: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The body may echo the request, don't include it.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("read vault secret %s: unexpected status %s", v.Path, resp.Status)
+	}
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode vault secret: %w", err)
+	}
+	data := map[string]string{}
+	for k, val := range body.Data.Data {
+		if s, ok := val.(string); ok {
+			data[k] = s
+		} else {
+			data[k] = fmt.Sprint(val)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,262 @@
+// Package simulate estimates the cost and the wall time of orchestrations
+// from recorded runs, without calling a model, for capacity planning (e.g.
+// "review 5,000 files with model X"). Record a few representative runs (the
+// session files), then either estimate a plan with Estimate, or run the
+// orchestration itself with a Provider replaying the recorded responses.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/clock"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// Pricing are the prices of a model in USD per million tokens.
+type Pricing struct {
+	Input      float64
+	Output     float64
+	CacheWrite float64
+	CacheRead  float64
+}
+
+// Cost returns the price of the usage in USD.
+func (p Pricing) Cost(u llm.TokenUsage) float64 {
+	return (float64(u.InputTokens)*p.Input +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.CacheCreationTokens)*p.CacheWrite +
+		float64(u.CacheReadTokens)*p.CacheRead) / 1e6
+}
+
+// Latency models the response time of a model.
+type Latency struct {
+	// PerRequest is the time to the first token, including the network.
+	PerRequest time.Duration
+	// PerOutputToken is the generation time of an output token.
+	PerOutputToken time.Duration
+}
+
+// Of returns the response time of a request with the usage.
+func (l Latency) Of(u llm.TokenUsage) time.Duration {
+	return l.PerRequest + time.Duration(u.OutputTokens)*l.PerOutputToken
+}
+
+// Run is a recorded run of an agent: its assistant messages, with their token
+// usage and tool calls.
+type Run []llm.Message
+
+// RecordedRuns extracts the runs from histories, e.g. the messages of session
+// files (see core.ReadSession). Histories without assistant messages are left
+// out.
+func RecordedRuns(histories ...[]llm.Message) []Run {
+	var runs []Run
+	for _, history := range histories {
+		var run Run
+		for _, msg := range history {
+			if msg.Role == llm.RoleAssistant {
+				run = append(run, msg)
+			}
+		}
+		if len(run) > 0 {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Usage returns the total usage of the run.
+func (r Run) Usage() llm.TokenUsage {
+	return llm.History(r).Usage()
+}
+
+// Plan describes an orchestration to estimate.
+type Plan struct {
+	// Items is the number of items processed by an agent each, e.g. files
+	// to review (mandatory).
+	Items int
+	// Runs are recorded runs of single items (mandatory), the items are
+	// assumed to cost their average.
+	Runs []Run
+	// Concurrency is the number of agents running at the same time,
+	// defaults to 1.
+	Concurrency int
+	Pricing     Pricing
+	Latency     Latency
+	// ToolTime is the time the tools of a turn take, added after each
+	// response with tool calls (optional).
+	ToolTime time.Duration
+	// TokensPerMinute is the rate limit of the model (optional), the wall
+	// time can't be shorter than the time to spend the tokens under it.
+	TokensPerMinute int
+}
+
+// Estimation is the estimated cost and wall time of a Plan.
+type Estimation struct {
+	Requests int
+	Usage    llm.TokenUsage
+	// Cost is in USD.
+	Cost float64
+	// ItemTime is the average time of an item, WallTime is the time of the
+	// whole plan.
+	ItemTime time.Duration
+	WallTime time.Duration
+	// RateLimited is set if the rate limit determines the wall time.
+	RateLimited bool
+}
+
+func (e Estimation) String() string {
+	return fmt.Sprintf("%d requests, %d tokens, %.2f USD, %s (%s per item)",
+		e.Requests, e.Usage.Total(), e.Cost, e.WallTime.Round(time.Second), e.ItemTime.Round(time.Millisecond))
+}
+
+// Estimate estimates the cost and the wall time of the plan from the averages
+// of its recorded runs.
+func Estimate(p Plan) (Estimation, error) {
+	if p.Items <= 0 {
+		return Estimation{}, fmt.Errorf("no items")
+	}
+	if len(p.Runs) == 0 {
+		return Estimation{}, fmt.Errorf("no recorded runs")
+	}
+	concurrency := max(p.Concurrency, 1)
+
+	var requests int
+	var usage llm.TokenUsage
+	var itemTime time.Duration
+	for _, run := range p.Runs {
+		requests += len(run)
+		for _, msg := range run {
+			itemTime += p.Latency.Of(msg.Usage)
+			if hasToolCalls(msg) {
+				itemTime += p.ToolTime
+			}
+		}
+		usage = usage.Add(run.Usage())
+	}
+	runs := float64(len(p.Runs))
+	items := float64(p.Items)
+	e := Estimation{
+		Requests: int(math.Round(float64(requests) / runs * items)),
+		Usage:    scaleUsage(usage, items/runs),
+		ItemTime: time.Duration(float64(itemTime) / runs),
+	}
+	e.Cost = p.Pricing.Cost(e.Usage)
+	batches := (p.Items + concurrency - 1) / concurrency
+	e.WallTime = time.Duration(batches) * e.ItemTime
+	if p.TokensPerMinute > 0 {
+		limited := time.Duration(float64(e.Usage.Total()) / float64(p.TokensPerMinute) * float64(time.Minute))
+		if limited > e.WallTime {
+			e.WallTime, e.RateLimited = limited, true
+		}
+	}
+	return e, nil
+}
+
+func scaleUsage(u llm.TokenUsage, f float64) llm.TokenUsage {
+	scale := func(n int64) int64 { return int64(math.Round(float64(n) * f)) }
+	return llm.TokenUsage{
+		InputTokens:         scale(u.InputTokens),
+		OutputTokens:        scale(u.OutputTokens),
+		CacheCreationTokens: scale(u.CacheCreationTokens),
+		CacheReadTokens:     scale(u.CacheReadTokens),
+	}
+}
+
+func hasToolCalls(msg llm.Message) bool {
+	for _, part := range msg.Parts {
+		if _, ok := part.(llm.ToolCall); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Provider is an llm.Provider which doesn't call a model: it responds with the
+// messages of the recorded runs, accounting their usage, cost and latency
+// (see Stats). It lets an orchestration run as is to measure it, e.g. with
+// its real concurrency and budgets. The tools are called with the recorded
+// inputs, run the agents with stub tools or in dry-run mode.
+//
+// The nth response of a conversation is the nth message of a recorded run,
+// the run is selected by the first message of the conversation. The last
+// message of the run is repeated if the conversation is longer. It's safe
+// for concurrent use.
+type Provider struct {
+	// Runs are the recorded runs to replay (mandatory).
+	Runs    []Run
+	Pricing Pricing
+	Latency Latency
+	// Wait waits the latency on the clock of the requests, e.g. a
+	// clock.Fake, instead of only accounting it.
+	Wait bool
+
+	mu    sync.Mutex
+	stats Stats
+}
+
+// Stats are the totals of the requests sent to a Provider.
+type Stats struct {
+	Requests int
+	Usage    llm.TokenUsage
+	// Cost is in USD.
+	Cost float64
+	// Latency is the sum of the response times, the wall time of the
+	// requests if they are sequential.
+	Latency time.Duration
+}
+
+func (p *Provider) NewMessage(ctx context.Context, params llm.NewMessageParams) (llm.Message, error) {
+	if len(p.Runs) == 0 {
+		return llm.Message{}, fmt.Errorf("no recorded runs")
+	}
+	run := p.Runs[runIndex(params.History, len(p.Runs))]
+	var turn int
+	for _, msg := range params.History {
+		if msg.Role == llm.RoleAssistant {
+			turn++
+		}
+	}
+	msg := run[min(turn, len(run)-1)]
+	msg.Metadata = nil
+	latency := p.Latency.Of(msg.Usage)
+
+	p.mu.Lock()
+	p.stats.Requests++
+	p.stats.Usage = p.stats.Usage.Add(msg.Usage)
+	p.stats.Cost += p.Pricing.Cost(msg.Usage)
+	p.stats.Latency += latency
+	p.mu.Unlock()
+
+	if p.Wait && latency > 0 {
+		select {
+		case <-clock.Or(params.Clock).After(latency):
+		case <-ctx.Done():
+			return llm.Message{}, ctx.Err()
+		}
+	}
+	return msg, nil
+}
+
+// Stats returns the totals of the requests so far.
+func (p *Provider) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// runIndex selects the recorded run of a conversation by its first message.
+func runIndex(history []llm.Message, runs int) int {
+	if len(history) == 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	for _, part := range history[0].Parts {
+		fmt.Fprintf(h, "%v", part)
+	}
+	return int(h.Sum32() % uint32(runs))
+}
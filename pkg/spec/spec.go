@@ -0,0 +1,216 @@
+// Package spec loads agent definitions from YAML (or JSON) files, so agents
+// can be shipped and tweaked as configuration instead of Go code.
+package spec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/agent"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/invopop/jsonschema"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the definition of an agent. Example:
+//
+//	name: reviewer
+//	model:
+//	  provider: anthropic
+//	system: You are a code reviewer.
+//	tools: [git, search]
+//	max_token_usage: 500000
+//	timebox: 5m
+//	result_schema:
+//	  type: object
+//	  properties:
+//	    summary: {type: string}
+//	  required: [summary]
+type Spec struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Model       Model  `yaml:"model"`
+	// System is the system prompt (mandatory).
+	System string `yaml:"system"`
+	// Prompt is the default prompt of a run.
+	Prompt string `yaml:"prompt"`
+	// Tools are the names of the tools, resolved by a ToolLookup.
+	Tools            []string      `yaml:"tools"`
+	MaxTokenUsage    int           `yaml:"max_token_usage"`
+	MaxToolLogLength int           `yaml:"max_tool_log_length"`
+	Timebox          time.Duration `yaml:"timebox"`
+	Planning         bool          `yaml:"planning"`
+	// ResultSchema is the JSON schema of the result, it must describe an
+	// object. If empty, the result is a string.
+	ResultSchema map[string]any `yaml:"result_schema"`
+}
+
+type Model struct {
+	Provider        string              `yaml:"provider"`
+	Name            string              `yaml:"name"`
+	MaxOutputTokens int                 `yaml:"max_output_tokens"`
+	ReasoningEffort llm.ReasoningEffort `yaml:"reasoning_effort"`
+	Verbosity       llm.Verbosity       `yaml:"verbosity"`
+	// WebSearch enables the web search of the provider (Anthropic and
+	// Gemini only).
+	WebSearch bool `yaml:"web_search"`
+}
+
+// ToolLookup resolves a tool name of a spec to tool definitions. A name can
+// refer to a single tool or to a whole toolset, see tool.Registry.Lookup.
+type ToolLookup func(name string) ([]tool.Definition, error)
+
+// Load reads and parses a spec file.
+func Load(filePath string) (Spec, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return Spec{}, fmt.Errorf("read file: %w", err)
+	}
+	s, err := Parse(b)
+	if err != nil {
+		return Spec{}, fmt.Errorf("parse %s: %w", filePath, err)
+	}
+	return s, nil
+}
+
+// Parse parses a YAML or JSON spec and validates it.
+func Parse(b []byte) (Spec, error) {
+	var s Spec
+	if err := yaml.Unmarshal(b, &s); err != nil {
+		return Spec{}, fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return Spec{}, err
+	}
+	return s, nil
+}
+
+func (s Spec) Validate() error {
+	if s.System == "" {
+		return fmt.Errorf("missing system prompt")
+	}
+	if s.MaxTokenUsage < 0 || s.MaxToolLogLength < 0 || s.Timebox < 0 {
+		return fmt.Errorf("budgets must not be negative")
+	}
+	if _, err := s.Schema(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// LLMModel returns the model of the spec with the defaults applied.
+func (s Spec) LLMModel() (llm.Model, error) {
+	model := llm.Model{
+		Provider:        llm.ProviderName(s.Model.Provider),
+		Name:            s.Model.Name,
+		MaxOutputTokens: s.Model.MaxOutputTokens,
+		ReasoningEffort: s.Model.ReasoningEffort,
+		Verbosity:       s.Model.Verbosity,
+	}
+	if s.Model.WebSearch {
+		model.HostedSearch = &llm.HostedSearchConfig{}
+	}
+	if err := model.SetDefaults(); err != nil {
+		return llm.Model{}, fmt.Errorf("set defaults on model: %w", err)
+	}
+	return model, nil
+}
+
+// Schema returns the result schema, or nil if the result is a string.
+func (s Spec) Schema() (*jsonschema.Schema, error) {
+	if len(s.ResultSchema) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(s.ResultSchema)
+	if err != nil {
+		return nil, fmt.Errorf("marshal result schema: %w", err)
+	}
+	var schema jsonschema.Schema
+	if err := json.Unmarshal(b, &schema); err != nil {
+		return nil, fmt.Errorf("unmarshal result schema: %w", err)
+	}
+	if schema.Type != "object" {
+		return nil, fmt.Errorf("result schema must describe an object, got %q", schema.Type)
+	}
+	return &schema, nil
+}
+
+// ToolDefinitions resolves the tools of the spec. If lookup is nil, the tools
+// are resolved from tool.DefaultRegistry.
+func (s Spec) ToolDefinitions(lookup ToolLookup) ([]tool.Definition, error) {
+	if lookup == nil {
+		lookup = tool.DefaultRegistry.Lookup(tool.FactoryParams{})
+	}
+	var defs []tool.Definition
+	for _, name := range s.Tools {
+		d, err := lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("tool %q: %w", name, err)
+		}
+		defs = append(defs, d...)
+	}
+	return defs, nil
+}
+
+// NewBase creates the agent base of the spec.
+func (s Spec) NewBase(logger *slog.Logger) (*agent.Base, error) {
+	model, err := s.LLMModel()
+	if err != nil {
+		return nil, err
+	}
+	return &agent.Base{
+		Model:            model,
+		MaxToolLogLength: s.MaxToolLogLength,
+		Logger:           logger,
+		MaxTokenUsage:    s.MaxTokenUsage,
+		Timebox:          s.Timebox,
+	}, nil
+}
+
+// RunParams returns the parameters of a run. The prompt of the spec is used
+// if prompt is empty.
+func (s Spec) RunParams(prompt string, lookup ToolLookup) (agent.RunParams, error) {
+	if prompt == "" {
+		prompt = s.Prompt
+	}
+	if prompt == "" {
+		return agent.RunParams{}, fmt.Errorf("missing prompt")
+	}
+	tools, err := s.ToolDefinitions(lookup)
+	if err != nil {
+		return agent.RunParams{}, err
+	}
+	schema, err := s.Schema()
+	if err != nil {
+		return agent.RunParams{}, err
+	}
+	return agent.RunParams{
+		System:       s.System,
+		Prompt:       prompt,
+		Tools:        tools,
+		Planning:     s.Planning,
+		ResultSchema: schema,
+	}, nil
+}
+
+// Run runs the agent of the spec. The result is the JSON object described by
+// the result schema, or a JSON string if the spec has no result schema.
+func Run(ctx context.Context, b *agent.Base, p agent.RunParams) (json.RawMessage, agent.RunMeta, error) {
+	if p.ResultSchema != nil {
+		return agent.Run[json.RawMessage](ctx, b, p)
+	}
+	text, meta, err := agent.Run[string](ctx, b, p)
+	if err != nil {
+		return nil, meta, err
+	}
+	result, err := json.Marshal(text)
+	if err != nil {
+		return nil, meta, fmt.Errorf("marshal result: %w", err)
+	}
+	return result, meta, nil
+}
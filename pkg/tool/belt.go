@@ -1,11 +1,14 @@
 package tool
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
 	"github.com/invopop/jsonschema"
@@ -19,60 +22,190 @@ import (
 // to indicated that they are not required in the JSON schema (behaviour of the
 // github.com/invopop/jsonschema lib).
 type Belt[ResultT any] struct {
-	agent           agenter[ResultT]
+	agent agenter[ResultT]
+	// mu guards toolDefinitions, tools can be added and removed while
+	// others are running (see AddTools).
+	mu              sync.RWMutex
 	toolDefinitions map[string]Definition
+	// rawFinalResult is set if the final result is not wrapped, see
+	// NewBeltParams.FinalResultSchema.
+	rawFinalResult bool
+	// finalResultName is the name of the FinalResult tool, empty if it is
+	// disabled.
+	finalResultName string
+	planning        bool
+	findings        bool
 }
 
 type agenter[ResultT any] interface {
 	SetFinalResult(ResultT)
+	SetPlan(Plan)
+	AddFinding(context.Context, Finding)
 }
 
 type Definition struct {
 	llm.ToolDefinition
 	UseFunc func(context.Context, json.RawMessage) (string, error)
+	// Examples are example inputs, only used in the documentation (see Docs).
+	Examples []json.RawMessage
+	// MaxResultBytes limits the size of the results of the tool, overriding
+	// the limit of the agent (see core.NewAgentParams.MaxToolResultBytes).
+	MaxResultBytes int
+	// SummarizeAboveTokens condenses the results of the tool larger than
+	// this many tokens with the summarizer of the agent, overriding its
+	// threshold (see core.NewAgentParams.SummarizeToolResults). Set it for
+	// tools with huge outputs, e.g. build logs.
+	SummarizeAboveTokens int
+	// Mutating marks tools changing files or external systems (e.g. writing
+	// files, posting comments). In dry-run mode they are not called, see
+	// DryRunTool.
+	Mutating bool
+	// DryRunFunc describes what the tool would do with the input, without
+	// doing it (optional, see NewMutating).
+	DryRunFunc func(context.Context, json.RawMessage) (string, error)
 }
 
 type NewBeltParams[ResultT any] struct {
 	Agent agenter[ResultT]
+	// Tools are added to the built-in tools, the ones with the name of a
+	// built-in tool (see IsBuiltin) are ignored.
 	Tools []Definition
+	// EnablePlanning adds the built-in UpdatePlan tool.
+	EnablePlanning bool
+	// EnableFindings adds the built-in EmitFinding tool.
+	EnableFindings bool
+	// FinalResultSchema overrides the schema generated from ResultT, for
+	// results defined at runtime (e.g. ResultT is json.RawMessage). It must
+	// describe an object, which is unmarshaled into ResultT as is.
+	FinalResultSchema *jsonschema.Schema
+	// FinalResultName and FinalResultDescription override the name and the
+	// description of the FinalResult tool, e.g. if "FinalResult" conflicts
+	// with the prompt or its language (optional).
+	FinalResultName        string
+	FinalResultDescription string
+	// DisableFinalResult leaves the FinalResult tool out, for agents whose
+	// result is their last text response.
+	DisableFinalResult bool
 }
 
 func NewBelt[ResultT any](p NewBeltParams[ResultT]) *Belt[ResultT] {
-	tb := &Belt[ResultT]{agent: p.Agent}
+	tb := &Belt[ResultT]{agent: p.Agent, planning: p.EnablePlanning, findings: p.EnableFindings}
 
-	finalResultSchema := GenerateSchema[finalResultPrimitiveInput[ResultT]]()
-	if structResultType[ResultT]() {
-		finalResultSchema = GenerateSchema[ResultT]()
+	tb.toolDefinitions = map[string]Definition{}
+	if !p.DisableFinalResult {
+		tb.finalResultName = cmp.Or(p.FinalResultName, FinalResultToolName)
+		tb.toolDefinitions[tb.finalResultName] = tb.finalResultDefinition(p)
 	}
-	tb.toolDefinitions = map[string]Definition{
-		FinalResultToolName: {
+	if p.EnablePlanning {
+		tb.toolDefinitions[UpdatePlanToolName] = Definition{
 			ToolDefinition: llm.ToolDefinition{
-				Name:        FinalResultToolName,
-				Description: finalResultDescription,
-				Schema:      finalResultSchema,
+				Name:        UpdatePlanToolName,
+				Description: updatePlanDescription,
+				Schema:      GenerateSchema[Plan](),
 			},
-			UseFunc: tb.finalResult,
-		},
+			UseFunc: tb.updatePlan,
+		}
+	}
+	if p.EnableFindings {
+		tb.toolDefinitions[EmitFindingToolName] = Definition{
+			ToolDefinition: llm.ToolDefinition{
+				Name:        EmitFindingToolName,
+				Description: emitFindingDescription,
+				Schema:      GenerateSchema[Finding](),
+			},
+			UseFunc: tb.emitFinding,
+		}
 	}
 	for _, def := range p.Tools {
-		tb.toolDefinitions[def.Name] = Definition{
-			ToolDefinition: def.ToolDefinition,
-			UseFunc:        def.UseFunc,
+		// The built-in tools can't be replaced, as in AddTools.
+		if !tb.IsBuiltin(def.Name) {
+			tb.toolDefinitions[def.Name] = def
 		}
 	}
 
 	return tb
 }
 
+func (tb *Belt[ResultT]) finalResultDefinition(p NewBeltParams[ResultT]) Definition {
+	finalResultSchema := GenerateSchema[finalResultPrimitiveInput[ResultT]]()
+	switch {
+	case p.FinalResultSchema != nil:
+		finalResultSchema = p.FinalResultSchema
+		tb.rawFinalResult = true
+	case structResultType[ResultT]():
+		finalResultSchema = GenerateSchema[ResultT]()
+	}
+	return Definition{
+		ToolDefinition: llm.ToolDefinition{
+			Name:        tb.finalResultName,
+			Description: cmp.Or(p.FinalResultDescription, finalResultDescription),
+			Schema:      finalResultSchema,
+		},
+		UseFunc: tb.finalResult,
+	}
+}
+
 func (tb *Belt[ResultT]) UseTool(ctx context.Context, name string, input json.RawMessage) (string, error) {
-	toolFunc, ok := tb.toolDefinitions[name]
+	toolFunc, ok := tb.Definition(name)
 	if !ok {
 		return "", fmt.Errorf("unknown tool: %s", name)
 	}
 	return toolFunc.UseFunc(ctx, input)
 }
 
+// AddTools adds tools to the belt, replacing the ones with the same name.
+// The built-in tools (see IsBuiltin) can't be replaced, the definitions with
+// their names are ignored.
+func (tb *Belt[ResultT]) AddTools(defs ...Definition) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, def := range defs {
+		if !tb.IsBuiltin(def.Name) {
+			tb.toolDefinitions[def.Name] = def
+		}
+	}
+}
+
+// RemoveTools removes tools from the belt by name. The built-in tools (see
+// IsBuiltin) can't be removed, they and the unknown names are ignored.
+func (tb *Belt[ResultT]) RemoveTools(names ...string) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	for _, name := range names {
+		if !tb.IsBuiltin(name) {
+			delete(tb.toolDefinitions, name)
+		}
+	}
+}
+
+// DryRunTool is UseTool for dry-runs: mutating tools return the description
+// of their effect instead of being called.
+func (tb *Belt[ResultT]) DryRunTool(ctx context.Context, name string, input json.RawMessage) (string, error) {
+	def, ok := tb.Definition(name)
+	if !ok || !def.Mutating {
+		return tb.UseTool(ctx, name, input)
+	}
+	if def.DryRunFunc != nil {
+		res, err := def.DryRunFunc(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		return "Dry-run, nothing was changed. " + res, nil
+	}
+	return fmt.Sprintf("Dry-run, nothing was changed. The %s tool would be called with: %s", name, input), nil
+}
+
+// Definition returns the definition of a tool.
+func (tb *Belt[ResultT]) Definition(name string) (Definition, bool) {
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	def, ok := tb.toolDefinitions[name]
+	return def, ok
+}
+
 func (tb *Belt[ResultT]) LLMDefinitions() []llm.ToolDefinition {
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
 	var keys []string
 	for name := range tb.toolDefinitions {
 		keys = append(keys, name)
@@ -87,20 +220,77 @@ func (tb *Belt[ResultT]) LLMDefinitions() []llm.ToolDefinition {
 	return params
 }
 
+// FinalResultDefinition returns the definition of the FinalResult tool, the
+// zero value if it is disabled.
 func (tb *Belt[ResultT]) FinalResultDefinition() llm.ToolDefinition {
-	return tb.toolDefinitions[FinalResultToolName].ToolDefinition
+	if tb.finalResultName == "" {
+		return llm.ToolDefinition{}
+	}
+	def, _ := tb.Definition(tb.finalResultName)
+	return def.ToolDefinition
+}
+
+// FinalResultName returns the name of the FinalResult tool, empty if it is
+// disabled (see NewBeltParams.FinalResultName).
+func (tb *Belt[ResultT]) FinalResultName() string {
+	return tb.finalResultName
+}
+
+// IsBuiltin reports whether the tool is a built-in tool of the belt: the
+// FinalResult tool with its configured name, and UpdatePlan and EmitFinding
+// if they are enabled.
+func (tb *Belt[ResultT]) IsBuiltin(name string) bool {
+	return (tb.finalResultName != "" && name == tb.finalResultName) ||
+		(tb.planning && name == UpdatePlanToolName) ||
+		(tb.findings && name == EmitFindingToolName)
 }
 
 func GenerateSchema[T any]() *jsonschema.Schema {
+	t := reflect.TypeFor[T]()
+	// Inlining recursive types would never end, they are referenced from the
+	// definitions of the schema instead.
+	recursive := recursiveType(t, map[reflect.Type]bool{})
 	reflector := jsonschema.Reflector{
 		AllowAdditionalProperties: false,
-		DoNotReference:            true,
+		DoNotReference:            !recursive,
+	}
+	schema := reflector.ReflectFromType(t)
+	if !recursive {
+		return schema
+	}
+	// The providers expect the object at the root, not a reference to it.
+	def, ok := schema.Definitions[strings.TrimPrefix(schema.Ref, "#/$defs/")]
+	if !ok {
+		return schema
+	}
+	root := *def
+	root.Version = schema.Version
+	root.Definitions = schema.Definitions
+	return &root
+}
+
+// recursiveType reports whether t contains itself, e.g. a tree of nodes.
+// visiting are the types on the path from the root.
+func recursiveType(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return recursiveType(t.Elem(), visiting)
+	case reflect.Struct:
+		if visiting[t] {
+			return true
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		for i := range t.NumField() {
+			if recursiveType(t.Field(i).Type, visiting) {
+				return true
+			}
+		}
 	}
-	var v T
-	return reflector.Reflect(v)
+	return false
 }
 
 func structResultType[ResultT any]() bool {
-	var val ResultT
-	return reflect.TypeOf(val).Kind() == reflect.Struct
+	// TypeFor, unlike TypeOf, works with interface types too (e.g. any).
+	return reflect.TypeFor[ResultT]().Kind() == reflect.Struct
 }
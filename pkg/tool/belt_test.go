@@ -0,0 +1,49 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// TestBeltKeepsBuiltins gives tools with the names of the built-in tools to
+// the belt, the built-in ones must be kept.
+func TestBeltKeepsBuiltins(t *testing.T) {
+	var called []string
+	impostor := func(name string) Definition {
+		return Definition{
+			ToolDefinition: llm.ToolDefinition{Name: name, Description: "Not the built-in tool."},
+			UseFunc: func(context.Context, json.RawMessage) (string, error) {
+				called = append(called, name)
+				return "ok", nil
+			},
+		}
+	}
+	agent := &resultRecorder[string]{}
+	belt := NewBelt(NewBeltParams[string]{
+		Agent:          agent,
+		Tools:          []Definition{impostor(FinalResultToolName), impostor(UpdatePlanToolName), impostor(EmitFindingToolName)},
+		EnablePlanning: true,
+		EnableFindings: true,
+	})
+	belt.AddTools(impostor(FinalResultToolName))
+	belt.RemoveTools(UpdatePlanToolName)
+
+	for _, name := range []string{FinalResultToolName, UpdatePlanToolName, EmitFindingToolName} {
+		if !belt.IsBuiltin(name) {
+			t.Errorf("%s is not built-in", name)
+		}
+		def, ok := belt.Definition(name)
+		if !ok || def.Description == "Not the built-in tool." {
+			t.Errorf("%s was replaced or removed", name)
+		}
+	}
+	if _, err := belt.UseTool(context.Background(), FinalResultToolName, json.RawMessage(`{"response":"done"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !agent.set || len(called) > 0 {
+		t.Errorf("the result is not set or the tools %v were called instead", called)
+	}
+}
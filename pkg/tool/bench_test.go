@@ -0,0 +1,35 @@
+package tool
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// schemaInput is a tool input of a typical size, with nested objects.
+type schemaInput struct {
+	Path    string   `json:"path" jsonschema_description:"Path of the file"`
+	Lines   []int    `json:"lines,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Labels  []string `json:"labels,omitempty"`
+	Options struct {
+		Recursive bool   `json:"recursive,omitempty"`
+		Depth     int    `json:"depth,omitempty"`
+		Mode      string `json:"mode,omitempty" jsonschema:"enum=fast,enum=thorough"`
+	} `json:"options"`
+	Comments []struct {
+		Line int    `json:"line"`
+		Body string `json:"body"`
+	} `json:"comments,omitempty"`
+}
+
+// BenchmarkSchema measures the generation of a tool schema in the format of
+// the strictest provider.
+func BenchmarkSchema(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := llm.NormalizeSchema(GenerateSchema[schemaInput](), llm.ProviderOpenAI); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package tool
+
+import "context"
+
+type runIDKey struct{}
+
+// WithRunID returns a context carrying the ID of the run calling the tool.
+func WithRunID(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, runID)
+}
+
+// RunIDFromContext returns the ID of the run calling the tool, e.g. to
+// attribute the changes of the tool to the run (see journal.Journal).
+func RunIDFromContext(ctx context.Context) string {
+	runID, _ := ctx.Value(runIDKey{}).(string)
+	return runID
+}
@@ -0,0 +1,158 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// Doc is the documentation of a tool, as seen by the model.
+type Doc struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Parameters  []ParameterDoc     `json:"parameters,omitempty"`
+	Schema      *jsonschema.Schema `json:"schema,omitempty"`
+	Examples    []json.RawMessage  `json:"examples,omitempty"`
+}
+
+// ParameterDoc describes an input field. Fields of nested objects are listed
+// with dotted names, array items with a [] suffix.
+type ParameterDoc struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+// Definitions returns the definitions of the belt sorted by name, including
+// the built-in tools.
+func (tb *Belt[ResultT]) Definitions() []Definition {
+	tb.mu.RLock()
+	defer tb.mu.RUnlock()
+	var defs []Definition
+	for _, def := range tb.toolDefinitions {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
+// Docs returns the documentation of the tool definitions. Use
+// Belt.Definitions to include the built-in tools of an agent.
+func Docs(defs []Definition) []Doc {
+	var docs []Doc
+	for _, def := range defs {
+		doc := Doc{
+			Name:        def.Name,
+			Description: def.Description,
+			Schema:      def.Schema,
+			Examples:    def.Examples,
+		}
+		if def.Schema != nil {
+			doc.Parameters = parameterDocs("", def.Schema)
+			for _, example := range def.Schema.Examples {
+				if b, err := json.Marshal(example); err == nil {
+					doc.Examples = append(doc.Examples, b)
+				}
+			}
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func parameterDocs(prefix string, schema *jsonschema.Schema) []ParameterDoc {
+	if schema.Properties == nil {
+		return nil
+	}
+	var params []ParameterDoc
+	for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+		name, prop := prefix+pair.Key, pair.Value
+		params = append(params, ParameterDoc{
+			Name:        name,
+			Type:        schemaType(prop),
+			Required:    slices.Contains(schema.Required, pair.Key),
+			Description: describe(prop),
+		})
+		switch {
+		case prop.Type == "object":
+			params = append(params, parameterDocs(name+".", prop)...)
+		case prop.Type == "array" && prop.Items != nil && prop.Items.Type == "object":
+			params = append(params, parameterDocs(name+"[].", prop.Items)...)
+		}
+	}
+	return params
+}
+
+func schemaType(schema *jsonschema.Schema) string {
+	if schema.Type == "array" && schema.Items != nil {
+		return "array of " + schemaType(schema.Items)
+	}
+	if schema.Type == "" {
+		return "any"
+	}
+	return schema.Type
+}
+
+func describe(schema *jsonschema.Schema) string {
+	desc := schema.Description
+	if len(schema.Enum) > 0 {
+		var values []string
+		for _, v := range schema.Enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		desc = strings.TrimSpace(desc + " One of: " + strings.Join(values, ", ") + ".")
+	}
+	if schema.Default != nil {
+		desc = strings.TrimSpace(fmt.Sprintf("%s Defaults to %v.", desc, schema.Default))
+	}
+	return desc
+}
+
+// WriteDocsJSON writes the documentation of the tools as an indented JSON
+// array.
+func WriteDocsJSON(w io.Writer, defs []Definition) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(Docs(defs)); err != nil {
+		return fmt.Errorf("encode tool docs: %w", err)
+	}
+	return nil
+}
+
+// WriteDocsMarkdown writes the documentation of the tools as Markdown, one
+// section per tool with a parameter table.
+func WriteDocsMarkdown(w io.Writer, defs []Definition) error {
+	var sb strings.Builder
+	sb.WriteString("# Tools\n")
+	for _, doc := range Docs(defs) {
+		fmt.Fprintf(&sb, "\n## %s\n\n%s\n", doc.Name, doc.Description)
+		if len(doc.Parameters) > 0 {
+			sb.WriteString("\n| Parameter | Type | Required | Description |\n|---|---|---|---|\n")
+			for _, p := range doc.Parameters {
+				required := "no"
+				if p.Required {
+					required = "yes"
+				}
+				fmt.Fprintf(&sb, "| `%s` | %s | %s | %s |\n", p.Name, p.Type, required, escapeTableCell(p.Description))
+			}
+		}
+		for _, example := range doc.Examples {
+			fmt.Fprintf(&sb, "\nExample input:\n\n```json\n%s\n```\n", example)
+		}
+	}
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("write tool docs: %w", err)
+	}
+	return nil
+}
+
+func escapeTableCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,138 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// DefaultMaxEnrichExamples is the number of examples added by Enrich if
+// EnrichParams.MaxExamples is not set.
+const DefaultMaxEnrichExamples = 2
+
+// EnrichParams configures Enrich.
+type EnrichParams struct {
+	// MaxExamples limits the examples appended to the description, defaults
+	// to DefaultMaxEnrichExamples.
+	MaxExamples int
+	// MaxResultBytes is the limit of the results of the tools without their
+	// own limit (see Definition.MaxResultBytes), 0 if unlimited.
+	MaxResultBytes int
+}
+
+// Enrich appends usage examples and size hints to the description of a
+// tool, which improves the accuracy of the tool calls of smaller models:
+//
+//   - the Examples of the definition and of its schema, or an example
+//     generated from the schema (the required fields) if it has none,
+//   - the limits of the input (maximum lengths and items) from the schema,
+//   - the size limit of the results.
+//
+// The tool is not changed otherwise.
+func Enrich(def Definition, p EnrichParams) Definition {
+	maxExamples := p.MaxExamples
+	if maxExamples <= 0 {
+		maxExamples = DefaultMaxEnrichExamples
+	}
+	var hints []string
+
+	examples := def.Examples
+	if def.Schema != nil {
+		for _, example := range def.Schema.Examples {
+			if b, err := json.Marshal(example); err == nil {
+				examples = append(examples, b)
+			}
+		}
+		if len(examples) == 0 {
+			if b, err := json.Marshal(exampleValue(def.Schema)); err == nil {
+				examples = append(examples, b)
+			}
+		}
+	}
+	for _, example := range examples[:min(len(examples), maxExamples)] {
+		hints = append(hints, fmt.Sprintf("Example input: %s", example))
+	}
+
+	if def.Schema != nil {
+		if limits := inputLimits("", def.Schema); len(limits) > 0 {
+			hints = append(hints, "Input limits: "+strings.Join(limits, ", ")+".")
+		}
+	}
+
+	maxResultBytes := p.MaxResultBytes
+	if def.MaxResultBytes > 0 {
+		maxResultBytes = def.MaxResultBytes
+	}
+	if maxResultBytes > 0 {
+		hints = append(hints, fmt.Sprintf(
+			"Results longer than %d bytes are truncated, request smaller parts if possible.", maxResultBytes))
+	}
+
+	if len(hints) > 0 {
+		def.Description = strings.TrimSpace(def.Description) + "\n\n" + strings.Join(hints, "\n")
+	}
+	return def
+}
+
+// exampleValue returns a placeholder value of the schema, objects only have
+// their required fields.
+func exampleValue(schema *jsonschema.Schema) any {
+	switch {
+	case len(schema.Examples) > 0:
+		return schema.Examples[0]
+	case schema.Default != nil:
+		return schema.Default
+	case len(schema.Enum) > 0:
+		return schema.Enum[0]
+	}
+	switch schema.Type {
+	case "object":
+		obj := map[string]any{}
+		for _, name := range schema.Required {
+			if prop, ok := schema.Properties.Get(name); ok {
+				obj[name] = exampleValue(prop)
+			}
+		}
+		return obj
+	case "array":
+		if schema.Items == nil {
+			return []any{}
+		}
+		return []any{exampleValue(schema.Items)}
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	case "string":
+		return "..."
+	default:
+		return nil
+	}
+}
+
+// inputLimits lists the length limits of the fields, with dotted names like
+// ParameterDoc.
+func inputLimits(prefix string, schema *jsonschema.Schema) []string {
+	if schema.Properties == nil {
+		return nil
+	}
+	var limits []string
+	for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+		name, prop := prefix+pair.Key, pair.Value
+		if prop.MaxLength != nil {
+			limits = append(limits, fmt.Sprintf("%s at most %d characters", name, *prop.MaxLength))
+		}
+		if prop.MaxItems != nil {
+			limits = append(limits, fmt.Sprintf("%s at most %d items", name, *prop.MaxItems))
+		}
+		switch {
+		case prop.Type == "object":
+			limits = append(limits, inputLimits(name+".", prop)...)
+		case prop.Type == "array" && prop.Items != nil && prop.Items.Type == "object":
+			limits = append(limits, inputLimits(name+"[].", prop.Items)...)
+		}
+	}
+	return limits
+}
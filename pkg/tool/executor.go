@@ -0,0 +1,22 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Executor executes tool calls outside of the process of the agent, e.g. on
+// the build VM while the agent runs in a control plane (see package remote).
+// The built-in tools (FinalResult, UpdatePlan, EmitFinding) are always
+// executed by the Belt.
+type Executor interface {
+	Execute(ctx context.Context, call Call) (string, error)
+}
+
+// Call is a tool call to execute.
+type Call struct {
+	RunID      string
+	ToolCallID string
+	Name       string
+	Input      json.RawMessage
+}
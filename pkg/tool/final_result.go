@@ -1,9 +1,12 @@
 package tool
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jsoncodec"
 )
 
 const FinalResultToolName = "FinalResult"
@@ -14,22 +17,49 @@ type finalResultPrimitiveInput[ResultT any] struct {
 }
 
 func (tb *Belt[ResultT]) finalResult(_ context.Context, llmInput json.RawMessage) (string, error) {
+	llmInput, err := finalResultInput(llmInput)
+	if err != nil {
+		return "", err
+	}
 	// Primitive types must be wrapped in an object to be valid JSON.
 	// We could also wrap complex types to make the code simpler, eliminating
 	// all checks doing `...structResultType[ResultT]...`, but that would be an
 	// unnecessary extra layer for the LLM.
-	if !structResultType[ResultT]() {
+	if !tb.rawFinalResult && !structResultType[ResultT]() {
 		var input finalResultPrimitiveInput[ResultT]
-		if err := json.Unmarshal(llmInput, &input); err != nil {
+		if err := jsoncodec.Unmarshal(llmInput, &input); err != nil {
 			return "", fmt.Errorf("unmarshal input: %w", err)
 		}
+		var fields map[string]json.RawMessage
+		if err := jsoncodec.Unmarshal(llmInput, &fields); err != nil || fields["response"] == nil {
+			return "", fmt.Errorf(`the input must be an object with a "response" field`)
+		}
 		tb.agent.SetFinalResult(input.Response)
 	} else {
 		var input ResultT
-		if err := json.Unmarshal(llmInput, &input); err != nil {
+		if err := jsoncodec.Unmarshal(llmInput, &input); err != nil {
 			return "", fmt.Errorf("unmarshal input: %w", err)
 		}
 		tb.agent.SetFinalResult(input)
 	}
 	return "Final result processed.", nil
 }
+
+// finalResultInput checks the input of a FinalResult call. Models sometimes
+// send the object encoded as a JSON string, it is decoded. Empty and null
+// inputs are rejected, they would set a zero result.
+func finalResultInput(input json.RawMessage) (json.RawMessage, error) {
+	input = bytes.TrimSpace(input)
+	if len(input) > 0 && input[0] == '"' {
+		var s string
+		if err := json.Unmarshal(input, &s); err == nil {
+			if trimmed := bytes.TrimSpace([]byte(s)); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+				input = trimmed
+			}
+		}
+	}
+	if len(input) == 0 || bytes.Equal(input, []byte("null")) {
+		return nil, fmt.Errorf("the input must be a JSON object")
+	}
+	return input, nil
+}
@@ -0,0 +1,55 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jsoncodec"
+)
+
+const EmitFindingToolName = "EmitFinding"
+const emitFindingDescription = `Reports a finding (e.g. an issue found in a file) as soon as you find it. ` +
+	`Call it once per finding while you work, the findings are collected and delivered to the user as they come. ` +
+	`Don't repeat the reported findings in the final result unless asked to.`
+
+type FindingSeverity string
+
+const (
+	FindingInfo    FindingSeverity = "info"
+	FindingWarning FindingSeverity = "warning"
+	FindingError   FindingSeverity = "error"
+)
+
+// Finding is an intermediate result reported with the EmitFinding tool.
+type Finding struct {
+	Title    string          `json:"title" jsonschema_description:"Short summary of the finding"`
+	Details  string          `json:"details,omitempty" jsonschema_description:"Explanation of the finding and how to address it"`
+	Location string          `json:"location,omitempty" jsonschema_description:"Where the finding is, e.g. a file path with a line number"`
+	Severity FindingSeverity `json:"severity,omitempty" jsonschema:"enum=info,enum=warning,enum=error" jsonschema_description:"Severity of the finding"`
+}
+
+func (f Finding) validate() error {
+	if strings.TrimSpace(f.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	switch f.Severity {
+	case "", FindingInfo, FindingWarning, FindingError:
+	default:
+		return fmt.Errorf("invalid severity %q", f.Severity)
+	}
+	return nil
+}
+
+func (tb *Belt[ResultT]) emitFinding(ctx context.Context, llmInput json.RawMessage) (string, error) {
+	var finding Finding
+	if err := jsoncodec.Unmarshal(llmInput, &finding); err != nil {
+		return "", fmt.Errorf("unmarshal input: %w", err)
+	}
+	if err := finding.validate(); err != nil {
+		return "", err
+	}
+	tb.agent.AddFinding(ctx, finding)
+	return "Finding recorded.", nil
+}
@@ -0,0 +1,145 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+// resultRecorder is the agent of the belts under test.
+type resultRecorder[ResultT any] struct {
+	result ResultT
+	set    bool
+}
+
+func (r *resultRecorder[ResultT]) SetFinalResult(v ResultT) {
+	r.result, r.set = v, true
+}
+
+func (r *resultRecorder[ResultT]) SetPlan(Plan) {}
+
+func (r *resultRecorder[ResultT]) AddFinding(context.Context, Finding) {}
+
+type treeNode struct {
+	Name     string     `json:"name"`
+	Children []treeNode `json:"children,omitempty"`
+}
+
+type review struct {
+	Summary  string            `json:"summary"`
+	Score    int               `json:"score"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Parent   *review           `json:"parent,omitempty"`
+	Findings []Finding         `json:"findings"`
+}
+
+var finalResultSeeds = []string{
+	`{"response":"done"}`,
+	`"{\"response\":\"done\"}"`,
+	`{"summary":"ok","score":3,"findings":[{"title":"x"}]}`,
+	`{"name":"root","children":[{"name":"leaf"}]}`,
+	`{"parent":{"parent":{"summary":"deep"}}}`,
+	`{"response":null}`,
+	`{"score":1e400}`,
+	`{"response":"\ud800"}`,
+	`null`,
+	`""`,
+	`[]`,
+	`{`,
+	``,
+}
+
+// checkFinalResult calls the FinalResult tool of a belt with the input: an
+// accepted input must set a result, a rejected one must not.
+func checkFinalResult[ResultT any](t *testing.T, input []byte) {
+	agent := &resultRecorder[ResultT]{}
+	belt := NewBelt(NewBeltParams[ResultT]{Agent: agent})
+	_, err := belt.UseTool(context.Background(), FinalResultToolName, input)
+	if err == nil && !agent.set {
+		t.Errorf("%T: input %q accepted without setting the result", agent.result, input)
+	}
+	if err != nil && agent.set {
+		t.Errorf("%T: input %q rejected (%v) after setting the result", agent.result, input, err)
+	}
+	if err == nil {
+		if _, err := json.Marshal(agent.result); err != nil {
+			t.Errorf("%T: marshal the result of %q: %v", agent.result, input, err)
+		}
+	}
+}
+
+func FuzzFinalResult(f *testing.F) {
+	for _, seed := range finalResultSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		checkFinalResult[string](t, input)
+		checkFinalResult[int](t, input)
+		checkFinalResult[any](t, input)
+		checkFinalResult[review](t, input)
+		checkFinalResult[treeNode](t, input)
+		checkFinalResult[json.RawMessage](t, input)
+	})
+}
+
+// FuzzFinalResultSchema checks the belts with the schemas defined at runtime,
+// e.g. loaded from a configuration, see NewBeltParams.FinalResultSchema.
+func FuzzFinalResultSchema(f *testing.F) {
+	for _, schema := range []*jsonschema.Schema{
+		GenerateSchema[review](),
+		GenerateSchema[treeNode](),
+		GenerateSchema[Plan](),
+	} {
+		b, err := json.Marshal(schema)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b, []byte(`{"summary":"ok"}`))
+	}
+	f.Add([]byte(`{"type":"object","properties":{"a":{"$ref":"#/$defs/a"}}}`), []byte(`{"a":1}`))
+	f.Add([]byte(`{"type":["object","null"],"additionalProperties":true}`), []byte(`null`))
+	f.Add([]byte(`true`), []byte(`{}`))
+
+	f.Fuzz(func(t *testing.T, schemaJSON, input []byte) {
+		var schema jsonschema.Schema
+		if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+			return
+		}
+		agent := &resultRecorder[json.RawMessage]{}
+		belt := NewBelt(NewBeltParams[json.RawMessage]{Agent: agent, FinalResultSchema: &schema})
+		for _, def := range belt.LLMDefinitions() {
+			if _, err := json.Marshal(def.Schema); err != nil {
+				t.Fatalf("marshal the schema of %s: %v", def.Name, err)
+			}
+		}
+		_, err := belt.UseTool(context.Background(), FinalResultToolName, input)
+		if err == nil && !json.Valid(agent.result) {
+			t.Errorf("input %q accepted with an invalid result %q", input, agent.result)
+		}
+	})
+}
+
+func TestGenerateSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema *jsonschema.Schema
+	}{
+		{"struct", GenerateSchema[review]()},
+		{"recursive", GenerateSchema[treeNode]()},
+		{"plan", GenerateSchema[Plan]()},
+		{"primitive result", GenerateSchema[finalResultPrimitiveInput[string]]()},
+		{"interface result", GenerateSchema[finalResultPrimitiveInput[any]]()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.schema.Type != "object" {
+				t.Errorf("type = %q, want the object at the root", tt.schema.Type)
+			}
+			if _, err := json.Marshal(tt.schema); err != nil {
+				t.Errorf("marshal: %v", err)
+			}
+		})
+	}
+}
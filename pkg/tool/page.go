@@ -0,0 +1,92 @@
+package tool
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jsoncodec"
+)
+
+// DefaultPageLimit is the page size if neither the model nor the tool sets it.
+const DefaultPageLimit = 50
+
+// PaginationGuidance is appended to the description of paginated tools.
+const PaginationGuidance = "The result is paginated: it contains `items`, `offset`, `limit`, `total` and `has_more`. " +
+	"If `has_more` is true and you need more items, call the tool again with `offset` set to `next_offset`. " +
+	"Prefer narrowing the request over paging through everything."
+
+// PageInput adds the pagination fields to a tool input, embed it into the
+// input struct of a paginated tool (see NewPaged).
+type PageInput struct {
+	Offset int `json:"offset,omitempty" jsonschema_description:"Index of the first item to return, defaults to 0"`
+	Limit  int `json:"limit,omitempty" jsonschema_description:"Maximum number of items to return"`
+}
+
+// PageParams returns the pagination fields, it's promoted to the embedding
+// input struct.
+func (p PageInput) PageParams() PageInput {
+	return p
+}
+
+// Pager is implemented by the input structs embedding PageInput.
+type Pager interface {
+	PageParams() PageInput
+}
+
+// Page is the pagination envelope of a tool result.
+type Page[T any] struct {
+	Items      []T  `json:"items"`
+	Offset     int  `json:"offset"`
+	Limit      int  `json:"limit"`
+	Total      int  `json:"total"`
+	HasMore    bool `json:"has_more"`
+	NextOffset int  `json:"next_offset,omitempty"`
+}
+
+// Paginate returns the requested page of items. maxLimit caps the limit
+// requested by the model and is the default limit, DefaultPageLimit is used
+// if it's zero.
+func Paginate[T any](items []T, in PageInput, maxLimit int) Page[T] {
+	if maxLimit <= 0 {
+		maxLimit = DefaultPageLimit
+	}
+	limit := in.Limit
+	if limit <= 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+	offset := min(max(in.Offset, 0), len(items))
+	end := min(offset+limit, len(items))
+	page := Page[T]{
+		Items:   items[offset:end],
+		Offset:  offset,
+		Limit:   limit,
+		Total:   len(items),
+		HasMore: end < len(items),
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	if page.HasMore {
+		page.NextOffset = end
+	}
+	return page
+}
+
+// NewPaged creates a tool returning its items in pages. InputT must embed
+// PageInput, fn returns all the items and the requested page is cut from
+// them. The pagination guidance is appended to the description.
+func NewPaged[InputT Pager, ItemT any](
+	name, description string, maxLimit int, fn func(context.Context, InputT) ([]ItemT, error),
+) Definition {
+	return New(name, description+"\n\n"+PaginationGuidance, func(ctx context.Context, input InputT) (string, error) {
+		items, err := fn(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		b, err := jsoncodec.Marshal(Paginate(items, input.PageParams(), maxLimit))
+		if err != nil {
+			return "", fmt.Errorf("marshal page: %w", err)
+		}
+		return string(b), nil
+	})
+}
@@ -0,0 +1,84 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jsoncodec"
+)
+
+const UpdatePlanToolName = "UpdatePlan"
+const updatePlanDescription = `Creates or updates your plan for the task. ` +
+	`Use it for multi-step tasks: lay out the steps before starting, then keep their statuses up to date as you progress. ` +
+	`Always send the full plan, it replaces the previous one. At most one step can be in progress at a time.`
+
+type PlanStepStatus string
+
+const (
+	PlanStepPending    PlanStepStatus = "pending"
+	PlanStepInProgress PlanStepStatus = "in_progress"
+	PlanStepCompleted  PlanStepStatus = "completed"
+)
+
+type PlanStep struct {
+	Description string         `json:"description" jsonschema_description:"Short description of the step"`
+	Status      PlanStepStatus `json:"status" jsonschema:"enum=pending,enum=in_progress,enum=completed" jsonschema_description:"Status of the step"`
+}
+
+type Plan struct {
+	Steps       []PlanStep `json:"steps" jsonschema_description:"The steps of the plan in order"`
+	Explanation string     `json:"explanation,omitempty" jsonschema_description:"Optional note on why the plan changed"`
+}
+
+// String renders the plan as a checklist.
+func (p Plan) String() string {
+	var lines []string
+	for i, step := range p.Steps {
+		mark := " "
+		switch step.Status {
+		case PlanStepInProgress:
+			mark = "~"
+		case PlanStepCompleted:
+			mark = "x"
+		}
+		lines = append(lines, fmt.Sprintf("%d. [%s] %s", i+1, mark, step.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p Plan) validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("the plan must have at least one step")
+	}
+	var inProgress int
+	for i, step := range p.Steps {
+		switch step.Status {
+		case PlanStepPending, PlanStepCompleted:
+		case PlanStepInProgress:
+			inProgress++
+		default:
+			return fmt.Errorf("step %d: invalid status %q", i+1, step.Status)
+		}
+		if strings.TrimSpace(step.Description) == "" {
+			return fmt.Errorf("step %d: description is required", i+1)
+		}
+	}
+	if inProgress > 1 {
+		return fmt.Errorf("only one step can be in progress, got %d", inProgress)
+	}
+	return nil
+}
+
+func (tb *Belt[ResultT]) updatePlan(_ context.Context, llmInput json.RawMessage) (string, error) {
+	var plan Plan
+	if err := jsoncodec.Unmarshal(llmInput, &plan); err != nil {
+		return "", fmt.Errorf("unmarshal input: %w", err)
+	}
+	if err := plan.validate(); err != nil {
+		return "", err
+	}
+	tb.agent.SetPlan(plan)
+	return "Plan updated.", nil
+}
@@ -0,0 +1,174 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jsoncodec"
+)
+
+// ErrPolicyDenied is returned (wrapped) when a tool call is denied by a Policy.
+var ErrPolicyDenied = errors.New("denied by policy")
+
+type PolicyEffect string
+
+const (
+	PolicyAllow PolicyEffect = "allow"
+	PolicyDeny  PolicyEffect = "deny"
+)
+
+// Policy decides which tool calls are permitted, so the same agent can be
+// deployed with different risk profiles. It is evaluated before UseFunc.
+//
+// The first rule matching the name of the tool decides. Allow rules can
+// restrict the inputs of the call further: the paths of fs tools, the hosts
+// of network tools and the commands of shell tools are found by the names of
+// the input fields (see PathFields, HostFields and CommandFields).
+// The built-in tools of the agent are not checked, see Belt.IsBuiltin: a tool
+// of the caller with the name of a built-in one is.
+type Policy struct {
+	Rules []PolicyRule
+	// Default applies if no rule matches, defaults to PolicyAllow.
+	Default PolicyEffect
+}
+
+type PolicyRule struct {
+	// Tools are glob patterns (path.Match) of the tool names, e.g. "git_*".
+	// The rule matches every tool if empty.
+	Tools  []string
+	Effect PolicyEffect
+	// Paths are globs the paths of the input must match (optional). A
+	// trailing "/**" matches everything under a directory, e.g. "src/**".
+	Paths []string
+	// Hosts are the hosts the URLs of the input may point to (optional),
+	// "*.example.com" matches the subdomains.
+	Hosts []string
+	// Commands are the programs the commands of the input may run
+	// (optional), matched against the first word of the command. Commands
+	// chaining other commands (e.g. with ";", "&&" or "$(...)") are denied.
+	Commands []string
+}
+
+// Input fields checked by the restrictions of the rules. The values can be
+// strings or arrays of strings.
+var (
+	PathFields    = []string{"path", "paths", "file", "files", "dir", "directory", "old_path", "new_path"}
+	HostFields    = []string{"url", "urls", "host", "hosts"}
+	CommandFields = []string{"command", "commands", "cmd"}
+)
+
+// Check returns an error wrapping ErrPolicyDenied if the call is not allowed.
+func (p *Policy) Check(name string, input json.RawMessage) error {
+	if p == nil {
+		return nil
+	}
+	rule, ok := p.match(name)
+	if !ok {
+		if p.Default == PolicyDeny {
+			return fmt.Errorf("%w: tool %q is not allowed", ErrPolicyDenied, name)
+		}
+		return nil
+	}
+	if rule.Effect == PolicyDeny {
+		return fmt.Errorf("%w: tool %q is denied", ErrPolicyDenied, name)
+	}
+	if len(rule.Paths) == 0 && len(rule.Hosts) == 0 && len(rule.Commands) == 0 {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := jsoncodec.Unmarshal(input, &fields); err != nil {
+		return fmt.Errorf("%w: can't check the input of %q: %v", ErrPolicyDenied, name, err)
+	}
+	if len(rule.Paths) > 0 {
+		for _, v := range inputValues(fields, PathFields) {
+			if !slices.ContainsFunc(rule.Paths, func(pattern string) bool { return matchPath(pattern, v) }) {
+				return fmt.Errorf("%w: path %q is not allowed for %q", ErrPolicyDenied, v, name)
+			}
+		}
+	}
+	if len(rule.Hosts) > 0 {
+		for _, v := range inputValues(fields, HostFields) {
+			host := hostOf(v)
+			if !slices.ContainsFunc(rule.Hosts, func(pattern string) bool { return matchHost(pattern, host) }) {
+				return fmt.Errorf("%w: host %q is not allowed for %q", ErrPolicyDenied, host, name)
+			}
+		}
+	}
+	if len(rule.Commands) > 0 {
+		for _, v := range inputValues(fields, CommandFields) {
+			if strings.ContainsAny(v, ";&|`$<>\n") {
+				return fmt.Errorf("%w: command %q of %q uses shell operators", ErrPolicyDenied, v, name)
+			}
+			program, _, _ := strings.Cut(strings.TrimSpace(v), " ")
+			if !slices.Contains(rule.Commands, program) && !slices.Contains(rule.Commands, path.Base(program)) {
+				return fmt.Errorf("%w: command %q is not allowed for %q", ErrPolicyDenied, program, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) match(name string) (PolicyRule, bool) {
+	for _, rule := range p.Rules {
+		if len(rule.Tools) == 0 {
+			return rule, true
+		}
+		for _, pattern := range rule.Tools {
+			if ok, _ := path.Match(pattern, name); ok {
+				return rule, true
+			}
+		}
+	}
+	return PolicyRule{}, false
+}
+
+// inputValues returns the string values of the given fields of the input.
+func inputValues(fields map[string]json.RawMessage, names []string) []string {
+	var values []string
+	for _, name := range names {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		var s string
+		if err := jsoncodec.Unmarshal(raw, &s); err == nil {
+			values = append(values, s)
+			continue
+		}
+		var list []string
+		if err := jsoncodec.Unmarshal(raw, &list); err == nil {
+			values = append(values, list...)
+		}
+	}
+	return values
+}
+
+func matchPath(pattern, p string) bool {
+	p = path.Clean(p)
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return p == path.Clean(dir) || strings.HasPrefix(p, path.Clean(dir)+"/")
+	}
+	ok, _ := path.Match(path.Clean(pattern), p)
+	return ok
+}
+
+// hostOf returns the host of a URL, or the value itself if it's not a URL.
+func hostOf(v string) string {
+	if u, err := url.Parse(v); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return v
+}
+
+func matchHost(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return strings.EqualFold(pattern, host)
+}
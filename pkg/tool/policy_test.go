@@ -0,0 +1,46 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Tools: []string{"read_*"}, Effect: PolicyAllow, Paths: []string{"src/**"}},
+			{Tools: []string{"shell"}, Effect: PolicyAllow, Commands: []string{"go", "git"}},
+			{Tools: []string{"fetch"}, Effect: PolicyAllow, Hosts: []string{"*.example.com"}},
+		},
+		Default: PolicyDeny,
+	}
+	tests := []struct {
+		name    string
+		tool    string
+		input   string
+		allowed bool
+	}{
+		{"allowed path", "read_file", `{"path":"src/main.go"}`, true},
+		{"denied path", "read_file", `{"path":"/etc/passwd"}`, false},
+		{"allowed command", "shell", `{"command":"go test ./..."}`, true},
+		{"chained command", "shell", `{"command":"go test; rm -rf /"}`, false},
+		{"allowed host", "fetch", `{"url":"https://api.example.com/x"}`, true},
+		{"denied host", "fetch", `{"url":"https://example.org"}`, false},
+		{"default", "write_file", `{}`, false},
+		// Only the built-in tools of the belt are exempt, by the agent.
+		{"name of a built-in tool", FinalResultToolName, `{}`, false},
+		{"name of the plan tool", UpdatePlanToolName, `{}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.tool, json.RawMessage(tt.input))
+			if tt.allowed && err != nil {
+				t.Errorf("Check = %v, want allowed", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrPolicyDenied) {
+				t.Errorf("Check = %v, want ErrPolicyDenied", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package tool
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/journal"
+)
+
+// FactoryParams configure the tools created by a Factory.
+type FactoryParams struct {
+	// Dir is the root directory of tools working on local files, defaults to
+	// the current directory.
+	Dir string
+	// Journal records the original content of the files changed by the
+	// tools (optional), so the changes of a run can be rolled back.
+	Journal *journal.Journal
+}
+
+// Factory creates the tool definitions registered under a name.
+type Factory func(p FactoryParams) ([]Definition, error)
+
+// Registry maps names to tools, so tool belts can be assembled declaratively
+// (e.g. from a spec: "tools: [git, search.SearchCode]"). A name refers to a
+// single tool or to a toolset, and "<toolset>.<tool>" selects one tool of a
+// toolset. It's safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]Factory{}}
+}
+
+// DefaultRegistry is the registry the toolset packages register to when
+// imported.
+var DefaultRegistry = NewRegistry()
+
+// Register registers a factory, registering a name twice is an error.
+func (r *Registry) Register(name string, f Factory) error {
+	if name == "" || strings.Contains(name, ".") {
+		return fmt.Errorf("invalid tool name %q", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.factories[name]; ok {
+		return fmt.Errorf("tool %q is already registered", name)
+	}
+	r.factories[name] = f
+	return nil
+}
+
+// RegisterDefinition registers a single tool under its name.
+func (r *Registry) RegisterDefinition(def Definition) error {
+	return r.Register(def.Name, func(FactoryParams) ([]Definition, error) {
+		return []Definition{def}, nil
+	})
+}
+
+// MustRegister registers a factory to the DefaultRegistry, it panics on
+// error. It's meant to be called from init functions.
+func MustRegister(name string, f Factory) {
+	if err := DefaultRegistry.Register(name, f); err != nil {
+		panic(err)
+	}
+}
+
+// Names returns the registered names in alphabetical order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var names []string
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the tools registered under name.
+func (r *Registry) Resolve(name string, p FactoryParams) ([]Definition, error) {
+	if p.Dir == "" {
+		p.Dir = "."
+	}
+	group, toolName, selectOne := strings.Cut(name, ".")
+	r.mu.RLock()
+	f, ok := r.factories[group]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown tool %q, registered: %s", name, strings.Join(r.Names(), ", "))
+	}
+	defs, err := f(p)
+	if err != nil {
+		return nil, fmt.Errorf("create tool %q: %w", group, err)
+	}
+	if !selectOne {
+		return defs, nil
+	}
+	for _, def := range defs {
+		if strings.EqualFold(def.Name, toolName) {
+			return []Definition{def}, nil
+		}
+	}
+	return nil, fmt.Errorf("toolset %q has no tool %q", group, toolName)
+}
+
+// Lookup returns a function resolving names with the given parameters, e.g.
+// for spec.Spec.ToolDefinitions.
+func (r *Registry) Lookup(p FactoryParams) func(name string) ([]Definition, error) {
+	return func(name string) ([]Definition, error) {
+		return r.Resolve(name, p)
+	}
+}
@@ -0,0 +1,207 @@
+// Package remote executes tools on a remote worker over HTTP, e.g. when the
+// agent runs in a control plane but the tools must run on the build VM.
+//
+// The worker serves its tools with NewHandler:
+//
+//	GET  /tools              lists the tool definitions
+//	POST /tools/{name}/call  calls a tool
+//
+// and the agent uses a Client as its tool.Executor, with the definitions
+// listed by the worker as its tools.
+package remote
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/invopop/jsonschema"
+)
+
+// definition is the wire format of a tool definition.
+type definition struct {
+	Name           string             `json:"name"`
+	Description    string             `json:"description"`
+	InputSchema    *jsonschema.Schema `json:"input_schema"`
+	Mutating       bool               `json:"mutating,omitempty"`
+	MaxResultBytes int                `json:"max_result_bytes,omitempty"`
+}
+
+type callRequest struct {
+	RunID      string          `json:"run_id,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	Input      json.RawMessage `json:"input"`
+}
+
+// callResponse contains the output of the tool, or its error.
+type callResponse struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Client calls the tools of a worker, it implements tool.Executor.
+type Client struct {
+	// URL is the base URL of the worker (mandatory).
+	URL string
+	// Token is sent as a bearer token (optional).
+	Token string
+	// HTTPClient defaults to http.DefaultClient. Set a timeout on it to
+	// bound the tool calls.
+	HTTPClient *http.Client
+}
+
+// Definitions lists the tools of the worker. Their UseFunc calls the worker,
+// so they can also be used without setting the Client as the executor.
+func (c *Client) Definitions(ctx context.Context) ([]tool.Definition, error) {
+	var defs []definition
+	if err := c.do(ctx, http.MethodGet, "/tools", nil, &defs); err != nil {
+		return nil, fmt.Errorf("list remote tools: %w", err)
+	}
+	var tools []tool.Definition
+	for _, d := range defs {
+		name := d.Name
+		tools = append(tools, tool.Definition{
+			ToolDefinition: llm.ToolDefinition{
+				Name:        d.Name,
+				Description: d.Description,
+				Schema:      d.InputSchema,
+			},
+			UseFunc: func(ctx context.Context, input json.RawMessage) (string, error) {
+				return c.Execute(ctx, tool.Call{RunID: tool.RunIDFromContext(ctx), Name: name, Input: input})
+			},
+			Mutating:       d.Mutating,
+			MaxResultBytes: d.MaxResultBytes,
+		})
+	}
+	return tools, nil
+}
+
+// Execute calls the tool on the worker. Errors of the tool are returned as
+// is, so the model sees the same error as with a local tool.
+func (c *Client) Execute(ctx context.Context, call tool.Call) (string, error) {
+	req := callRequest{RunID: call.RunID, ToolCallID: call.ToolCallID, Input: call.Input}
+	var resp callResponse
+	if err := c.do(ctx, http.MethodPost, "/tools/"+url.PathEscape(call.Name)+"/call", req, &resp); err != nil {
+		return "", fmt.Errorf("call remote tool %s: %w", call.Name, err)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("%s", resp.Error)
+	}
+	return resp.Output, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := cmp.Or(c.HTTPClient, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+type HandlerParams struct {
+	// Tools are the tools served by the worker (mandatory).
+	Tools []tool.Definition
+	// Token is required as a bearer token (optional, but the tools are
+	// served to anyone who can reach the worker without it).
+	Token string
+}
+
+// NewHandler serves the tools to Clients.
+func NewHandler(p HandlerParams) http.Handler {
+	tools := map[string]tool.Definition{}
+	var defs []definition
+	for _, t := range p.Tools {
+		tools[t.Name] = t
+		defs = append(defs, definition{
+			Name:           t.Name,
+			Description:    t.Description,
+			InputSchema:    t.Schema,
+			Mutating:       t.Mutating,
+			MaxResultBytes: t.MaxResultBytes,
+		})
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tools", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, defs)
+	})
+	mux.HandleFunc("POST /tools/{name}/call", func(w http.ResponseWriter, r *http.Request) {
+		t, ok := tools[r.PathValue("name")]
+		if !ok {
+			http.Error(w, "unknown tool", http.StatusNotFound)
+			return
+		}
+		var req callRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("decode request: %s", err), http.StatusBadRequest)
+			return
+		}
+		output, err := call(tool.WithRunID(r.Context(), req.RunID), t, req.Input)
+		if err != nil {
+			writeJSON(w, callResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, callResponse{Output: output})
+	})
+
+	if p.Token == "" {
+		return mux
+	}
+	want := []byte("Bearer " + p.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// call calls the tool, converting a panic into an error like the agent does.
+func call(ctx context.Context, t tool.Definition, input json.RawMessage) (res string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = "", fmt.Errorf("tool panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return t.UseFunc(ctx, input)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,46 @@
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jsoncodec"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/llm"
+)
+
+// New creates a tool Definition with a typed input. The JSON schema is
+// generated from InputT and the raw LLM input is unmarshaled into it before
+// calling fn.
+func New[InputT any](name, description string, fn func(context.Context, InputT) (string, error)) Definition {
+	return Definition{
+		ToolDefinition: llm.ToolDefinition{
+			Name:        name,
+			Description: description,
+			Schema:      GenerateSchema[InputT](),
+		},
+		UseFunc: func(ctx context.Context, llmInput json.RawMessage) (string, error) {
+			var input InputT
+			if err := jsoncodec.Unmarshal(llmInput, &input); err != nil {
+				return "", fmt.Errorf("unmarshal input: %w", err)
+			}
+			return fn(ctx, input)
+		},
+	}
+}
+
+// NewMutating is New for tools changing files or external systems. dryRun
+// validates the input and describes the effect of the call without doing
+// it, see Definition.Mutating.
+func NewMutating[InputT any](name, description string, fn, dryRun func(context.Context, InputT) (string, error)) Definition {
+	def := New(name, description, fn)
+	def.Mutating = true
+	def.DryRunFunc = func(ctx context.Context, llmInput json.RawMessage) (string, error) {
+		var input InputT
+		if err := jsoncodec.Unmarshal(llmInput, &input); err != nil {
+			return "", fmt.Errorf("unmarshal input: %w", err)
+		}
+		return dryRun(ctx, input)
+	}
+	return def
+}
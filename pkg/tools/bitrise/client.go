@@ -0,0 +1,295 @@
+// Package bitrise provides tools to query builds, logs, artifacts and
+// workflows via the Bitrise API.
+package bitrise
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+const defaultBaseURL = "https://api.bitrise.io/v0.1"
+
+// maxCachedLogs bounds the raw logs of finished builds kept by a client.
+const maxCachedLogs = 8
+
+type NewClientParams struct {
+	// Token is a Bitrise personal access token or workspace API token
+	// (mandatory, unless the secrets of the run have it).
+	Token string
+	// BaseURL defaults to https://api.bitrise.io/v0.1.
+	BaseURL    string
+	HTTPClient *http.Client
+	// TokenSecret is the secret holding the token if Token is empty, fetched
+	// from the secrets of the run (see secrets.FromContext). Defaults to
+	// DefaultTokenSecret.
+	TokenSecret string
+}
+
+// DefaultTokenSecret is the default name of the secret holding the token.
+const DefaultTokenSecret = "BITRISE_API_TOKEN"
+
+// Client is a minimal Bitrise API client covering what the tools need.
+type Client struct {
+	token       string
+	tokenSecret string
+	baseURL     string
+	httpClient  *http.Client
+
+	mu   sync.Mutex
+	logs map[string]string // raw logs of finished builds by app and build slug
+}
+
+func NewClient(p NewClientParams) *Client {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &Client{
+		token:       p.Token,
+		tokenSecret: cmp.Or(p.TokenSecret, DefaultTokenSecret),
+		baseURL:     baseURL,
+		httpClient:  httpClient,
+	}
+}
+
+type Build struct {
+	Slug              string     `json:"slug"`
+	BuildNumber       int        `json:"build_number"`
+	Status            int        `json:"status"`
+	StatusText        string     `json:"status_text"`
+	AbortReason       string     `json:"abort_reason,omitempty"`
+	Branch            string     `json:"branch"`
+	CommitHash        string     `json:"commit_hash"`
+	CommitMessage     string     `json:"commit_message"`
+	PullRequestID     int        `json:"pull_request_id,omitempty"`
+	TriggeredWorkflow string     `json:"triggered_workflow"`
+	StackIdentifier   string     `json:"stack_identifier"`
+	MachineTypeID     string     `json:"machine_type_id"`
+	TriggeredAt       time.Time  `json:"triggered_at"`
+	StartedOnWorkerAt *time.Time `json:"started_on_worker_at,omitempty"`
+	FinishedAt        *time.Time `json:"finished_at,omitempty"`
+	IsOnHold          bool       `json:"is_on_hold"`
+	IsProcessed       bool       `json:"is_processed"`
+}
+
+type Artifact struct {
+	Slug                string `json:"slug"`
+	Title               string `json:"title"`
+	ArtifactType        string `json:"artifact_type"`
+	FileSizeBytes       int64  `json:"file_size_bytes"`
+	IsPublicPageEnabled bool   `json:"is_public_page_enabled"`
+}
+
+type ListBuildsParams struct {
+	Branch   string
+	Workflow string
+	// Status filters by build status: 0 (not finished), 1 (successful),
+	// 2 (failed), 3 (aborted with failure), 4 (aborted with success).
+	Status *int
+	Limit  int
+}
+
+func (c *Client) ListBuilds(ctx context.Context, appSlug string, p ListBuildsParams) ([]Build, error) {
+	q := url.Values{}
+	if p.Branch != "" {
+		q.Set("branch", p.Branch)
+	}
+	if p.Workflow != "" {
+		q.Set("workflow", p.Workflow)
+	}
+	if p.Status != nil {
+		q.Set("status", fmt.Sprint(*p.Status))
+	}
+	if p.Limit > 0 {
+		q.Set("limit", fmt.Sprint(p.Limit))
+	}
+	var resp struct {
+		Data []Build `json:"data"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("/apps/%s/builds?%s", url.PathEscape(appSlug), q.Encode()), &resp); err != nil {
+		return nil, fmt.Errorf("list builds: %w", err)
+	}
+	return resp.Data, nil
+}
+
+func (c *Client) GetBuild(ctx context.Context, appSlug, buildSlug string) (Build, error) {
+	var resp struct {
+		Data Build `json:"data"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("/apps/%s/builds/%s", url.PathEscape(appSlug), url.PathEscape(buildSlug)), &resp); err != nil {
+		return Build{}, fmt.Errorf("get build: %w", err)
+	}
+	return resp.Data, nil
+}
+
+// GetBuildLog returns the full raw log of a finished build. The raw logs of
+// finished builds don't change, they are downloaded once and kept by the
+// client.
+func (c *Client) GetBuildLog(ctx context.Context, appSlug, buildSlug string) (string, error) {
+	key := appSlug + "/" + buildSlug
+	c.mu.Lock()
+	log, ok := c.logs[key]
+	c.mu.Unlock()
+	if ok {
+		return log, nil
+	}
+
+	var resp struct {
+		IsArchived        bool   `json:"is_archived"`
+		ExpiringRawLogURL string `json:"expiring_raw_log_url"`
+		LogChunks         []struct {
+			Chunk    string `json:"chunk"`
+			Position int    `json:"position"`
+		} `json:"log_chunks"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("/apps/%s/builds/%s/log", url.PathEscape(appSlug), url.PathEscape(buildSlug)), &resp); err != nil {
+		return "", fmt.Errorf("get build log info: %w", err)
+	}
+	if resp.ExpiringRawLogURL == "" {
+		// The build is still running, only the chunks are available.
+		for _, chunk := range resp.LogChunks {
+			log += chunk.Chunk
+		}
+		return log, nil
+	}
+
+	// The raw log URL is pre-signed, it must not receive the API token.
+	b, err := c.get(ctx, resp.ExpiringRawLogURL, false)
+	if err != nil {
+		return "", fmt.Errorf("download raw log: %w", err)
+	}
+	log = string(b)
+	c.mu.Lock()
+	if len(c.logs) >= maxCachedLogs {
+		clear(c.logs)
+	}
+	if c.logs == nil {
+		c.logs = map[string]string{}
+	}
+	c.logs[key] = log
+	c.mu.Unlock()
+	return log, nil
+}
+
+func (c *Client) ListArtifacts(ctx context.Context, appSlug, buildSlug string) ([]Artifact, error) {
+	var resp struct {
+		Data []Artifact `json:"data"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("/apps/%s/builds/%s/artifacts", url.PathEscape(appSlug), url.PathEscape(buildSlug)), &resp); err != nil {
+		return nil, fmt.Errorf("list artifacts: %w", err)
+	}
+	return resp.Data, nil
+}
+
+// GetArtifactURL returns a short-lived download URL of an artifact.
+func (c *Client) GetArtifactURL(ctx context.Context, appSlug, buildSlug, artifactSlug string) (string, error) {
+	var resp struct {
+		Data struct {
+			ExpiringDownloadURL string `json:"expiring_download_url"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("/apps/%s/builds/%s/artifacts/%s", url.PathEscape(appSlug), url.PathEscape(buildSlug), url.PathEscape(artifactSlug))
+	if err := c.getJSON(ctx, path, &resp); err != nil {
+		return "", fmt.Errorf("get artifact: %w", err)
+	}
+	return resp.Data.ExpiringDownloadURL, nil
+}
+
+// DownloadArtifact downloads at most maxBytes of an artifact.
+func (c *Client) DownloadArtifact(ctx context.Context, appSlug, buildSlug, artifactSlug string, maxBytes int64) ([]byte, error) {
+	downloadURL, err := c.GetArtifactURL(ctx, appSlug, buildSlug, artifactSlug)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download artifact: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("download artifact: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxBytes))
+}
+
+func (c *Client) ListWorkflows(ctx context.Context, appSlug string) ([]string, error) {
+	var resp struct {
+		Data []string `json:"data"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("/apps/%s/build-workflows", url.PathEscape(appSlug)), &resp); err != nil {
+		return nil, fmt.Errorf("list workflows: %w", err)
+	}
+	return resp.Data, nil
+}
+
+// GetBitriseYML returns the app's bitrise.yml, which defines the workflows
+// and their steps.
+func (c *Client) GetBitriseYML(ctx context.Context, appSlug string) (string, error) {
+	b, err := c.get(ctx, c.baseURL+fmt.Sprintf("/apps/%s/bitrise.yml", url.PathEscape(appSlug)), true)
+	if err != nil {
+		return "", fmt.Errorf("get bitrise.yml: %w", err)
+	}
+	return string(b), nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v any) error {
+	b, err := c.get(ctx, c.baseURL+path, true)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unmarshal response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, u string, authenticate bool) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	if authenticate {
+		token := c.token
+		if token == "" {
+			if token, err = secrets.Get(ctx, c.tokenSecret); err != nil {
+				return nil, fmt.Errorf("get token: %w", err)
+			}
+		}
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		msg := string(b)
+		if len(msg) > 500 {
+			msg = truncate.Head(msg, 500) + "..."
+		}
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+	}
+	return b, nil
+}
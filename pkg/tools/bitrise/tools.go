@@ -0,0 +1,233 @@
+package bitrise
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/chunk"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/prompt/compress"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+const (
+	DefaultMaxLogBytes      = 30 * 1024
+	defaultMaxArtifactBytes = 100 * 1024
+	defaultBuildsLimit      = 10
+)
+
+type Toolset struct {
+	// Client is the authenticated Bitrise API client (mandatory).
+	Client *Client
+	// AppSlug is the app the tools operate on (mandatory).
+	AppSlug string
+	// MaxLogBytes limits the size of a build log read. Defaults to
+	// DefaultMaxLogBytes.
+	MaxLogBytes int
+	// CompressLogs strips ANSI codes and timestamps and collapses repeated
+	// lines of the build log parts before returning them.
+	CompressLogs bool
+}
+
+func (ts Toolset) Tools() []tool.Definition {
+	return []tool.Definition{
+		tool.New(
+			"ListBitriseBuilds",
+			"Lists the most recent builds of the app, optionally filtered by branch, workflow and status.",
+			ts.listBuilds,
+		),
+		tool.New(
+			"GetBitriseBuild",
+			"Returns the details of a build: status, branch, commit, workflow, stack, machine type and timing.",
+			ts.getBuild,
+		),
+		tool.New(
+			"GetBitriseBuildLog",
+			fmt.Sprintf("Reads a part of a build log. By default returns the end of the log, where failures usually are. "+
+				"Use offset to read earlier parts, or chunk to read the log in parts split at the step boundaries; "+
+				"at most %d bytes are returned per call.", ts.maxLogBytes()),
+			ts.getBuildLog,
+		),
+		tool.New(
+			"ListBitriseArtifacts",
+			"Lists the artifacts of a build (test reports, archives, logs) with their types and sizes.",
+			ts.listArtifacts,
+		),
+		tool.New(
+			"ReadBitriseArtifact",
+			"Reads the beginning of a text artifact, such as a test report.",
+			ts.readArtifact,
+		),
+		tool.New(
+			"ListBitriseWorkflows",
+			"Lists the workflows of the app.",
+			ts.listWorkflows,
+		),
+		tool.New(
+			"GetBitriseYML",
+			"Returns the bitrise.yml configuration of the app, which defines the workflows and their steps with their inputs.",
+			ts.getBitriseYML,
+		),
+	}
+}
+
+type listBuildsInput struct {
+	Branch   string `json:"branch,omitempty" jsonschema_description:"Filter by branch"`
+	Workflow string `json:"workflow,omitempty" jsonschema_description:"Filter by workflow"`
+	Status   *int   `json:"status,omitempty" jsonschema:"enum=0,enum=1,enum=2,enum=3,enum=4" jsonschema_description:"Filter by status: 0 running, 1 successful, 2 failed, 3 aborted with failure, 4 aborted with success"`
+	Limit    int    `json:"limit,omitempty" jsonschema_description:"Maximum number of builds to return. Defaults to 10."`
+}
+
+func (ts Toolset) listBuilds(ctx context.Context, input listBuildsInput) (string, error) {
+	limit := input.Limit
+	if limit <= 0 {
+		limit = defaultBuildsLimit
+	}
+	builds, err := ts.Client.ListBuilds(ctx, ts.AppSlug, ListBuildsParams{
+		Branch:   input.Branch,
+		Workflow: input.Workflow,
+		Status:   input.Status,
+		Limit:    limit,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(builds) == 0 {
+		return "No builds found.", nil
+	}
+	return marshal(builds)
+}
+
+type buildInput struct {
+	BuildSlug string `json:"build_slug" jsonschema_description:"The slug (ID) of the build"`
+}
+
+func (ts Toolset) getBuild(ctx context.Context, input buildInput) (string, error) {
+	build, err := ts.Client.GetBuild(ctx, ts.AppSlug, input.BuildSlug)
+	if err != nil {
+		return "", err
+	}
+	return marshal(build)
+}
+
+type buildLogInput struct {
+	BuildSlug string `json:"build_slug" jsonschema_description:"The slug (ID) of the build"`
+	Offset    *int   `json:"offset,omitempty" jsonschema_description:"Byte offset to start reading from. Negative values count from the end. Defaults to the last part of the log."`
+	Chunk     *int   `json:"chunk,omitempty" jsonschema_description:"Index of the chunk to read instead of offset (0-based), the chunks end at step boundaries where possible. Negative values count from the end."`
+}
+
+func (ts Toolset) getBuildLog(ctx context.Context, input buildLogInput) (string, error) {
+	log, err := ts.Client.GetBuildLog(ctx, ts.AppSlug, input.BuildSlug)
+	if err != nil {
+		return "", err
+	}
+	if input.Chunk != nil {
+		return ts.logChunk(log, *input.Chunk)
+	}
+	maxBytes := ts.maxLogBytes()
+	start := len(log) - maxBytes
+	if input.Offset != nil {
+		start = *input.Offset
+		if start < 0 {
+			start += len(log)
+		}
+	}
+	start = min(max(start, 0), len(log))
+	end := min(start+maxBytes, len(log))
+	// Don't cut multi-byte characters in half.
+	for start < end && !utf8.RuneStart(log[start]) {
+		start++
+	}
+	part := strings.ToValidUTF8(log[start:end], "")
+	if ts.CompressLogs {
+		part = compress.Compress(part, compress.Options{MaxTokens: compress.EstimateTokens(part)})
+	}
+	return fmt.Sprintf("[bytes %d-%d of %d]\n%s", start, end, len(log), part), nil
+}
+
+func (ts Toolset) logChunk(log string, requested int) (string, error) {
+	chunks := chunk.Split(log, chunk.Params{Kind: chunk.KindLog, MaxBytes: ts.maxLogBytes()})
+	index := requested
+	if index < 0 {
+		index += len(chunks)
+	}
+	if index < 0 || index >= len(chunks) {
+		return "", fmt.Errorf("chunk %d out of range, the log has %d chunks", requested, len(chunks))
+	}
+	c := chunks[index]
+	part := strings.ToValidUTF8(c.Text, "")
+	if ts.CompressLogs {
+		part = compress.Compress(part, compress.Options{MaxTokens: compress.EstimateTokens(part)})
+	}
+	return fmt.Sprintf("[chunk %d/%d, lines %d-%d]\n%s", c.Index, len(chunks)-1, c.StartLine, c.EndLine, part), nil
+}
+
+func (ts Toolset) listArtifacts(ctx context.Context, input buildInput) (string, error) {
+	artifacts, err := ts.Client.ListArtifacts(ctx, ts.AppSlug, input.BuildSlug)
+	if err != nil {
+		return "", err
+	}
+	if len(artifacts) == 0 {
+		return "The build has no artifacts.", nil
+	}
+	return marshal(artifacts)
+}
+
+type readArtifactInput struct {
+	BuildSlug    string `json:"build_slug" jsonschema_description:"The slug (ID) of the build"`
+	ArtifactSlug string `json:"artifact_slug" jsonschema_description:"The slug (ID) of the artifact"`
+}
+
+func (ts Toolset) readArtifact(ctx context.Context, input readArtifactInput) (string, error) {
+	b, err := ts.Client.DownloadArtifact(ctx, ts.AppSlug, input.BuildSlug, input.ArtifactSlug, defaultMaxArtifactBytes+1)
+	if err != nil {
+		return "", err
+	}
+	// Sniff the beginning, without the character the cut may have split.
+	head := b[:min(len(b), 1024)]
+	if len(head) < len(b) {
+		for i := max(len(head)-utf8.UTFMax, 0); i < len(head); i++ {
+			if utf8.RuneStart(head[i]) && !utf8.FullRune(head[i:]) {
+				head = head[:i]
+				break
+			}
+		}
+	}
+	if !utf8.Valid(head) {
+		return "", fmt.Errorf("artifact is not a text file")
+	}
+	if len(b) > defaultMaxArtifactBytes {
+		return strings.ToValidUTF8(string(b[:defaultMaxArtifactBytes]), "") +
+			fmt.Sprintf("\n\n[artifact truncated after %d bytes]", defaultMaxArtifactBytes), nil
+	}
+	return string(b), nil
+}
+
+func (ts Toolset) listWorkflows(ctx context.Context, _ struct{}) (string, error) {
+	workflows, err := ts.Client.ListWorkflows(ctx, ts.AppSlug)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(workflows, "\n"), nil
+}
+
+func (ts Toolset) getBitriseYML(ctx context.Context, _ struct{}) (string, error) {
+	return ts.Client.GetBitriseYML(ctx, ts.AppSlug)
+}
+
+func (ts Toolset) maxLogBytes() int {
+	if ts.MaxLogBytes > 0 {
+		return ts.MaxLogBytes
+	}
+	return DefaultMaxLogBytes
+}
+
+func marshal(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal: %w", err)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,66 @@
+package bitrise
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestBuildLogDownloadedOnce reads a finished build log in several parts,
+// the raw log must be downloaded only once.
+func TestBuildLogDownloadedOnce(t *testing.T) {
+	var downloads int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app/builds/build/log":
+			fmt.Fprintf(w, `{"expiring_raw_log_url":%q}`, server.URL+"/raw")
+		case "/raw":
+			downloads++
+			fmt.Fprint(w, strings.Repeat("log line\n", 100))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ts := Toolset{Client: NewClient(NewClientParams{Token: "token", BaseURL: server.URL}), AppSlug: "app", MaxLogBytes: 100}
+	for _, offset := range []int{0, 100, 200} {
+		if _, err := ts.getBuildLog(context.Background(), buildLogInput{BuildSlug: "build", Offset: &offset}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("the raw log was downloaded %d times, want once", downloads)
+	}
+}
+
+// TestReadArtifactSplitRune reads a text artifact with a multi-byte character
+// across the sniffed beginning, it must not be taken for a binary file.
+func TestReadArtifactSplitRune(t *testing.T) {
+	text := strings.Repeat("a", 1023) + "ő" + strings.Repeat("b", 100)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/app/builds/build/artifacts/artifact":
+			fmt.Fprintf(w, `{"data":{"expiring_download_url":%q}}`, server.URL+"/download")
+		case "/download":
+			fmt.Fprint(w, text)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ts := Toolset{Client: NewClient(NewClientParams{Token: "token", BaseURL: server.URL}), AppSlug: "app"}
+	got, err := ts.readArtifact(context.Background(), readArtifactInput{BuildSlug: "build", ArtifactSlug: "artifact"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != text {
+		t.Errorf("read %q, want the artifact", got)
+	}
+}
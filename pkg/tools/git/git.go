@@ -0,0 +1,141 @@
+// Package git provides tools for inspecting a local git repository. The tools
+// are read-only unless write mode is explicitly enabled.
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+// DefaultMaxOutputBytes is the default limit of a single tool output.
+const DefaultMaxOutputBytes = 100 * 1024
+
+type Toolset struct {
+	// Dir is the path to the git repository (mandatory).
+	Dir string
+	// AllowWrite enables tools that modify the repository (staging,
+	// committing, creating branches).
+	AllowWrite bool
+	// MaxOutputBytes limits the size of a tool output. Defaults to
+	// DefaultMaxOutputBytes.
+	MaxOutputBytes int
+}
+
+// The registered toolset is read-only.
+func init() {
+	tool.MustRegister("git", func(p tool.FactoryParams) ([]tool.Definition, error) {
+		return Toolset{Dir: p.Dir}.Tools(), nil
+	})
+}
+
+// Tools returns the git tool definitions, including the write tools if
+// AllowWrite is set.
+func (ts Toolset) Tools() []tool.Definition {
+	tools := []tool.Definition{
+		tool.New(
+			"GitDiff",
+			"Shows the diff between two revisions, or between a revision and the working tree if `to` is omitted. "+
+				"Can be limited to specific paths.",
+			ts.diff,
+		),
+		tool.New(
+			"GitLog",
+			"Shows the commit history (hash, author, date, subject), optionally limited to specific paths.",
+			ts.log,
+		),
+		tool.New(
+			"GitBlame",
+			"Shows which commit and author last modified each line of a file. Can be limited to a line range.",
+			ts.blame,
+		),
+		tool.New(
+			"GitShowFile",
+			"Shows the content of a file at a given revision.",
+			ts.showFile,
+		),
+		tool.New(
+			"GitChangedFiles",
+			"Lists the files changed between two revisions with their change status (A: added, M: modified, D: deleted, R: renamed).",
+			ts.changedFiles,
+		),
+	}
+	if ts.AllowWrite {
+		tools = append(tools,
+			tool.NewMutating(
+				"GitCreateBranch",
+				"Creates a new branch from a revision and checks it out.",
+				ts.createBranch, ts.createBranchDryRun,
+			),
+			tool.NewMutating(
+				"GitCommit",
+				"Stages the given paths and creates a commit with the given message.",
+				ts.commit, ts.commitDryRun,
+			),
+		)
+	}
+	return tools
+}
+
+// run runs git in the repository, in the sandbox of the run if there is
+// one (see sandbox.FromContext).
+func (ts Toolset) run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = ts.Dir
+	if sb := sandbox.FromContext(ctx); sb != nil {
+		var err error
+		if cmd, err = sb.Command(ctx, ts.Dir, "git", args...); err != nil {
+			return "", err
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+
+	out := stdout.String()
+	maxBytes := ts.MaxOutputBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxOutputBytes
+	}
+	if len(out) > maxBytes {
+		head := truncate.Head(out, maxBytes)
+		out = fmt.Sprintf(
+			"%s\n\n[output truncated, %d of %d bytes shown, narrow down the request (e.g. with paths)]",
+			head, len(head), len(out),
+		)
+	}
+	if out == "" {
+		return "No output.", nil
+	}
+	return out, nil
+}
+
+// validateRev rejects revisions that could be interpreted as command line
+// flags.
+func validateRev(name, rev string) error {
+	if strings.HasPrefix(rev, "-") {
+		return fmt.Errorf("invalid %s %q", name, rev)
+	}
+	return nil
+}
+
+func withPaths(args []string, paths []string) []string {
+	if len(paths) == 0 {
+		return args
+	}
+	return append(append(args, "--"), paths...)
+}
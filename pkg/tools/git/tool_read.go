@@ -0,0 +1,122 @@
+package git
+
+import (
+	"context"
+	"fmt"
+)
+
+const defaultLogLimit = 20
+
+type diffInput struct {
+	From         string   `json:"from" jsonschema_description:"The base revision (commit hash, branch or tag)"`
+	To           string   `json:"to,omitempty" jsonschema_description:"The target revision. Compares to the working tree if omitted."`
+	Paths        []string `json:"paths,omitempty" jsonschema_description:"Limit the diff to these paths"`
+	ContextLines int      `json:"context_lines,omitempty" jsonschema_description:"Number of context lines around changes. Defaults to 3."`
+}
+
+func (ts Toolset) diff(ctx context.Context, input diffInput) (string, error) {
+	if err := validateRev("from", input.From); err != nil {
+		return "", err
+	}
+	if err := validateRev("to", input.To); err != nil {
+		return "", err
+	}
+	if input.From == "" {
+		return "", fmt.Errorf("from is required")
+	}
+	args := []string{"diff", "--no-color", "--no-ext-diff"}
+	if input.ContextLines > 0 {
+		args = append(args, fmt.Sprintf("--unified=%d", input.ContextLines))
+	}
+	args = append(args, input.From)
+	if input.To != "" {
+		args = append(args, input.To)
+	}
+	return ts.run(ctx, withPaths(args, input.Paths)...)
+}
+
+type logInput struct {
+	Revision string   `json:"revision,omitempty" jsonschema_description:"The revision to start from. Defaults to HEAD."`
+	Paths    []string `json:"paths,omitempty" jsonschema_description:"Only show commits touching these paths"`
+	Limit    int      `json:"limit,omitempty" jsonschema_description:"Maximum number of commits to show. Defaults to 20."`
+}
+
+func (ts Toolset) log(ctx context.Context, input logInput) (string, error) {
+	if err := validateRev("revision", input.Revision); err != nil {
+		return "", err
+	}
+	limit := input.Limit
+	if limit <= 0 {
+		limit = defaultLogLimit
+	}
+	args := []string{"log", "--no-color", fmt.Sprintf("--max-count=%d", limit), "--format=%h %an %ad %s", "--date=short"}
+	if input.Revision != "" {
+		args = append(args, input.Revision)
+	}
+	return ts.run(ctx, withPaths(args, input.Paths)...)
+}
+
+type blameInput struct {
+	Path      string `json:"path" jsonschema_description:"Path of the file relative to the repository root"`
+	Revision  string `json:"revision,omitempty" jsonschema_description:"The revision to blame. Defaults to the working tree."`
+	StartLine int    `json:"start_line,omitempty" jsonschema_description:"First line of the range to blame (1-based)"`
+	EndLine   int    `json:"end_line,omitempty" jsonschema_description:"Last line of the range to blame (inclusive)"`
+}
+
+func (ts Toolset) blame(ctx context.Context, input blameInput) (string, error) {
+	if input.Path == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if err := validateRev("revision", input.Revision); err != nil {
+		return "", err
+	}
+	args := []string{"blame", "--date=short"}
+	if input.StartLine > 0 {
+		lineRange := fmt.Sprintf("%d,", input.StartLine)
+		if input.EndLine > 0 {
+			lineRange += fmt.Sprint(input.EndLine)
+		}
+		args = append(args, "-L", lineRange)
+	}
+	if input.Revision != "" {
+		args = append(args, input.Revision)
+	}
+	return ts.run(ctx, withPaths(args, []string{input.Path})...)
+}
+
+type showFileInput struct {
+	Path     string `json:"path" jsonschema_description:"Path of the file relative to the repository root"`
+	Revision string `json:"revision" jsonschema_description:"The revision to show the file at"`
+}
+
+func (ts Toolset) showFile(ctx context.Context, input showFileInput) (string, error) {
+	if input.Path == "" || input.Revision == "" {
+		return "", fmt.Errorf("path and revision are required")
+	}
+	if err := validateRev("revision", input.Revision); err != nil {
+		return "", err
+	}
+	return ts.run(ctx, "show", "--no-color", input.Revision+":"+input.Path)
+}
+
+type changedFilesInput struct {
+	From string `json:"from" jsonschema_description:"The base revision"`
+	To   string `json:"to,omitempty" jsonschema_description:"The target revision. Compares to the working tree if omitted."`
+}
+
+func (ts Toolset) changedFiles(ctx context.Context, input changedFilesInput) (string, error) {
+	if input.From == "" {
+		return "", fmt.Errorf("from is required")
+	}
+	if err := validateRev("from", input.From); err != nil {
+		return "", err
+	}
+	if err := validateRev("to", input.To); err != nil {
+		return "", err
+	}
+	args := []string{"diff", "--no-color", "--name-status", input.From}
+	if input.To != "" {
+		args = append(args, input.To)
+	}
+	return ts.run(ctx, args...)
+}
@@ -0,0 +1,76 @@
+package git
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"strings"
+)
+
+type createBranchInput struct {
+	Name     string `json:"name" jsonschema_description:"Name of the new branch"`
+	Revision string `json:"revision,omitempty" jsonschema_description:"The revision to branch from. Defaults to HEAD."`
+}
+
+func (input createBranchInput) validate() error {
+	if input.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if err := validateRev("name", input.Name); err != nil {
+		return err
+	}
+	return validateRev("revision", input.Revision)
+}
+
+func (ts Toolset) createBranch(ctx context.Context, input createBranchInput) (string, error) {
+	if err := input.validate(); err != nil {
+		return "", err
+	}
+	args := []string{"checkout", "-b", input.Name}
+	if input.Revision != "" {
+		args = append(args, input.Revision)
+	}
+	if _, err := ts.run(ctx, args...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Created and checked out branch %q.", input.Name), nil
+}
+
+func (ts Toolset) createBranchDryRun(_ context.Context, input createBranchInput) (string, error) {
+	if err := input.validate(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Would create and check out branch %q from %s.", input.Name, cmp.Or(input.Revision, "HEAD")), nil
+}
+
+type commitInput struct {
+	Message string   `json:"message" jsonschema_description:"The commit message"`
+	Paths   []string `json:"paths" jsonschema_description:"Paths to stage before committing"`
+}
+
+func (input commitInput) validate() error {
+	if input.Message == "" || len(input.Paths) == 0 {
+		return fmt.Errorf("message and paths are required")
+	}
+	return nil
+}
+
+func (ts Toolset) commit(ctx context.Context, input commitInput) (string, error) {
+	if err := input.validate(); err != nil {
+		return "", err
+	}
+	if _, err := ts.run(ctx, withPaths([]string{"add"}, input.Paths)...); err != nil {
+		return "", err
+	}
+	if _, err := ts.run(ctx, "commit", "--message", input.Message); err != nil {
+		return "", err
+	}
+	return ts.run(ctx, "log", "--no-color", "--max-count=1", "--format=%H %s")
+}
+
+func (ts Toolset) commitDryRun(_ context.Context, input commitInput) (string, error) {
+	if err := input.validate(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Would stage %s and commit them with the message:\n%s", strings.Join(input.Paths, ", "), input.Message), nil
+}
@@ -0,0 +1,301 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jail"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/journal"
+)
+
+// HunkError describes why a hunk could not be applied.
+type HunkError struct {
+	Path   string
+	Hunk   int // 1-based index of the hunk within the file patch
+	Reason string
+}
+
+func (e HunkError) Error() string {
+	if e.Hunk == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Reason)
+	}
+	return fmt.Sprintf("%s: hunk #%d: %s", e.Path, e.Hunk, e.Reason)
+}
+
+// ApplyError aggregates all failures of a patch. If it is returned, no file
+// was modified.
+type ApplyError struct {
+	Failures []HunkError
+}
+
+func (e *ApplyError) Error() string {
+	var lines []string
+	for _, f := range e.Failures {
+		lines = append(lines, f.Error())
+	}
+	return fmt.Sprintf("patch does not apply, no files were changed:\n%s", strings.Join(lines, "\n"))
+}
+
+// FileResult describes the outcome of applying a file patch.
+type FileResult struct {
+	Path    string
+	Action  string // "create", "delete", "modify" or "rename"
+	Hunks   int
+	Offsets []int // line offsets at which hunks were applied, relative to the header
+	content string
+	oldPath string
+}
+
+// applyRunID is the run of the journal restoring the files if writing a
+// patch fails, see Apply.
+const applyRunID = "apply"
+
+// Apply validates all file patches against the files under root and, unless
+// dryRun is set, writes the changes. It is all or nothing: if any hunk fails,
+// an *ApplyError listing every failure is returned and nothing is written.
+// If writing a file fails, the files written before it are restored.
+func Apply(root string, patches []FilePatch, dryRun bool) ([]FileResult, error) {
+	var results []FileResult
+	var failures []HunkError
+	patched := map[string]bool{}
+	for _, fp := range patches {
+		res, errs := applyFile(root, fp)
+		failures = append(failures, errs...)
+		results = append(results, res)
+		// The file patches are validated against the original files, a
+		// second patch of a file would overwrite the first one.
+		for _, p := range slices.Compact([]string{fp.OldPath, fp.NewPath}) {
+			if p == "" {
+				continue
+			}
+			if patched[p] {
+				failures = append(failures, HunkError{Path: p, Reason: "the file is patched more than once, merge its patches"})
+			}
+			patched[p] = true
+		}
+	}
+	if len(failures) > 0 {
+		return nil, &ApplyError{Failures: failures}
+	}
+	if dryRun {
+		return results, nil
+	}
+
+	original := journal.New()
+	for _, res := range results {
+		if err := writeRecorded(root, res, original); err != nil {
+			if rollbackErr := original.RollbackRun(applyRunID); rollbackErr != nil {
+				return nil, fmt.Errorf("%w, restoring the patched files failed, the patch is partially applied: %w", err, rollbackErr)
+			}
+			return nil, fmt.Errorf("%w, no files were changed", err)
+		}
+	}
+	return results, nil
+}
+
+func applyFile(root string, fp FilePatch) (FileResult, []HunkError) {
+	path := fp.NewPath
+	if path == "" {
+		path = fp.OldPath
+	}
+	res := FileResult{Path: path, Hunks: len(fp.Hunks), oldPath: fp.OldPath}
+	fail := func(hunk int, format string, args ...any) (FileResult, []HunkError) {
+		return res, []HunkError{{Path: path, Hunk: hunk, Reason: fmt.Sprintf(format, args...)}}
+	}
+
+	for _, p := range []string{fp.OldPath, fp.NewPath} {
+		if p == "" {
+			continue
+		}
+		if _, err := resolve(root, p); err != nil {
+			return fail(0, "%s", err)
+		}
+	}
+
+	var lines []string
+	trailingNewline := true
+	switch {
+	case fp.OldPath == "":
+		res.Action = "create"
+		if _, err := os.Stat(mustResolve(root, fp.NewPath)); err == nil {
+			return fail(0, "file already exists")
+		}
+	default:
+		content, err := os.ReadFile(mustResolve(root, fp.OldPath))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fail(0, "file does not exist")
+			}
+			return fail(0, "read file: %s", err)
+		}
+		s := string(content)
+		trailingNewline = s == "" || strings.HasSuffix(s, "\n")
+		if s != "" {
+			lines = strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+		}
+		switch {
+		case fp.NewPath == "":
+			res.Action = "delete"
+		case fp.NewPath != fp.OldPath:
+			res.Action = "rename"
+		default:
+			res.Action = "modify"
+		}
+	}
+
+	var failures []HunkError
+	var delta, minPos int
+	for i, h := range fp.Hunks {
+		old := h.oldLines()
+		expected := max(h.OldStart-1, 0) + delta
+		if len(old) == 0 && h.OldStart > 0 {
+			expected = h.OldStart + delta // pure insertion after line OldStart
+		}
+		pos := findLines(lines, old, expected, minPos)
+		if pos < 0 {
+			failures = append(failures, HunkError{Path: path, Hunk: i + 1, Reason: mismatchReason(lines, old, expected)})
+			continue
+		}
+		res.Offsets = append(res.Offsets, pos-expected)
+		newLines := h.newLines()
+		lines = slices.Concat(lines[:pos], newLines, lines[pos+len(old):])
+		delta += len(newLines) - len(old)
+		minPos = pos + len(newLines)
+		if h.NoNewlineAtEnd && pos+len(newLines) == len(lines) {
+			trailingNewline = false
+		} else if pos+len(newLines) == len(lines) && len(newLines) > 0 {
+			trailingNewline = true
+		}
+	}
+	if len(failures) > 0 {
+		return res, failures
+	}
+
+	if res.Action != "delete" {
+		res.content = strings.Join(lines, "\n")
+		if trailingNewline && len(lines) > 0 {
+			res.content += "\n"
+		}
+	}
+	return res, nil
+}
+
+// findLines finds needle in lines, preferring the expected position and then
+// the closest position to it, not before minPos.
+func findLines(lines, needle []string, expected, minPos int) int {
+	matchesAt := func(pos int) bool {
+		if pos < minPos || pos+len(needle) > len(lines) {
+			return false
+		}
+		for i, l := range needle {
+			if lines[pos+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+	for offset := 0; offset <= len(lines); offset++ {
+		if matchesAt(expected + offset) {
+			return expected + offset
+		}
+		if offset > 0 && matchesAt(expected-offset) {
+			return expected - offset
+		}
+	}
+	return -1
+}
+
+// mismatchReason explains the first difference between the expected lines
+// and the file content at the expected position.
+func mismatchReason(lines, old []string, expected int) string {
+	if expected > len(lines) {
+		return fmt.Sprintf("expected at line %d, but the file only has %d lines", expected+1, len(lines))
+	}
+	for i, want := range old {
+		n := expected + i
+		if n >= len(lines) {
+			return fmt.Sprintf("context not found anywhere in the file; at line %d expected %q, got end of file", n+1, want)
+		}
+		if lines[n] != want {
+			hint := ""
+			if strings.TrimSpace(lines[n]) == strings.TrimSpace(want) {
+				hint = " (whitespace differs)"
+			}
+			return fmt.Sprintf(
+				"context not found anywhere in the file; at line %d expected %q, got %q%s",
+				n+1, want, lines[n], hint,
+			)
+		}
+	}
+	return "context not found anywhere in the file, hunks may be out of order"
+}
+
+// writeRecorded writes the result after recording the files it changes in
+// the journal.
+func writeRecorded(root string, res FileResult, original *journal.Journal) error {
+	for _, p := range []string{res.oldPath, res.Path} {
+		if p == "" {
+			continue
+		}
+		if err := original.Record(applyRunID, mustResolve(root, p)); err != nil {
+			return err
+		}
+	}
+	return writeResult(root, res)
+}
+
+func writeResult(root string, res FileResult) error {
+	path := mustResolve(root, res.Path)
+	switch res.Action {
+	case "delete":
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("delete %s: %w", res.Path, err)
+		}
+		return nil
+	}
+
+	mode := os.FileMode(0o644)
+	if res.oldPath != "" {
+		if info, err := os.Stat(mustResolve(root, res.oldPath)); err == nil {
+			mode = info.Mode().Perm()
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create directory of %s: %w", res.Path, err)
+	}
+	if err := os.WriteFile(path, []byte(res.content), mode); err != nil {
+		return fmt.Errorf("write %s: %w", res.Path, err)
+	}
+	if res.Action == "rename" {
+		if err := os.Remove(mustResolve(root, res.oldPath)); err != nil {
+			return fmt.Errorf("remove %s: %w", res.oldPath, err)
+		}
+	}
+	return nil
+}
+
+// resolve returns the absolute path of p, rejecting paths outside of root,
+// including the ones escaping it through symlinks.
+func resolve(root, p string) (string, error) {
+	if filepath.IsAbs(p) {
+		return "", fmt.Errorf("path %q is outside of the working tree", p)
+	}
+	j, err := jail.New(root)
+	if err != nil {
+		return "", err
+	}
+	abs, err := j.Resolve(p)
+	if errors.Is(err, jail.ErrOutsideRoot) {
+		return "", fmt.Errorf("path %q is outside of the working tree", p)
+	}
+	return abs, err
+}
+
+func mustResolve(root, p string) string {
+	abs, _ := resolve(root, p)
+	return abs
+}
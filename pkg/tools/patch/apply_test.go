@@ -0,0 +1,80 @@
+package patch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const modifyA = `--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+ one
+-two
++TWO
+`
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    string
+		wantErr  string
+		wantFile string
+		// rejected is set if the patch must fail validation, before
+		// writing any file.
+		rejected bool
+	}{
+		{"modify", modifyA, "", "one\nTWO\n", false},
+		{
+			// The write of the second file fails (a directory is in the
+			// way), the first one must be restored.
+			"write fails",
+			modifyA + "--- a/b.txt\n+++ b/dir\n@@ -1 +1 @@\n-b\n+B\n",
+			"no files were changed", "one\ntwo\n", false,
+		},
+		{
+			"same file twice",
+			modifyA + strings.ReplaceAll(modifyA, "TWO", "2"),
+			"patched more than once", "one\ntwo\n", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("one\ntwo\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, "b.txt"), []byte("b\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Mkdir(filepath.Join(root, "dir"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			patches, err := Parse(tt.patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Apply(root, patches, false)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatal(err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Apply = %v, want %q", err, tt.wantErr)
+			}
+			var applyErr *ApplyError
+			if errors.As(err, &applyErr) != tt.rejected {
+				t.Errorf("Apply = %v, rejected: %t, want %t", err, !tt.rejected, tt.rejected)
+			}
+			b, err := os.ReadFile(filepath.Join(root, "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.wantFile {
+				t.Errorf("a.txt = %q, want %q", b, tt.wantFile)
+			}
+		})
+	}
+}
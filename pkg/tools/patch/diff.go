@@ -0,0 +1,149 @@
+package patch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FilePatch is the set of hunks to apply to a single file.
+type FilePatch struct {
+	OldPath string // empty for new files
+	NewPath string // empty for deleted files
+	Hunks   []Hunk
+}
+
+type Hunk struct {
+	OldStart int // 1-based line number in the original file
+	NewStart int
+	Lines    []HunkLine
+	// NoNewlineAtEnd is set if the new side of the hunk ends without a
+	// trailing newline.
+	NoNewlineAtEnd bool
+}
+
+type HunkLine struct {
+	Kind byte // ' ', '-' or '+'
+	Text string
+}
+
+// oldLines returns the lines the hunk expects in the original file.
+func (h Hunk) oldLines() []string {
+	var lines []string
+	for _, l := range h.Lines {
+		if l.Kind != '+' {
+			lines = append(lines, l.Text)
+		}
+	}
+	return lines
+}
+
+// newLines returns the lines replacing oldLines.
+func (h Hunk) newLines() []string {
+	var lines []string
+	for _, l := range h.Lines {
+		if l.Kind != '-' {
+			lines = append(lines, l.Text)
+		}
+	}
+	return lines
+}
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Parse parses a unified diff, as produced by `git diff` or `diff -u`.
+func Parse(diff string) ([]FilePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(diff, "\r\n", "\n"), "\n")
+	var patches []FilePatch
+	var current *FilePatch
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patches = append(patches, FilePatch{
+				OldPath: parsePath(line[4:]),
+				NewPath: parsePath(lines[i+1][4:]),
+			})
+			current = &patches[len(patches)-1]
+			i++
+
+		case strings.HasPrefix(line, "@@"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk without file header", i+1)
+			}
+			m := hunkHeaderRegexp.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header %q", i+1, line)
+			}
+			oldCount, newCount := parseCount(m[2]), parseCount(m[4])
+			hunk := Hunk{OldStart: atoi(m[1]), NewStart: atoi(m[3])}
+
+			// Read lines until both sides of the hunk are complete.
+			var oldSeen, newSeen int
+			for oldSeen < oldCount || newSeen < newCount {
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("hunk %q: unexpected end of diff", line)
+				}
+				l := lines[i]
+				if l == "" {
+					// Some editors strip the trailing space of empty context lines.
+					l = " "
+				}
+				switch l[0] {
+				case ' ':
+					oldSeen++
+					newSeen++
+				case '-':
+					oldSeen++
+				case '+':
+					newSeen++
+				case '\\':
+					continue
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line in hunk %q: %q", i+1, line, l)
+				}
+				hunk.Lines = append(hunk.Lines, HunkLine{Kind: l[0], Text: l[1:]})
+			}
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], `\`) {
+				i++
+				if last := hunk.Lines[len(hunk.Lines)-1]; last.Kind != '-' {
+					hunk.NoNewlineAtEnd = true
+				}
+			}
+			current.Hunks = append(current.Hunks, hunk)
+		}
+		// Everything else ("diff --git", "index", ...) is ignored.
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file patches found in diff")
+	}
+	return patches, nil
+}
+
+func parsePath(s string) string {
+	s, _, _ = strings.Cut(s, "\t") // strip timestamps of `diff -u`
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		return s[2:]
+	}
+	return s
+}
+
+func parseCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	return atoi(s)
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
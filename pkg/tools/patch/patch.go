@@ -0,0 +1,111 @@
+// Package patch provides a tool that applies unified diffs produced by the
+// model to a working tree, reporting hunk-level failures back to it.
+package patch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/journal"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+type Toolset struct {
+	// Root is the working tree the patch paths are relative to (mandatory).
+	Root string
+	// DryRunOnly forces every call to be a dry-run, the model can only
+	// validate patches.
+	DryRunOnly bool
+	// Journal records the files before patching them (optional), so the
+	// changes of a run can be reverted with journal.Journal.RollbackRun.
+	Journal *journal.Journal
+}
+
+func init() {
+	tool.MustRegister("patch", func(p tool.FactoryParams) ([]tool.Definition, error) {
+		return Toolset{Root: p.Dir, Journal: p.Journal}.Tools(), nil
+	})
+}
+
+func (ts Toolset) Tools() []tool.Definition {
+	return []tool.Definition{
+		tool.NewMutating(
+			"ApplyPatch",
+			"Applies a unified diff (as produced by `git diff`) to the working tree. Paths are relative to the repository root. "+
+				"Hunks must contain enough unchanged context lines to locate them; line numbers may be approximate. "+
+				"The patch is applied atomically: if any hunk fails, no file is changed and the failures are reported. "+
+				"Use dry_run to validate a patch without changing files.",
+			ts.applyPatch, ts.applyPatchDryRun,
+		),
+	}
+}
+
+type applyPatchInput struct {
+	Patch  string `json:"patch" jsonschema_description:"The unified diff to apply"`
+	DryRun bool   `json:"dry_run,omitempty" jsonschema_description:"Only validate the patch, don't change any files"`
+}
+
+// applyPatchDryRun validates the patch in dry-run mode of the agent.
+func (ts Toolset) applyPatchDryRun(ctx context.Context, input applyPatchInput) (string, error) {
+	input.DryRun = true
+	return ts.applyPatch(ctx, input)
+}
+
+func (ts Toolset) applyPatch(ctx context.Context, input applyPatchInput) (string, error) {
+	patches, err := Parse(input.Patch)
+	if err != nil {
+		return "", fmt.Errorf("parse patch: %w", err)
+	}
+	dryRun := input.DryRun || ts.DryRunOnly
+	if !dryRun {
+		if err := ts.record(ctx, patches); err != nil {
+			return "", err
+		}
+	}
+	results, err := Apply(ts.Root, patches, dryRun)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, res := range results {
+		line := fmt.Sprintf("%s: %s", res.Path, res.Action)
+		if res.Hunks > 0 {
+			line += fmt.Sprintf(", %d hunks", res.Hunks)
+		}
+		for i, offset := range res.Offsets {
+			if offset != 0 {
+				line += fmt.Sprintf(", hunk #%d applied with offset %d", i+1, offset)
+			}
+		}
+		lines = append(lines, line)
+	}
+	if dryRun {
+		return "Dry-run, the patch applies cleanly:\n" + strings.Join(lines, "\n"), nil
+	}
+	return "Patch applied:\n" + strings.Join(lines, "\n"), nil
+}
+
+// record saves the files touched by the patches in the journal of the run.
+func (ts Toolset) record(ctx context.Context, patches []FilePatch) error {
+	if ts.Journal == nil {
+		return nil
+	}
+	runID := tool.RunIDFromContext(ctx)
+	for _, fp := range patches {
+		for _, p := range []string{fp.OldPath, fp.NewPath} {
+			if p == "" {
+				continue
+			}
+			abs, err := resolve(ts.Root, p)
+			if err != nil {
+				continue // reported by Apply
+			}
+			if err := ts.Journal.Record(runID, abs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+// Package search provides a fast, pure-Go code search tool that respects
+// .gitignore files, so agents don't need to shell out to grep.
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/jail"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+)
+
+const (
+	DefaultMaxMatches  = 100
+	DefaultMaxFileSize = 5 * 1024 * 1024
+	maxLineLength      = 500
+)
+
+type Toolset struct {
+	// Root is the directory to search in (mandatory).
+	Root string
+	// MaxMatches is the upper limit of matches the model can request.
+	// Defaults to DefaultMaxMatches.
+	MaxMatches int
+	// MaxFileSize is the size above which files are skipped. Defaults to
+	// DefaultMaxFileSize.
+	MaxFileSize int64
+}
+
+func init() {
+	tool.MustRegister("search", func(p tool.FactoryParams) ([]tool.Definition, error) {
+		return Toolset{Root: p.Dir}.Tools(), nil
+	})
+}
+
+func (ts Toolset) Tools() []tool.Definition {
+	return []tool.Definition{
+		tool.New(
+			"SearchCode",
+			"Searches file contents with a regular expression (RE2 syntax), like ripgrep. "+
+				"Files ignored by .gitignore, binary files and the .git directory are skipped. "+
+				"Returns matches as `path:line: text`, context lines as `path-line- text`.",
+			ts.searchCode,
+		),
+		tool.New(
+			"FindFiles",
+			"Finds files by glob pattern (e.g. `**/*_test.go`). Files ignored by .gitignore are skipped.",
+			ts.findFiles,
+		),
+	}
+}
+
+type searchCodeInput struct {
+	Pattern        string   `json:"pattern" jsonschema_description:"The regular expression to search for"`
+	Path           string   `json:"path,omitempty" jsonschema_description:"Subdirectory to search in, relative to the repository root"`
+	Globs          []string `json:"globs,omitempty" jsonschema_description:"Only search files matching any of these globs (e.g. '*.go', 'src/**/*.ts'). Globs without a slash match the file name."`
+	IgnoreCase     bool     `json:"ignore_case,omitempty" jsonschema_description:"Search case-insensitively"`
+	ContextLines   int      `json:"context_lines,omitempty" jsonschema_description:"Number of lines to show before and after each match"`
+	MaxMatches     int      `json:"max_matches,omitempty" jsonschema_description:"Maximum number of matches to return"`
+	FilesWithMatch bool     `json:"files_with_matches,omitempty" jsonschema_description:"Only return the paths of matching files"`
+	IncludeIgnored bool     `json:"include_ignored,omitempty" jsonschema_description:"Also search files ignored by .gitignore"`
+}
+
+type fileMatches struct {
+	lines []string
+	// starts are the indexes of lines where the output of the matches begins.
+	starts []int
+	count  int
+}
+
+func (ts Toolset) searchCode(ctx context.Context, input searchCodeInput) (string, error) {
+	if input.Pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	pattern := input.Pattern
+	if input.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("compile pattern: %w", err)
+	}
+	maxMatches := ts.maxMatches(input.MaxMatches)
+
+	files, err := ts.walk(ctx, input.Path, input.Globs, input.IncludeIgnored)
+	if err != nil {
+		return "", err
+	}
+
+	// Files are searched in parallel, but picked up in walk order and stop
+	// being picked up once enough matches were found, so the output is
+	// deterministic.
+	results := make([]fileMatches, len(files))
+	var next, found atomic.Int64
+	var wg sync.WaitGroup
+	for range runtime.NumCPU() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && found.Load() < int64(maxMatches) {
+				i := int(next.Add(1) - 1)
+				if i >= len(files) {
+					return
+				}
+				results[i] = ts.searchFile(files[i], re, input.ContextLines, input.FilesWithMatch, maxMatches)
+				found.Add(int64(results[i].count))
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	var out []string
+	var total int
+	// The search stopped early if files were left unsearched.
+	limited := int(next.Load()) < len(files)
+	for _, r := range results {
+		if r.count == 0 {
+			continue
+		}
+		if remaining := maxMatches - total; r.count > remaining {
+			cut := len(r.lines)
+			if remaining < len(r.starts) {
+				cut = r.starts[remaining]
+			}
+			out = append(out, r.lines[:cut]...)
+			total = maxMatches
+			limited = true
+			break
+		}
+		total += r.count
+		out = append(out, r.lines...)
+	}
+	if total == 0 {
+		return "No matches found.", nil
+	}
+	result := strings.Join(out, "\n")
+	if limited {
+		result += fmt.Sprintf("\n\n[results limited to %d matches, narrow down the search with path or globs]", maxMatches)
+	}
+	return result, nil
+}
+
+// searchFile returns the matches of a file, it stops after limit+1 matches.
+func (ts Toolset) searchFile(rel string, re *regexp.Regexp, contextLines int, filesOnly bool, limit int) fileMatches {
+	content, err := os.ReadFile(filepath.Join(ts.Root, filepath.FromSlash(rel)))
+	if err != nil || isBinary(content) {
+		return fileMatches{}
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	var res fileMatches
+	lastPrinted := -1
+	for i, line := range lines {
+		if !re.MatchString(line) {
+			continue
+		}
+		res.count++
+		if filesOnly {
+			return fileMatches{lines: []string{rel}, starts: []int{0}, count: 1}
+		}
+		if res.count > limit {
+			break
+		}
+		res.starts = append(res.starts, len(res.lines))
+		start := max(i-contextLines, lastPrinted+1)
+		if lastPrinted >= 0 && start > lastPrinted+1 {
+			res.lines = append(res.lines, "--")
+		}
+		end := min(i+contextLines, len(lines)-1)
+		for j := start; j <= end; j++ {
+			sep := "-"
+			if re.MatchString(lines[j]) {
+				sep = ":"
+			}
+			res.lines = append(res.lines, fmt.Sprintf("%s%s%d%s %s", rel, sep, j+1, sep, cutLine(lines[j])))
+		}
+		lastPrinted = end
+	}
+	return res
+}
+
+type findFilesInput struct {
+	Pattern        string `json:"pattern" jsonschema_description:"The glob pattern to match, relative to the repository root"`
+	Path           string `json:"path,omitempty" jsonschema_description:"Subdirectory to search in, relative to the repository root"`
+	MaxResults     int    `json:"max_results,omitempty" jsonschema_description:"Maximum number of paths to return"`
+	IncludeIgnored bool   `json:"include_ignored,omitempty" jsonschema_description:"Also return files ignored by .gitignore"`
+}
+
+func (ts Toolset) findFiles(ctx context.Context, input findFilesInput) (string, error) {
+	if input.Pattern == "" {
+		return "", fmt.Errorf("pattern is required")
+	}
+	files, err := ts.walk(ctx, input.Path, []string{input.Pattern}, input.IncludeIgnored)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "No files found.", nil
+	}
+	maxResults := ts.maxMatches(input.MaxResults)
+	if len(files) > maxResults {
+		return fmt.Sprintf(
+			"%s\n\n[%d more files omitted, narrow down the pattern]",
+			strings.Join(files[:maxResults], "\n"), len(files)-maxResults,
+		), nil
+	}
+	return strings.Join(files, "\n"), nil
+}
+
+// walk returns the searchable files under subdir in lexical order, as slash
+// separated paths relative to the root.
+func (ts Toolset) walk(ctx context.Context, subdir string, globs []string, includeIgnored bool) ([]string, error) {
+	j, err := jail.New(ts.Root)
+	if err != nil {
+		return nil, err
+	}
+	root := j.Root()
+	relStart, err := j.Rel(subdir)
+	if err != nil {
+		return nil, fmt.Errorf("path %q is outside of the repository", subdir)
+	}
+	start := filepath.Join(root, filepath.FromSlash(relStart))
+	maxFileSize := ts.MaxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+
+	ig := &ignorer{}
+	if !includeIgnored && relStart != "." {
+		// Load the .gitignore files of the parent directories of subdir, the
+		// rest is loaded while walking.
+		ig.load(root, "")
+		parts := strings.Split(relStart, "/")
+		for i := 1; i < len(parts); i++ {
+			rel := strings.Join(parts[:i], "/")
+			ig.load(filepath.Join(root, filepath.FromSlash(rel)), rel)
+		}
+	}
+
+	var files []string
+	err = filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip unreadable entries
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if rel != "." && !includeIgnored && ig.ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			if !includeIgnored {
+				ig.load(p, strings.TrimPrefix(rel, "."))
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || (!includeIgnored && ig.ignored(rel, false)) || !matchesAnyGlob(globs, rel) {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxFileSize {
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", subdir, err)
+	}
+	return files, nil
+}
+
+func (ts Toolset) maxMatches(requested int) int {
+	limit := ts.MaxMatches
+	if limit <= 0 {
+		limit = DefaultMaxMatches
+	}
+	if requested > 0 && requested < limit {
+		return requested
+	}
+	return limit
+}
+
+func matchesAnyGlob(globs []string, rel string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+	for _, g := range globs {
+		if !strings.Contains(g, "/") {
+			if ok, _ := path.Match(g, path.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if matchGlob(strings.TrimPrefix(g, "/"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0
+}
+
+func cutLine(s string) string {
+	if len(s) <= maxLineLength {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxLineLength], "") + "..."
+}
@@ -0,0 +1,49 @@
+package search
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSearchCodeLimited searches more matches than allowed, exactly the
+// allowed matches must be returned with the note about the limit.
+func TestSearchCodeLimited(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt": "match 1\nmatch 2\nmatch 3\nmatch 4\nmatch 5\n",
+		"b.txt": "match 6\n",
+		"c.txt": "match 7\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		maxMatches int
+		want       int
+		limited    bool
+	}{
+		{"within a file", 3, 3, true},
+		{"whole file", 5, 5, true},
+		{"across files", 6, 6, true},
+		{"everything", 7, 7, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Toolset{Root: root}.searchCode(context.Background(), searchCodeInput{Pattern: "match", MaxMatches: tc.maxMatches})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(got, ": match"); n != tc.want {
+				t.Errorf("%d matches, want %d:\n%s", n, tc.want, got)
+			}
+			if limited := strings.Contains(got, "[results limited"); limited != tc.limited {
+				t.Errorf("limited = %v, want %v:\n%s", limited, tc.limited, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Package shell provides a tool running shell commands. The commands run in
+// the sandbox of the run (see core.NewAgentParams.EnableSandbox), running
+// them on the host must be enabled explicitly.
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/sandbox"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+const (
+	// DefaultTimeout limits a single command.
+	DefaultTimeout = 2 * time.Minute
+	// DefaultMaxOutputBytes limits the output returned to the model.
+	DefaultMaxOutputBytes = 30 * 1024
+)
+
+type Toolset struct {
+	// Dir is the working directory of the commands (mandatory).
+	Dir string
+	// AllowHost runs the commands on the host if the run is not sandboxed.
+	// Model-generated commands can do anything the process can, only enable
+	// it for trusted prompts.
+	AllowHost bool
+	// Timeout defaults to DefaultTimeout.
+	Timeout time.Duration
+	// MaxOutputBytes defaults to DefaultMaxOutputBytes.
+	MaxOutputBytes int
+}
+
+// The registered toolset only runs commands in a sandbox.
+func init() {
+	tool.MustRegister("shell", func(p tool.FactoryParams) ([]tool.Definition, error) {
+		return Toolset{Dir: p.Dir}.Tools(), nil
+	})
+}
+
+func (ts Toolset) Tools() []tool.Definition {
+	return []tool.Definition{
+		tool.NewMutating(
+			"RunCommand",
+			"Runs a shell command (sh -c) in the working directory and returns its exit code and combined output. "+
+				"Commands are non-interactive and time out, don't start long-running processes (e.g. servers).",
+			ts.runCommand, ts.runCommandDryRun,
+		),
+	}
+}
+
+type runCommandInput struct {
+	Command string `json:"command" jsonschema_description:"The shell command to run"`
+}
+
+func (ts Toolset) runCommand(ctx context.Context, input runCommandInput) (string, error) {
+	if strings.TrimSpace(input.Command) == "" {
+		return "", fmt.Errorf("command is required")
+	}
+	timeout := ts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch sb := sandbox.FromContext(ctx); {
+	case sb != nil:
+		var err error
+		if cmd, err = sb.Command(ctx, ts.Dir, "sh", "-c", input.Command); err != nil {
+			return "", err
+		}
+	case ts.AllowHost:
+		cmd = exec.CommandContext(ctx, "sh", "-c", input.Command)
+		cmd.Dir = ts.Dir
+	default:
+		return "", fmt.Errorf("shell commands can only run in a sandbox, and this run is not sandboxed")
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	exitCode := 0
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && ctx.Err() == nil:
+		exitCode = exitErr.ExitCode()
+	case ctx.Err() != nil:
+		return "", fmt.Errorf("command timed out after %s: %s", timeout, ts.truncate(out.String()))
+	case err != nil:
+		return "", fmt.Errorf("run command: %w", err)
+	}
+	return fmt.Sprintf("Exit code: %d\n%s", exitCode, ts.truncate(out.String())), nil
+}
+
+func (ts Toolset) runCommandDryRun(_ context.Context, input runCommandInput) (string, error) {
+	if strings.TrimSpace(input.Command) == "" {
+		return "", fmt.Errorf("command is required")
+	}
+	return fmt.Sprintf("Would run the command: %s", input.Command), nil
+}
+
+// truncate keeps the end of the output, where the errors usually are.
+func (ts Toolset) truncate(out string) string {
+	maxBytes := ts.MaxOutputBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxOutputBytes
+	}
+	if len(out) <= maxBytes {
+		return out
+	}
+	return fmt.Sprintf(
+		"[output truncated, the last %d of %d bytes shown]\n%s",
+		maxBytes, len(out), truncate.Tail(out, maxBytes),
+	)
+}
@@ -0,0 +1,168 @@
+package vcs
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type NewBitbucketParams struct {
+	Token     string // mandatory, unless the secrets of the run have it
+	Workspace string // mandatory
+	Repo      string // mandatory
+	// BaseURL defaults to https://api.bitbucket.org/2.0.
+	BaseURL            string
+	HTTPClient         *http.Client
+	MinRequestInterval time.Duration
+	// TokenSecret is the secret holding the token if Token is empty, fetched
+	// from the secrets of the run (see secrets.FromContext). Defaults to
+	// BITBUCKET_TOKEN.
+	TokenSecret string
+}
+
+type bitbucket struct {
+	api  *apiClient
+	repo string
+}
+
+func NewBitbucket(p NewBitbucketParams) Client {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.bitbucket.org/2.0"
+	}
+	return &bitbucket{
+		api:  newAPIClient(baseURL, p.Token, cmp.Or(p.TokenSecret, "BITBUCKET_TOKEN"), nil, p.HTTPClient, p.MinRequestInterval),
+		repo: fmt.Sprintf("/repositories/%s/%s", p.Workspace, p.Repo),
+	}
+}
+
+type bitbucketUser struct {
+	DisplayName string `json:"display_name"`
+}
+
+type bitbucketRef struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+}
+
+func (bb *bitbucket) GetPullRequest(ctx context.Context, number int) (PullRequest, error) {
+	var pr struct {
+		ID          int           `json:"id"`
+		Title       string        `json:"title"`
+		Description string        `json:"description"`
+		State       string        `json:"state"`
+		Author      bitbucketUser `json:"author"`
+		Source      bitbucketRef  `json:"source"`
+		Destination bitbucketRef  `json:"destination"`
+		Links       struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	}
+	if err := bb.api.getJSON(ctx, fmt.Sprintf("%s/pullrequests/%d", bb.repo, number), &pr); err != nil {
+		return PullRequest{}, fmt.Errorf("get pull request: %w", err)
+	}
+	return PullRequest{
+		Number:       pr.ID,
+		Title:        pr.Title,
+		Description:  pr.Description,
+		Author:       pr.Author.DisplayName,
+		State:        pr.State,
+		SourceBranch: pr.Source.Branch.Name,
+		TargetBranch: pr.Destination.Branch.Name,
+		HeadCommit:   pr.Source.Commit.Hash,
+		URL:          pr.Links.HTML.Href,
+	}, nil
+}
+
+func (bb *bitbucket) GetDiff(ctx context.Context, number int) (string, error) {
+	b, err := bb.api.do(ctx, http.MethodGet, fmt.Sprintf("%s/pullrequests/%d/diff", bb.repo, number), nil, "text/plain")
+	if err != nil {
+		return "", fmt.Errorf("get diff: %w", err)
+	}
+	return string(b), nil
+}
+
+// bitbucketPage is a page of a paginated endpoint of Bitbucket, Next is the
+// URL of the next page.
+type bitbucketPage[T any] struct {
+	Values []T    `json:"values"`
+	Next   string `json:"next"`
+}
+
+func bitbucketItems[T any](b []byte) ([]T, error) {
+	var page bitbucketPage[T]
+	err := json.Unmarshal(b, &page)
+	return page.Values, err
+}
+
+func bitbucketNextPage(_ http.Header, b []byte) string {
+	var page bitbucketPage[json.RawMessage]
+	if err := json.Unmarshal(b, &page); err != nil {
+		return ""
+	}
+	return page.Next
+}
+
+func (bb *bitbucket) ListComments(ctx context.Context, number int) ([]Comment, error) {
+	type bitbucketComment struct {
+		ID      int64 `json:"id"`
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+		User      bitbucketUser `json:"user"`
+		CreatedOn time.Time     `json:"created_on"`
+		Deleted   bool          `json:"deleted"`
+		Inline    *struct {
+			Path string `json:"path"`
+			To   int    `json:"to"`
+		} `json:"inline"`
+	}
+	path := fmt.Sprintf("%s/pullrequests/%d/comments?pagelen=100", bb.repo, number)
+	values, err := getPages(ctx, bb.api, path, bitbucketItems[bitbucketComment], bitbucketNextPage)
+	if err != nil {
+		return nil, fmt.Errorf("list comments: %w", err)
+	}
+
+	var comments []Comment
+	for _, v := range values {
+		if v.Deleted {
+			continue
+		}
+		c := Comment{
+			ID:        strconv.FormatInt(v.ID, 10),
+			Author:    v.User.DisplayName,
+			Body:      v.Content.Raw,
+			CreatedAt: v.CreatedOn,
+		}
+		if v.Inline != nil {
+			c.Path = v.Inline.Path
+			c.Line = v.Inline.To
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+func (bb *bitbucket) PostComment(ctx context.Context, number int, comment NewComment) error {
+	body := map[string]any{
+		"content": map[string]any{"raw": comment.Body},
+	}
+	if comment.Path != "" {
+		body["inline"] = map[string]any{"path": comment.Path, "to": comment.Line}
+	}
+	path := fmt.Sprintf("%s/pullrequests/%d/comments", bb.repo, number)
+	if _, err := bb.api.do(ctx, http.MethodPost, path, body, ""); err != nil {
+		return fmt.Errorf("post comment: %w", err)
+	}
+	return nil
+}
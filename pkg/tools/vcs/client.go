@@ -0,0 +1,229 @@
+// Package vcs provides provider-agnostic pull request tools backed by the
+// GitHub, GitLab and Bitbucket REST APIs.
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/secrets"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+type PullRequest struct {
+	Number       int    `json:"number"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	Author       string `json:"author"`
+	State        string `json:"state"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	HeadCommit   string `json:"head_commit"`
+	URL          string `json:"url"`
+}
+
+type Comment struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	Path      string    `json:"path,omitempty"`
+	Line      int       `json:"line,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewComment is a comment to post. If Path and Line are set, the comment is
+// attached to that line of the diff, otherwise it is a general comment.
+type NewComment struct {
+	Body string
+	Path string
+	Line int
+}
+
+// Client is implemented by all supported VCS providers.
+type Client interface {
+	GetPullRequest(ctx context.Context, number int) (PullRequest, error)
+	GetDiff(ctx context.Context, number int) (string, error)
+	ListComments(ctx context.Context, number int) ([]Comment, error)
+	PostComment(ctx context.Context, number int, comment NewComment) error
+}
+
+// apiClient is the HTTP layer shared by the provider implementations. It
+// authenticates requests and spaces them out to respect rate limits.
+type apiClient struct {
+	baseURL    string
+	headers    map[string]string
+	httpClient *http.Client
+	limiter    *rateLimiter
+	// token is fetched from the secrets of the run if empty.
+	token       string
+	tokenSecret string
+}
+
+func newAPIClient(baseURL, token, tokenSecret string, headers map[string]string, httpClient *http.Client, minInterval time.Duration) *apiClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &apiClient{
+		baseURL:     baseURL,
+		token:       token,
+		tokenSecret: tokenSecret,
+		headers:     headers,
+		httpClient:  httpClient,
+		limiter:     &rateLimiter{interval: minInterval},
+	}
+}
+
+func (c *apiClient) do(ctx context.Context, method, path string, body any, accept string) ([]byte, error) {
+	b, _, err := c.send(ctx, method, path, body, accept)
+	return b, err
+}
+
+// send sends a request to path, or to the absolute URL of a page (see
+// getPages), and returns the body and the headers of the response.
+func (c *apiClient) send(ctx context.Context, method, path string, body any, accept string) ([]byte, http.Header, error) {
+	url := c.baseURL + path
+	if strings.Contains(path, "://") {
+		// The token must not be sent to another host.
+		if !strings.HasPrefix(path, c.baseURL+"/") {
+			return nil, nil, fmt.Errorf("%s is not a URL of the API", path)
+		}
+		url = path
+	}
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal body: %w", err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, nil, fmt.Errorf("new request: %w", err)
+	}
+	token := c.token
+	if token == "" {
+		if token, err = secrets.Get(ctx, c.tokenSecret); err != nil {
+			return nil, nil, fmt.Errorf("get token: %w", err)
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, truncate.Head(string(respBody), 500))
+	}
+	return respBody, resp.Header, nil
+}
+
+func (c *apiClient) getJSON(ctx context.Context, path string, v any) error {
+	b, err := c.do(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unmarshal response: %w", err)
+	}
+	return nil
+}
+
+// maxPages limits the pages fetched from a paginated endpoint.
+const maxPages = 50
+
+// getPages fetches all the pages of a paginated endpoint, starting at path.
+// items parses the items of a page, next returns the path or the URL of the
+// next page, empty after the last one.
+func getPages[T any](ctx context.Context, c *apiClient, path string, items func([]byte) ([]T, error), next func(http.Header, []byte) string) ([]T, error) {
+	var all []T
+	for range maxPages {
+		b, header, err := c.send(ctx, http.MethodGet, path, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		page, err := items(b)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal response: %w", err)
+		}
+		all = append(all, page...)
+		if path = next(header, b); path == "" {
+			return all, nil
+		}
+	}
+	return nil, fmt.Errorf("more than %d pages of results", maxPages)
+}
+
+// jsonItems parses a page which is a JSON array of the items.
+func jsonItems[T any](b []byte) ([]T, error) {
+	var items []T
+	err := json.Unmarshal(b, &items)
+	return items, err
+}
+
+// nextLink returns the URL of the next page from the Link header (RFC 8288),
+// used by GitHub and GitLab.
+func nextLink(header http.Header, _ []byte) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(target), "<>")
+	}
+	return ""
+}
+
+// rateLimiter enforces a minimal interval between requests.
+type rateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+func (rl *rateLimiter) wait(ctx context.Context) error {
+	if rl.interval <= 0 {
+		return nil
+	}
+	rl.mu.Lock()
+	now := time.Now()
+	at := rl.next
+	if at.Before(now) {
+		at = now
+	}
+	rl.next = at.Add(rl.interval)
+	rl.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package vcs
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type NewGitHubParams struct {
+	Token string // mandatory, unless the secrets of the run have it
+	Owner string // mandatory
+	Repo  string // mandatory
+	// BaseURL defaults to https://api.github.com, set it for GitHub Enterprise.
+	BaseURL            string
+	HTTPClient         *http.Client
+	MinRequestInterval time.Duration
+	// TokenSecret is the secret holding the token if Token is empty, fetched
+	// from the secrets of the run (see secrets.FromContext). Defaults to
+	// GITHUB_TOKEN.
+	TokenSecret string
+}
+
+type gitHub struct {
+	api  *apiClient
+	repo string
+}
+
+func NewGitHub(p NewGitHubParams) Client {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.github.com"
+	}
+	headers := map[string]string{"X-GitHub-Api-Version": "2022-11-28"}
+	return &gitHub{
+		api:  newAPIClient(baseURL, p.Token, cmp.Or(p.TokenSecret, "GITHUB_TOKEN"), headers, p.HTTPClient, p.MinRequestInterval),
+		repo: fmt.Sprintf("/repos/%s/%s", p.Owner, p.Repo),
+	}
+}
+
+type gitHubUser struct {
+	Login string `json:"login"`
+}
+
+func (gh *gitHub) GetPullRequest(ctx context.Context, number int) (PullRequest, error) {
+	var pr struct {
+		Number  int        `json:"number"`
+		Title   string     `json:"title"`
+		Body    string     `json:"body"`
+		State   string     `json:"state"`
+		HTMLURL string     `json:"html_url"`
+		User    gitHubUser `json:"user"`
+		Head    struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	}
+	if err := gh.api.getJSON(ctx, fmt.Sprintf("%s/pulls/%d", gh.repo, number), &pr); err != nil {
+		return PullRequest{}, fmt.Errorf("get pull request: %w", err)
+	}
+	return PullRequest{
+		Number:       pr.Number,
+		Title:        pr.Title,
+		Description:  pr.Body,
+		Author:       pr.User.Login,
+		State:        pr.State,
+		SourceBranch: pr.Head.Ref,
+		TargetBranch: pr.Base.Ref,
+		HeadCommit:   pr.Head.SHA,
+		URL:          pr.HTMLURL,
+	}, nil
+}
+
+func (gh *gitHub) GetDiff(ctx context.Context, number int) (string, error) {
+	path := fmt.Sprintf("%s/pulls/%d", gh.repo, number)
+	b, err := gh.api.do(ctx, http.MethodGet, path, nil, "application/vnd.github.v3.diff")
+	if err != nil {
+		return "", fmt.Errorf("get diff: %w", err)
+	}
+	return string(b), nil
+}
+
+func (gh *gitHub) ListComments(ctx context.Context, number int) ([]Comment, error) {
+	type gitHubComment struct {
+		ID        int64      `json:"id"`
+		Body      string     `json:"body"`
+		User      gitHubUser `json:"user"`
+		Path      string     `json:"path"`
+		Line      int        `json:"line"`
+		CreatedAt time.Time  `json:"created_at"`
+	}
+
+	// Conversation comments and review (line) comments are separate resources.
+	issueComments, err := getPages(ctx, gh.api, fmt.Sprintf("%s/issues/%d/comments?per_page=100", gh.repo, number), jsonItems[gitHubComment], nextLink)
+	if err != nil {
+		return nil, fmt.Errorf("list issue comments: %w", err)
+	}
+	reviewComments, err := getPages(ctx, gh.api, fmt.Sprintf("%s/pulls/%d/comments?per_page=100", gh.repo, number), jsonItems[gitHubComment], nextLink)
+	if err != nil {
+		return nil, fmt.Errorf("list review comments: %w", err)
+	}
+
+	var comments []Comment
+	for _, c := range append(issueComments, reviewComments...) {
+		comments = append(comments, Comment{
+			ID:        strconv.FormatInt(c.ID, 10),
+			Author:    c.User.Login,
+			Body:      c.Body,
+			Path:      c.Path,
+			Line:      c.Line,
+			CreatedAt: c.CreatedAt,
+		})
+	}
+	return comments, nil
+}
+
+func (gh *gitHub) PostComment(ctx context.Context, number int, comment NewComment) error {
+	if comment.Path == "" {
+		path := fmt.Sprintf("%s/issues/%d/comments", gh.repo, number)
+		body := map[string]any{"body": comment.Body}
+		if _, err := gh.api.do(ctx, http.MethodPost, path, body, ""); err != nil {
+			return fmt.Errorf("post comment: %w", err)
+		}
+		return nil
+	}
+
+	pr, err := gh.GetPullRequest(ctx, number)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/pulls/%d/comments", gh.repo, number)
+	body := map[string]any{
+		"body":      comment.Body,
+		"commit_id": pr.HeadCommit,
+		"path":      comment.Path,
+		"line":      comment.Line,
+		"side":      "RIGHT",
+	}
+	if _, err := gh.api.do(ctx, http.MethodPost, path, body, ""); err != nil {
+		return fmt.Errorf("post review comment: %w", err)
+	}
+	return nil
+}
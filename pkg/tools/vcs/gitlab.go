@@ -0,0 +1,209 @@
+package vcs
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type NewGitLabParams struct {
+	Token string // mandatory, unless the secrets of the run have it
+	// Project is the numeric ID or the full path (e.g. "group/project") of the project (mandatory).
+	Project string
+	// BaseURL defaults to https://gitlab.com/api/v4, set it for self-managed instances.
+	BaseURL            string
+	HTTPClient         *http.Client
+	MinRequestInterval time.Duration
+	// TokenSecret is the secret holding the token if Token is empty, fetched
+	// from the secrets of the run (see secrets.FromContext). Defaults to
+	// GITLAB_TOKEN.
+	TokenSecret string
+}
+
+type gitLab struct {
+	api     *apiClient
+	project string
+}
+
+func NewGitLab(p NewGitLabParams) Client {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = "https://gitlab.com/api/v4"
+	}
+	return &gitLab{
+		api:     newAPIClient(baseURL, p.Token, cmp.Or(p.TokenSecret, "GITLAB_TOKEN"), nil, p.HTTPClient, p.MinRequestInterval),
+		project: "/projects/" + url.PathEscape(p.Project),
+	}
+}
+
+type gitLabUser struct {
+	Username string `json:"username"`
+}
+
+type gitLabMergeRequest struct {
+	IID          int        `json:"iid"`
+	Title        string     `json:"title"`
+	Description  string     `json:"description"`
+	State        string     `json:"state"`
+	SourceBranch string     `json:"source_branch"`
+	TargetBranch string     `json:"target_branch"`
+	SHA          string     `json:"sha"`
+	WebURL       string     `json:"web_url"`
+	Author       gitLabUser `json:"author"`
+	DiffRefs     struct {
+		BaseSHA  string `json:"base_sha"`
+		StartSHA string `json:"start_sha"`
+		HeadSHA  string `json:"head_sha"`
+	} `json:"diff_refs"`
+}
+
+func (gl *gitLab) getMergeRequest(ctx context.Context, number int) (gitLabMergeRequest, error) {
+	var mr gitLabMergeRequest
+	if err := gl.api.getJSON(ctx, fmt.Sprintf("%s/merge_requests/%d", gl.project, number), &mr); err != nil {
+		return mr, fmt.Errorf("get merge request: %w", err)
+	}
+	return mr, nil
+}
+
+func (gl *gitLab) GetPullRequest(ctx context.Context, number int) (PullRequest, error) {
+	mr, err := gl.getMergeRequest(ctx, number)
+	if err != nil {
+		return PullRequest{}, err
+	}
+	return PullRequest{
+		Number:       mr.IID,
+		Title:        mr.Title,
+		Description:  mr.Description,
+		Author:       mr.Author.Username,
+		State:        mr.State,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		HeadCommit:   mr.SHA,
+		URL:          mr.WebURL,
+	}, nil
+}
+
+// gitLabNextPage returns the next page from the Link header, or from the
+// X-Next-Page header if there is no link.
+func gitLabNextPage(path string) func(http.Header, []byte) string {
+	return func(header http.Header, b []byte) string {
+		if link := nextLink(header, b); link != "" {
+			return link
+		}
+		page := header.Get("X-Next-Page")
+		if page == "" {
+			return ""
+		}
+		base, query, _ := strings.Cut(path, "?")
+		values, _ := url.ParseQuery(query)
+		values.Set("page", page)
+		return base + "?" + values.Encode()
+	}
+}
+
+func (gl *gitLab) GetDiff(ctx context.Context, number int) (string, error) {
+	type gitLabDiff struct {
+		OldPath     string `json:"old_path"`
+		NewPath     string `json:"new_path"`
+		Diff        string `json:"diff"`
+		NewFile     bool   `json:"new_file"`
+		DeletedFile bool   `json:"deleted_file"`
+	}
+	path := fmt.Sprintf("%s/merge_requests/%d/diffs?per_page=100", gl.project, number)
+	diffs, err := getPages(ctx, gl.api, path, jsonItems[gitLabDiff], gitLabNextPage(path))
+	if err != nil {
+		return "", fmt.Errorf("get diff: %w", err)
+	}
+
+	// The API returns the hunks per file, reassemble them into a unified diff.
+	var sb strings.Builder
+	for _, d := range diffs {
+		oldPath, newPath := "a/"+d.OldPath, "b/"+d.NewPath
+		if d.NewFile {
+			oldPath = "/dev/null"
+		}
+		if d.DeletedFile {
+			newPath = "/dev/null"
+		}
+		fmt.Fprintf(&sb, "diff --git a/%s b/%s\n--- %s\n+++ %s\n%s", d.OldPath, d.NewPath, oldPath, newPath, d.Diff)
+		if !strings.HasSuffix(d.Diff, "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+func (gl *gitLab) ListComments(ctx context.Context, number int) ([]Comment, error) {
+	type gitLabNote struct {
+		ID        int64      `json:"id"`
+		Body      string     `json:"body"`
+		Author    gitLabUser `json:"author"`
+		System    bool       `json:"system"`
+		CreatedAt time.Time  `json:"created_at"`
+		Position  *struct {
+			NewPath string `json:"new_path"`
+			NewLine int    `json:"new_line"`
+		} `json:"position"`
+	}
+	path := fmt.Sprintf("%s/merge_requests/%d/notes?per_page=100", gl.project, number)
+	notes, err := getPages(ctx, gl.api, path, jsonItems[gitLabNote], gitLabNextPage(path))
+	if err != nil {
+		return nil, fmt.Errorf("list notes: %w", err)
+	}
+
+	var comments []Comment
+	for _, n := range notes {
+		if n.System {
+			continue // e.g. "added 1 commit"
+		}
+		c := Comment{
+			ID:        strconv.FormatInt(n.ID, 10),
+			Author:    n.Author.Username,
+			Body:      n.Body,
+			CreatedAt: n.CreatedAt,
+		}
+		if n.Position != nil {
+			c.Path = n.Position.NewPath
+			c.Line = n.Position.NewLine
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+func (gl *gitLab) PostComment(ctx context.Context, number int, comment NewComment) error {
+	if comment.Path == "" {
+		path := fmt.Sprintf("%s/merge_requests/%d/notes", gl.project, number)
+		if _, err := gl.api.do(ctx, http.MethodPost, path, map[string]any{"body": comment.Body}, ""); err != nil {
+			return fmt.Errorf("post note: %w", err)
+		}
+		return nil
+	}
+
+	mr, err := gl.getMergeRequest(ctx, number)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/merge_requests/%d/discussions", gl.project, number)
+	body := map[string]any{
+		"body": comment.Body,
+		"position": map[string]any{
+			"position_type": "text",
+			"base_sha":      mr.DiffRefs.BaseSHA,
+			"start_sha":     mr.DiffRefs.StartSHA,
+			"head_sha":      mr.DiffRefs.HeadSHA,
+			"old_path":      comment.Path,
+			"new_path":      comment.Path,
+			"new_line":      comment.Line,
+		},
+	}
+	if _, err := gl.api.do(ctx, http.MethodPost, path, body, ""); err != nil {
+		return fmt.Errorf("post discussion: %w", err)
+	}
+	return nil
+}
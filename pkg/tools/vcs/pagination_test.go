@@ -0,0 +1,72 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestListCommentsPaginated serves the comments in pages of one comment,
+// every page must be fetched.
+func TestListCommentsPaginated(t *testing.T) {
+	const pages = 3
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		page = max(page, 1)
+		next := fmt.Sprintf("%s%s?page=%d", server.URL, r.URL.Path, page+1)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/repositories/"):
+			if page == pages {
+				next = ""
+			}
+			fmt.Fprintf(w, `{"values":[{"id":%d,"content":{"raw":"comment %d"}}],"next":%q}`, page, page, next)
+			return
+		case strings.HasPrefix(r.URL.Path, "/projects/") && page < pages:
+			// GitLab without the Link header.
+			w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+		case page < pages:
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next", <%s>; rel="last"`, next, server.URL))
+		}
+		fmt.Fprintf(w, `[{"id":%d,"body":"comment %d"}]`, page, page)
+	}))
+	defer server.Close()
+
+	for name, client := range map[string]Client{
+		"github":    NewGitHub(NewGitHubParams{Token: "token", Owner: "o", Repo: "r", BaseURL: server.URL}),
+		"gitlab":    NewGitLab(NewGitLabParams{Token: "token", Project: "1", BaseURL: server.URL}),
+		"bitbucket": NewBitbucket(NewBitbucketParams{Token: "token", Workspace: "w", Repo: "r", BaseURL: server.URL}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			comments, err := client.ListComments(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := pages
+			if name == "github" {
+				// The issue and the review comments.
+				want = 2 * pages
+			}
+			if len(comments) != want {
+				t.Errorf("%d comments, want %d: %+v", len(comments), want, comments)
+			}
+		})
+	}
+}
+
+func TestPageOutsideAPI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://attacker.example.com/steal>; rel="next"`)
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	client := NewGitHub(NewGitHubParams{Token: "token", Owner: "o", Repo: "r", BaseURL: server.URL})
+	if _, err := client.ListComments(context.Background(), 1); err == nil {
+		t.Error("the next page outside the API was fetched")
+	}
+}
@@ -0,0 +1,147 @@
+package vcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/bitrise-ai-core/pkg/tool"
+	"github.com/bitrise-io/bitrise-ai-core/pkg/truncate"
+)
+
+// DefaultMaxDiffBytes is the default limit of the diff returned to the model.
+const DefaultMaxDiffBytes = 200 * 1024
+
+type Toolset struct {
+	// Client is the VCS provider client (mandatory).
+	Client Client
+	// AllowWrite enables posting comments.
+	AllowWrite bool
+	// MaxDiffBytes limits the size of the returned diff. Defaults to
+	// DefaultMaxDiffBytes.
+	MaxDiffBytes int
+}
+
+// Tools returns the pull request tool definitions, including the comment
+// posting tool if AllowWrite is set.
+func (ts Toolset) Tools() []tool.Definition {
+	tools := []tool.Definition{
+		tool.New(
+			"GetPullRequest",
+			"Returns the metadata of a pull request: title, description, author, state, branches and head commit.",
+			ts.getPullRequest,
+		),
+		tool.New(
+			"GetPullRequestDiff",
+			"Returns the unified diff of a pull request.",
+			ts.getDiff,
+		),
+		tool.New(
+			"ListPullRequestComments",
+			"Lists the existing comments of a pull request, including line comments with their file path and line.",
+			ts.listComments,
+		),
+	}
+	if ts.AllowWrite {
+		tools = append(tools, tool.NewMutating(
+			"PostPullRequestComment",
+			"Posts a comment on a pull request. If path and line are given, the comment is attached to that line "+
+				"of the new version of the file, otherwise it is a general comment.",
+			ts.postComment, ts.postCommentDryRun,
+		))
+	}
+	return tools
+}
+
+type pullRequestInput struct {
+	Number int `json:"number" jsonschema_description:"The pull request number"`
+}
+
+func (ts Toolset) getPullRequest(ctx context.Context, input pullRequestInput) (string, error) {
+	pr, err := ts.Client.GetPullRequest(ctx, input.Number)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(pr)
+	if err != nil {
+		return "", fmt.Errorf("marshal pull request: %w", err)
+	}
+	return string(b), nil
+}
+
+func (ts Toolset) getDiff(ctx context.Context, input pullRequestInput) (string, error) {
+	diff, err := ts.Client.GetDiff(ctx, input.Number)
+	if err != nil {
+		return "", err
+	}
+	maxBytes := ts.MaxDiffBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxDiffBytes
+	}
+	if len(diff) > maxBytes {
+		head := truncate.Head(diff, maxBytes)
+		diff = fmt.Sprintf("%s\n\n[diff truncated, %d of %d bytes shown]", head, len(head), len(diff))
+	}
+	if diff == "" {
+		return "The pull request has no changes.", nil
+	}
+	return diff, nil
+}
+
+func (ts Toolset) listComments(ctx context.Context, input pullRequestInput) (string, error) {
+	comments, err := ts.Client.ListComments(ctx, input.Number)
+	if err != nil {
+		return "", err
+	}
+	if len(comments) == 0 {
+		return "The pull request has no comments.", nil
+	}
+	b, err := json.Marshal(comments)
+	if err != nil {
+		return "", fmt.Errorf("marshal comments: %w", err)
+	}
+	return string(b), nil
+}
+
+type postCommentInput struct {
+	Number int    `json:"number" jsonschema_description:"The pull request number"`
+	Body   string `json:"body" jsonschema_description:"The comment text (Markdown)"`
+	Path   string `json:"path,omitempty" jsonschema_description:"Path of the file to comment on"`
+	Line   int    `json:"line,omitempty" jsonschema_description:"Line number in the new version of the file"`
+}
+
+func (input postCommentInput) validate() error {
+	if input.Body == "" {
+		return fmt.Errorf("body is required")
+	}
+	if (input.Path == "") != (input.Line == 0) {
+		return fmt.Errorf("path and line must be set together")
+	}
+	return nil
+}
+
+func (ts Toolset) postComment(ctx context.Context, input postCommentInput) (string, error) {
+	if err := input.validate(); err != nil {
+		return "", err
+	}
+	err := ts.Client.PostComment(ctx, input.Number, NewComment{
+		Body: input.Body,
+		Path: input.Path,
+		Line: input.Line,
+	})
+	if err != nil {
+		return "", err
+	}
+	return "Comment posted.", nil
+}
+
+func (ts Toolset) postCommentDryRun(_ context.Context, input postCommentInput) (string, error) {
+	if err := input.validate(); err != nil {
+		return "", err
+	}
+	target := fmt.Sprintf("pull request #%d", input.Number)
+	if input.Path != "" {
+		target += fmt.Sprintf(" at %s:%d", input.Path, input.Line)
+	}
+	return fmt.Sprintf("Would post a comment on %s:\n%s", target, input.Body), nil
+}
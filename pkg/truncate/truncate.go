@@ -0,0 +1,162 @@
+// Package truncate shortens texts for logs and for the model without
+// breaking them: the cuts are at rune boundaries, so the result is valid
+// UTF-8, and JSON documents stay valid JSON.
+package truncate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Head returns the beginning of s within maxBytes.
+func Head(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	if maxBytes <= 0 {
+		return ""
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
+
+// Tail returns the end of s within maxBytes.
+func Tail(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	if maxBytes <= 0 {
+		return ""
+	}
+	cut := len(s) - maxBytes
+	for cut < len(s) && !utf8.RuneStart(s[cut]) {
+		cut++
+	}
+	return s[cut:]
+}
+
+// Middle keeps the beginning and the end of s within maxBytes, replacing
+// the middle with marker (e.g. "..."). If maxBytes leaves no room for the
+// marker, the end of s is returned.
+func Middle(s string, maxBytes int, marker string) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	if maxBytes <= len(marker) {
+		return Tail(s, maxBytes)
+	}
+	half := (maxBytes - len(marker)) / 2
+	return Head(s, half) + marker + Tail(s, half)
+}
+
+// JSON shortens a JSON document to about maxBytes, keeping it valid: long
+// strings are cut and the elements of long arrays and objects are elided,
+// with a note of what was omitted. The values are shrunk until the document
+// fits, deeply nested documents may still exceed maxBytes. Other texts are
+// shortened with Head.
+func JSON(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	if !json.Valid([]byte(s)) {
+		return Head(s, maxBytes)
+	}
+	var out string
+	for maxString, maxItems := maxBytes, 1000; ; maxString, maxItems = maxString/2, maxItems/2 {
+		var buf bytes.Buffer
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		if err := elide(dec, &buf, max(maxString, 8), max(maxItems, 1)); err != nil {
+			return Head(s, maxBytes)
+		}
+		out = buf.String()
+		if len(out) <= maxBytes || maxString < 8 && maxItems < 1 {
+			return out
+		}
+	}
+}
+
+// elide re-encodes the next value of dec, eliding what exceeds the limits.
+func elide(dec *json.Decoder, buf *bytes.Buffer, maxString, maxItems int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch v := tok.(type) {
+	case json.Delim:
+		open, end := byte(v), byte(']')
+		if v == '{' {
+			end = '}'
+		}
+		buf.WriteByte(open)
+		n := 0
+		for dec.More() {
+			var key string
+			if open == '{' {
+				k, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key, _ = k.(string)
+			}
+			if n >= maxItems {
+				var skipped json.RawMessage
+				if err := dec.Decode(&skipped); err != nil {
+					return err
+				}
+				n++
+				continue
+			}
+			if n > 0 {
+				buf.WriteByte(',')
+			}
+			if open == '{' {
+				writeString(buf, key)
+				buf.WriteByte(':')
+			}
+			if err := elide(dec, buf, maxString, maxItems); err != nil {
+				return err
+			}
+			n++
+		}
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		if omitted := n - maxItems; omitted > 0 {
+			buf.WriteByte(',')
+			if open == '{' {
+				writeString(buf, "...")
+				buf.WriteByte(':')
+				writeString(buf, fmt.Sprintf("%d more fields omitted", omitted))
+			} else {
+				writeString(buf, fmt.Sprintf("... %d more items omitted", omitted))
+			}
+		}
+		buf.WriteByte(end)
+	case string:
+		if len(v) > maxString {
+			v = fmt.Sprintf("%s... (%d bytes omitted)", Head(v, maxString), len(v)-len(Head(v, maxString)))
+		}
+		writeString(buf, v)
+	case json.Number:
+		buf.WriteString(v.String())
+	default: // bool or null
+		b, _ := json.Marshal(v)
+		buf.Write(b)
+	}
+	return nil
+}
+
+// writeString writes s as a JSON string, without escaping HTML.
+func writeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	buf.Truncate(buf.Len() - 1) // the newline of Encode
+}
@@ -0,0 +1,209 @@
+// Package workspace collects basic facts about the machine and the project the
+// agent works on, so they can be given to the model upfront instead of being
+// discovered with tool calls.
+package workspace
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Context is a snapshot of the workspace. Fields that could not be collected
+// are left empty.
+type Context struct {
+	Dir          string
+	OS           string
+	Arch         string
+	NumCPU       int
+	MemoryBytes  uint64
+	GitBranch    string
+	GitCommit    string
+	ProjectTypes []string
+	// ToolVersions contains the versions of the toolchains of the detected
+	// project types (e.g. Xcode, Go), describing the stack.
+	ToolVersions map[string]string
+	// CI contains the detected CI environment (e.g. Bitrise app and build
+	// number), if any.
+	CI map[string]string
+}
+
+// projectMarkers maps files and directories (glob patterns in the workspace
+// root) to the project type they indicate.
+var projectMarkers = []struct {
+	pattern     string
+	projectType string
+}{
+	{"go.mod", "Go"},
+	{"package.json", "Node.js"},
+	{"*.xcodeproj", "iOS/macOS (Xcode)"},
+	{"*.xcworkspace", "iOS/macOS (Xcode)"},
+	{"Package.swift", "Swift Package"},
+	{"Podfile", "CocoaPods"},
+	{"build.gradle", "Android/Gradle"},
+	{"build.gradle.kts", "Android/Gradle"},
+	{"pubspec.yaml", "Flutter"},
+	{"app.json", "React Native/Expo"},
+	{"pom.xml", "Java (Maven)"},
+	{"Gemfile", "Ruby"},
+	{"requirements.txt", "Python"},
+	{"pyproject.toml", "Python"},
+	{"Cargo.toml", "Rust"},
+	{"*.csproj", ".NET"},
+	{"bitrise.yml", "Bitrise configuration"},
+}
+
+// toolVersionCommands are the commands printing the toolchain version of a
+// project type. Only the first line of the output is kept.
+var toolVersionCommands = map[string][]string{
+	"Go":                {"go", "version"},
+	"Node.js":           {"node", "--version"},
+	"iOS/macOS (Xcode)": {"xcodebuild", "-version"},
+	"Flutter":           {"flutter", "--version"},
+	"Ruby":              {"ruby", "--version"},
+	"Python":            {"python3", "--version"},
+	"Rust":              {"rustc", "--version"},
+}
+
+// ciEnvs are the environment variables describing the CI environment.
+var ciEnvs = []string{
+	"BITRISE_APP_SLUG",
+	"BITRISE_BUILD_NUMBER",
+	"BITRISE_BUILD_SLUG",
+	"BITRISE_TRIGGERED_WORKFLOW_ID",
+	"BITRISE_GIT_BRANCH",
+	"BITRISEIO_GIT_BRANCH_DEST",
+}
+
+// CollectTimeout limits the commands run by Collect.
+const CollectTimeout = 5 * time.Second
+
+// Collect creates a snapshot of the workspace in dir. It is best effort,
+// missing information is not an error.
+func Collect(ctx context.Context, dir string) Context {
+	ctx, cancel := context.WithTimeout(ctx, CollectTimeout)
+	defer cancel()
+
+	wc := Context{
+		Dir:         dir,
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		NumCPU:      runtime.NumCPU(),
+		MemoryBytes: totalMemory(ctx),
+		GitBranch:   gitOutput(ctx, dir, "rev-parse", "--abbrev-ref", "HEAD"),
+		GitCommit:   gitOutput(ctx, dir, "rev-parse", "HEAD"),
+	}
+
+	seen := map[string]bool{}
+	for _, m := range projectMarkers {
+		matches, _ := filepath.Glob(filepath.Join(dir, m.pattern))
+		if len(matches) > 0 && !seen[m.projectType] {
+			seen[m.projectType] = true
+			wc.ProjectTypes = append(wc.ProjectTypes, m.projectType)
+		}
+	}
+	for _, projectType := range wc.ProjectTypes {
+		args, ok := toolVersionCommands[projectType]
+		if !ok {
+			continue
+		}
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			continue
+		}
+		if wc.ToolVersions == nil {
+			wc.ToolVersions = map[string]string{}
+		}
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		wc.ToolVersions[args[0]] = firstLine
+	}
+
+	for _, name := range ciEnvs {
+		if v := os.Getenv(name); v != "" {
+			if wc.CI == nil {
+				wc.CI = map[string]string{}
+			}
+			wc.CI[name] = v
+		}
+	}
+	return wc
+}
+
+// Render formats the snapshot for the system prompt.
+func (wc Context) Render() string {
+	var sb strings.Builder
+	sb.WriteString("<workspace>\n")
+	if wc.Dir != "" {
+		fmt.Fprintf(&sb, "Working directory: %s\n", wc.Dir)
+	}
+	fmt.Fprintf(&sb, "OS: %s/%s\n", wc.OS, wc.Arch)
+	fmt.Fprintf(&sb, "CPUs: %d\n", wc.NumCPU)
+	if wc.MemoryBytes > 0 {
+		fmt.Fprintf(&sb, "Memory: %.1f GB\n", float64(wc.MemoryBytes)/(1<<30))
+	}
+	if wc.GitBranch != "" {
+		fmt.Fprintf(&sb, "Git branch: %s\n", wc.GitBranch)
+	}
+	if wc.GitCommit != "" {
+		fmt.Fprintf(&sb, "Git commit: %s\n", wc.GitCommit)
+	}
+	if len(wc.ProjectTypes) > 0 {
+		fmt.Fprintf(&sb, "Project type: %s\n", strings.Join(wc.ProjectTypes, ", "))
+	}
+	for _, projectType := range wc.ProjectTypes {
+		if args, ok := toolVersionCommands[projectType]; ok && wc.ToolVersions[args[0]] != "" {
+			fmt.Fprintf(&sb, "%s version: %s\n", args[0], wc.ToolVersions[args[0]])
+		}
+	}
+	for _, name := range ciEnvs {
+		if v, ok := wc.CI[name]; ok {
+			fmt.Fprintf(&sb, "%s: %s\n", name, v)
+		}
+	}
+	sb.WriteString("</workspace>")
+	return sb.String()
+}
+
+func gitOutput(ctx context.Context, dir string, args ...string) string {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func totalMemory(ctx context.Context) uint64 {
+	switch runtime.GOOS {
+	case "linux":
+		file, err := os.Open("/proc/meminfo")
+		if err != nil {
+			return 0
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "MemTotal:" {
+				kb, _ := strconv.ParseUint(fields[1], 10, 64)
+				return kb * 1024
+			}
+		}
+	case "darwin":
+		out, err := exec.CommandContext(ctx, "sysctl", "-n", "hw.memsize").Output()
+		if err != nil {
+			return 0
+		}
+		v, _ := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+		return v
+	}
+	return 0
+}